@@ -0,0 +1,56 @@
+// Command apikey generates an API key. The key is printed once on stderr for
+// the client; the entry for the server's keys file, holding only the key's
+// hash, is printed on stdout.
+//
+//	go run ./cmd/apikey -id ci-2024 -client acme -scopes submit,read -tenant acme
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/auth"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/tenant"
+)
+
+func main() {
+	id := flag.String("id", "", "key id, shown in logs")
+	client := flag.String("client", "", "client id the key belongs to")
+	scopes := flag.String("scopes", "submit,read", "comma-separated scopes: submit, read, admin")
+	tenantID := flag.String("tenant", "", "tenant the key is bound to, needed for keys without the admin scope when the server has tenants")
+	flag.Parse()
+
+	if *tenantID != "" {
+		if err := tenant.ValidateID(*tenantID); err != nil {
+			fmt.Fprintln(os.Stderr, "apikey:", err)
+			os.Exit(2)
+		}
+	}
+
+	secret, err := auth.Generate()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "apikey:", err)
+		os.Exit(1)
+	}
+
+	key := auth.Key{ID: *id, ClientID: *client, Hash: auth.Hash(secret), Tenant: *tenantID}
+	for _, s := range strings.Split(*scopes, ",") {
+		key.Scopes = append(key.Scopes, auth.Scope(strings.TrimSpace(s)))
+	}
+	if err := key.IsValid(); err != nil {
+		fmt.Fprintln(os.Stderr, "apikey:", err)
+		os.Exit(2)
+	}
+
+	fmt.Fprintf(os.Stderr, "key: %s\n(it is not stored, give it to the client now)\n", secret)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(key); err != nil {
+		fmt.Fprintln(os.Stderr, "apikey:", err)
+		os.Exit(1)
+	}
+}
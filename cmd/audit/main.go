@@ -0,0 +1,46 @@
+// Command audit verifies the hash chain of an audit log. It prints the number
+// of entries and the hash of the last one, which vouches for all of them, or
+// names the first entry that was changed, removed or reordered.
+//
+//	go run ./cmd/audit -file audit.jsonl
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/audit"
+)
+
+func main() {
+	file := flag.String("file", "", "audit log to verify")
+	flag.Parse()
+
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "audit: -file is required")
+		os.Exit(2)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "audit:", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	entries, err := audit.Read(f)
+	if err == nil {
+		err = audit.Verify(entries)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "audit:", err)
+		os.Exit(1)
+	}
+
+	head := ""
+	if len(entries) > 0 {
+		head = entries[len(entries)-1].Hash
+	}
+	fmt.Printf("ok: %d entries, head %s\n", len(entries), head)
+}
@@ -0,0 +1,97 @@
+// Command email converts e-receipt messages saved as .eml files into receipt
+// requests. It prints a JSON array of requests, which /receipts/process and
+// cmd/simulate accept, and reports messages that need manual review on
+// stderr.
+//
+//	go run ./cmd/email receipts/*.eml > receipts.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/currency"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/email"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/parse"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/service"
+)
+
+func main() {
+	timezone := flag.String("timezone", "", "IANA time zone of the stores")
+	code := flag.String("currency", "", "ISO 4217 currency of the receipts")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: email [flags] message.eml ... (or - for stdin)\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	paths := flag.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+
+	// An unknown currency is parsed like the default one and then reported
+	// by validation.
+	cur, ok := currency.Lookup(*code)
+	if !ok {
+		cur = currency.MustLookup(currency.Default)
+	}
+
+	reqs := []service.ReqProcessReceipt{}
+	review := false
+	for _, path := range paths {
+		req, err := convert(path, cur)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "needs review %s: %v\n", path, err)
+			review = true
+			continue
+		}
+
+		req.Timezone, req.Currency = *timezone, *code
+		if err := req.IsValid(); err != nil {
+			fmt.Fprintf(os.Stderr, "needs review %s: %v\n", path, err)
+			review = true
+			continue
+		}
+		reqs = append(reqs, *req)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(reqs); err != nil {
+		fmt.Fprintln(os.Stderr, "email:", err)
+		os.Exit(1)
+	}
+
+	if review {
+		os.Exit(1)
+	}
+}
+
+func convert(path string, cur currency.Currency) (*service.ReqProcessReceipt, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	msg, err := email.Read(r)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := parse.Text(msg.Text, cur)
+	if err != nil {
+		return nil, err
+	}
+
+	req := service.ConvertParsedToReq(parsed)
+	return &req, nil
+}
@@ -0,0 +1,310 @@
+// Command receipt validates and scores receipt files without starting the
+// server, printing each receipt's points and per-rule breakdown.
+//
+//	go run ./cmd/receipt examples/*.json
+//	cat receipt.json | go run ./cmd/receipt -format json
+//
+// Files found through a directory or glob that are not receipts, such as a
+// rates or rules file, are skipped with a note on stderr; a file named on its
+// own is always scored.
+//
+// The exit status is 0 when every receipt is valid, 1 when any receipt fails
+// validation, 2 for usage errors and 3 when a file cannot be read.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/catalog"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/currency"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/rules"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/service"
+)
+
+const (
+	exitOK = iota
+	exitInvalid
+	exitUsage
+	exitIO
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+type result struct {
+	Source    string              `json:"source"`
+	Valid     bool                `json:"valid"`
+	Errors    []string            `json:"errors,omitempty"`
+	Retailer  string              `json:"retailer,omitempty"`
+	Total     string              `json:"total,omitempty"`
+	Currency  string              `json:"currency,omitempty"`
+	Points    int64               `json:"points"`
+	Breakdown []models.RulePoints `json:"breakdown,omitempty"`
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("receipt", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", "table", "output format, table or json")
+	rulesPath := flags.String("rules", "", "rule set file (default: built-in rules)")
+	ratesPath := flags.String("rates", "", "exchange-rate table file (default: USD only)")
+	catalogPath := flags.String("catalog", "", "product catalog file, .csv or .json")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: receipt [flags] receipt.json|glob|dir|- ...\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(stderr, "receipt: unknown format %q\n", *format)
+		return exitUsage
+	}
+
+	var opts []service.Option
+	if *rulesPath != "" {
+		compiled, err := rules.LoadFile(*rulesPath)
+		if err != nil {
+			return failed(stderr, err)
+		}
+		opts = append(opts, service.WithRules(compiled))
+	}
+	if *ratesPath != "" {
+		rates, err := currency.LoadRatesFile(*ratesPath)
+		if err != nil {
+			return failed(stderr, err)
+		}
+		opts = append(opts, service.WithRates(rates))
+	}
+	if *catalogPath != "" {
+		c, err := catalog.LoadFile(*catalogPath)
+		if err != nil {
+			return failed(stderr, err)
+		}
+		opts = append(opts, service.WithCatalog(c))
+	}
+	svc := service.NewService(opts...)
+
+	sources := flags.Args()
+	if len(sources) == 0 {
+		sources = []string{"-"}
+	}
+
+	code := exitOK
+	results := []result{}
+	for _, source := range sources {
+		files, err := expand(source)
+		if err != nil {
+			fmt.Fprintln(stderr, "receipt:", err)
+			code = exitIO
+			continue
+		}
+		expanded := len(files) != 1 || files[0] != source
+
+		for _, file := range files {
+			reqs, err := read(file, stdin, expanded)
+			var ioErr *os.PathError
+			if errors.As(err, &ioErr) {
+				fmt.Fprintln(stderr, "receipt:", err)
+				code = exitIO
+				continue
+			}
+			if errors.Is(err, errNotReceipt) {
+				fmt.Fprintf(stderr, "receipt: skipping %s: not a receipt\n", file)
+				continue
+			}
+			if err != nil {
+				results = append(results, result{Source: file, Errors: []string{err.Error()}})
+				continue
+			}
+
+			for i, req := range reqs {
+				name := file
+				if len(reqs) > 1 {
+					name = fmt.Sprintf("%s[%d]", file, i)
+				}
+				results = append(results, score(svc, name, req))
+			}
+		}
+	}
+
+	for _, r := range results {
+		if !r.Valid && code == exitOK {
+			code = exitInvalid
+		}
+	}
+
+	var err error
+	if *format == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(results)
+	} else {
+		err = writeTable(stdout, results)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "receipt:", err)
+		return exitIO
+	}
+
+	return code
+}
+
+func failed(stderr io.Writer, err error) int {
+	fmt.Fprintln(stderr, "receipt:", err)
+	var ioErr *os.PathError
+	if errors.As(err, &ioErr) {
+		return exitIO
+	}
+	return exitUsage
+}
+
+// expand turns a source into files: stdin, a glob, every .json file in a
+// directory, or the file itself.
+func expand(source string) ([]string, error) {
+	if source == "-" {
+		return []string{source}, nil
+	}
+
+	if strings.ContainsAny(source, "*?[") {
+		files, err := filepath.Glob(source)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("%s: no files match", source)
+		}
+		return files, nil
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return filepath.Glob(filepath.Join(source, "*.json"))
+	}
+	return []string{source}, nil
+}
+
+// errNotReceipt is a JSON file with none of a receipt's fields.
+var errNotReceipt = errors.New("not a receipt")
+
+// receiptFields are the fields at least one of which every receipt has.
+var receiptFields = []string{"retailer", "purchaseDate", "purchaseTime", "total", "items"}
+
+// read decodes a single receipt or an array of receipts. With onlyReceipts,
+// JSON that is not a receipt is errNotReceipt.
+func read(file string, stdin io.Reader, onlyReceipts bool) ([]service.ReqProcessReceipt, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data = bytes.TrimSpace(data)
+	if onlyReceipts && !looksLikeReceipt(data) {
+		return nil, errNotReceipt
+	}
+
+	var reqs []service.ReqProcessReceipt
+	if len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &reqs)
+	} else {
+		reqs = make([]service.ReqProcessReceipt, 1)
+		err = json.Unmarshal(data, &reqs[0])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	return reqs, nil
+}
+
+// looksLikeReceipt reports whether data, a JSON object or array of
+// objects, has a receipt field in any object. Other JSON is left for the
+// receipt decoder to report.
+func looksLikeReceipt(data []byte) bool {
+	var objects []map[string]json.RawMessage
+	if len(data) > 0 && data[0] == '[' {
+		if err := json.Unmarshal(data, &objects); err != nil {
+			return true
+		}
+	} else {
+		objects = make([]map[string]json.RawMessage, 1)
+		if err := json.Unmarshal(data, &objects[0]); err != nil {
+			return true
+		}
+	}
+
+	for _, object := range objects {
+		for _, field := range receiptFields {
+			if _, ok := object[field]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func score(svc *service.Service, source string, req service.ReqProcessReceipt) result {
+	r := result{Source: source, Retailer: req.Retailer, Total: req.Total, Currency: req.Currency}
+
+	resp, err := svc.ScoreReceipt(context.Background(), req)
+	if err != nil {
+		for _, line := range strings.Split(err.Error(), "\n") {
+			r.Errors = append(r.Errors, line)
+		}
+		return r
+	}
+
+	r.Valid = true
+	r.Currency = resp.Receipt.Currency
+	r.Points = resp.Receipt.Points
+	r.Breakdown = resp.Receipt.Breakdown
+	return r
+}
+
+func writeTable(w io.Writer, results []result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	for i, r := range results {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+
+		if !r.Valid {
+			fmt.Fprintf(tw, "%s: invalid\n", r.Source)
+			for _, e := range r.Errors {
+				fmt.Fprintf(tw, "  %s\n", e)
+			}
+			continue
+		}
+
+		fmt.Fprintf(tw, "%s: %s %s %s\n", r.Source, r.Retailer, r.Total, r.Currency)
+		fmt.Fprintf(tw, "  RULE\tPOINTS\n")
+		for _, b := range r.Breakdown {
+			fmt.Fprintf(tw, "  %s\t%d\n", b.Name, b.Points)
+		}
+		fmt.Fprintf(tw, "  total\t%d\n", r.Points)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	invalid := write("invalid.json", `{"retailer": "Target"}`)
+	malformed := write("malformed.json", `{"retailer": `)
+	config := filepath.Join(dir, "config")
+	if err := os.Mkdir(config, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	rates := filepath.Join(config, "rates.json")
+	if err := os.WriteFile(rates, []byte(`{"version": "2024-01-01", "base": "USD", "rates": {"EUR": 1.1}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		wantCode int
+		wantOut  string
+	}{
+		{
+			name:     "run: should score an example file",
+			args:     []string{"../../examples/morning-receipt.json"},
+			wantCode: exitOK,
+			wantOut:  "total                15",
+		},
+		{
+			name:     "run: should score files matching a glob",
+			args:     []string{"../../examples/*-receipt.json"},
+			wantCode: exitOK,
+			wantOut:  "simple-receipt.json: Target 1.25 USD",
+		},
+		{
+			name:     "run: should read stdin",
+			stdin:    `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "total": "1.25", "items": [{"shortDescription": "Pepsi", "price": "1.25"}]}`,
+			wantCode: exitOK,
+			wantOut:  "-: Target 1.25 USD",
+		},
+		{
+			name:     "run: should exit 1 for invalid receipts",
+			args:     []string{"../../examples/morning-receipt.json", invalid},
+			wantCode: exitInvalid,
+			wantOut:  "invalid.json: invalid",
+		},
+		{
+			name:     "run: should treat malformed JSON as invalid",
+			args:     []string{malformed},
+			wantCode: exitInvalid,
+			wantOut:  "invalid JSON",
+		},
+		{
+			name:     "run: should score the examples directory",
+			args:     []string{"../../examples/"},
+			wantCode: exitOK,
+			wantOut:  "simple-receipt.json: Target 1.25 USD",
+		},
+		{
+			name:     "run: should skip JSON that is not a receipt in a directory",
+			args:     []string{config, "../../examples/simple-receipt.json"},
+			wantCode: exitOK,
+			wantOut:  "simple-receipt.json: Target 1.25 USD",
+		},
+		{
+			name:     "run: should score a named file that is not a receipt",
+			args:     []string{rates},
+			wantCode: exitInvalid,
+			wantOut:  "rates.json: invalid",
+		},
+		{
+			name:     "run: should exit 3 for missing files",
+			args:     []string{filepath.Join(dir, "missing.json")},
+			wantCode: exitIO,
+		},
+		{
+			name:     "run: should exit 3 when a glob matches nothing",
+			args:     []string{filepath.Join(dir, "*.txt")},
+			wantCode: exitIO,
+		},
+		{
+			name:     "run: should prefer I/O errors over invalid receipts",
+			args:     []string{invalid, filepath.Join(dir, "missing.json")},
+			wantCode: exitIO,
+		},
+		{
+			name:     "run: should exit 2 for unknown formats",
+			args:     []string{"-format", "xml", invalid},
+			wantCode: exitUsage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Errorf("got %d, want %d: %s", code, tt.wantCode, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.wantOut) {
+				t.Errorf("got %q, want it to contain %q", stdout.String(), tt.wantOut)
+			}
+		})
+	}
+}
+
+func TestRunJSON(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-format", "json", "../../examples/morning-receipt.json"}, nil, &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("got %d, want %d: %s", code, exitOK, stderr.String())
+	}
+
+	var results []result
+	if err := json.Unmarshal(stdout.Bytes(), &results); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if len(results) != 1 || !results[0].Valid || results[0].Points != 15 || len(results[0].Breakdown) != 7 {
+		t.Errorf("got %+v, want 15 points from 7 rules", results)
+	}
+}
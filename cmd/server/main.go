@@ -1,10 +1,293 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
 	"github.com/FourSigma/receipt-processor-challenge/pkg/api"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/audit"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/auth"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/catalog"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/config"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/currency"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/jwt"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/logging"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/ratelimit"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/retailer"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/rules"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/service"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/tenant"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/trace"
 )
 
 func main() {
-	a := api.New()
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:", err)
+		os.Exit(2)
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.Logging())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:", err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+	slog.Info("effective config", "config", cfg)
+
+	opts := []api.Option{api.WithConfig(*cfg), api.WithLogger(logger), api.WithVersion(version())}
+
+	// Rate limits and daily quotas share one store, in memory until one
+	// shared between servers is configured.
+	limits := ratelimit.NewMemoryStore()
+
+	// Every tenant's changes go to one audit log, so one chain vouches for
+	// all of them.
+	auditLog := audit.NewLog(audit.WithMaxEntries(cfg.AuditMaxEntries))
+	if cfg.AuditFile != "" {
+		auditLog, err = audit.OpenFile(cfg.AuditFile, audit.WithMaxEntries(cfg.AuditMaxEntries))
+		if err != nil {
+			slog.Error("Failed to open audit log", "err", err)
+			os.Exit(1)
+		}
+	}
+	defer auditLog.Close()
+
+	m := api.NewMetrics()
+	opts = append(opts, api.WithMetrics(m))
+
+	if cfg.TenantsFile == "" {
+		svc, closer, err := newService(*cfg, tenant.Tenant{}, limits, m, auditLog)
+		if err != nil {
+			slog.Error("Failed to start service", "err", err)
+			os.Exit(1)
+		}
+		if closer != nil {
+			defer closer.Close()
+		}
+		opts = append(opts, api.WithService(svc))
+	} else {
+		tenants, err := tenant.LoadFile(cfg.TenantsFile)
+		if err != nil {
+			slog.Error("Failed to load tenants", "err", err)
+			os.Exit(1)
+		}
+
+		services := map[string]*service.Service{}
+		for _, t := range tenants.List() {
+			svc, closer, err := newService(*cfg, t, limits, m, auditLog)
+			if err != nil {
+				slog.Error("Failed to start service", "tenant", t.ID, "err", err)
+				os.Exit(1)
+			}
+			if closer != nil {
+				defer closer.Close()
+			}
+			services[t.ID] = svc
+		}
+		opts = append(opts, api.WithTenants(tenants, services))
+	}
+	if cfg.RateLimits != "" {
+		policy, err := ratelimit.ParsePolicy(cfg.RateLimits)
+		if err != nil {
+			slog.Error("Failed to parse rate limits", "err", err)
+			os.Exit(1)
+		}
+		opts = append(opts, api.WithLimiter(ratelimit.New(policy, ratelimit.WithStore(limits))))
+	}
+
+	if cfg.KeysFile != "" {
+		keys, err := auth.LoadFile(cfg.KeysFile)
+		if err != nil {
+			slog.Error("Failed to load API keys", "err", err)
+			os.Exit(1)
+		}
+		opts = append(opts, api.WithKeys(keys))
+	}
+
+	if cfg.JWKSFile != "" || cfg.JWKSURL != "" {
+		tokens, err := newValidator(*cfg)
+		if err != nil {
+			slog.Error("Failed to load JWKS", "err", err)
+			os.Exit(1)
+		}
+		opts = append(opts, api.WithTokens(tokens))
+	}
+
+	if cfg.TraceFile != "" {
+		exporter, err := trace.NewFileExporter(cfg.TraceFile)
+		if err != nil {
+			slog.Error("Failed to open trace file", "err", err)
+			os.Exit(1)
+		}
+		defer exporter.Close()
+		tracer := trace.New(exporter, trace.WithErrorHandler(func(err error) {
+			slog.Warn("Failed to export span", "err", err)
+		}))
+		opts = append(opts, api.WithTracer(tracer))
+	}
+
+	if cfg.KeysFile == "" && cfg.JWKSFile == "" && cfg.JWKSURL == "" {
+		slog.Warn("No API keys or JWKS are configured, authentication is disabled")
+	}
+
+	a := api.New(opts...)
 	a.Run()
 }
+
+// version is the module version, or the VCS revision of a development
+// build.
+func version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return "dev"
+}
+
+// closers closes each of its closers.
+type closers []io.Closer
+
+func (c closers) Close() error {
+	var err error
+	for _, closer := range c {
+		err = errors.Join(err, closer.Close())
+	}
+	return err
+}
+
+// newValidator loads the JWKS so a bad file or URL fails at startup.
+func newValidator(cfg config.Config) (*jwt.Validator, error) {
+	ttl := time.Duration(cfg.JWKSCacheTTL)
+
+	keys := jwt.NewFileSource(cfg.JWKSFile, ttl)
+	if cfg.JWKSURL != "" {
+		keys = jwt.NewURLSource(cfg.JWKSURL, ttl, &http.Client{Timeout: 10 * time.Second})
+	}
+	if err := keys.Load(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return jwt.NewValidator(keys, cfg.JWTIssuer, cfg.JWTAudience)
+}
+
+// newService loads the files named in the configuration for a tenant, or
+// for the whole deployment when t is the zero Tenant. Each tenant stores its
+// receipts in its own partition of the store path, and its own rule set
+// replaces the server's. Daily quotas are counted in counters, and
+// submissions are reported to observer. Changes are recorded in auditLog.
+// Points adjustments, the review queue, rule set changes and retailer changes
+// are kept beside the receipts, as receipts.adjustments.jsonl,
+// receipts.reviews.jsonl, receipts.rulesets.jsonl and
+// receipts.retailers.jsonl. The returned closer, if any, releases them and
+// the receipt store.
+func newService(cfg config.Config, t tenant.Tenant, counters ratelimit.Store, observer service.Observer, auditLog *audit.Log) (*service.Service, io.Closer, error) {
+	opts := []service.Option{
+		service.WithDailyQuota(cfg.DailyQuota, counters),
+		service.WithObserver(observer),
+		service.WithAudit(auditLog),
+		service.WithReviews(service.NewReviewQueue(cfg.MaxReviews)),
+	}
+	var closer io.Closer
+
+	if t.ID != "" {
+		opts = append(opts, service.WithTenant(t))
+		if t.RulesFile != "" {
+			cfg.RulesFile = t.RulesFile
+		}
+	}
+
+	compiled := rules.MustCompile(rules.Default())
+	if cfg.RulesFile != "" {
+		var err error
+		if compiled, err = rules.LoadFile(cfg.RulesFile); err != nil {
+			return nil, nil, err
+		}
+	}
+	opts = append(opts, service.WithRules(compiled))
+
+	var retailers *retailer.Registry
+	if cfg.RetailersFile != "" {
+		var err error
+		if retailers, err = retailer.LoadFile(cfg.RetailersFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if cfg.Store == config.StoreFile {
+		ext := filepath.Ext(cfg.StorePath)
+		base := strings.TrimSuffix(cfg.StorePath, ext)
+		if t.ID != "" {
+			base += "." + t.ID
+		}
+
+		store, err := service.OpenFileStore(base + ext)
+		if err != nil {
+			return nil, nil, err
+		}
+		ledger, err := service.OpenFileLedger(base + ".adjustments" + ext)
+		if err != nil {
+			store.Close()
+			return nil, nil, err
+		}
+		reviews, err := service.OpenFileReviewQueue(base+".reviews"+ext, cfg.MaxReviews)
+		if err != nil {
+			closers{store, ledger}.Close()
+			return nil, nil, err
+		}
+		registry, err := rules.OpenRegistry(base+".rulesets"+ext, compiled)
+		if err != nil {
+			closers{store, ledger, reviews}.Close()
+			return nil, nil, err
+		}
+		if retailers, err = retailer.OpenRegistry(base+".retailers"+ext, retailers); err != nil {
+			closers{store, ledger, reviews, registry}.Close()
+			return nil, nil, err
+		}
+		opts = append(opts,
+			service.WithStore(store), service.WithLedger(ledger), service.WithReviews(reviews), service.WithRuleRegistry(registry))
+		closer = closers{store, ledger, reviews, registry, retailers}
+	}
+	if retailers != nil {
+		opts = append(opts, service.WithRetailers(retailers))
+	}
+
+	if cfg.CatalogFile != "" {
+		c, err := catalog.LoadFile(cfg.CatalogFile)
+		if err != nil {
+			return nil, closer, err
+		}
+		opts = append(opts, service.WithCatalog(c))
+	}
+
+	if cfg.RatesFile != "" {
+		rates, err := currency.LoadRatesFile(cfg.RatesFile)
+		if err != nil {
+			return nil, closer, err
+		}
+		opts = append(opts, service.WithRates(rates))
+	}
+
+	return service.NewService(opts...), closer, nil
+}
@@ -0,0 +1,139 @@
+// Command simulate replays receipt files through two rule sets and reports
+// how the candidate rules would change the points awarded.
+//
+//	go run ./cmd/simulate -candidate new-rules.json examples/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/rules"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/service"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/simulate"
+)
+
+func main() {
+	basePath := flag.String("base", "", "base rule set file (default: built-in rules)")
+	candidatePath := flag.String("candidate", "", "candidate rule set file (required)")
+	top := flag.Int("top", simulate.DefaultTop, "number of most changed receipts to list")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: simulate [flags] -candidate rules.json receipt.json|dir ...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *candidatePath == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	base := rules.MustCompile(rules.Default())
+	if *basePath != "" {
+		var err error
+		if base, err = rules.LoadFile(*basePath); err != nil {
+			fatal(err)
+		}
+	}
+
+	candidate, err := rules.LoadFile(*candidatePath)
+	if err != nil {
+		fatal(err)
+	}
+
+	var receipts []models.Receipt
+	for _, path := range flag.Args() {
+		rs, err := loadReceipts(path)
+		if err != nil {
+			fatal(err)
+		}
+		receipts = append(receipts, rs...)
+	}
+
+	report := simulate.Run(receipts, base, candidate, *top)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// loadReceipts reads a receipt file, or every .json file in a directory. A
+// file may hold a single receipt or an array of receipts. Invalid receipts
+// are reported and skipped.
+func loadReceipts(path string) ([]models.Receipt, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		paths, err := filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+
+		var receipts []models.Receipt
+		for _, p := range paths {
+			rs, err := loadReceipts(p)
+			if err != nil {
+				return nil, err
+			}
+			receipts = append(receipts, rs...)
+		}
+		return receipts, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var reqs []service.ReqProcessReceipt
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &reqs)
+	} else {
+		reqs = make([]service.ReqProcessReceipt, 1)
+		err = json.Unmarshal(data, &reqs[0])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var receipts []models.Receipt
+	for i, req := range reqs {
+		if err := req.IsValid(); err != nil {
+			fmt.Fprintf(os.Stderr, "skipping %s[%d]: %v\n", path, i, err)
+			continue
+		}
+
+		r, err := service.ConvertReqToReceiptTwo(req)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping %s[%d]: %v\n", path, i, err)
+			continue
+		}
+
+		// Name receipts after their source so the report is readable.
+		r.Id = fmt.Sprintf("%s[%d]", path, i)
+		receipts = append(receipts, r)
+	}
+
+	return receipts, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "simulate:", err)
+	os.Exit(1)
+}
@@ -2,4 +2,4 @@ module github.com/FourSigma/receipt-processor-challenge
 
 go 1.23.5
 
-require github.com/google/uuid v1.6.0 // indirect
+require github.com/google/uuid v1.6.0
@@ -4,74 +4,640 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
+	"math"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/FourSigma/receipt-processor-challenge/pkg/auth"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/config"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/jwt"
 	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/parse"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/ratelimit"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/receiptcsv"
 	"github.com/FourSigma/receipt-processor-challenge/pkg/service"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/tenant"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/tlsconf"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/trace"
 )
 
-func New() API {
-	return API{
+type Option func(*API)
+
+// WithService replaces the default in-memory service.
+func WithService(svc *service.Service) Option {
+	return func(a *API) {
+		a.svc = svc
+	}
+}
+
+// WithConfig replaces config.Default.
+func WithConfig(cfg config.Config) Option {
+	return func(a *API) {
+		a.cfg = cfg
+	}
+}
+
+// WithKeys requires every request to carry an API key from keys. Without it
+// authentication is disabled.
+func WithKeys(keys *auth.Keyring) Option {
+	return func(a *API) {
+		a.keys = keys
+	}
+}
+
+// WithTokens accepts bearer tokens checked by tokens, crediting the token's
+// subject as the user.
+func WithTokens(tokens *jwt.Validator) Option {
+	return func(a *API) {
+		a.tokens = tokens
+	}
+}
+
+// WithTenants serves each tenant in tenants from its own service, keyed by
+// tenant ID. Requests must then resolve to a tenant.
+func WithTenants(tenants *tenant.Registry, services map[string]*service.Service) Option {
+	return func(a *API) {
+		a.tenants = tenants
+		a.services = services
+	}
+}
+
+// WithLimiter rate limits each route per user, client or, for
+// unauthenticated requests, IP address.
+func WithLimiter(limiter *ratelimit.Limiter) Option {
+	return func(a *API) {
+		a.limiter = limiter
+	}
+}
+
+// WithMetrics serves m on GET /metrics and records every request in it.
+// Services report to m when it is also their service.Observer.
+func WithMetrics(m *Metrics) Option {
+	return func(a *API) {
+		a.metrics = m
+	}
+}
+
+// WithTracer traces every request, continuing the caller's trace when the
+// request has a traceparent header.
+func WithTracer(tracer *trace.Tracer) Option {
+	return func(a *API) {
+		a.tracer = tracer
+	}
+}
+
+// WithVersion sets the version reported by GET /status.
+func WithVersion(version string) Option {
+	return func(a *API) {
+		a.version = version
+	}
+}
+
+// WithLogger replaces slog.Default.
+func WithLogger(log *slog.Logger) Option {
+	return func(a *API) {
+		a.log = log
+	}
+}
+
+func New(opts ...Option) API {
+	a := API{
 		svc: service.NewService(),
+		cfg: config.Default(),
+		log: slog.Default(),
+
+		version: "dev",
+		started: time.Now(),
+		ready:   new(atomic.Bool),
+	}
+
+	for _, opt := range opts {
+		opt(&a)
 	}
+
+	if a.metrics != nil {
+		a.metrics.registerStores(a.allServices())
+	}
+
+	// Services have opened their stores and loaded their rules by now.
+	a.ready.Store(true)
+
+	return a
 }
 
 type API struct {
-	svc *service.Service
+	svc    *service.Service
+	cfg    config.Config
+	keys   *auth.Keyring
+	tokens *jwt.Validator
+
+	tenants  *tenant.Registry
+	services map[string]*service.Service
+
+	limiter *ratelimit.Limiter
+	metrics *Metrics
+	tracer  *trace.Tracer
+	log     *slog.Logger
+
+	version string
+	started time.Time
+	// ready is shared by copies of the API, so Run can flip it for the
+	// handler it serves.
+	ready *atomic.Bool
 }
 
-func (a API) Run() {
-	// Routes
+// allServices is every tenant's service, or the service keyed by "" without
+// tenants.
+func (a API) allServices() map[string]*service.Service {
+	if a.tenants == nil {
+		return map[string]*service.Service{"": a.svc}
+	}
+	return a.services
+}
+
+// service is the service of the request's tenant.
+func (a API) service(r *http.Request) *service.Service {
+	if id, ok := tenant.FromContext(r.Context()); ok {
+		return a.services[id]
+	}
+	return a.svc
+}
+
+// Handler routes requests, limiting JSON and text bodies to MaxBodyBytes and
+// uploads to MaxUploadBytes. With keys or tokens configured every route
+// requires credentials with the route's scope, and with tenants every request
+// is resolved to one. With a limiter every route is rate limited, with
+// metrics every request is counted, and with a tracer traced. Every request
+// is given a request ID and logged. The health and readiness probes need no
+// credentials. The admin routes are served too unless AdminAddr is set.
+func (a API) Handler() http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc("POST /receipts/process", a.ProcessReceipt)
-	mux.HandleFunc("GET /receipts/{id}/points", a.GetReceipt)
+	mux.Handle("POST /receipts/process", a.body(auth.ScopeSubmit, a.ProcessReceipt))
+	mux.Handle("POST /receipts/process/text", a.body(auth.ScopeSubmit, a.ProcessReceiptText))
+	mux.Handle("POST /receipts/process/email", a.upload(auth.ScopeSubmit, a.ProcessReceiptEmail))
+	mux.Handle("POST /receipts/import", a.upload(auth.ScopeSubmit, a.ImportReceipts))
+	mux.Handle("GET /receipts/export", a.body(auth.ScopeRead, a.ExportReceipts))
+	mux.Handle("GET /receipts/{id}/points", a.body(auth.ScopeRead, a.GetReceipt))
+	mux.Handle("GET /receipts/{id}/breakdown", a.body(auth.ScopeRead, a.GetBreakdown))
+	if a.cfg.AdminAddr == "" {
+		mux.Handle("/admin/", a.adminMux())
+	}
 
-	// Server setup and shutdown
-	server := &http.Server{
-		Addr:         ":8080",
-		Handler:      mux,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 5 * time.Second,
+	h := a.protected(mux)
+
+	// Probes, status and metrics are for the whole deployment, so they skip
+	// tenant resolution. Status and metrics need the admin scope.
+	root := http.NewServeMux()
+	root.HandleFunc("GET /healthz", a.Healthz)
+	root.HandleFunc("GET /readyz", a.Readyz)
+	root.Handle("GET /status", a.authenticated(requireScope(auth.ScopeAdmin, http.HandlerFunc(a.Status))))
+	if a.metrics != nil {
+		root.Handle("GET /metrics", a.authenticated(requireScope(auth.ScopeAdmin, a.metrics.Registry().Handler())))
+		h = a.metrics.instrument(mux, h)
+	}
+	root.Handle("/", h)
+
+	return a.observed(root, mux, root)
+}
+
+// AdminHandler serves only the admin routes, for a separate admin port. Every
+// admin route needs the admin scope.
+func (a API) AdminHandler() http.Handler {
+	admin := a.adminMux()
+
+	h := a.protected(admin)
+	if a.metrics != nil {
+		h = a.metrics.instrument(admin, h)
+	}
+
+	return a.observed(h, admin)
+}
+
+func (a API) adminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("POST /admin/simulate", a.body(auth.ScopeAdmin, a.Simulate))
+	mux.Handle("GET /admin/retailers", a.body(auth.ScopeAdmin, a.ListRetailers))
+	mux.Handle("GET /admin/retailers/resolve", a.body(auth.ScopeAdmin, a.ResolveRetailer))
+	mux.Handle("GET /admin/retailers/{id}", a.body(auth.ScopeAdmin, a.GetRetailer))
+	mux.Handle("PUT /admin/retailers/{id}", a.body(auth.ScopeAdmin, a.PutRetailer))
+	mux.Handle("DELETE /admin/retailers/{id}", a.body(auth.ScopeAdmin, a.DeleteRetailer))
+	mux.Handle("GET /admin/reviews", a.body(auth.ScopeAdmin, a.ListReviews))
+	mux.Handle("GET /admin/reviews/{id}", a.body(auth.ScopeAdmin, a.GetReview))
+	mux.Handle("DELETE /admin/reviews/{id}", a.body(auth.ScopeAdmin, a.DeleteReview))
+	mux.Handle("GET /admin/rulesets", a.body(auth.ScopeAdmin, a.ListRuleSets))
+	mux.Handle("POST /admin/rulesets", a.body(auth.ScopeAdmin, a.CreateRuleSet))
+	mux.Handle("POST /admin/rulesets/validate", a.body(auth.ScopeAdmin, a.ValidateRuleSet))
+	mux.Handle("GET /admin/rulesets/audit", a.body(auth.ScopeAdmin, a.ListRuleChanges))
+	mux.Handle("GET /admin/rulesets/{version}", a.body(auth.ScopeAdmin, a.GetRuleSet))
+	mux.Handle("POST /admin/rulesets/{version}/activate", a.body(auth.ScopeAdmin, a.ActivateRuleSet))
+	mux.Handle("GET /admin/audit", a.body(auth.ScopeAdmin, a.ListAudit))
+	mux.Handle("POST /admin/adjustments", a.body(auth.ScopeAdmin, a.AdjustPoints))
+	mux.Handle("GET /admin/users/{id}/ledger", a.body(auth.ScopeAdmin, a.GetLedger))
+	return mux
+}
+
+// body wraps a route taking a JSON or text body.
+func (a API) body(scope auth.Scope, h http.HandlerFunc) http.Handler {
+	return a.rateLimit(limitBody(a.cfg.MaxBodyBytes, requireScope(scope, h)))
+}
+
+// upload wraps a route taking an email or CSV upload.
+func (a API) upload(scope auth.Scope, h http.HandlerFunc) http.Handler {
+	return a.rateLimit(limitBody(a.cfg.MaxUploadBytes, requireScope(scope, h)))
+}
+
+// protected authenticates requests and resolves their tenant.
+func (a API) protected(h http.Handler) http.Handler {
+	if a.tenants != nil {
+		h = a.resolveTenant(h)
+	}
+	return a.authenticated(h)
+}
+
+// observed gives requests an ID, traces them and logs them, by the routes
+// muxes match them to.
+func (a API) observed(h http.Handler, muxes ...*http.ServeMux) http.Handler {
+	return requestID(a.traced(a.accessLog(h, muxes...), muxes...))
+}
+
+// authenticated is authenticate when keys or tokens are configured.
+func (a API) authenticated(h http.Handler) http.Handler {
+	if a.keys == nil && a.tokens == nil {
+		return h
+	}
+	return a.authenticate(h)
+}
+
+// TenantHeader names the tenant of a request.
+const TenantHeader = "X-Tenant-ID"
+
+// resolveTenant passes the request's tenant to h in the request context.
+func (a API) resolveTenant(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		id, err := a.tenantID(r)
+		if err != nil {
+			EncodeJSONError(rw, err)
+			return
+		}
+		logAttrs(rw, slog.String("tenant", id))
+		h.ServeHTTP(rw, r.WithContext(tenant.NewContext(r.Context(), id)))
+	})
+}
+
+// tenantID resolves a request's tenant from its credentials, the
+// X-Tenant-ID header or the Host. Credentials bound to a tenant cannot name
+// another; unbound credentials must be admin credentials.
+func (a API) tenantID(r *http.Request) (string, error) {
+	requested := r.Header.Get(TenantHeader)
+	if requested == "" {
+		if t, ok := a.tenants.ByHost(r.Host); ok {
+			requested = t.ID
+		}
+	}
+
+	if p, ok := auth.FromContext(r.Context()); ok {
+		switch {
+		case p.Tenant != "" && requested != "" && requested != p.Tenant:
+			return "", fmt.Errorf("%w: %w", models.ErrForbidden, service.ErrTenantMismatch)
+		case p.Tenant != "":
+			requested = p.Tenant
+		case !p.Has(auth.ScopeAdmin):
+			return "", fmt.Errorf("%w: %w", models.ErrForbidden, auth.ErrTenantUnbound)
+		}
+	}
+
+	if _, ok := a.services[requested]; !ok {
+		return "", fmt.Errorf("%w: %q", models.ErrTenantNotFound, requested)
+	}
+	return requested, nil
+}
+
+// APIKeyHeader carries the API key of a request.
+const APIKeyHeader = "X-API-Key"
+
+// authenticate rejects requests without valid credentials and passes their
+// principal to h in the request context.
+func (a API) authenticate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		p, err := a.principal(r)
+		if err != nil {
+			EncodeJSONError(rw, fmt.Errorf("%w: %w", models.ErrUnauthorized, err))
+			return
+		}
+		logAttrs(rw, slog.String("client_id", p.ClientID), slog.String("user_id", p.UserID))
+		h.ServeHTTP(rw, r.WithContext(auth.NewContext(r.Context(), p)))
+	})
+}
+
+// principal authenticates an API key, a bearer token, or both. A key with a
+// token is a client acting for a user: it keeps the key's client and scopes
+// and credits the token's subject.
+func (a API) principal(r *http.Request) (auth.Principal, error) {
+	key := r.Header.Get(APIKeyHeader)
+	token, bearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !bearer || a.tokens == nil {
+		token = ""
+	}
+
+	var p auth.Principal
+	if key != "" || token == "" {
+		var err error
+		if p, err = a.keys.Authenticate(key); err != nil {
+			return auth.Principal{}, err
+		}
+	}
+	if token == "" {
+		return p, nil
+	}
+
+	claims, err := a.tokens.Validate(r.Context(), strings.TrimSpace(token))
+	if err != nil {
+		return auth.Principal{}, err
+	}
+	if key == "" {
+		p = auth.Principal{ClientID: claims.AuthorizedParty, Tenant: claims.Tenant, Scopes: auth.ParseScopes(claims.Scope)}
+	}
+	p.UserID = claims.Subject
+
+	return p, nil
+}
+
+// requireScope rejects authenticated requests whose key lacks scope.
+// Requests pass when authentication is disabled.
+func requireScope(scope auth.Scope, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if p, ok := auth.FromContext(r.Context()); ok && !p.Has(scope) {
+			EncodeJSONError(rw, fmt.Errorf("%w: %w: %s", models.ErrForbidden, auth.ErrScopeMissing, scope))
+			return
+		}
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// rateLimit takes a token from the caller's bucket for the matched route,
+// reporting the bucket in RateLimit-* headers. Requests pass when the
+// limiter's store fails, so an outage of a shared store does not take the
+// API down.
+func (a API) rateLimit(h http.Handler) http.Handler {
+	if a.limiter == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		res, limited, err := a.limiter.Allow(r.Context(), r.Pattern, caller(r))
+		if err != nil {
+			a.log.WarnContext(r.Context(), "Failed to rate limit request, allowing it", "route", r.Pattern, "err", err)
+		}
+		if !limited || err != nil {
+			h.ServeHTTP(rw, r)
+			return
+		}
+
+		rw.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit.Requests))
+		rw.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		rw.Header().Set("RateLimit-Reset", ceilSeconds(res.Reset))
+		if !res.Allowed {
+			rw.Header().Set("Retry-After", ceilSeconds(res.RetryAfter))
+			EncodeJSONError(rw, fmt.Errorf("%w: %s", models.ErrRateLimited, r.Pattern))
+			return
+		}
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// caller is who a request is rate limited as: its user, its client or its
+// IP address, within its tenant.
+func caller(r *http.Request) string {
+	id, _ := tenant.FromContext(r.Context())
+
+	if p, ok := auth.FromContext(r.Context()); ok {
+		if p.UserID != "" {
+			return id + "|user:" + p.UserID
+		}
+		return id + "|client:" + p.ClientID
 	}
 
-	go func() {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return id + "|ip:" + host
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
 
-		log.Println("Starting server on :8080")
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Fatalf("Failed to start server on :8080 - %s", err)
+func limitBody(n int64, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(rw, r.Body, n)
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// Run serves the API on Addr, and the admin routes on AdminAddr when it is
+// set, until SIGINT or SIGTERM.
+func (a API) Run() {
+	// Server setup and shutdown
+	newServer := func(addr string, h http.Handler) *http.Server {
+		return &http.Server{
+			Addr:         addr,
+			Handler:      h,
+			ReadTimeout:  time.Duration(a.cfg.ReadTimeout),
+			WriteTimeout: time.Duration(a.cfg.WriteTimeout),
 		}
+	}
+	servers := []*http.Server{newServer(a.cfg.Addr, a.Handler())}
+	if a.cfg.AdminAddr != "" {
+		servers = append(servers, newServer(a.cfg.AdminAddr, a.AdminHandler()))
+	}
+
+	listen := (*http.Server).ListenAndServe
+	if a.cfg.TLS().Enabled() {
+		reloader, err := tlsconf.New(a.cfg.TLS())
+		if err != nil {
+			a.log.Error("Failed to load TLS configuration", "err", err)
+			os.Exit(1)
+		}
+		for _, server := range servers {
+			server.TLSConfig = reloader.Config()
+		}
+		listen = func(server *http.Server) error { return server.ListenAndServeTLS("", "") }
+
+		// Certificates are rotated with SIGHUP; connections already open keep
+		// the certificate they were made with.
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := reloader.Reload(); err != nil {
+					a.log.Error("Failed to reload TLS certificates, keeping the previous ones", "err", err)
+					continue
+				}
+				a.log.Info("Reloaded TLS certificates")
+			}
+		}()
+	}
+
+	for _, server := range servers {
+		go func() {
+
+			a.log.Info("Starting server", "addr", server.Addr, "tls", a.cfg.TLS().Enabled())
+			if err := listen(server); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				a.log.Error("Failed to start server", "addr", server.Addr, "err", err)
+				os.Exit(1)
+			}
 
-	}()
+		}()
+	}
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("Starting to server shutdown...")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Fail readiness first, and keep serving for ShutdownDelay so the
+	// orchestrator sees it and stops routing requests here before Shutdown
+	// drains the open ones.
+	a.ready.Store(false)
+	if delay := time.Duration(a.cfg.ShutdownDelay); delay > 0 {
+		a.log.Info("Failing readiness before shutdown", "delay", delay)
+		time.Sleep(delay)
+	}
+	a.log.Info("Starting to server shutdown...")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(a.cfg.ShutdownTimeout))
 	defer cancel()
 
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server shutdown error: %v", err)
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			a.log.Error("Server shutdown error", "addr", server.Addr, "err", err)
+			os.Exit(1)
+		}
 	}
 
-	log.Println("Server gracefully stopped...")
+	a.log.Info("Server gracefully stopped...")
 
 }
 
 func (a API) ProcessReceipt(rw http.ResponseWriter, r *http.Request) {
 	body := service.ReqProcessReceipt{}
 
-	if err := DecodeJSON(r, &body); err != nil {
+	if err := a.decode(r, &body); err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	resp, err := a.service(r).ProcessReceipt(r.Context(), body)
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+	logAttrs(rw, slog.String("receipt_id", resp.Id))
+
+	EncodeJSON(rw, resp, http.StatusOK)
+}
+
+// ProcessReceiptText accepts the receipt as a text/plain body, with optional
+// timezone and currency query parameters, or as a JSON ReqProcessReceiptText.
+func (a API) ProcessReceiptText(rw http.ResponseWriter, r *http.Request) {
+	body := service.ReqProcessReceiptText{}
+
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "application/json" {
+		if err := a.decode(r, &body); err != nil {
+			EncodeJSONError(rw, err)
+			return
+		}
+	} else {
+		text, err := readBody(r)
+		if err != nil {
+			EncodeJSONError(rw, err)
+			return
+		}
+		body.Text = string(text)
+		body.Timezone = r.URL.Query().Get("timezone")
+		body.Currency = r.URL.Query().Get("currency")
+	}
+
+	resp, err := a.service(r).ProcessReceiptText(r.Context(), body)
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+	logAttrs(rw, slog.String("receipt_id", resp.Id))
+
+	EncodeJSON(rw, resp, http.StatusOK)
+}
+
+// ProcessReceiptEmail accepts a raw message/rfc822 body, with optional
+// timezone and currency query parameters. Messages queued for review are
+// answered with 202 Accepted.
+func (a API) ProcessReceiptEmail(rw http.ResponseWriter, r *http.Request) {
+	message, err := readBody(r)
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	req := service.ReqProcessReceiptEmail{
+		Message:  string(message),
+		Timezone: r.URL.Query().Get("timezone"),
+		Currency: r.URL.Query().Get("currency"),
+	}
+
+	resp, err := a.service(r).ProcessReceiptEmail(r.Context(), req)
+	if err != nil {
+		EncodeJSONError(rw, err)
 		return
 	}
 
-	resp, err := a.svc.ProcessReceipt(r.Context(), body)
+	code := http.StatusOK
+	if resp.ReviewId != "" {
+		code = http.StatusAccepted
+		logAttrs(rw, slog.String("review_id", resp.ReviewId))
+	} else {
+		logAttrs(rw, slog.String("receipt_id", resp.Id))
+	}
+	EncodeJSON(rw, resp, code)
+}
+
+// ImportReceipts accepts a text/csv body. Query parameters named like the
+// fields of receiptcsv.Mapping, such as ?key=order_id, override its default
+// column names.
+func (a API) ImportReceipts(rw http.ResponseWriter, r *http.Request) {
+	data, err := readBody(r)
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	req := service.ReqImportReceipts{
+		CSV:     string(data),
+		Mapping: receiptcsv.DefaultMapping(),
+	}
+
+	if query := r.URL.Query(); len(query) > 0 {
+		columns := map[string]string{}
+		for name := range query {
+			columns[name] = query.Get(name)
+		}
+		b, _ := json.Marshal(columns)
+		if err := json.Unmarshal(b, &req.Mapping); err != nil {
+			EncodeJSONError(rw, fmt.Errorf("%w: %w", models.ErrInvalidInput, err))
+			return
+		}
+	}
+
+	resp, err := a.service(r).ImportReceipts(r.Context(), req)
 	if err != nil {
 		EncodeJSONError(rw, err)
 		return
@@ -80,12 +646,29 @@ func (a API) ProcessReceipt(rw http.ResponseWriter, r *http.Request) {
 	EncodeJSON(rw, resp, http.StatusOK)
 }
 
+// ExportReceipts writes the stored receipts as CSV, with their points and
+// one column per rule.
+func (a API) ExportReceipts(rw http.ResponseWriter, r *http.Request) {
+	resp, err := a.service(r).ExportReceipts(r.Context(), service.ReqExportReceipts{})
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/csv")
+	rw.Header().Set("Content-Disposition", `attachment; filename="receipts.csv"`)
+	if err := receiptcsv.Write(rw, resp.Receipts); err != nil {
+		logAttrs(rw, slog.Any("err", err))
+	}
+}
+
 func (a API) GetReceipt(rw http.ResponseWriter, r *http.Request) {
 	req := service.ReqGetPoints{
 		Id: r.PathValue("id"),
 	}
+	logAttrs(rw, slog.String("receipt_id", req.Id))
 
-	resp, err := a.svc.GetPoints(r.Context(), req)
+	resp, err := a.service(r).GetPoints(r.Context(), req)
 	if err != nil {
 		EncodeJSONError(rw, err)
 		return
@@ -94,24 +677,366 @@ func (a API) GetReceipt(rw http.ResponseWriter, r *http.Request) {
 	EncodeJSON(rw, resp, http.StatusOK)
 }
 
-func DecodeJSON(r *http.Request, val any) error {
-	defer r.Body.Close()
-	return json.NewDecoder(r.Body).Decode(val)
+func (a API) GetBreakdown(rw http.ResponseWriter, r *http.Request) {
+	req := service.ReqGetBreakdown{
+		Id: r.PathValue("id"),
+	}
+	logAttrs(rw, slog.String("receipt_id", req.Id))
+
+	resp, err := a.service(r).GetBreakdown(r.Context(), req)
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	EncodeJSON(rw, resp, http.StatusOK)
+}
+
+// AdjustPoints answers 201 Created with the adjustment and the user's new
+// balance.
+func (a API) AdjustPoints(rw http.ResponseWriter, r *http.Request) {
+	body := service.ReqAdjustPoints{}
+
+	if err := a.decode(r, &body); err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	resp, err := a.service(r).AdjustPoints(r.Context(), body)
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+	logAttrs(rw, slog.String("adjustment_id", resp.Id), slog.String("user_id", resp.UserID))
+
+	rw.Header().Set("Location", "/admin/users/"+url.PathEscape(resp.UserID)+"/ledger")
+	EncodeJSON(rw, resp, http.StatusCreated)
+}
+
+func (a API) GetLedger(rw http.ResponseWriter, r *http.Request) {
+	req := service.ReqGetLedger{
+		UserID: r.PathValue("id"),
+	}
+
+	resp, err := a.service(r).GetLedger(r.Context(), req)
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	EncodeJSON(rw, resp, http.StatusOK)
+}
+
+func (a API) Simulate(rw http.ResponseWriter, r *http.Request) {
+	body := service.ReqSimulate{}
+
+	if err := a.decode(r, &body); err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	resp, err := a.service(r).Simulate(r.Context(), body)
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	EncodeJSON(rw, resp, http.StatusOK)
+}
+
+func (a API) ListRetailers(rw http.ResponseWriter, r *http.Request) {
+	resp, err := a.service(r).ListRetailers(r.Context(), service.ReqListRetailers{})
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	EncodeJSON(rw, resp, http.StatusOK)
+}
+
+func (a API) ResolveRetailer(rw http.ResponseWriter, r *http.Request) {
+	req := service.ReqResolveRetailer{
+		Name: r.URL.Query().Get("name"),
+	}
+
+	resp, err := a.service(r).ResolveRetailer(r.Context(), req)
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	EncodeJSON(rw, resp, http.StatusOK)
+}
+
+func (a API) GetRetailer(rw http.ResponseWriter, r *http.Request) {
+	req := service.ReqGetRetailer{
+		Id: r.PathValue("id"),
+	}
+
+	resp, err := a.service(r).GetRetailer(r.Context(), req)
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	EncodeJSON(rw, resp, http.StatusOK)
+}
+
+func (a API) PutRetailer(rw http.ResponseWriter, r *http.Request) {
+	body := service.ReqPutRetailer{}
+
+	if err := a.decode(r, &body); err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+	body.ID = r.PathValue("id")
+
+	resp, err := a.service(r).PutRetailer(r.Context(), body)
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	EncodeJSON(rw, resp, http.StatusOK)
+}
+
+func (a API) DeleteRetailer(rw http.ResponseWriter, r *http.Request) {
+	req := service.ReqDeleteRetailer{
+		Id: r.PathValue("id"),
+	}
+
+	if _, err := a.service(r).DeleteRetailer(r.Context(), req); err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (a API) ListReviews(rw http.ResponseWriter, r *http.Request) {
+	resp, err := a.service(r).ListReviews(r.Context(), service.ReqListReviews{})
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	EncodeJSON(rw, resp, http.StatusOK)
+}
+
+func (a API) GetReview(rw http.ResponseWriter, r *http.Request) {
+	req := service.ReqGetReview{
+		Id: r.PathValue("id"),
+	}
+
+	resp, err := a.service(r).GetReview(r.Context(), req)
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	EncodeJSON(rw, resp, http.StatusOK)
+}
+
+func (a API) DeleteReview(rw http.ResponseWriter, r *http.Request) {
+	req := service.ReqDeleteReview{
+		Id: r.PathValue("id"),
+	}
+
+	if _, err := a.service(r).DeleteReview(r.Context(), req); err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (a API) ListRuleSets(rw http.ResponseWriter, r *http.Request) {
+	resp, err := a.service(r).ListRuleSets(r.Context(), service.ReqListRuleSets{})
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	EncodeJSON(rw, resp, http.StatusOK)
+}
+
+func (a API) GetRuleSet(rw http.ResponseWriter, r *http.Request) {
+	req := service.ReqGetRuleSet{
+		Version: r.PathValue("version"),
+	}
+
+	resp, err := a.service(r).GetRuleSet(r.Context(), req)
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	EncodeJSON(rw, resp, http.StatusOK)
+}
+
+// CreateRuleSet answers 201 Created with the new, inactive version.
+func (a API) CreateRuleSet(rw http.ResponseWriter, r *http.Request) {
+	body := service.ReqCreateRuleSet{}
+
+	if err := a.decode(r, &body); err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	resp, err := a.service(r).CreateRuleSet(r.Context(), body)
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Location", "/admin/rulesets/"+url.PathEscape(resp.RuleSet.Version))
+	EncodeJSON(rw, resp, http.StatusCreated)
+}
+
+func (a API) ValidateRuleSet(rw http.ResponseWriter, r *http.Request) {
+	body := service.ReqValidateRuleSet{}
+
+	if err := a.decode(r, &body); err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	resp, err := a.service(r).ValidateRuleSet(r.Context(), body)
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	EncodeJSON(rw, resp, http.StatusOK)
+}
+
+// ActivateRuleSet takes an optional JSON body with the time to activate at.
+func (a API) ActivateRuleSet(rw http.ResponseWriter, r *http.Request) {
+	body := service.ReqActivateRuleSet{}
+
+	if r.ContentLength != 0 {
+		if err := a.decode(r, &body); err != nil {
+			EncodeJSONError(rw, err)
+			return
+		}
+	}
+	body.Version = r.PathValue("version")
+
+	resp, err := a.service(r).ActivateRuleSet(r.Context(), body)
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	EncodeJSON(rw, resp, http.StatusOK)
+}
+
+func (a API) ListRuleChanges(rw http.ResponseWriter, r *http.Request) {
+	resp, err := a.service(r).ListRuleChanges(r.Context(), service.ReqListRuleChanges{})
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	EncodeJSON(rw, resp, http.StatusOK)
+}
+
+// ListAudit filters the audit log by the actor, action, target, since, until
+// and limit query parameters. Times are RFC 3339.
+func (a API) ListAudit(rw http.ResponseWriter, r *http.Request) {
+	req, err := auditRequest(r.URL.Query())
+	if err != nil {
+		EncodeJSONError(rw, fmt.Errorf("%w: %w", models.ErrInvalidInput, err))
+		return
+	}
+
+	resp, err := a.service(r).ListAudit(r.Context(), req)
+	if err != nil {
+		EncodeJSONError(rw, err)
+		return
+	}
+
+	EncodeJSON(rw, resp, http.StatusOK)
+}
+
+func auditRequest(q url.Values) (service.ReqListAudit, error) {
+	req := service.ReqListAudit{
+		Actor:  q.Get("actor"),
+		Action: q.Get("action"),
+		Target: q.Get("target"),
+	}
+
+	var err error
+	if v := q.Get("since"); v != "" {
+		if req.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return req, fmt.Errorf("since: %w", err)
+		}
+	}
+	if v := q.Get("until"); v != "" {
+		if req.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return req, fmt.Errorf("until: %w", err)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if req.Limit, err = strconv.Atoi(v); err != nil {
+			return req, fmt.Errorf("limit: %w", err)
+		}
+	}
+	return req, nil
+}
+
+// decode is DecodeJSON with the configured strictness.
+func (a API) decode(r *http.Request, val any) error {
+	_, span := trace.Start(r.Context(), "decode")
+	defer span.End()
+
+	var opts []DecodeOption
+	if a.cfg.DisallowUnknownFields {
+		opts = append(opts, DisallowUnknownFields())
+	}
+	err := DecodeJSON(r, val, opts...)
+	span.RecordError(err)
+	return err
 }
 
 func EncodeJSON(rw http.ResponseWriter, val any, code int) {
-	rw.WriteHeader(code)
 	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
 	if err := json.NewEncoder(rw).Encode(val); err != nil {
-		log.Println(err)
+		logAttrs(rw, slog.Any("err", err))
 	}
 }
 
+// Problem is an RFC 9457 problem details response.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Errors and Parsed extend problems for text receipts that were parsed
+	// but failed validation.
+	Errors []string       `json:"errors,omitempty"`
+	Parsed *parse.Receipt `json:"parsed,omitempty"`
+}
+
+// EncodeJSONError writes err as a problem response. The title is the models
+// error err wraps; only request decoding errors add a detail, and parsed text
+// receipts their validation errors and parsed fields, so internal errors are
+// never shown to clients.
 func EncodeJSONError(rw http.ResponseWriter, err error) {
 	code := http.StatusInternalServerError
 	message := "internal server error"
 
 	switch {
+	case errors.Is(err, models.ErrBodyTooLarge):
+		code = http.StatusRequestEntityTooLarge
+		message = models.ErrBodyTooLarge.Error()
+
+	case errors.Is(err, models.ErrMediaTypeInvalid):
+		code = http.StatusUnsupportedMediaType
+		message = models.ErrMediaTypeInvalid.Error()
+		rw.Header().Set("Accept", "application/json")
+
 	case errors.Is(err, models.ErrInvalidInput):
 		code = http.StatusBadRequest
 		message = models.ErrInvalidInput.Error()
@@ -119,7 +1044,63 @@ func EncodeJSONError(rw http.ResponseWriter, err error) {
 	case errors.Is(err, models.ErrNotFound):
 		code = http.StatusNotFound
 		message = models.ErrNotFound.Error()
+
+	case errors.Is(err, models.ErrRetailerNotFound):
+		code = http.StatusNotFound
+		message = models.ErrRetailerNotFound.Error()
+
+	case errors.Is(err, models.ErrRuleSetNotFound):
+		code = http.StatusNotFound
+		message = models.ErrRuleSetNotFound.Error()
+
+	case errors.Is(err, models.ErrConflict):
+		code = http.StatusConflict
+		message = models.ErrConflict.Error()
+
+	case errors.Is(err, models.ErrUnauthorized):
+		code = http.StatusUnauthorized
+		message = models.ErrUnauthorized.Error()
+		rw.Header().Add("WWW-Authenticate", `APIKey header="`+APIKeyHeader+`"`)
+		rw.Header().Add("WWW-Authenticate", `Bearer`)
+
+	case errors.Is(err, models.ErrForbidden):
+		code = http.StatusForbidden
+		message = models.ErrForbidden.Error()
+
+	case errors.Is(err, models.ErrTenantNotFound):
+		code = http.StatusNotFound
+		message = models.ErrTenantNotFound.Error()
+
+	case errors.Is(err, models.ErrQuotaExceeded):
+		code = http.StatusTooManyRequests
+		message = models.ErrQuotaExceeded.Error()
+
+	case errors.Is(err, models.ErrRateLimited):
+		code = http.StatusTooManyRequests
+		message = models.ErrRateLimited.Error()
+	}
+
+	if code == http.StatusInternalServerError {
+		logAttrs(rw, slog.Any("err", err))
 	}
 
-	http.Error(rw, message, code)
+	problem := Problem{Type: "about:blank", Title: message, Status: code}
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) {
+		problem.Detail = decodeErr.Error()
+	}
+	var parsedErr *service.ParsedError
+	if errors.As(err, &parsedErr) {
+		problem.Parsed = parsedErr.Parsed
+		for _, cause := range service.Causes(err) {
+			problem.Errors = append(problem.Errors, cause.Error())
+		}
+	}
+
+	rw.Header().Set("Content-Type", "application/problem+json")
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.WriteHeader(code)
+	if err := json.NewEncoder(rw).Encode(problem); err != nil {
+		logAttrs(rw, slog.Any("write_err", err))
+	}
 }
@@ -1,12 +1,33 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/FourSigma/receipt-processor-challenge/pkg/auth"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/config"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/jwt"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/logging"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/ratelimit"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/requestid"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/rules"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/service"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/tenant"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/trace"
 	"github.com/google/uuid"
 )
 
@@ -170,3 +191,1056 @@ func TestAPISadPath(t *testing.T) {
 		}
 	})
 }
+
+func TestAPIProcessReceiptText(t *testing.T) {
+	api := New()
+
+	t.Run("POST /receipts/process/text should accept plain text", func(t *testing.T) {
+		text := strings.NewReader("Target\n2022-01-01 13:01\nPepsi 12PK 6.49\nTOTAL 6.49")
+		req := httptest.NewRequest("POST", "/receipts/process/text?timezone=America/Chicago", text)
+		req.Header.Set("Content-Type", "text/plain")
+
+		rec := httptest.NewRecorder()
+		api.ProcessReceiptText(rec, req)
+		if rec.Code != 200 {
+			t.Fatal("got", rec.Code, "want 200", rec.Body.String())
+		}
+
+		var response struct {
+			Id     string
+			Parsed struct {
+				Total struct {
+					Value      string
+					Confidence float64
+				}
+			}
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if _, err := uuid.Parse(response.Id); err != nil {
+			t.Errorf("got %v, want nil", err)
+		}
+		if response.Parsed.Total.Value != "6.49" || response.Parsed.Total.Confidence == 0 {
+			t.Errorf("got %+v, want total 6.49 with a confidence", response.Parsed.Total)
+		}
+	})
+
+	t.Run("POST /receipts/process/text should accept JSON", func(t *testing.T) {
+		body := strings.NewReader(`{"text": "Target\n2022-01-01 13:01\nPepsi 6.49\nTOTAL 6.49", "currency": "USD"}`)
+		req := httptest.NewRequest("POST", "/receipts/process/text", body)
+		req.Header.Set("Content-Type", "application/json")
+
+		rec := httptest.NewRecorder()
+		api.ProcessReceiptText(rec, req)
+		if rec.Code != 200 {
+			t.Error("got", rec.Code, "want 200", rec.Body.String())
+		}
+	})
+
+	t.Run("POST /receipts/process/text should return the parsed fields of an invalid receipt", func(t *testing.T) {
+		text := strings.NewReader("Target\nPepsi 6.49\nTOTAL 6.49")
+		req := httptest.NewRequest("POST", "/receipts/process/text", text)
+
+		rec := httptest.NewRecorder()
+		api.ProcessReceiptText(rec, req)
+		if rec.Code != 400 {
+			t.Fatal("got", rec.Code, "want 400", rec.Body.String())
+		}
+
+		var problem Problem
+		if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if problem.Parsed == nil || problem.Parsed.Total.Value != "6.49" || problem.Parsed.Total.Confidence == 0 {
+			t.Errorf("got %+v, want the parsed total 6.49 with a confidence", problem.Parsed)
+		}
+		if !slices.Contains(problem.Errors, service.ErrPurchaseDateEmpty.Error()) {
+			t.Errorf("got %v, want %q", problem.Errors, service.ErrPurchaseDateEmpty)
+		}
+	})
+
+	t.Run("POST /receipts/process/text should reject unparseable text", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/receipts/process/text", strings.NewReader("hello"))
+
+		rec := httptest.NewRecorder()
+		api.ProcessReceiptText(rec, req)
+		if rec.Code != 400 {
+			t.Error("got", rec.Code, "want 400")
+		}
+	})
+}
+
+func TestAPIProcessReceiptEmail(t *testing.T) {
+	api := New()
+
+	t.Run("POST /receipts/process/email should process a message", func(t *testing.T) {
+		message, err := os.ReadFile("../../examples/receipt.eml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("POST", "/receipts/process/email", bytes.NewReader(message))
+
+		rec := httptest.NewRecorder()
+		api.ProcessReceiptEmail(rec, req)
+		if rec.Code != 200 {
+			t.Error("got", rec.Code, "want 200", rec.Body.String())
+		}
+	})
+
+	t.Run("POST /receipts/process/email should accept unparseable messages for review", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/receipts/process/email", strings.NewReader("Subject: hi\r\n\r\nhello\n"))
+
+		rec := httptest.NewRecorder()
+		api.ProcessReceiptEmail(rec, req)
+		if rec.Code != 202 {
+			t.Fatal("got", rec.Code, "want 202")
+		}
+
+		var response map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		id, _ := response["reviewId"].(string)
+
+		recReview := httptest.NewRecorder()
+		reqReview := httptest.NewRequest("GET", "/admin/reviews/"+id, nil)
+		reqReview.SetPathValue("id", id)
+		api.GetReview(recReview, reqReview)
+		if recReview.Code != 200 {
+			t.Error("got", recReview.Code, "want 200")
+		}
+	})
+}
+
+func TestAPIImportExportReceipts(t *testing.T) {
+	api := New()
+
+	t.Run("POST /receipts/import should import with a column mapping", func(t *testing.T) {
+		csv := "order,retailer,purchaseDate,purchaseTime,total,item,price\n" +
+			"1,Target,2022-01-01,13:01,6.49,Pepsi,6.49\n" +
+			"2,Target,2022-01-01,13:01,,Pepsi,6.49\n"
+		req := httptest.NewRequest("POST", "/receipts/import?key=order&shortDescription=item", strings.NewReader(csv))
+
+		rec := httptest.NewRecorder()
+		api.ImportReceipts(rec, req)
+		if rec.Code != 200 {
+			t.Fatal("got", rec.Code, "want 200", rec.Body.String())
+		}
+
+		var response struct {
+			Imported []map[string]any
+			Errors   []map[string]any
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if len(response.Imported) != 1 || len(response.Errors) != 1 || response.Errors[0]["line"] != 3.0 {
+			t.Errorf("got %+v, want one import and an error on line 3", response)
+		}
+	})
+
+	t.Run("GET /receipts/export should write CSV", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		api.ExportReceipts(rec, httptest.NewRequest("GET", "/receipts/export", nil))
+		if rec.Code != 200 || rec.Header().Get("Content-Type") != "text/csv" {
+			t.Fatal("got", rec.Code, rec.Header().Get("Content-Type"), "want 200 text/csv")
+		}
+
+		lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+		if len(lines) != 2 || !strings.Contains(lines[0], "rule:alphanumeric") || !strings.Contains(lines[1], ",Target,") {
+			t.Errorf("got %q, want a header and the imported receipt", lines)
+		}
+	})
+
+	t.Run("POST /receipts/import should reject a file without the mapped columns", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		api.ImportReceipts(rec, httptest.NewRequest("POST", "/receipts/import", strings.NewReader("a,b\n1,2\n")))
+		if rec.Code != 400 {
+			t.Error("got", rec.Code, "want 400")
+		}
+	})
+}
+
+func TestAPIBodyLimits(t *testing.T) {
+	cfg := config.Default()
+	cfg.MaxBodyBytes = 64
+	handler := New(WithConfig(cfg)).Handler()
+
+	t.Run("Handler: should reject bodies over MaxBodyBytes", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/receipts/process", strings.NewReader(EXAMPLE2)))
+		if rec.Code != 413 {
+			t.Error("got", rec.Code, "want 413")
+		}
+	})
+
+	t.Run("Handler: should reject uploads over MaxUploadBytes", func(t *testing.T) {
+		cfg := cfg
+		cfg.MaxUploadBytes = 16
+		rec := httptest.NewRecorder()
+		New(WithConfig(cfg)).Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/receipts/process/email", strings.NewReader("Subject: hi\r\n\r\nhello\n")))
+		if rec.Code != 413 {
+			t.Error("got", rec.Code, "want 413")
+		}
+	})
+
+	t.Run("Handler: should allow uploads up to MaxUploadBytes", func(t *testing.T) {
+		csv := "receipt,retailer,purchaseDate,purchaseTime,total,shortDescription,price\n" +
+			"1,Target,2022-01-01,13:01,6.49,Pepsi,6.49\n"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/receipts/import", strings.NewReader(csv)))
+		if rec.Code != 200 {
+			t.Error("got", rec.Code, "want 200", rec.Body.String())
+		}
+	})
+}
+
+func TestAPIDecodeJSON(t *testing.T) {
+	receipt := `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "total": "1.25", "items": [{"shortDescription": "Pepsi", "price": "1.25"}]`
+
+	tests := []struct {
+		name        string
+		strict      bool
+		maxBody     int64
+		contentType string
+		body        string
+		wantCode    int
+		wantDetail  error
+	}{
+		{name: "DecodeJSON: should accept a body without a content type", body: receipt + "}", wantCode: 200},
+		{name: "DecodeJSON: should accept a JSON content type with parameters", contentType: "application/json; charset=utf-8", body: receipt + "}", wantCode: 200},
+		{name: "DecodeJSON: should ignore unknown fields by default", body: receipt + `, "note": "hi"}`, wantCode: 200},
+		{name: "DecodeJSON: should reject unknown fields when configured", strict: true, body: receipt + `, "note": "hi"}`, wantCode: 400, wantDetail: ErrUnknownField},
+		{name: "DecodeJSON: should reject another content type", contentType: "application/x-www-form-urlencoded", body: receipt + "}", wantCode: 415, wantDetail: ErrContentType},
+		{name: "DecodeJSON: should reject trailing data", body: receipt + `} {"retailer": "Walmart"}`, wantCode: 400, wantDetail: ErrTrailingData},
+		{name: "DecodeJSON: should reject a repeated key", body: receipt + `, "total": "9.99"}`, wantCode: 400, wantDetail: ErrDuplicateKey},
+		{name: "DecodeJSON: should reject a repeated key in an item", body: strings.Replace(receipt, `"price": "1.25"`, `"price": "1.25", "price": "0.01"`, 1) + "}", wantCode: 400, wantDetail: ErrDuplicateKey},
+		{name: "DecodeJSON: should reject a key repeated in another case", body: receipt + `, "Total": "9.99"}`, wantCode: 400, wantDetail: ErrDuplicateKey},
+		{name: "DecodeJSON: should reject a body nested too deeply", body: receipt + `, "note": ` + strings.Repeat("[", MaxJSONDepth) + strings.Repeat("]", MaxJSONDepth) + "}", wantCode: 400, wantDetail: ErrJSONDepth},
+		{name: "DecodeJSON: should accept nesting up to the limit", body: receipt + `, "note": ` + strings.Repeat("[", MaxJSONDepth-1) + strings.Repeat("]", MaxJSONDepth-1) + "}", wantCode: 200},
+		{name: "DecodeJSON: should reject a truncated body", body: receipt, wantCode: 400, wantDetail: ErrJSONSyntax},
+		{name: "DecodeJSON: should reject a value of the wrong type", body: `{"retailer": 1}`, wantCode: 400, wantDetail: ErrJSONType},
+		{name: "DecodeJSON: should reject a body over the limit", maxBody: 16, body: receipt + "}", wantCode: 413, wantDetail: ErrBodyLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.DisallowUnknownFields = tt.strict
+			if tt.maxBody > 0 {
+				cfg.MaxBodyBytes = tt.maxBody
+			}
+
+			req := httptest.NewRequest("POST", "/receipts/process", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			New(WithConfig(cfg)).Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatal("got", rec.Code, "want", tt.wantCode, rec.Body.String())
+			}
+			if tt.wantDetail == nil {
+				return
+			}
+
+			if got := rec.Header().Get("Content-Type"); got != "application/problem+json" {
+				t.Errorf("got Content-Type %q, want application/problem+json", got)
+			}
+			var problem Problem
+			if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+				t.Fatal(err)
+			}
+			if problem.Status != tt.wantCode || !strings.HasPrefix(problem.Detail, tt.wantDetail.Error()) {
+				t.Errorf("got %+v, want status %d and detail %q", problem, tt.wantCode, tt.wantDetail)
+			}
+		})
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	keys, err := auth.NewKeyring([]auth.Key{
+		{ID: "acme", ClientID: "acme", Hash: auth.Hash("rk_acme"), Scopes: []auth.Scope{auth.ScopeSubmit, auth.ScopeRead}},
+		{ID: "globex", ClientID: "globex", Hash: auth.Hash("rk_globex"), Scopes: []auth.Scope{auth.ScopeRead}},
+		{ID: "ops", ClientID: "ops", Hash: auth.Hash("rk_ops"), Scopes: []auth.Scope{auth.ScopeRead, auth.ScopeAdmin}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := New(WithKeys(keys)).Handler()
+
+	do := func(method, target, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(APIKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("POST", "/receipts/process", "rk_acme", EXAMPLE1)
+	if rec.Code != 200 {
+		t.Fatal("got", rec.Code, "want 200", rec.Body.String())
+	}
+	var created struct{ Id string }
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	points := "/receipts/" + created.Id + "/points"
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		key      string
+		body     string
+		wantCode int
+	}{
+		{name: "Handler: should reject a request without a key", method: "GET", target: points, wantCode: 401},
+		{name: "Handler: should reject an unknown key", method: "GET", target: points, key: "rk_nope", wantCode: 401},
+		{name: "Handler: should reject a key without the route's scope", method: "POST", target: "/receipts/process", key: "rk_globex", body: EXAMPLE1, wantCode: 403},
+		{name: "Handler: should reject admin routes without the admin scope", method: "GET", target: "/admin/reviews", key: "rk_acme", wantCode: 403},
+		{name: "Handler: should let a client read its own receipt", method: "GET", target: points, key: "rk_acme", wantCode: 200},
+		{name: "Handler: should hide a receipt from other clients", method: "GET", target: points, key: "rk_globex", wantCode: 404},
+		{name: "Handler: should let admins read any receipt", method: "GET", target: points, key: "rk_ops", wantCode: 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(tt.method, tt.target, tt.key, tt.body)
+			if rec.Code != tt.wantCode {
+				t.Error("got", rec.Code, "want", tt.wantCode, rec.Body.String())
+			}
+			if tt.wantCode == 401 && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("got no WWW-Authenticate header, want one")
+			}
+		})
+	}
+
+	t.Run("Handler: should export only the client's receipts", func(t *testing.T) {
+		for key, want := range map[string]int{"rk_acme": 2, "rk_globex": 1, "rk_ops": 2} {
+			rec := do("GET", "/receipts/export", key, "")
+			if lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n"); len(lines) != want {
+				t.Errorf("%s: got %d lines, want %d", key, len(lines), want)
+			}
+		}
+	})
+}
+
+func TestAPITokens(t *testing.T) {
+	secret := []byte("a shared secret of thirty-two bytes")
+	jwks := filepath.Join(t.TempDir(), "jwks.json")
+	doc := `{"keys": [{"kty": "oct", "kid": "hs-1", "k": "` + base64.RawURLEncoding.EncodeToString(secret) + `"}]}`
+	if err := os.WriteFile(jwks, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := jwt.NewValidator(jwt.NewFileSource(jwks, time.Hour), "https://id.example.com", "receipts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.NewKeyring([]auth.Key{
+		{ID: "acme", ClientID: "acme", Hash: auth.Hash("rk_acme"), Scopes: []auth.Scope{auth.ScopeSubmit, auth.ScopeRead}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := New(WithKeys(keys), WithTokens(tokens)).Handler()
+
+	token := func(sub string) string {
+		h := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","kid":"hs-1"}`))
+		c, _ := json.Marshal(map[string]any{"sub": sub, "iss": "https://id.example.com", "aud": "receipts", "exp": time.Now().Add(time.Hour).Unix()})
+		signed := h + "." + base64.RawURLEncoding.EncodeToString(c)
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	do := func(method, target, key, bearer, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(APIKeyHeader, key)
+		}
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	submit := func(key, bearer string) string {
+		rec := do("POST", "/receipts/process", key, bearer, EXAMPLE1)
+		if rec.Code != 200 {
+			t.Fatal("got", rec.Code, "want 200", rec.Body.String())
+		}
+		var created struct{ Id string }
+		if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+			t.Fatal(err)
+		}
+		return "/receipts/" + created.Id + "/points"
+	}
+
+	alice := submit("", token("alice"))
+	credited := submit("rk_acme", token("bob"))
+
+	tests := []struct {
+		name     string
+		target   string
+		key      string
+		bearer   string
+		wantCode int
+	}{
+		{name: "Handler: should let a user read their receipt", target: alice, bearer: token("alice"), wantCode: 200},
+		{name: "Handler: should hide a user's receipt from other users", target: alice, bearer: token("bob"), wantCode: 404},
+		{name: "Handler: should credit the user of a client's request", target: credited, bearer: token("bob"), wantCode: 200},
+		{name: "Handler: should reject an invalid token", target: alice, bearer: token("alice") + "x", wantCode: 401},
+		{name: "Handler: should reject a valid token with an unknown key", target: alice, key: "rk_nope", bearer: token("alice"), wantCode: 401},
+		{name: "Handler: should still accept API keys alone", target: credited, key: "rk_acme", wantCode: 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := do("GET", tt.target, tt.key, tt.bearer, ""); rec.Code != tt.wantCode {
+				t.Error("got", rec.Code, "want", tt.wantCode, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAPITenants(t *testing.T) {
+	tenants, err := tenant.NewRegistry([]tenant.Tenant{
+		{ID: "acme", Hosts: []string{"rewards.acme.com"}},
+		{ID: "globex", Hosts: []string{"points.globex.io"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	services := map[string]*service.Service{}
+	for _, tn := range tenants.List() {
+		services[tn.ID] = service.NewService(service.WithTenant(tn))
+	}
+
+	all := []auth.Scope{auth.ScopeSubmit, auth.ScopeRead, auth.ScopeAdmin}
+	keys, err := auth.NewKeyring([]auth.Key{
+		{ID: "acme", ClientID: "acme", Tenant: "acme", Hash: auth.Hash("rk_acme"), Scopes: all},
+		{ID: "globex", ClientID: "globex", Tenant: "globex", Hash: auth.Hash("rk_globex"), Scopes: all},
+		{ID: "ops", ClientID: "ops", Hash: auth.Hash("rk_ops"), Scopes: all},
+		{ID: "unbound", ClientID: "unbound", Hash: auth.Hash("rk_unbound"), Scopes: []auth.Scope{auth.ScopeRead}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := New(WithKeys(keys), WithTenants(tenants, services)).Handler()
+
+	do := func(method, target, key, host, tenantID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Host = host
+		if key != "" {
+			req.Header.Set(APIKeyHeader, key)
+		}
+		if tenantID != "" {
+			req.Header.Set(TenantHeader, tenantID)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("POST", "/receipts/process", "rk_acme", "", "", EXAMPLE1)
+	if rec.Code != 200 {
+		t.Fatal("got", rec.Code, "want 200", rec.Body.String())
+	}
+	var created struct{ Id string }
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	points := "/receipts/" + created.Id + "/points"
+
+	tests := []struct {
+		name     string
+		key      string
+		host     string
+		tenant   string
+		wantCode int
+	}{
+		{name: "Handler: should resolve the tenant from the key", key: "rk_acme", wantCode: 200},
+		{name: "Handler: should hide a tenant's receipts from another tenant's admin", key: "rk_globex", wantCode: 404},
+		{name: "Handler: should reject a key naming another tenant", key: "rk_globex", tenant: "acme", wantCode: 403},
+		{name: "Handler: should reject a key on another tenant's host", key: "rk_globex", host: "rewards.acme.com", wantCode: 403},
+		{name: "Handler: should let unbound admins choose the tenant by header", key: "rk_ops", tenant: "acme", wantCode: 200},
+		{name: "Handler: should let unbound admins choose the tenant by host", key: "rk_ops", host: "rewards.acme.com:8443", wantCode: 200},
+		{name: "Handler: should keep the header over the host", key: "rk_ops", host: "rewards.acme.com", tenant: "globex", wantCode: 404},
+		{name: "Handler: should reject unbound keys without admin", key: "rk_unbound", tenant: "acme", wantCode: 403},
+		{name: "Handler: should return 404 without a tenant", key: "rk_ops", wantCode: 404},
+		{name: "Handler: should return 404 for an unknown tenant", key: "rk_ops", tenant: "initech", wantCode: 404},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := do("GET", points, tt.key, tt.host, tt.tenant, ""); rec.Code != tt.wantCode {
+				t.Error("got", rec.Code, "want", tt.wantCode, rec.Body.String())
+			}
+		})
+	}
+
+	t.Run("Handler: should resolve the tenant from the host without authentication", func(t *testing.T) {
+		open := New(WithTenants(tenants, services)).Handler()
+		req := httptest.NewRequest("GET", points, nil)
+		req.Host = "rewards.acme.com"
+		rec := httptest.NewRecorder()
+		open.ServeHTTP(rec, req)
+		if rec.Code != 200 {
+			t.Error("got", rec.Code, "want 200", rec.Body.String())
+		}
+	})
+}
+
+func TestAPIRateLimit(t *testing.T) {
+	policy, err := ratelimit.ParsePolicy("POST /receipts/process=2/m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.NewKeyring([]auth.Key{
+		{ID: "acme", ClientID: "acme", Hash: auth.Hash("rk_acme"), Scopes: []auth.Scope{auth.ScopeSubmit}},
+		{ID: "globex", ClientID: "globex", Hash: auth.Hash("rk_globex"), Scopes: []auth.Scope{auth.ScopeSubmit}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := New(WithKeys(keys), WithLimiter(ratelimit.New(policy))).Handler()
+
+	do := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/receipts/process", strings.NewReader(EXAMPLE1))
+		req.Header.Set(APIKeyHeader, key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name          string
+		key           string
+		wantCode      int
+		wantRemaining string
+		wantRetry     string
+	}{
+		{name: "Handler: should allow the first request", key: "rk_acme", wantCode: 200, wantRemaining: "1"},
+		{name: "Handler: should allow the burst", key: "rk_acme", wantCode: 200, wantRemaining: "0"},
+		{name: "Handler: should reject requests over the limit", key: "rk_acme", wantCode: 429, wantRemaining: "0", wantRetry: "30"},
+		{name: "Handler: should limit each client apart", key: "rk_globex", wantCode: 200, wantRemaining: "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(tt.key)
+			if rec.Code != tt.wantCode {
+				t.Error("got", rec.Code, "want", tt.wantCode, rec.Body.String())
+			}
+			if got := rec.Header().Get("RateLimit-Limit"); got != "2" {
+				t.Errorf("got RateLimit-Limit %q, want 2", got)
+			}
+			if got := rec.Header().Get("RateLimit-Remaining"); got != tt.wantRemaining {
+				t.Errorf("got RateLimit-Remaining %q, want %q", got, tt.wantRemaining)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetry {
+				t.Errorf("got Retry-After %q, want %q", got, tt.wantRetry)
+			}
+		})
+	}
+
+	t.Run("Handler: should not limit other routes", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/receipts/export", nil)
+		req.Header.Set(APIKeyHeader, "rk_acme")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if got := rec.Header().Get("RateLimit-Limit"); got != "" {
+			t.Errorf("got RateLimit-Limit %q, want none", got)
+		}
+	})
+}
+
+func TestAPIMetrics(t *testing.T) {
+	m := NewMetrics()
+	svc := service.NewService(service.WithObserver(m))
+	keys, err := auth.NewKeyring([]auth.Key{
+		{ID: "acme", ClientID: "acme", Hash: auth.Hash("rk_acme"), Scopes: []auth.Scope{auth.ScopeSubmit}},
+		{ID: "ops", ClientID: "ops", Hash: auth.Hash("rk_ops"), Scopes: []auth.Scope{auth.ScopeAdmin}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := New(WithService(svc), WithKeys(keys), WithMetrics(m)).Handler()
+
+	do := func(method, target, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(APIKeyHeader, key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	do("POST", "/receipts/process", "rk_acme", EXAMPLE1)
+	do("POST", "/receipts/process", "rk_acme", `{"retailer": "", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "total": "1.00", "items": [{"shortDescription": "Pepsi", "price": "1.00"}]}`)
+	do("GET", "/receipts/nope/points", "rk_nope", "")
+	m.ReceiptProcessed("beta", models.Receipt{Breakdown: []models.RulePoints{{Name: "bonus", Points: 10}, {Name: "penalty", Points: -4}}})
+
+	t.Run("Handler: should require the admin scope for metrics", func(t *testing.T) {
+		if rec := do("GET", "/metrics", "rk_acme", ""); rec.Code != 403 {
+			t.Error("got", rec.Code, "want 403")
+		}
+	})
+
+	rec := do("GET", "/metrics", "rk_ops", "")
+	if rec.Code != 200 {
+		t.Fatal("got", rec.Code, "want 200", rec.Body.String())
+	}
+	body := rec.Body.String()
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "Handler: should count requests by route and code", want: `http_requests_total{route="POST /receipts/process",code="200"} 1`},
+		{name: "Handler: should count rejected requests", want: `http_requests_total{route="GET /receipts/{id}/points",code="401"} 1`},
+		{name: "Handler: should time requests", want: `http_request_duration_seconds_count{route="POST /receipts/process",code="400"} 1`},
+		{name: "Handler: should count processed receipts", want: `receipts_processed_total{tenant=""} 1`},
+		{name: "Handler: should count validation failures by error", want: `receipt_validation_failures_total{tenant="",error="retailer cannot be empty"} 1`},
+		{name: "Handler: should count points by rule", want: `receipt_points_awarded_total{tenant="",rule="alphanumeric"} 6`},
+		{name: "Handler: should count negative points as deductions", want: `receipt_points_deducted_total{tenant="beta",rule="penalty"} 4`},
+		{name: "Handler: should time rules", want: `rule_evaluation_duration_seconds_count{rule="alphanumeric"} 1`},
+		{name: "Handler: should report the store size", want: `receipts_stored{tenant=""} 1`},
+		{name: "Handler: should report runtime stats", want: "# TYPE go_goroutines gauge"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(body, tt.want) {
+				t.Errorf("got\n%s\nwant %s", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPILogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.Options{Format: logging.FormatJSON, Redact: []string{"retailer"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := service.NewService(service.WithLogger(logger))
+	handler := New(WithService(svc), WithLogger(logger)).Handler()
+
+	do := func(method, target, id, body string) (*httptest.ResponseRecorder, []map[string]any) {
+		buf.Reset()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if id != "" {
+			req.Header.Set(requestid.Header, id)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		var lines []map[string]any
+		dec := json.NewDecoder(&buf)
+		for dec.More() {
+			var line map[string]any
+			if err := dec.Decode(&line); err != nil {
+				t.Fatal(err)
+			}
+			lines = append(lines, line)
+		}
+		return rec, lines
+	}
+
+	t.Run("Handler: should echo the request ID", func(t *testing.T) {
+		rec, _ := do("GET", "/receipts/nope/points", "req-1", "")
+		if got := rec.Header().Get(requestid.Header); got != "req-1" {
+			t.Errorf("got %q, want req-1", got)
+		}
+	})
+
+	t.Run("Handler: should replace an unsafe request ID", func(t *testing.T) {
+		rec, _ := do("GET", "/receipts/nope/points", "bad id\n", "")
+		if got := rec.Header().Get(requestid.Header); uuid.Validate(got) != nil {
+			t.Errorf("got %q, want a generated ID", got)
+		}
+	})
+
+	rec, lines := do("POST", "/receipts/process", "req-2", EXAMPLE1)
+	if rec.Code != 200 || len(lines) != 2 {
+		t.Fatal("got", rec.Code, lines, "want 200 and two log lines")
+	}
+	size := rec.Body.Len()
+	var resp service.RespProcessReceipt
+	json.NewDecoder(rec.Body).Decode(&resp)
+	processed, access := lines[0], lines[1]
+
+	tests := []struct {
+		name string
+		line map[string]any
+		key  string
+		want any
+	}{
+		{name: "Handler: should log the method", line: access, key: "method", want: "POST"},
+		{name: "Handler: should log the route", line: access, key: "route", want: "POST /receipts/process"},
+		{name: "Handler: should log the status", line: access, key: "status", want: float64(200)},
+		{name: "Handler: should log the receipt ID", line: access, key: "receipt_id", want: resp.Id},
+		{name: "Handler: should log the request ID", line: access, key: "request_id", want: "req-2"},
+		{name: "Service: should log the request ID", line: processed, key: "request_id", want: "req-2"},
+		{name: "Service: should log the points", line: processed, key: "points", want: float64(28)},
+		{name: "Service: should redact the retailer", line: processed, key: "retailer", want: logging.Redacted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.line[tt.key]; got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("Handler: should log the size and latency", func(t *testing.T) {
+		if access["bytes"] != float64(size) {
+			t.Error("got", access["bytes"], "want", size)
+		}
+		if _, ok := access["latency"]; !ok {
+			t.Error("got no latency")
+		}
+	})
+}
+
+func TestAPITracing(t *testing.T) {
+	collector := trace.NewCollector()
+	handler := New(WithTracer(trace.New(collector))).Handler()
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	remote, _ := trace.ParseTraceparent(traceparent)
+
+	req := httptest.NewRequest("POST", "/receipts/process", strings.NewReader(EXAMPLE1))
+	req.Header.Set(trace.Header, traceparent)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Fatal("got", rec.Code, "want 200")
+	}
+
+	spans := map[string][]trace.SpanData{}
+	for _, span := range collector.Spans() {
+		spans[span.Name] = append(spans[span.Name], span)
+	}
+	server := spans["POST /receipts/process"]
+	if len(server) != 1 {
+		t.Fatal("got", collector.Spans(), "want one server span")
+	}
+
+	tests := []struct {
+		name   string
+		span   string
+		count  int
+		parent string
+	}{
+		{name: "Handler: should trace decoding", span: "decode", count: 1, parent: "POST /receipts/process"},
+		{name: "Handler: should trace the service", span: "ProcessReceipt", count: 1, parent: "POST /receipts/process"},
+		{name: "Handler: should trace validation", span: "IsValid", count: 1, parent: "ProcessReceipt"},
+		{name: "Handler: should trace conversion", span: "ConvertReqToReceiptTwo", count: 1, parent: "ProcessReceipt"},
+		{name: "Handler: should trace every rule", span: "RuleHandlerFn", count: len(rules.Default().Rules), parent: "ProcessReceipt"},
+		{name: "Handler: should trace the store write", span: "StoreReceipt", count: 1, parent: "ProcessReceipt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := spans[tt.span]
+			if len(got) != tt.count {
+				t.Fatal("got", len(got), "spans, want", tt.count)
+			}
+			parent := spans[tt.parent][0].Context.SpanID
+			for _, span := range got {
+				if span.Context.TraceID != remote.TraceID || span.Parent != parent {
+					t.Errorf("got %+v, want a child of %s", span, tt.parent)
+				}
+			}
+		})
+	}
+
+	t.Run("Handler: should continue the caller's trace", func(t *testing.T) {
+		if server[0].Context.TraceID != remote.TraceID || server[0].Parent != remote.SpanID {
+			t.Errorf("got %+v, want a child of %s", server[0], traceparent)
+		}
+	})
+}
+
+func TestAPIProbes(t *testing.T) {
+	keys, err := auth.NewKeyring([]auth.Key{
+		{ID: "acme", ClientID: "acme", Hash: auth.Hash("rk_acme"), Scopes: []auth.Scope{auth.ScopeSubmit}},
+		{ID: "ops", ClientID: "ops", Hash: auth.Hash("rk_ops"), Scopes: []auth.Scope{auth.ScopeAdmin}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := New(WithKeys(keys), WithVersion("v1.2.3"))
+	handler := a.Handler()
+
+	do := func(target, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set(APIKeyHeader, key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		key      string
+		wantCode int
+		wantBody string
+	}{
+		{name: "Healthz: should answer without credentials", target: "/healthz", wantCode: 200, wantBody: `{"status":"ok"}`},
+		{name: "Readyz: should answer only the status", target: "/readyz", wantCode: 200, wantBody: `{"status":"ready"}`},
+		{name: "Status: should require credentials", target: "/status", wantCode: 401},
+		{name: "Status: should require the admin scope", target: "/status", key: "rk_acme", wantCode: 403},
+		{name: "Status: should report the version and rule set", target: "/status", key: "rk_ops", wantCode: 200, wantBody: `"version":"v1.2.3"`},
+		{name: "Status: should report each readiness check", target: "/status", key: "rk_ops", wantCode: 200, wantBody: `"checks":{"default":"ok"}`},
+		{name: "Status: should report store stats", target: "/status", key: "rk_ops", wantCode: 200, wantBody: `"services":[{"ruleVersion":"default","receipts":0,"reviews":0}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(tt.target, tt.key)
+			if rec.Code != tt.wantCode {
+				t.Fatal("got", rec.Code, "want", tt.wantCode, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("got %s, want %s", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+
+	t.Run("Readyz: should fail while shutting down", func(t *testing.T) {
+		a.ready.Store(false)
+		defer a.ready.Store(true)
+
+		if rec := do("/readyz", ""); rec.Code != 503 || !strings.Contains(rec.Body.String(), "shutting down") {
+			t.Error("got", rec.Code, rec.Body.String(), "want 503")
+		}
+		if rec := do("/healthz", ""); rec.Code != 200 {
+			t.Error("got", rec.Code, "want 200")
+		}
+	})
+}
+
+func TestAPIRuleSets(t *testing.T) {
+	keys, err := auth.NewKeyring([]auth.Key{
+		{ID: "acme", ClientID: "acme", Hash: auth.Hash("rk_acme"), Scopes: []auth.Scope{auth.ScopeSubmit, auth.ScopeRead}},
+		{ID: "ops", ClientID: "ops", Hash: auth.Hash("rk_ops"), Scopes: []auth.Scope{auth.ScopeAdmin}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Default()
+	cfg.AdminAddr = ":8081"
+	a := New(WithKeys(keys), WithConfig(cfg))
+	public, admin := a.Handler(), a.AdminHandler()
+
+	do := func(handler http.Handler, method, target, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(APIKeyHeader, key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	const v2 = `{"version": "v2", "rules": [{"name": "flat", "expr": "10"}]}`
+	later := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name     string
+		handler  http.Handler
+		method   string
+		target   string
+		key      string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{name: "Handler: should not serve admin routes with an admin address", handler: public, method: "GET", target: "/admin/rulesets", key: "rk_ops", wantCode: 404},
+		{name: "AdminHandler: should require the admin scope", handler: admin, method: "GET", target: "/admin/rulesets", key: "rk_acme", wantCode: 403},
+		{name: "AdminHandler: should list the loaded version", handler: admin, method: "GET", target: "/admin/rulesets", key: "rk_ops", wantCode: 200, wantBody: `"version":"default"`},
+		{name: "AdminHandler: should report an invalid rule set", handler: admin, method: "POST", target: "/admin/rulesets/validate", key: "rk_ops", body: `{"version": "v2", "rules": [{"name": "flat", "expr": "10 +"}]}`, wantCode: 200, wantBody: `"valid":false`},
+		{name: "AdminHandler: should validate a rule set", handler: admin, method: "POST", target: "/admin/rulesets/validate", key: "rk_ops", body: v2, wantCode: 200, wantBody: `{"valid":true}`},
+		{name: "AdminHandler: should create a version", handler: admin, method: "POST", target: "/admin/rulesets", key: "rk_ops", body: v2, wantCode: 201, wantBody: `"createdBy":"client:ops"`},
+		{name: "AdminHandler: should refuse to replace a version", handler: admin, method: "POST", target: "/admin/rulesets", key: "rk_ops", body: v2, wantCode: 409},
+		{name: "AdminHandler: should refuse an invalid version", handler: admin, method: "POST", target: "/admin/rulesets", key: "rk_ops", body: `{"version": "v3"}`, wantCode: 400},
+		{name: "AdminHandler: should get a version", handler: admin, method: "GET", target: "/admin/rulesets/v2", key: "rk_ops", wantCode: 200, wantBody: `"active":false`},
+		{name: "AdminHandler: should return 404 for an unknown version", handler: admin, method: "GET", target: "/admin/rulesets/v9", key: "rk_ops", wantCode: 404},
+		{name: "AdminHandler: should schedule an activation", handler: admin, method: "POST", target: "/admin/rulesets/v2/activate", key: "rk_ops", body: `{"at": "` + later + `"}`, wantCode: 200, wantBody: `"scheduledAt":"` + later + `"`},
+		{name: "AdminHandler: should activate a version at once", handler: admin, method: "POST", target: "/admin/rulesets/v2/activate", key: "rk_ops", wantCode: 200, wantBody: `"active":true`},
+		{name: "AdminHandler: should keep an audit trail", handler: admin, method: "GET", target: "/admin/rulesets/audit", key: "rk_ops", wantCode: 200, wantBody: `"actor":"client:ops","action":"activate","version":"v2","previous":"default"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(tt.handler, tt.method, tt.target, tt.key, tt.body)
+			if rec.Code != tt.wantCode {
+				t.Fatal("got", rec.Code, "want", tt.wantCode, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("got %s, want %s", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+
+	t.Run("Handler: should score receipts with the active version", func(t *testing.T) {
+		var created service.RespProcessReceipt
+		json.NewDecoder(do(public, "POST", "/receipts/process", "rk_acme", EXAMPLE1).Body).Decode(&created)
+		rec := do(public, "GET", "/receipts/"+created.Id+"/points", "rk_acme", "")
+		if !strings.Contains(rec.Body.String(), `"points":10`) {
+			t.Errorf("got %s, want 10 points", rec.Body.String())
+		}
+	})
+}
+
+func TestAPIAudit(t *testing.T) {
+	keys, err := auth.NewKeyring([]auth.Key{
+		{ID: "acme", ClientID: "acme", Hash: auth.Hash("rk_acme"), Scopes: []auth.Scope{auth.ScopeSubmit, auth.ScopeRead}},
+		{ID: "ops", ClientID: "ops", Hash: auth.Hash("rk_ops"), Scopes: []auth.Scope{auth.ScopeAdmin}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := New(WithKeys(keys)).Handler()
+
+	do := func(method, target, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(APIKeyHeader, key)
+		req.Header.Set("X-Request-ID", "req-"+key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var created service.RespProcessReceipt
+	json.NewDecoder(do("POST", "/receipts/process", "rk_acme", EXAMPLE1).Body).Decode(&created)
+	do("PUT", "/admin/retailers/target", "rk_ops", `{"id": "target", "name": "Target"}`)
+	do("POST", "/admin/rulesets", "rk_ops", `{"version": "v2", "rules": [{"name": "flat", "expr": "10"}]}`)
+	do("POST", "/admin/rulesets/v2/activate", "rk_ops", "")
+
+	tests := []struct {
+		name     string
+		target   string
+		key      string
+		wantCode int
+		wantBody []string
+	}{
+		{name: "ListAudit: should require the admin scope", target: "/admin/audit", key: "rk_acme", wantCode: 403},
+		{name: "ListAudit: should refuse an invalid filter", target: "/admin/audit?since=yesterday", key: "rk_ops", wantCode: 400},
+		{name: "ListAudit: should refuse a negative limit", target: "/admin/audit?limit=-1", key: "rk_ops", wantCode: 400},
+		{
+			name: "ListAudit: should record who submitted a receipt", target: "/admin/audit?action=receipt.submit", key: "rk_ops", wantCode: 200,
+			wantBody: []string{`"seq":1`, `"actor":"client:acme"`, `"target":"receipt/` + created.Id + `"`, `"after":{"points":"28"`, `"requestId":"req-rk_acme"`, `"prevHash":""`},
+		},
+		{
+			name: "ListAudit: should record changes before and after", target: "/admin/audit?actor=client:ops&limit=1", key: "rk_ops", wantCode: 200,
+			wantBody: []string{`"seq":4`, `"action":"ruleset.activate"`, `"before":{"version":"default"},"after":{"version":"v2"}`},
+		},
+		{
+			name: "ListAudit: should filter by target", target: "/admin/audit?target=retailer/target", key: "rk_ops", wantCode: 200,
+			wantBody: []string{`"action":"retailer.put"`, `"after":{"name":"Target"}`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do("GET", tt.target, tt.key, "")
+			if rec.Code != tt.wantCode {
+				t.Fatal("got", rec.Code, "want", tt.wantCode, rec.Body.String())
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(rec.Body.String(), want) {
+					t.Errorf("got %s, want %s", rec.Body.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIAdjustments(t *testing.T) {
+	keys, err := auth.NewKeyring([]auth.Key{
+		{ID: "acme", ClientID: "acme", Hash: auth.Hash("rk_acme"), Scopes: []auth.Scope{auth.ScopeSubmit, auth.ScopeRead}},
+		{ID: "ops", ClientID: "ops", Hash: auth.Hash("rk_ops"), Scopes: []auth.Scope{auth.ScopeAdmin}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Adjustments apply to receipts credited to a user, which only bearer
+	// tokens submit, so ana's receipt is submitted to the service directly.
+	svc := service.NewService()
+	var receipt service.ReqProcessReceipt
+	if err := json.Unmarshal([]byte(EXAMPLE1), &receipt); err != nil {
+		t.Fatal(err)
+	}
+	created, err := svc.ProcessReceipt(auth.NewContext(context.Background(), auth.Principal{ClientID: "acme", UserID: "ana"}), receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := New(WithKeys(keys), WithService(svc)).Handler()
+
+	do := func(method, target, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(APIKeyHeader, key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var client service.RespProcessReceipt
+	json.NewDecoder(do("POST", "/receipts/process", "rk_acme", EXAMPLE1).Body).Decode(&client)
+	adjustment := `{"userId": "ana", "receiptId": "` + created.Id + `", "points": -8, "reason": "bad_receipt", "note": "duplicate"}`
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		key      string
+		body     string
+		wantCode int
+		wantBody []string
+	}{
+		{name: "AdjustPoints: should require the admin scope", method: "POST", target: "/admin/adjustments", key: "rk_acme", body: adjustment, wantCode: 403},
+		{name: "AdjustPoints: should refuse an unknown reason", method: "POST", target: "/admin/adjustments", key: "rk_ops", body: `{"userId": "ana", "points": 5, "reason": "bored"}`, wantCode: 400},
+		{name: "AdjustPoints: should refuse another agent", method: "POST", target: "/admin/adjustments", key: "rk_ops", body: `{"userId": "ana", "points": 5, "reason": "goodwill", "agentId": "agent-7"}`, wantCode: 403},
+		{
+			name: "AdjustPoints: should refuse a receipt not credited to a user", method: "POST", target: "/admin/adjustments", key: "rk_ops", wantCode: 400,
+			body: `{"userId": "ana", "receiptId": "` + client.Id + `", "points": 5, "reason": "missing_points"}`,
+		},
+		{
+			name: "AdjustPoints: should record an adjustment", method: "POST", target: "/admin/adjustments", key: "rk_ops", body: adjustment, wantCode: 201,
+			wantBody: []string{`"userId":"ana"`, `"points":-8`, `"reason":"bad_receipt"`, `"agentId":"client:ops"`, `"balance":20`},
+		},
+		{
+			name: "GetBreakdown: should show the adjustment beside the calculated points", method: "GET", target: "/receipts/" + created.Id + "/breakdown", key: "rk_acme", wantCode: 200,
+			wantBody: []string{`"points":28`, `"rules":[{"name":`, `"note":"duplicate"`, `"adjustedPoints":20`},
+		},
+		{name: "GetReceipt: should keep the calculated points", method: "GET", target: "/receipts/" + created.Id + "/points", key: "rk_acme", wantCode: 200, wantBody: []string{`{"points":28}`}},
+		{name: "GetLedger: should list the user's adjustments", method: "GET", target: "/admin/users/ana/ledger", key: "rk_ops", wantCode: 200, wantBody: []string{`"balance":20`, `"reason":"bad_receipt"`}},
+		{name: "ListAudit: should record who adjusted the balance", method: "GET", target: "/admin/audit?action=points.adjust", key: "rk_ops", wantCode: 200, wantBody: []string{`"actor":"client:ops"`, `"target":"user/ana"`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(tt.method, tt.target, tt.key, tt.body)
+			if rec.Code != tt.wantCode {
+				t.Fatal("got", rec.Code, "want", tt.wantCode, rec.Body.String())
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(rec.Body.String(), want) {
+					t.Errorf("got %s, want %s", rec.Body.String(), want)
+				}
+			}
+		})
+	}
+}
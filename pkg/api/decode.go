@@ -0,0 +1,211 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+)
+
+var (
+	ErrBodyLimit    = errors.New("body is larger than the server accepts")
+	ErrContentType  = errors.New("content type must be application/json")
+	ErrJSONSyntax   = errors.New("body is not valid JSON")
+	ErrJSONType     = errors.New("body has a value of the wrong type")
+	ErrUnknownField = errors.New("body has an unknown field")
+	ErrDuplicateKey = errors.New("body repeats a key")
+	ErrJSONDepth    = errors.New("body nests values too deeply")
+	ErrTrailingData = errors.New("body has data after the JSON value")
+)
+
+// DecodeError says why a request body was rejected. Its message is safe to
+// return to the client.
+type DecodeError struct {
+	Err    error
+	Detail string
+}
+
+func (e *DecodeError) Error() string {
+	if e.Detail == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ": " + e.Detail
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+type DecodeOption func(*decodeOptions)
+
+type decodeOptions struct {
+	disallowUnknownFields bool
+}
+
+// DisallowUnknownFields rejects bodies with fields val has no place for.
+func DisallowUnknownFields() DecodeOption {
+	return func(o *decodeOptions) {
+		o.disallowUnknownFields = true
+	}
+}
+
+// DecodeJSON decodes a request body holding exactly one JSON value into val.
+// Bodies with another Content-Type wrap models.ErrMediaTypeInvalid, bodies
+// over the limit set by limitBody wrap models.ErrBodyTooLarge, and malformed
+// bodies, including ones repeating a key, wrap models.ErrInvalidInput. A
+// missing Content-Type is read as JSON.
+func DecodeJSON(r *http.Request, val any, opts ...DecodeOption) error {
+	var o decodeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil || (mt != "application/json" && !strings.HasSuffix(mt, "+json")) {
+			return fmt.Errorf("%w: %w", models.ErrMediaTypeInvalid, &DecodeError{Err: ErrContentType, Detail: fmt.Sprintf("got %q", ct)})
+		}
+	}
+
+	data, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	if err := checkJSON(data); err != nil {
+		return fmt.Errorf("%w: %w", models.ErrInvalidInput, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if o.disallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(val); err != nil {
+		return fmt.Errorf("%w: %w", models.ErrInvalidInput, decodeError(err))
+	}
+
+	return nil
+}
+
+// readBody reads the whole request body, which limitBody bounds.
+func readBody(r *http.Request) ([]byte, error) {
+	defer r.Body.Close()
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, fmt.Errorf("%w: %w", models.ErrBodyTooLarge, &DecodeError{Err: ErrBodyLimit, Detail: fmt.Sprintf("limit is %d bytes", tooLarge.Limit)})
+		}
+		return nil, fmt.Errorf("%w: %w", models.ErrInvalidInput, err)
+	}
+
+	return data, nil
+}
+
+// MaxJSONDepth bounds how deeply objects and arrays may nest in a body.
+const MaxJSONDepth = 32
+
+// checkJSON rejects data that is not exactly one JSON value, that nests
+// deeper than MaxJSONDepth, or that repeats a key in an object, which
+// encoding/json would silently resolve to the last value. Keys are compared
+// ignoring case, as encoding/json matches them to fields.
+func checkJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	if err := checkValue(dec, 0); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return &DecodeError{Err: ErrTrailingData, Detail: fmt.Sprintf("at offset %d", dec.InputOffset())}
+	}
+
+	return nil
+}
+
+// checkValue checks the next value of dec, nested in depth objects and
+// arrays.
+func checkValue(dec *json.Decoder, depth int) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return decodeError(err)
+	}
+
+	if tok == json.Delim('{') || tok == json.Delim('[') {
+		if depth++; depth > MaxJSONDepth {
+			return &DecodeError{Err: ErrJSONDepth, Detail: fmt.Sprintf("limit is %d", MaxJSONDepth)}
+		}
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		seen := map[string]bool{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return decodeError(err)
+			}
+			name, _ := key.(string)
+			folded := foldKey(name)
+			if seen[folded] {
+				return &DecodeError{Err: ErrDuplicateKey, Detail: fmt.Sprintf("%q", name)}
+			}
+			seen[folded] = true
+
+			if err := checkValue(dec, depth); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return decodeError(err)
+		}
+
+	case json.Delim('['):
+		for dec.More() {
+			if err := checkValue(dec, depth); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return decodeError(err)
+		}
+	}
+
+	return nil
+}
+
+// foldKey folds the case of an object key the way encoding/json does when it
+// matches keys to fields, so "Retailer" repeats "retailer".
+func foldKey(name string) string {
+	return strings.ToLower(strings.ToUpper(name))
+}
+
+// decodeError turns an encoding/json error into a DecodeError whose detail
+// names the offending field or offset, without echoing the body.
+func decodeError(err error) error {
+	var syntax *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &syntax):
+		return &DecodeError{Err: ErrJSONSyntax, Detail: fmt.Sprintf("at offset %d", syntax.Offset)}
+	case errors.As(err, &typ):
+		return &DecodeError{Err: ErrJSONType, Detail: fmt.Sprintf("%q must be %s", typ.Field, typ.Type)}
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return &DecodeError{Err: ErrJSONSyntax, Detail: "unexpected end of body"}
+	}
+
+	// DisallowUnknownFields has no error type of its own.
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return &DecodeError{Err: ErrUnknownField, Detail: field}
+	}
+
+	return &DecodeError{Err: ErrJSONSyntax}
+}
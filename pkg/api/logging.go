@@ -0,0 +1,112 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/requestid"
+)
+
+// requestID passes the request's X-Request-ID to h in the request context,
+// generating one when the header is missing or unsafe to log, and echoes it
+// in the response.
+func requestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+		rw.Header().Set(requestid.Header, id)
+		h.ServeHTTP(rw, r.WithContext(requestid.NewContext(r.Context(), id)))
+	})
+}
+
+// accessLog logs one line per request with its route, status, latency and
+// size, plus anything handlers added with logAttrs.
+func (a API) accessLog(h http.Handler, muxes ...*http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		route := route(r, muxes...)
+		start := time.Now()
+		sw := newStatusWriter(rw)
+		h.ServeHTTP(sw, r)
+
+		level := slog.LevelInfo
+		switch {
+		case probes[route]:
+			level = slog.LevelDebug
+		case sw.code >= http.StatusInternalServerError:
+			level = slog.LevelError
+		}
+		attrs := append([]slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", sw.code),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int64("bytes", sw.bytes),
+		}, sw.attrs...)
+		a.log.LogAttrs(r.Context(), level, "Request", attrs...)
+	})
+}
+
+// route is the first pattern other than "/" that one of muxes matches r to,
+// looking into muxes mounted on them.
+func route(r *http.Request, muxes ...*http.ServeMux) string {
+	for _, mux := range muxes {
+		h, pattern := mux.Handler(r)
+		if sub, ok := h.(*http.ServeMux); ok {
+			pattern = route(r, sub)
+		}
+		if pattern != "" && pattern != "/" {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// logAttrs adds attrs to the request's access log line.
+func logAttrs(rw http.ResponseWriter, attrs ...slog.Attr) {
+	if sw, ok := rw.(*statusWriter); ok {
+		sw.attrs = append(sw.attrs, attrs...)
+	}
+}
+
+// statusWriter records the status code and size of a response, and the
+// attributes of its access log line.
+type statusWriter struct {
+	http.ResponseWriter
+	code        int
+	bytes       int64
+	wroteHeader bool
+	attrs       []slog.Attr
+}
+
+// newStatusWriter wraps rw, unless it is already a statusWriter so that
+// middleware share one.
+func newStatusWriter(rw http.ResponseWriter) *statusWriter {
+	if sw, ok := rw.(*statusWriter); ok {
+		return sw
+	}
+	return &statusWriter{ResponseWriter: rw, code: http.StatusOK}
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.code = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/metrics"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/service"
+)
+
+// ruleBuckets are rule evaluation times in seconds, from 1µs to 10ms.
+var ruleBuckets = []float64{1e-6, 1e-5, 1e-4, 1e-3, 1e-2}
+
+// Metrics are the server's Prometheus metrics. They are a
+// service.RuleObserver.
+type Metrics struct {
+	registry *metrics.Registry
+
+	requests  *metrics.Counter
+	latency   *metrics.Histogram
+	processed *metrics.Counter
+	invalid   *metrics.Counter
+	awarded   *metrics.Counter
+	deducted  *metrics.Counter
+	rules     *metrics.Histogram
+}
+
+func NewMetrics() *Metrics {
+	reg := metrics.NewRegistry()
+	reg.RegisterRuntime()
+
+	return &Metrics{
+		registry:  reg,
+		requests:  reg.NewCounter("http_requests_total", "HTTP requests by route and status code.", "route", "code"),
+		latency:   reg.NewHistogram("http_request_duration_seconds", "HTTP request latency by route and status code.", metrics.DefaultBuckets, "route", "code"),
+		processed: reg.NewCounter("receipts_processed_total", "Receipts scored and stored.", "tenant"),
+		invalid:   reg.NewCounter("receipt_validation_failures_total", "Receipts rejected as invalid, by the sentinel error they failed with.", "tenant", "error"),
+		awarded:   reg.NewCounter("receipt_points_awarded_total", "Points awarded to stored receipts by rule.", "tenant", "rule"),
+		deducted:  reg.NewCounter("receipt_points_deducted_total", "Points deducted from stored receipts by rule.", "tenant", "rule"),
+		rules:     reg.NewHistogram("rule_evaluation_duration_seconds", "Time taken to evaluate each rule of a scored receipt.", ruleBuckets, "rule"),
+	}
+}
+
+func (m *Metrics) Registry() *metrics.Registry {
+	return m.registry
+}
+
+// ReceiptProcessed counts a stored receipt and its points by rule. Counters
+// only go up, so negative points from expression rules are counted as
+// deductions.
+func (m *Metrics) ReceiptProcessed(tenant string, r models.Receipt) {
+	m.processed.Inc(tenant)
+	for _, result := range r.Breakdown {
+		switch {
+		case result.Points > 0:
+			m.awarded.Add(float64(result.Points), tenant, result.Name)
+		case result.Points < 0:
+			m.deducted.Add(float64(-result.Points), tenant, result.Name)
+		}
+	}
+}
+
+// ReceiptRejected counts invalid receipts once per sentinel error they
+// failed with. Other rejections, such as quotas, show in the request
+// metrics.
+func (m *Metrics) ReceiptRejected(tenant string, err error) {
+	if !errors.Is(err, models.ErrInvalidInput) {
+		return
+	}
+
+	causes := service.Causes(err)
+	if len(causes) == 0 {
+		m.invalid.Inc(tenant, models.ErrInvalidInput.Error())
+	}
+	for _, cause := range causes {
+		m.invalid.Inc(tenant, cause.Error())
+	}
+}
+
+func (m *Metrics) ObserveRule(rule string, points int64, elapsed time.Duration) {
+	m.rules.Observe(elapsed.Seconds(), rule)
+}
+
+// registerStores reports the size of each service's store.
+func (m *Metrics) registerStores(services map[string]*service.Service) {
+	stats := func(value func(*service.RespStats) int) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			samples := make([]metrics.Sample, 0, len(services))
+			for tenant, svc := range services {
+				resp, err := svc.Stats(context.Background(), service.ReqStats{})
+				if err != nil {
+					continue
+				}
+				samples = append(samples, metrics.Sample{Labels: []string{tenant}, Value: float64(value(resp))})
+			}
+			return samples
+		}
+	}
+
+	m.registry.NewGaugeFunc("receipts_stored", "Receipts in the store.", stats(func(r *service.RespStats) int { return r.Receipts }), "tenant")
+	m.registry.NewGaugeFunc("reviews_queued", "Email submissions waiting for manual review.", stats(func(r *service.RespStats) int { return r.Reviews }), "tenant")
+}
+
+// instrument counts and times every request by the route mux matches it to.
+func (m *Metrics) instrument(mux *http.ServeMux, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		route := route(r, mux)
+		if route == "" {
+			route = "unmatched"
+		}
+
+		start := time.Now()
+		sw := newStatusWriter(rw)
+		h.ServeHTTP(sw, r)
+
+		code := strconv.Itoa(sw.code)
+		m.requests.Inc(route, code)
+		m.latency.Observe(time.Since(start).Seconds(), route, code)
+	})
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"cmp"
+	"net/http"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/service"
+)
+
+// probes are the routes orchestrators poll, which are logged at debug level.
+var probes = map[string]bool{
+	"GET /healthz": true,
+	"GET /readyz":  true,
+}
+
+type Health struct {
+	Status string `json:"status"`
+}
+
+// Readiness is the result of the readiness probe. The probe needs no
+// credentials, so the outcome of each check is only reported by GET /status.
+type Readiness struct {
+	Status string `json:"status"`
+}
+
+type Status struct {
+	Version   string    `json:"version"`
+	GoVersion string    `json:"goVersion"`
+	StartedAt time.Time `json:"startedAt"`
+	Uptime    string    `json:"uptime"`
+	Ready     bool      `json:"ready"`
+	// Checks has the outcome of each service's readiness check keyed by
+	// tenant, or "default" without tenants.
+	Checks   map[string]string   `json:"checks,omitempty"`
+	Services []service.RespStats `json:"services"`
+}
+
+// Healthz answers as long as the process can serve requests.
+func (a API) Healthz(rw http.ResponseWriter, r *http.Request) {
+	EncodeJSON(rw, Health{Status: "ok"}, http.StatusOK)
+}
+
+// Readyz answers 200 when every service's store is open and its rules are
+// loaded, and 503 otherwise or once the server is shutting down.
+func (a API) Readyz(rw http.ResponseWriter, r *http.Request) {
+	status, _, ready := a.readiness(r)
+
+	code := http.StatusOK
+	if !ready {
+		code = http.StatusServiceUnavailable
+	}
+	EncodeJSON(rw, Readiness{Status: status}, code)
+}
+
+// readiness runs each service's readiness check, returning the overall
+// status and the outcome of each check.
+func (a API) readiness(r *http.Request) (string, map[string]string, bool) {
+	if !a.ready.Load() {
+		return "shutting down", nil, false
+	}
+
+	checks := map[string]string{}
+	ready := true
+	for id, svc := range a.allServices() {
+		if id == "" {
+			id = "default"
+		}
+		if _, err := svc.Ready(r.Context(), service.ReqReady{}); err != nil {
+			checks[id] = err.Error()
+			ready = false
+			continue
+		}
+		checks[id] = "ok"
+	}
+	if !ready {
+		return "not ready", checks, false
+	}
+
+	return "ready", checks, true
+}
+
+// Status reports the build, uptime, readiness checks and each service's rule
+// set version and store size.
+func (a API) Status(rw http.ResponseWriter, r *http.Request) {
+	_, checks, ready := a.readiness(r)
+	resp := Status{
+		Version:   a.version,
+		GoVersion: runtime.Version(),
+		StartedAt: a.started,
+		Uptime:    time.Since(a.started).Round(time.Second).String(),
+		Ready:     ready,
+		Checks:    checks,
+		Services:  []service.RespStats{},
+	}
+
+	for _, svc := range a.allServices() {
+		stats, err := svc.Stats(r.Context(), service.ReqStats{})
+		if err != nil {
+			EncodeJSONError(rw, err)
+			return
+		}
+		resp.Services = append(resp.Services, *stats)
+	}
+	slices.SortFunc(resp.Services, func(a, b service.RespStats) int {
+		return cmp.Compare(a.Tenant, b.Tenant)
+	})
+
+	EncodeJSON(rw, resp, http.StatusOK)
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/trace"
+)
+
+// traced starts a server span for every request, named after its route, as a
+// child of the request's traceparent when it has a valid one.
+func (a API) traced(h http.Handler, muxes ...*http.ServeMux) http.Handler {
+	if a.tracer == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		remote, _ := trace.ParseTraceparent(r.Header.Get(trace.Header))
+
+		name, route := r.Method, route(r, muxes...)
+		if route != "" {
+			name = route
+		}
+		ctx, span := a.tracer.StartServer(r.Context(), name, remote,
+			trace.String("http.request.method", r.Method),
+			trace.String("http.route", route),
+			trace.String("url.path", r.URL.Path),
+		)
+		defer span.End()
+
+		sw := newStatusWriter(rw)
+		h.ServeHTTP(sw, r.WithContext(ctx))
+
+		span.SetAttributes(trace.Int("http.response.status_code", sw.code))
+		if sw.code >= http.StatusInternalServerError {
+			span.RecordError(errors.New(http.StatusText(sw.code)))
+		}
+	})
+}
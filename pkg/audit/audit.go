@@ -0,0 +1,298 @@
+// Package audit keeps an append-only, tamper-evident log of state changes.
+// Each entry holds the hash of the one before it, so changing, removing or
+// reordering entries breaks the chain from that point on.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/jsonl"
+)
+
+// DefaultMaxEntries is how many entries a Log keeps in memory for queries
+// unless WithMaxEntries is given.
+const DefaultMaxEntries = 100_000
+
+var (
+	ErrChainBroken = errors.New("audit log hash chain is broken")
+	ErrActionEmpty = errors.New("audit entry action cannot be empty")
+	ErrTargetEmpty = errors.New("audit entry target cannot be empty")
+)
+
+// Entry is one state change. Before and After summarize the target, such as
+// a receipt's points, and are empty when it did not exist.
+type Entry struct {
+	Seq       int64             `json:"seq"`
+	Time      time.Time         `json:"time"`
+	Tenant    string            `json:"tenant,omitempty"`
+	Actor     string            `json:"actor,omitempty"`
+	Action    string            `json:"action"`
+	Target    string            `json:"target"`
+	Before    map[string]string `json:"before,omitempty"`
+	After     map[string]string `json:"after,omitempty"`
+	RequestID string            `json:"requestId,omitempty"`
+	// PrevHash is the Hash of the entry before, empty for the first.
+	PrevHash string `json:"prevHash"`
+	Hash     string `json:"hash"`
+}
+
+func (e Entry) IsValid() error {
+	var err error
+	if e.Action == "" {
+		err = errors.Join(err, ErrActionEmpty)
+	}
+	if e.Target == "" {
+		err = errors.Join(err, ErrTargetEmpty)
+	}
+	return err
+}
+
+// Sum is the entry's hash: SHA-256 over its JSON encoding without Hash.
+// Map keys are encoded sorted, so the encoding is stable.
+func (e Entry) Sum() string {
+	e.Hash = ""
+	b, _ := json.Marshal(e)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// follows checks that e comes after the entry numbered seq with hash prev.
+func (e Entry) follows(seq int64, prev string) error {
+	switch {
+	case e.Seq != seq+1:
+		return fmt.Errorf("%w: entry %d has sequence number %d", ErrChainBroken, seq+1, e.Seq)
+	case e.PrevHash != prev:
+		return fmt.Errorf("%w: entry %d does not follow entry %d", ErrChainBroken, e.Seq, seq)
+	case e.Sum() != e.Hash:
+		return fmt.Errorf("%w: entry %d does not match its hash", ErrChainBroken, e.Seq)
+	}
+	return nil
+}
+
+type Option func(*Log)
+
+// WithClock replaces time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(l *Log) {
+		l.now = now
+	}
+}
+
+// WithMaxEntries keeps only the newest max entries in memory, and so in
+// queries. A log file still keeps every entry.
+func WithMaxEntries(max int) Option {
+	return func(l *Log) {
+		l.max = max
+	}
+}
+
+// Log appends entries to an optional JSON lines file and keeps the newest in
+// memory for queries. It is safe for concurrent use.
+type Log struct {
+	mu      sync.Mutex
+	file    *os.File
+	entries []Entry
+	max     int
+	// seq and head are the sequence number and hash of the last entry, which
+	// may no longer be kept in entries.
+	seq  int64
+	head string
+	now  func() time.Time
+}
+
+// NewLog keeps entries in memory only.
+func NewLog(opts ...Option) *Log {
+	l := &Log{max: DefaultMaxEntries, now: time.Now}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// OpenFile appends to the log at path, creating it if needed. The existing
+// entries are verified first, and a broken chain is an error wrapping
+// ErrChainBroken. A last entry torn by a crash while it was written is
+// truncated with a warning, which leaves the chain before it intact.
+func OpenFile(path string, opts ...Option) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	l := NewLog(opts...)
+
+	// A broken chain is kept apart from decoding errors, so that it is never
+	// taken for a torn line and truncated.
+	var broken error
+	err = jsonl.Replay(f, path, func(line []byte) error {
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return err
+		}
+		if broken == nil {
+			if broken = e.follows(l.seq, l.head); broken == nil {
+				l.add(e)
+			}
+		}
+		return nil
+	})
+	if err == nil && broken != nil {
+		err = fmt.Errorf("%s: %w", path, broken)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	l.file = f
+	return l, nil
+}
+
+// Append completes e with its sequence number, time and hashes, and writes
+// it. The file is synced before Append returns, and left as it was when the
+// entry cannot be written.
+func (l *Log) Append(e Entry) (Entry, error) {
+	if err := e.IsValid(); err != nil {
+		return Entry{}, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.Seq = l.seq + 1
+	e.Time = l.now().UTC()
+	e.PrevHash = l.head
+	e.Hash = e.Sum()
+
+	if l.file != nil {
+		if err := jsonl.Append(l.file, e); err != nil {
+			return Entry{}, err
+		}
+	}
+
+	l.add(e)
+	return e, nil
+}
+
+// add makes e the last entry. l.mu must be held. Entries beyond max are
+// dropped in batches, so adding stays cheap.
+func (l *Log) add(e Entry) {
+	if l.max > 0 && len(l.entries) >= 2*l.max {
+		l.entries = slices.Clone(l.entries[len(l.entries)-l.max:])
+	}
+	l.entries = append(l.entries, e)
+	l.seq, l.head = e.Seq, e.Hash
+}
+
+// kept returns the newest max entries. l.mu must be held.
+func (l *Log) kept() []Entry {
+	if l.max > 0 && len(l.entries) > l.max {
+		return l.entries[len(l.entries)-l.max:]
+	}
+	return l.entries
+}
+
+// Filter selects entries. Empty fields match every entry.
+type Filter struct {
+	Tenant string
+	Actor  string
+	Action string
+	Target string
+	Since  time.Time
+	Until  time.Time
+	// Limit keeps the newest entries, when positive.
+	Limit int
+}
+
+func (f Filter) match(e Entry) bool {
+	return (f.Tenant == "" || e.Tenant == f.Tenant) &&
+		(f.Actor == "" || e.Actor == f.Actor) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.Target == "" || e.Target == f.Target) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until))
+}
+
+// Query returns the entries kept in memory that match f, oldest first.
+func (l *Log) Query(f Filter) []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var entries []Entry
+	for _, e := range l.kept() {
+		if f.match(e) {
+			entries = append(entries, e)
+		}
+	}
+	if f.Limit > 0 && len(entries) > f.Limit {
+		entries = entries[len(entries)-f.Limit:]
+	}
+	return slices.Clone(entries)
+}
+
+// Head is the hash of the last entry, which vouches for every entry before
+// it, and the number of entries.
+func (l *Log) Head() (string, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.head, int(l.seq)
+}
+
+func (l *Log) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Read decodes a JSON lines log without verifying it.
+func Read(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Verify checks that entries are numbered from 1, that each hash matches
+// its entry and that each entry holds the hash of the one before. The error
+// names the first entry that fails.
+func Verify(entries []Entry) error {
+	var seq int64
+	prev := ""
+	for _, e := range entries {
+		if err := e.follows(seq, prev); err != nil {
+			return err
+		}
+		seq, prev = e.Seq, e.Hash
+	}
+	return nil
+}
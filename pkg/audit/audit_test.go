@@ -0,0 +1,243 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	l, err := OpenFile(path, WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []Entry{
+		{Tenant: "acme", Actor: "client:acme", Action: "receipt.submit", Target: "receipt/1", After: map[string]string{"points": "28"}},
+		{Tenant: "acme", Actor: "user:ana", Action: "retailer.put", Target: "retailer/target", Before: map[string]string{"name": "Target"}, After: map[string]string{"name": "Target Corp"}},
+		{Tenant: "globex", Actor: "client:globex", Action: "receipt.submit", Target: "receipt/2", After: map[string]string{"points": "109"}},
+	}
+	for _, e := range entries {
+		if _, err := l.Append(e); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Minute)
+	}
+	l.Close()
+
+	t.Run("Append: should refuse an entry without an action or target", func(t *testing.T) {
+		_, err := NewLog().Append(Entry{})
+		if !errors.Is(err, ErrActionEmpty) || !errors.Is(err, ErrTargetEmpty) {
+			t.Error("got", err, "want", ErrActionEmpty, ErrTargetEmpty)
+		}
+	})
+
+	t.Run("OpenFile: should reopen a log and extend its chain", func(t *testing.T) {
+		l, err := OpenFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+
+		head, n := l.Head()
+		e, err := l.Append(Entry{Action: "review.delete", Target: "review/1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 3 || e.Seq != 4 || e.PrevHash != head {
+			t.Errorf("got seq %d after %d entries, prev %s, want 4 after 3, prev %s", e.Seq, n, e.PrevHash, head)
+		}
+	})
+
+	t.Run("Query: should filter entries", func(t *testing.T) {
+		l, err := OpenFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+
+		tests := []struct {
+			filter Filter
+			want   []int64
+		}{
+			{filter: Filter{}, want: []int64{1, 2, 3, 4}},
+			{filter: Filter{Tenant: "acme"}, want: []int64{1, 2}},
+			{filter: Filter{Action: "receipt.submit"}, want: []int64{1, 3}},
+			{filter: Filter{Actor: "user:ana"}, want: []int64{2}},
+			{filter: Filter{Target: "receipt/2"}, want: []int64{3}},
+			{filter: Filter{Since: time.Date(2024, 1, 1, 12, 1, 0, 0, time.UTC), Until: time.Date(2024, 1, 1, 12, 2, 0, 0, time.UTC)}, want: []int64{2}},
+			{filter: Filter{Limit: 2}, want: []int64{3, 4}},
+		}
+		for _, tt := range tests {
+			var got []int64
+			for _, e := range l.Query(tt.filter) {
+				got = append(got, e.Seq)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("%+v: got %v, want %v", tt.filter, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("Verify: should detect tampering", func(t *testing.T) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		original, err := Read(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Verify(original); err != nil {
+			t.Fatal("got", err, "want an intact chain")
+		}
+
+		tests := []struct {
+			name   string
+			tamper func([]Entry) []Entry
+			want   string
+		}{
+			{name: "changed", tamper: func(e []Entry) []Entry { e[1].After["name"] = "Other"; return e }, want: "entry 2 does not match its hash"},
+			{name: "rehashed", tamper: func(e []Entry) []Entry { e[1].Actor = "user:bo"; e[1].Hash = e[1].Sum(); return e }, want: "entry 3 does not follow entry 2"},
+			{name: "removed", tamper: func(e []Entry) []Entry { return append(e[:1], e[2:]...) }, want: "entry 2 has sequence number 3"},
+			{name: "truncated head", tamper: func(e []Entry) []Entry { return e[1:] }, want: "entry 1 has sequence number 2"},
+		}
+		for _, tt := range tests {
+			entries, _ := Read(bytes.NewReader(b))
+			err := Verify(tt.tamper(entries))
+			if !errors.Is(err, ErrChainBroken) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%s: got %v, want %s", tt.name, err, tt.want)
+			}
+		}
+	})
+
+	t.Run("OpenFile: should refuse a tampered log", func(t *testing.T) {
+		entries, err := Read(mustOpen(t, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[0].After["points"] = "2800"
+
+		tampered := filepath.Join(t.TempDir(), "tampered.jsonl")
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, e := range entries {
+			enc.Encode(e)
+		}
+		if err := os.WriteFile(tampered, buf.Bytes(), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := OpenFile(tampered); !errors.Is(err, ErrChainBroken) {
+			t.Error("got", err, "want", ErrChainBroken)
+		}
+	})
+
+	t.Run("OpenFile: should truncate a torn last entry", func(t *testing.T) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		torn := filepath.Join(t.TempDir(), "torn.jsonl")
+		if err := os.WriteFile(torn, append(b, `{"seq":5,"time":"2024-01-`...), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		l, err := OpenFile(torn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		head, n := l.Head()
+		e, err := l.Append(Entry{Action: "review.delete", Target: "review/2"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Close()
+		if n != 4 || e.Seq != 5 || e.PrevHash != head {
+			t.Errorf("got seq %d after %d entries, want 5 after 4", e.Seq, n)
+		}
+
+		entries, err := Read(mustOpen(t, torn))
+		if err == nil {
+			err = Verify(entries)
+		}
+		if err != nil || len(entries) != 5 {
+			t.Errorf("got %d entries, %v, want 5 entries and an intact chain", len(entries), err)
+		}
+	})
+
+	t.Run("OpenFile: should refuse a broken last entry without a newline", func(t *testing.T) {
+		entries, err := Read(mustOpen(t, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[len(entries)-1].Actor = "user:bo"
+
+		broken := filepath.Join(t.TempDir(), "broken.jsonl")
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, e := range entries {
+			enc.Encode(e)
+		}
+		if err := os.WriteFile(broken, bytes.TrimSuffix(buf.Bytes(), []byte("\n")), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := OpenFile(broken); !errors.Is(err, ErrChainBroken) {
+			t.Error("got", err, "want", ErrChainBroken)
+		}
+	})
+
+	t.Run("WithMaxEntries: should keep the newest entries and extend the chain", func(t *testing.T) {
+		l, err := OpenFile(path, WithMaxEntries(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+
+		for i := range 3 {
+			if _, err := l.Append(Entry{Action: "review.delete", Target: fmt.Sprintf("review/%d", i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var got []int64
+		for _, e := range l.Query(Filter{}) {
+			got = append(got, e.Seq)
+		}
+		if want := []int64{6, 7}; !slices.Equal(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if _, n := l.Head(); n != 7 {
+			t.Errorf("got %d entries, want 7", n)
+		}
+
+		entries, err := Read(mustOpen(t, path))
+		if err == nil {
+			err = Verify(entries)
+		}
+		if err != nil || len(entries) != 7 {
+			t.Errorf("got %d entries in the file, %v, want 7 and an intact chain", len(entries), err)
+		}
+	})
+}
+
+func mustOpen(t *testing.T, path string) *os.File {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
@@ -0,0 +1,240 @@
+// Package auth authenticates API clients by key. Keys are stored as SHA-256
+// hashes, each tied to a client and the operations it may perform.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// KeyPrefix starts every generated key, so leaked keys are easy to find.
+const KeyPrefix = "rk_"
+
+// HashPrefix names the algorithm of a stored key hash.
+const HashPrefix = "sha256:"
+
+type Scope string
+
+const (
+	ScopeSubmit Scope = "submit" // Process and import receipts.
+	ScopeRead   Scope = "read"   // Read points and export receipts.
+	ScopeAdmin  Scope = "admin"  // Admin endpoints, and every client's receipts.
+)
+
+func (s Scope) IsValid() bool {
+	switch s {
+	case ScopeSubmit, ScopeRead, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+var (
+	ErrKeyMissing      = errors.New("api key is missing")
+	ErrKeyUnknown      = errors.New("api key is not recognized")
+	ErrScopeMissing    = errors.New("api key does not have the required scope")
+	ErrTenantUnbound   = errors.New("credentials without a tenant need the admin scope")
+	ErrKeyIdEmpty      = errors.New("key id cannot be empty")
+	ErrKeyIdDuplicate  = errors.New("key id is used twice")
+	ErrClientIdEmpty   = errors.New("client id cannot be empty")
+	ErrClientIdInvalid = errors.New("client id must be letters, digits, dots, dashes and underscores")
+	ErrHashInvalid     = errors.New("key hash must be sha256: followed by 64 hex digits")
+	ErrHashDuplicate   = errors.New("key hash is used twice")
+	ErrScopesEmpty     = errors.New("key must have at least one scope")
+	ErrScopeInvalid    = errors.New("scope must be submit, read or admin")
+)
+
+var (
+	reClientId = regexp.MustCompile(`^[A-Za-z0-9._\-]+$`)
+	reHash     = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+)
+
+// Key is a stored API key. The secret itself is never stored, only its hash.
+type Key struct {
+	ID       string  `json:"id"`
+	ClientID string  `json:"clientId"`
+	Hash     string  `json:"hash"`
+	Scopes   []Scope `json:"scopes"`
+	// Tenant binds the key to one tenant. Keys without a tenant need the
+	// admin scope in deployments with tenants.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+func (k Key) IsValid() error {
+	var err error
+
+	if strings.TrimSpace(k.ID) == "" {
+		err = errors.Join(err, ErrKeyIdEmpty)
+	}
+
+	if k.ClientID == "" {
+		err = errors.Join(err, ErrClientIdEmpty)
+	} else if !reClientId.MatchString(k.ClientID) {
+		err = errors.Join(err, ErrClientIdInvalid)
+	}
+
+	if !reHash.MatchString(k.Hash) {
+		err = errors.Join(err, ErrHashInvalid)
+	}
+
+	if len(k.Scopes) == 0 {
+		err = errors.Join(err, ErrScopesEmpty)
+	}
+	for _, s := range k.Scopes {
+		if !s.IsValid() {
+			err = errors.Join(err, fmt.Errorf("%w: %q", ErrScopeInvalid, s))
+		}
+	}
+
+	return err
+}
+
+// Hash is the stored form of a key secret.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return HashPrefix + hex.EncodeToString(sum[:])
+}
+
+// Generate returns a new random key secret.
+func Generate() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return KeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// UserScopes are granted to bearer tokens that carry no known scope.
+var UserScopes = []Scope{ScopeSubmit, ScopeRead}
+
+// ParseScopes reads a space-separated scope claim, ignoring scopes it does
+// not know. Without any known scope it returns UserScopes.
+func ParseScopes(claim string) []Scope {
+	var scopes []Scope
+	for _, s := range strings.Fields(claim) {
+		if scope := Scope(s); scope.IsValid() && !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return slices.Clone(UserScopes)
+	}
+	return scopes
+}
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	ClientID string
+	KeyID    string // Empty for bearer tokens.
+	UserID   string // Subject of the bearer token, empty for API keys alone.
+	Tenant   string // Tenant the credentials are bound to, if any.
+	Scopes   []Scope
+}
+
+func (p Principal) Has(scope Scope) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal of an authenticated request. It reports
+// false when authentication is disabled.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(Principal)
+	return p, ok
+}
+
+// Keyring holds the keys clients may authenticate with. It is read-only once
+// built, so it is safe for concurrent use.
+type Keyring struct {
+	byHash map[string]Key
+}
+
+type keyringFile struct {
+	Keys []Key `json:"keys"`
+}
+
+func NewKeyring(keys []Key) (*Keyring, error) {
+	k := &Keyring{byHash: map[string]Key{}}
+
+	var err error
+	ids := map[string]bool{}
+	for _, key := range keys {
+		if kerr := key.IsValid(); kerr != nil {
+			err = errors.Join(err, fmt.Errorf("key %q: %w", key.ID, kerr))
+			continue
+		}
+		if ids[key.ID] {
+			err = errors.Join(err, fmt.Errorf("%w: %q", ErrKeyIdDuplicate, key.ID))
+		}
+		if _, ok := k.byHash[key.Hash]; ok {
+			err = errors.Join(err, fmt.Errorf("%w: %q", ErrHashDuplicate, key.ID))
+		}
+		ids[key.ID] = true
+		k.byHash[key.Hash] = key
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Load reads a keyring from JSON of the form {"keys": [...]}.
+func Load(r io.Reader) (*Keyring, error) {
+	var file keyringFile
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("error decoding keys: %w", err)
+	}
+	return NewKeyring(file.Keys)
+}
+
+func LoadFile(path string) (*Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	k, err := Load(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return k, nil
+}
+
+// Authenticate returns the principal a key secret belongs to. Secrets are
+// looked up by hash, so lookup timing says nothing about stored secrets. A nil
+// Keyring knows no keys.
+func (k *Keyring) Authenticate(secret string) (Principal, error) {
+	if secret == "" {
+		return Principal{}, ErrKeyMissing
+	}
+	if k == nil {
+		return Principal{}, ErrKeyUnknown
+	}
+
+	key, ok := k.byHash[Hash(secret)]
+	if !ok {
+		return Principal{}, ErrKeyUnknown
+	}
+
+	return Principal{ClientID: key.ClientID, KeyID: key.ID, Tenant: key.Tenant, Scopes: key.Scopes}, nil
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestKeyring(t *testing.T) {
+	secret, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, KeyPrefix) {
+		t.Errorf("got %q, want prefix %q", secret, KeyPrefix)
+	}
+
+	keys, err := NewKeyring([]Key{
+		{ID: "acme-ci", ClientID: "acme", Hash: Hash(secret), Scopes: []Scope{ScopeSubmit, ScopeRead}},
+		{ID: "ops", ClientID: "internal", Hash: Hash("rk_ops"), Scopes: []Scope{ScopeAdmin}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Authenticate: should return the key's principal", func(t *testing.T) {
+		p, err := keys.Authenticate(secret)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if p.ClientID != "acme" || p.KeyID != "acme-ci" || !p.Has(ScopeSubmit) || p.Has(ScopeAdmin) {
+			t.Errorf("got %+v, want acme with submit and read", p)
+		}
+	})
+
+	tests := []struct {
+		name    string
+		secret  string
+		wantErr error
+	}{
+		{name: "Authenticate: should return error for a missing key", secret: "", wantErr: ErrKeyMissing},
+		{name: "Authenticate: should return error for an unknown key", secret: "rk_unknown", wantErr: ErrKeyUnknown},
+		{name: "Authenticate: should return error for the hash itself", secret: Hash(secret), wantErr: ErrKeyUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := keys.Authenticate(tt.secret); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("FromContext: should return the principal", func(t *testing.T) {
+		if _, ok := FromContext(context.Background()); ok {
+			t.Error("got a principal, want none")
+		}
+		p, ok := FromContext(NewContext(context.Background(), Principal{ClientID: "acme"}))
+		if !ok || p.ClientID != "acme" {
+			t.Errorf("got %+v %v, want acme", p, ok)
+		}
+	})
+}
+
+func TestLoad(t *testing.T) {
+	hash := Hash("rk_test")
+
+	tests := []struct {
+		name    string
+		json    string
+		wantErr error
+	}{
+		{
+			name: "Load: should load keys",
+			json: `{"keys": [{"id": "a", "clientId": "acme", "hash": "` + hash + `", "scopes": ["submit"]}]}`,
+		},
+		{
+			name:    "Load: should return error for a plain-text key",
+			json:    `{"keys": [{"id": "a", "clientId": "acme", "hash": "rk_test", "scopes": ["submit"]}]}`,
+			wantErr: ErrHashInvalid,
+		},
+		{
+			name:    "Load: should return error for an unknown scope",
+			json:    `{"keys": [{"id": "a", "clientId": "acme", "hash": "` + hash + `", "scopes": ["write"]}]}`,
+			wantErr: ErrScopeInvalid,
+		},
+		{
+			name:    "Load: should return error for a key without scopes",
+			json:    `{"keys": [{"id": "a", "clientId": "acme", "hash": "` + hash + `"}]}`,
+			wantErr: ErrScopesEmpty,
+		},
+		{
+			name:    "Load: should return error for an invalid client id",
+			json:    `{"keys": [{"id": "a", "clientId": "acme corp", "hash": "` + hash + `", "scopes": ["read"]}]}`,
+			wantErr: ErrClientIdInvalid,
+		},
+		{
+			name:    "Load: should return error for a duplicate id",
+			json:    `{"keys": [{"id": "a", "clientId": "acme", "hash": "` + hash + `", "scopes": ["read"]}, {"id": "a", "clientId": "acme", "hash": "` + Hash("rk_other") + `", "scopes": ["read"]}]}`,
+			wantErr: ErrKeyIdDuplicate,
+		},
+		{
+			name:    "Load: should return error for a duplicate hash",
+			json:    `{"keys": [{"id": "a", "clientId": "acme", "hash": "` + hash + `", "scopes": ["read"]}, {"id": "b", "clientId": "other", "hash": "` + hash + `", "scopes": ["read"]}]}`,
+			wantErr: ErrHashDuplicate,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Load(strings.NewReader(tt.json)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("Load: should return error for unknown fields", func(t *testing.T) {
+		if _, err := Load(strings.NewReader(`{"keys": [{"id": "a", "key": "rk_test"}]}`)); err == nil {
+			t.Error("got nil, want error")
+		}
+	})
+}
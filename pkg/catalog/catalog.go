@@ -0,0 +1,295 @@
+// Package catalog matches receipt item descriptions, such as "Mountain Dew
+// 12PK", to products in a local catalog so items can be categorized.
+package catalog
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// DefaultThreshold is the lowest score Match accepts.
+const DefaultThreshold = 0.75
+
+var (
+	ErrSKUEmpty      = errors.New("product sku cannot be empty")
+	ErrSKUDuplicate  = errors.New("product skus must be unique")
+	ErrNameEmpty     = errors.New("product name cannot be empty")
+	ErrCategoryEmpty = errors.New("product category cannot be empty")
+	ErrFormat        = errors.New("catalog file must be .csv or .json")
+	ErrCSVHeader     = errors.New("catalog csv must have sku, name and category columns")
+)
+
+var (
+	reNonAlnum = regexp.MustCompile(`[^a-z0-9]+`)
+
+	// reSize matches pack and size tokens, like "12pk", "2l" or "oz", that
+	// vary between receipts for the same product.
+	reSize = regexp.MustCompile(`^(\d+(\.\d+)?(pk|pack|ct|oz|fl|floz|lb|lbs|g|kg|ml|l)?|pk|pack|ct|oz|fl|lb|lbs|ml)$`)
+)
+
+type Product struct {
+	SKU      string   `json:"sku"`
+	Name     string   `json:"name"`
+	Category string   `json:"category"`
+	Aliases  []string `json:"aliases,omitempty"`
+}
+
+// Normalize lowercases a description, replaces punctuation with spaces and
+// drops size and pack tokens, so "Klarbrunn 12-PK 12 FL OZ" becomes
+// "klarbrunn".
+func Normalize(desc string) string {
+	fields := strings.Fields(reNonAlnum.ReplaceAllString(strings.ToLower(desc), " "))
+
+	kept := fields[:0]
+	for _, f := range fields {
+		if !reSize.MatchString(f) {
+			kept = append(kept, f)
+		}
+	}
+
+	return strings.Join(kept, " ")
+}
+
+type entry struct {
+	product Product
+	keys    []string
+}
+
+// Catalog is an immutable set of products. It is safe for concurrent use.
+type Catalog struct {
+	entries   []entry
+	threshold float64
+}
+
+type Option func(*Catalog)
+
+// WithThreshold overrides DefaultThreshold.
+func WithThreshold(score float64) Option {
+	return func(c *Catalog) {
+		c.threshold = score
+	}
+}
+
+func New(products []Product, opts ...Option) (*Catalog, error) {
+	c := &Catalog{threshold: DefaultThreshold}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	var err error
+	seen := map[string]bool{}
+
+	for i, p := range products {
+		var perr error
+		if p.SKU == "" {
+			perr = errors.Join(perr, ErrSKUEmpty)
+		} else if seen[p.SKU] {
+			perr = errors.Join(perr, ErrSKUDuplicate)
+		}
+		if Normalize(p.Name) == "" {
+			perr = errors.Join(perr, ErrNameEmpty)
+		}
+		if p.Category == "" {
+			perr = errors.Join(perr, ErrCategoryEmpty)
+		}
+		if perr != nil {
+			err = errors.Join(err, fmt.Errorf("product %d (%q): %w", i, p.SKU, perr))
+			continue
+		}
+		seen[p.SKU] = true
+
+		e := entry{product: p}
+		for _, name := range append([]string{p.Name}, p.Aliases...) {
+			if key := Normalize(name); key != "" && !slices.Contains(e.keys, key) {
+				e.keys = append(e.keys, key)
+			}
+		}
+		c.entries = append(c.entries, e)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+type Match struct {
+	Product
+	// Score is 1 for an exact match of the normalized description.
+	Score float64 `json:"score"`
+}
+
+// Match finds the product that best matches an item description. The score
+// blends token overlap with edit distance; ties go to the lowest SKU.
+func (c *Catalog) Match(desc string) (Match, bool) {
+	normalized := Normalize(desc)
+	if normalized == "" {
+		return Match{}, false
+	}
+
+	var best Match
+	for _, e := range c.entries {
+		for _, key := range e.keys {
+			score := similarity(normalized, key)
+			if score > best.Score || (score == best.Score && score > 0 && e.product.SKU < best.SKU) {
+				best = Match{Product: e.product, Score: score}
+			}
+		}
+	}
+
+	if best.Score < c.threshold {
+		return Match{}, false
+	}
+	return best, true
+}
+
+func (c *Catalog) Len() int {
+	return len(c.entries)
+}
+
+func similarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	return (tokenOverlap(a, b) + editRatio(a, b)) / 2
+}
+
+// tokenOverlap is the Dice coefficient of the two token sets, counting
+// tokens within one edit of each other as shared to absorb OCR typos.
+func tokenOverlap(a, b string) float64 {
+	at, bt := strings.Fields(a), strings.Fields(b)
+
+	shared := 0
+	used := make([]bool, len(bt))
+	for _, x := range at {
+		for j, y := range bt {
+			if used[j] {
+				continue
+			}
+			if x == y || (len(x) > 3 && len(y) > 3 && levenshtein(x, y) <= 1) {
+				used[j] = true
+				shared++
+				break
+			}
+		}
+	}
+
+	return 2 * float64(shared) / float64(len(at)+len(bt))
+}
+
+func editRatio(a, b string) float64 {
+	longest := max(len(a), len(b))
+	return 1 - float64(levenshtein(a, b))/float64(longest)
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+// LoadFile loads a catalog from a .csv or .json file.
+func LoadFile(path string, opts ...Option) (*Catalog, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var products []Product
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		products, err = ReadCSV(f)
+	case ".json":
+		products, err = ReadJSON(f)
+	default:
+		err = ErrFormat
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	c, err := New(products, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// ReadJSON decodes a JSON array of products.
+func ReadJSON(r io.Reader) ([]Product, error) {
+	var products []Product
+	if err := json.NewDecoder(r).Decode(&products); err != nil {
+		return nil, fmt.Errorf("error decoding catalog: %w", err)
+	}
+	return products, nil
+}
+
+// ReadCSV reads products from a CSV file with a header row naming the sku,
+// name and category columns, and optionally an aliases column whose values
+// are separated by "|".
+func ReadCSV(r io.Reader) ([]Product, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading catalog header: %w", err)
+	}
+
+	cols := map[string]int{}
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"sku", "name", "category"} {
+		if _, ok := cols[required]; !ok {
+			return nil, ErrCSVHeader
+		}
+	}
+
+	var products []Product
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return products, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading catalog: %w", err)
+		}
+
+		p := Product{
+			SKU:      record[cols["sku"]],
+			Name:     record[cols["name"]],
+			Category: record[cols["category"]],
+		}
+		if i, ok := cols["aliases"]; ok && record[i] != "" {
+			p.Aliases = strings.Split(record[i], "|")
+		}
+		products = append(products, p)
+	}
+}
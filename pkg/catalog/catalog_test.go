@@ -0,0 +1,113 @@
+package catalog
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "Normalize: should lowercase and trim", input: "  Gatorade ", want: "gatorade"},
+		{name: "Normalize: should drop pack sizes", input: "Mountain Dew 12PK", want: "mountain dew"},
+		{name: "Normalize: should drop volumes", input: "   Klarbrunn 12-PK 12 FL OZ  ", want: "klarbrunn"},
+		{name: "Normalize: should replace punctuation", input: "Pepsi - 12-oz", want: "pepsi"},
+		{name: "Normalize: should keep words with digits", input: "V8 Juice 2L", want: "v8 juice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Normalize(tt.input); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+const catalogCSV = `sku,name,category,aliases
+BEV-001,Gatorade,beverages,Gatorade Thirst Quencher
+BEV-002,Mountain Dew,beverages,Mtn Dew
+FRZ-001,Emils Cheese Pizza,frozen,
+SNK-001,Doritos Nacho Cheese,snacks,Doritos
+`
+
+func TestMatch(t *testing.T) {
+	products, err := ReadCSV(strings.NewReader(catalogCSV))
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	c, err := New(products)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		wantSKU string
+	}{
+		{name: "Match: should match the exact name", input: "Gatorade", wantSKU: "BEV-001"},
+		{name: "Match: should match ignoring the pack size", input: "Mountain Dew 12PK", wantSKU: "BEV-002"},
+		{name: "Match: should match an alias", input: "MTN DEW 2L", wantSKU: "BEV-002"},
+		{name: "Match: should tolerate a typo", input: "Emils Chese Pizza", wantSKU: "FRZ-001"},
+		{name: "Match: should match a longer description", input: "Doritos Nacho Cheese 9.25oz", wantSKU: "SNK-001"},
+		{name: "Match: should not match unrelated items", input: "Paper Towels", wantSKU: ""},
+		{name: "Match: should not match sizes alone", input: "12 oz", wantSKU: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := c.Match(tt.input)
+			if ok != (tt.wantSKU != "") || got.SKU != tt.wantSKU {
+				t.Errorf("got %q (score %.2f), want %q", got.SKU, got.Score, tt.wantSKU)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("ReadJSON: should decode products", func(t *testing.T) {
+		products, err := ReadJSON(strings.NewReader(`[{"sku": "BEV-003", "name": "Pepsi", "category": "beverages"}]`))
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if len(products) != 1 || products[0].Category != "beverages" {
+			t.Errorf("got %v, want one beverage", products)
+		}
+	})
+
+	t.Run("LoadFile: should load the example catalog", func(t *testing.T) {
+		c, err := LoadFile("../../examples/config/catalog.csv")
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if c.Len() == 0 {
+			t.Errorf("got an empty catalog")
+		}
+	})
+
+	t.Run("ReadCSV: should require the header columns", func(t *testing.T) {
+		_, err := ReadCSV(strings.NewReader("sku,title\nBEV-003,Pepsi\n"))
+		if !errors.Is(err, ErrCSVHeader) {
+			t.Errorf("got %v, want %v", err, ErrCSVHeader)
+		}
+	})
+
+	t.Run("New: should validate products", func(t *testing.T) {
+		_, err := New([]Product{
+			{SKU: "A", Name: "Pepsi", Category: "beverages"},
+			{SKU: "A", Name: "Coke", Category: "beverages"},
+			{SKU: "B", Name: "12 oz"},
+		})
+		for _, want := range []error{ErrSKUDuplicate, ErrNameEmpty, ErrCategoryEmpty} {
+			if !errors.Is(err, want) {
+				t.Errorf("got %v, want %v", err, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,490 @@
+// Package config loads the server configuration. Settings are layered, each
+// overriding the one before: defaults, a JSON config file, RECEIPT_*
+// environment variables and command-line flags.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/logging"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/ratelimit"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/tlsconf"
+)
+
+// EnvPrefix starts the environment variable of every setting, so the
+// -read-timeout flag is RECEIPT_READ_TIMEOUT.
+const EnvPrefix = "RECEIPT_"
+
+const (
+	StoreMemory = "memory"
+	StoreFile   = "file"
+)
+
+var (
+	ErrAddrInvalid       = errors.New("addr must be a host:port listen address")
+	ErrTimeoutInvalid    = errors.New("timeouts must be positive")
+	ErrDelayInvalid      = errors.New("shutdown delay cannot be negative")
+	ErrBodyLimitInvalid  = errors.New("body size limits must be positive")
+	ErrStoreInvalid      = errors.New("store must be memory or file")
+	ErrStorePathEmpty    = errors.New("store path cannot be empty for the file store")
+	ErrLogLevelInvalid   = errors.New("log level must be debug, info, warn or error")
+	ErrJWKSBoth          = errors.New("jwks file and jwks url cannot both be set")
+	ErrJWTClaimsEmpty    = errors.New("jwt issuer and audience must be set with a jwks")
+	ErrQuotaInvalid      = errors.New("daily quota cannot be negative")
+	ErrMaxReviewsInvalid = errors.New("max reviews must be positive")
+	ErrAuditMaxInvalid   = errors.New("audit max entries must be positive")
+	ErrAdminAddrInvalid  = errors.New("admin addr must be a host:port listen address other than addr")
+)
+
+// Duration is a time.Duration written as a string such as "5s" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"5s\": %w", err)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+type Config struct {
+	Addr string `json:"addr"`
+	// AdminAddr serves the /admin routes on their own port instead of Addr.
+	AdminAddr string `json:"adminAddr,omitempty"`
+
+	ReadTimeout     Duration `json:"readTimeout"`
+	WriteTimeout    Duration `json:"writeTimeout"`
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+	// ShutdownDelay is how long the server keeps serving after readiness
+	// fails on shutdown, so orchestrators see the failing probe and stop
+	// routing requests before connections are drained.
+	ShutdownDelay Duration `json:"shutdownDelay"`
+
+	// MaxBodyBytes bounds JSON and text request bodies; MaxUploadBytes bounds
+	// email and CSV uploads.
+	MaxBodyBytes   int64 `json:"maxBodyBytes"`
+	MaxUploadBytes int64 `json:"maxUploadBytes"`
+	// DisallowUnknownFields rejects JSON bodies with fields the API does not
+	// know, instead of ignoring them.
+	DisallowUnknownFields bool `json:"disallowUnknownFields"`
+
+	Store     string `json:"store"`
+	StorePath string `json:"storePath,omitempty"`
+
+	RulesFile     string `json:"rulesFile,omitempty"`
+	CatalogFile   string `json:"catalogFile,omitempty"`
+	RatesFile     string `json:"ratesFile,omitempty"`
+	RetailersFile string `json:"retailersFile,omitempty"`
+
+	LogLevel string `json:"logLevel"`
+	// LogFormat is text or json. LogRedact lists, comma-separated, the log
+	// attributes whose values are replaced with [REDACTED].
+	LogFormat string `json:"logFormat"`
+	LogRedact string `json:"logRedact"`
+
+	// TraceFile appends spans to a file as OTLP JSON. Tracing is disabled
+	// when it is empty.
+	TraceFile string `json:"traceFile,omitempty"`
+
+	// AuditFile appends the audit log of state changes to a file. It is
+	// kept in memory when empty. AuditMaxEntries caps the newest entries kept
+	// in memory for queries; the file keeps every entry.
+	AuditFile       string `json:"auditFile,omitempty"`
+	AuditMaxEntries int    `json:"auditMaxEntries"`
+
+	// TenantsFile lists the tenants served by the deployment. With it every
+	// request belongs to a tenant, and file stores are partitioned per tenant
+	// as receipts.<tenant>.jsonl.
+	TenantsFile string `json:"tenantsFile,omitempty"`
+
+	// KeysFile holds the hashed API keys clients authenticate with. Without it
+	// the API is open to anyone who can reach it.
+	KeysFile string `json:"keysFile,omitempty"`
+
+	// Bearer tokens are accepted when a JWKS file or URL is set. The key set
+	// is cached for JWKSCacheTTL.
+	JWKSFile     string   `json:"jwksFile,omitempty"`
+	JWKSURL      string   `json:"jwksUrl,omitempty"`
+	JWKSCacheTTL Duration `json:"jwksCacheTtl"`
+	JWTIssuer    string   `json:"jwtIssuer,omitempty"`
+	JWTAudience  string   `json:"jwtAudience,omitempty"`
+
+	// RateLimits are route=limit pairs, such as "POST /receipts/process=10/m,
+	// *=100/m", applied per user, client or IP address. DailyQuota is how many
+	// receipts each client or user may submit per UTC day. Zero is no limit.
+	RateLimits string `json:"rateLimits,omitempty"`
+	DailyQuota int    `json:"dailyQuota"`
+
+	// MaxReviews caps the emails queued for manual review per tenant.
+	// Further emails that need review are refused until reviews are deleted.
+	MaxReviews int `json:"maxReviews"`
+
+	// The server speaks HTTPS when a certificate is set. Client certificates
+	// are verified against TLSClientCAFile when TLSClientAuth is request or
+	// require.
+	TLSCertFile     string `json:"tlsCertFile,omitempty"`
+	TLSKeyFile      string `json:"tlsKeyFile,omitempty"`
+	TLSMinVersion   string `json:"tlsMinVersion"`
+	TLSCipherPolicy string `json:"tlsCipherPolicy"`
+	TLSClientAuth   string `json:"tlsClientAuth"`
+	TLSClientCAFile string `json:"tlsClientCAFile,omitempty"`
+}
+
+func Default() Config {
+	return Config{
+		Addr:            ":8080",
+		ReadTimeout:     Duration(5 * time.Second),
+		WriteTimeout:    Duration(5 * time.Second),
+		ShutdownTimeout: Duration(10 * time.Second),
+		MaxBodyBytes:    1 << 20,
+		MaxUploadBytes:  32 << 20,
+		Store:           StoreMemory,
+		MaxReviews:      1000,
+		AuditMaxEntries: 100_000,
+		LogLevel:        "info",
+		LogFormat:       logging.FormatText,
+		LogRedact:       "retailer",
+		JWKSCacheTTL:    Duration(10 * time.Minute),
+		TLSMinVersion:   "1.2",
+		TLSCipherPolicy: "default",
+		TLSClientAuth:   "none",
+	}
+}
+
+func (c Config) IsValid() error {
+	var err error
+
+	if _, _, aerr := net.SplitHostPort(c.Addr); aerr != nil {
+		err = errors.Join(err, fmt.Errorf("%w: %w", ErrAddrInvalid, aerr))
+	}
+	if c.AdminAddr != "" {
+		if _, _, aerr := net.SplitHostPort(c.AdminAddr); aerr != nil || c.AdminAddr == c.Addr {
+			err = errors.Join(err, fmt.Errorf("%w: %q", ErrAdminAddrInvalid, c.AdminAddr))
+		}
+	}
+
+	if c.ReadTimeout <= 0 || c.WriteTimeout <= 0 || c.ShutdownTimeout <= 0 || c.JWKSCacheTTL <= 0 {
+		err = errors.Join(err, ErrTimeoutInvalid)
+	}
+	if c.ShutdownDelay < 0 {
+		err = errors.Join(err, ErrDelayInvalid)
+	}
+
+	if c.MaxBodyBytes <= 0 || c.MaxUploadBytes <= 0 {
+		err = errors.Join(err, ErrBodyLimitInvalid)
+	}
+
+	switch c.Store {
+	case StoreMemory:
+	case StoreFile:
+		if c.StorePath == "" {
+			err = errors.Join(err, ErrStorePathEmpty)
+		}
+	default:
+		err = errors.Join(err, ErrStoreInvalid)
+	}
+
+	if _, lerr := c.Level(); lerr != nil {
+		err = errors.Join(err, lerr)
+	}
+	if c.LogFormat != logging.FormatText && c.LogFormat != logging.FormatJSON {
+		err = errors.Join(err, fmt.Errorf("%w: %q", logging.ErrFormatInvalid, c.LogFormat))
+	}
+
+	if c.JWKSFile != "" && c.JWKSURL != "" {
+		err = errors.Join(err, ErrJWKSBoth)
+	}
+	if (c.JWKSFile != "" || c.JWKSURL != "") && (c.JWTIssuer == "" || c.JWTAudience == "") {
+		err = errors.Join(err, ErrJWTClaimsEmpty)
+	}
+
+	if _, perr := ratelimit.ParsePolicy(c.RateLimits); perr != nil {
+		err = errors.Join(err, perr)
+	}
+	if c.DailyQuota < 0 {
+		err = errors.Join(err, ErrQuotaInvalid)
+	}
+	if c.MaxReviews <= 0 {
+		err = errors.Join(err, ErrMaxReviewsInvalid)
+	}
+	if c.AuditMaxEntries <= 0 {
+		err = errors.Join(err, ErrAuditMaxInvalid)
+	}
+
+	if terr := c.TLS().IsValid(); terr != nil {
+		err = errors.Join(err, terr)
+	}
+
+	return err
+}
+
+func (c Config) TLS() tlsconf.Options {
+	return tlsconf.Options{
+		CertFile:     c.TLSCertFile,
+		KeyFile:      c.TLSKeyFile,
+		MinVersion:   c.TLSMinVersion,
+		CipherPolicy: c.TLSCipherPolicy,
+		ClientAuth:   c.TLSClientAuth,
+		ClientCAFile: c.TLSClientCAFile,
+	}
+}
+
+// Logging is the logger configuration.
+func (c Config) Logging() logging.Options {
+	level, _ := c.Level()
+	return logging.Options{
+		Level:  level,
+		Format: c.LogFormat,
+		Redact: logging.ParseRedact(c.LogRedact),
+	}
+}
+
+// Level is the parsed LogLevel.
+func (c Config) Level() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrLogLevelInvalid, c.LogLevel)
+	}
+	return level, nil
+}
+
+// LogValue prints the effective configuration in structured logs.
+func (c Config) LogValue() slog.Value {
+	attrs := make([]slog.Attr, 0, len(settings))
+	for _, s := range settings {
+		attrs = append(attrs, slog.String(s.name, s.get(&c)))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// setting is one configuration value, settable from a flag named name and
+// the matching environment variable.
+type setting struct {
+	name  string
+	usage string
+	get   func(c *Config) string
+	set   func(c *Config, v string) error
+}
+
+func stringSetting(name, usage string, field func(c *Config) *string) setting {
+	return setting{
+		name:  name,
+		usage: usage,
+		get:   func(c *Config) string { return *field(c) },
+		set: func(c *Config, v string) error {
+			*field(c) = v
+			return nil
+		},
+	}
+}
+
+func durationSetting(name, usage string, field func(c *Config) *Duration) setting {
+	return setting{
+		name:  name,
+		usage: usage,
+		get:   func(c *Config) string { return field(c).String() },
+		set: func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return err
+			}
+			*field(c) = Duration(d)
+			return nil
+		},
+	}
+}
+
+func boolSetting(name, usage string, field func(c *Config) *bool) setting {
+	return setting{
+		name:  name,
+		usage: usage,
+		get:   func(c *Config) string { return strconv.FormatBool(*field(c)) },
+		set: func(c *Config, v string) error {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return err
+			}
+			*field(c) = b
+			return nil
+		},
+	}
+}
+
+func intSetting(name, usage string, field func(c *Config) *int) setting {
+	return setting{
+		name:  name,
+		usage: usage,
+		get:   func(c *Config) string { return strconv.Itoa(*field(c)) },
+		set: func(c *Config, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return err
+			}
+			*field(c) = n
+			return nil
+		},
+	}
+}
+
+func bytesSetting(name, usage string, field func(c *Config) *int64) setting {
+	return setting{
+		name:  name,
+		usage: usage,
+		get:   func(c *Config) string { return strconv.FormatInt(*field(c), 10) },
+		set: func(c *Config, v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return err
+			}
+			*field(c) = n
+			return nil
+		},
+	}
+}
+
+var settings = []setting{
+	stringSetting("addr", "listen address", func(c *Config) *string { return &c.Addr }),
+	stringSetting("admin-addr", "listen address of the admin routes, served on addr when empty", func(c *Config) *string { return &c.AdminAddr }),
+	durationSetting("read-timeout", "maximum duration for reading a request", func(c *Config) *Duration { return &c.ReadTimeout }),
+	durationSetting("write-timeout", "maximum duration for writing a response", func(c *Config) *Duration { return &c.WriteTimeout }),
+	durationSetting("shutdown-timeout", "maximum duration to finish requests on shutdown", func(c *Config) *Duration { return &c.ShutdownTimeout }),
+	durationSetting("shutdown-delay", "how long to keep serving after readiness fails on shutdown", func(c *Config) *Duration { return &c.ShutdownDelay }),
+	bytesSetting("max-body-bytes", "maximum JSON and text request body size", func(c *Config) *int64 { return &c.MaxBodyBytes }),
+	bytesSetting("max-upload-bytes", "maximum email and CSV upload size", func(c *Config) *int64 { return &c.MaxUploadBytes }),
+	boolSetting("disallow-unknown-fields", "reject JSON bodies with unknown fields", func(c *Config) *bool { return &c.DisallowUnknownFields }),
+	stringSetting("store", "receipt store backend, memory or file", func(c *Config) *string { return &c.Store }),
+	stringSetting("store-path", "file the file store appends receipts to", func(c *Config) *string { return &c.StorePath }),
+	stringSetting("rules-file", "rule set file, built-in rules when empty", func(c *Config) *string { return &c.RulesFile }),
+	stringSetting("catalog-file", "product catalog file, .csv or .json", func(c *Config) *string { return &c.CatalogFile }),
+	stringSetting("rates-file", "exchange-rate table file", func(c *Config) *string { return &c.RatesFile }),
+	stringSetting("retailers-file", "retailer registry file", func(c *Config) *string { return &c.RetailersFile }),
+	stringSetting("log-level", "log level, debug, info, warn or error", func(c *Config) *string { return &c.LogLevel }),
+	stringSetting("log-format", "log format, text or json", func(c *Config) *string { return &c.LogFormat }),
+	stringSetting("log-redact", "comma-separated log attributes to redact, such as retailer", func(c *Config) *string { return &c.LogRedact }),
+	stringSetting("trace-file", "file spans are appended to as OTLP JSON, tracing is disabled when empty", func(c *Config) *string { return &c.TraceFile }),
+	stringSetting("audit-file", "file the audit log is appended to, kept in memory when empty", func(c *Config) *string { return &c.AuditFile }),
+	intSetting("audit-max-entries", "newest audit entries kept in memory for queries", func(c *Config) *int { return &c.AuditMaxEntries }),
+	stringSetting("tenants-file", "tenants file, a single tenant when empty", func(c *Config) *string { return &c.TenantsFile }),
+	stringSetting("keys-file", "API keys file, authentication is disabled when empty", func(c *Config) *string { return &c.KeysFile }),
+	stringSetting("jwks-file", "JWKS file for bearer tokens", func(c *Config) *string { return &c.JWKSFile }),
+	stringSetting("jwks-url", "JWKS URL for bearer tokens", func(c *Config) *string { return &c.JWKSURL }),
+	durationSetting("jwks-cache-ttl", "how long the JWKS is cached", func(c *Config) *Duration { return &c.JWKSCacheTTL }),
+	stringSetting("jwt-issuer", "required iss claim of bearer tokens", func(c *Config) *string { return &c.JWTIssuer }),
+	stringSetting("jwt-audience", "required aud claim of bearer tokens", func(c *Config) *string { return &c.JWTAudience }),
+	stringSetting("rate-limits", "route=limit pairs such as \"POST /receipts/process=10/m,*=100/m\"", func(c *Config) *string { return &c.RateLimits }),
+	intSetting("max-reviews", "emails each tenant may hold in the review queue", func(c *Config) *int { return &c.MaxReviews }),
+	intSetting("daily-quota", "receipts each client or user may submit per UTC day, unlimited when 0", func(c *Config) *int { return &c.DailyQuota }),
+	stringSetting("tls-cert-file", "TLS certificate file, serves HTTPS when set", func(c *Config) *string { return &c.TLSCertFile }),
+	stringSetting("tls-key-file", "TLS private key file", func(c *Config) *string { return &c.TLSKeyFile }),
+	stringSetting("tls-min-version", "minimum TLS version, 1.2 or 1.3", func(c *Config) *string { return &c.TLSMinVersion }),
+	stringSetting("tls-cipher-policy", "TLS 1.2 cipher suites, default or strict (ECDHE with AEAD only)", func(c *Config) *string { return &c.TLSCipherPolicy }),
+	stringSetting("tls-client-auth", "client certificates, none, request or require", func(c *Config) *string { return &c.TLSClientAuth }),
+	stringSetting("tls-client-ca-file", "PEM bundle of CAs client certificates must chain to", func(c *Config) *string { return &c.TLSClientCAFile }),
+}
+
+// EnvName is the environment variable for a flag name.
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Load builds the configuration from args, usually os.Args[1:], and
+// lookupEnv, usually os.LookupEnv. The config file is named by the -config
+// flag or RECEIPT_CONFIG. Usage is written to output; -h returns
+// flag.ErrHelp.
+func Load(args []string, lookupEnv func(string) (string, bool), output io.Writer) (*Config, error) {
+	c := Default()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.SetOutput(output)
+	path := fs.String("config", "", "JSON config file (env "+EnvName("config")+")")
+
+	type flagValue struct {
+		setting setting
+		value   string
+	}
+	var flags []flagValue
+	for _, s := range settings {
+		s := s
+		usage := fmt.Sprintf("%s (env %s, default %q)", s.usage, EnvName(s.name), s.get(&c))
+		fs.Func(s.name, usage, func(v string) error {
+			flags = append(flags, flagValue{s, v})
+			return nil
+		})
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	if *path == "" {
+		*path, _ = lookupEnv(EnvName("config"))
+	}
+	if *path != "" {
+		if err := c.loadFile(*path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := lookupEnv(EnvName(s.name)); ok {
+			if err := s.set(&c, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", EnvName(s.name), err)
+			}
+		}
+	}
+
+	for _, f := range flags {
+		if err := f.setting.set(&c, f.value); err != nil {
+			return nil, fmt.Errorf("-%s: %w", f.setting.name, err)
+		}
+	}
+
+	if err := c.IsValid(); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// loadFile overlays the settings present in a JSON file.
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
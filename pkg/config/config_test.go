@@ -0,0 +1,128 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/logging"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/ratelimit"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/tlsconf"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(file, []byte(`{"addr": ":9000", "readTimeout": "7s", "logLevel": "warn", "rulesFile": "rules.json"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Load: should use defaults", func(t *testing.T) {
+		got, err := Load(nil, env(nil), io.Discard)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if *got != Default() {
+			t.Errorf("got %+v, want %+v", *got, Default())
+		}
+	})
+
+	t.Run("Load: should layer file, environment and flags", func(t *testing.T) {
+		got, err := Load(
+			[]string{"-config", file, "-addr", ":9002", "-write-timeout", "1m"},
+			env(map[string]string{"RECEIPT_ADDR": ":9001", "RECEIPT_LOG_LEVEL": "debug", "RECEIPT_MAX_BODY_BYTES": "2048"}),
+			io.Discard,
+		)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+
+		want := Default()
+		want.Addr = ":9002"                          // flag over environment over file
+		want.ReadTimeout = Duration(7 * time.Second) // file
+		want.WriteTimeout = Duration(time.Minute)    // flag
+		want.LogLevel = "debug"                      // environment over file
+		want.MaxBodyBytes = 2048                     // environment
+		want.RulesFile = "rules.json"                // file
+		if *got != want {
+			t.Errorf("got %+v, want %+v", *got, want)
+		}
+	})
+
+	t.Run("Load: should read the config file from the environment", func(t *testing.T) {
+		got, err := Load(nil, env(map[string]string{"RECEIPT_CONFIG": file}), io.Discard)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if got.Addr != ":9000" {
+			t.Errorf("got %q, want :9000", got.Addr)
+		}
+	})
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		wantErr error
+	}{
+		{name: "Load: should return error for an invalid address", args: []string{"-addr", "localhost"}, wantErr: ErrAddrInvalid},
+		{name: "Load: should return error for an admin address equal to the address", args: []string{"-admin-addr", ":8080"}, wantErr: ErrAdminAddrInvalid},
+		{name: "Load: should return error for a zero timeout", env: map[string]string{"RECEIPT_SHUTDOWN_TIMEOUT": "0s"}, wantErr: ErrTimeoutInvalid},
+		{name: "Load: should return error for a negative shutdown delay", env: map[string]string{"RECEIPT_SHUTDOWN_DELAY": "-1s"}, wantErr: ErrDelayInvalid},
+		{name: "Load: should return error for a negative body limit", args: []string{"-max-upload-bytes", "-1"}, wantErr: ErrBodyLimitInvalid},
+		{name: "Load: should return error for an unknown store", args: []string{"-store", "redis"}, wantErr: ErrStoreInvalid},
+		{name: "Load: should return error for a file store without a path", args: []string{"-store", "file"}, wantErr: ErrStorePathEmpty},
+		{name: "Load: should return error for an unknown log level", args: []string{"-log-level", "loud"}, wantErr: ErrLogLevelInvalid},
+		{name: "Load: should return error for a cert without a key", args: []string{"-tls-cert-file", "cert.pem"}, wantErr: tlsconf.ErrCertKeyEmpty},
+		{name: "Load: should return error for client auth without a CA", env: map[string]string{"RECEIPT_TLS_CERT_FILE": "cert.pem", "RECEIPT_TLS_KEY_FILE": "key.pem", "RECEIPT_TLS_CLIENT_AUTH": "require"}, wantErr: tlsconf.ErrClientCAEmpty},
+		{name: "Load: should return error for a JWKS without an issuer", args: []string{"-jwks-file", "jwks.json", "-jwt-audience", "receipts"}, wantErr: ErrJWTClaimsEmpty},
+		{name: "Load: should return error for a JWKS file and URL", env: map[string]string{"RECEIPT_JWKS_FILE": "jwks.json", "RECEIPT_JWKS_URL": "https://id.example.com/jwks", "RECEIPT_JWT_ISSUER": "a", "RECEIPT_JWT_AUDIENCE": "b"}, wantErr: ErrJWKSBoth},
+		{name: "Load: should return error for an invalid rate limit", args: []string{"-rate-limits", "POST /receipts/process=10 per minute"}, wantErr: ratelimit.ErrLimitInvalid},
+		{name: "Load: should return error for a negative daily quota", env: map[string]string{"RECEIPT_DAILY_QUOTA": "-1"}, wantErr: ErrQuotaInvalid},
+		{name: "Load: should return error for a non-positive audit max entries", env: map[string]string{"RECEIPT_AUDIT_MAX_ENTRIES": "0"}, wantErr: ErrAuditMaxInvalid},
+		{name: "Load: should return error for an unknown log format", args: []string{"-log-format", "xml"}, wantErr: logging.ErrFormatInvalid},
+		{name: "Load: should return help", args: []string{"-h"}, wantErr: flag.ErrHelp},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Load(tt.args, env(tt.env), io.Discard); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("Load: should return error for malformed values", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"-read-timeout", "soon"},
+			{"-max-body-bytes", "1MB"},
+			{"-config", filepath.Join(dir, "missing.json")},
+			{"extra"},
+		} {
+			if _, err := Load(args, env(nil), io.Discard); err == nil {
+				t.Errorf("%v: got nil, want error", args)
+			}
+		}
+	})
+
+	t.Run("Load: should return error for unknown file settings", func(t *testing.T) {
+		bad := filepath.Join(dir, "bad.json")
+		if err := os.WriteFile(bad, []byte(`{"adress": ":9000"}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load([]string{"-config", bad}, env(nil), io.Discard); err == nil {
+			t.Error("got nil, want error")
+		}
+	})
+}
@@ -0,0 +1,194 @@
+// Package currency describes ISO 4217 currencies and converts amounts to the
+// base currency points are calculated in, using a local exchange-rate table.
+package currency
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Default is assumed when a receipt does not name its currency.
+const Default = "USD"
+
+var (
+	ErrRatesVersionEmpty = errors.New("exchange rate table version cannot be empty")
+	ErrRatesBaseInvalid  = errors.New("exchange rate table base must be a known currency")
+	ErrRateInvalid       = errors.New("exchange rates must be positive and for known currencies")
+	ErrNoRate            = errors.New("no exchange rate for currency")
+)
+
+type Currency struct {
+	Code string
+	// MinorUnits is the number of decimal places amounts are written with.
+	MinorUnits int
+	// Round and Quarter are the steps a total must be a multiple of to count
+	// as a "round dollar" or a "multiple of 0.25" amount in this currency.
+	Round   float64
+	Quarter float64
+}
+
+func decimal2(code string) Currency {
+	return Currency{Code: code, MinorUnits: 2, Round: 1, Quarter: 0.25}
+}
+
+func decimal3(code string) Currency {
+	return Currency{Code: code, MinorUnits: 3, Round: 1, Quarter: 0.25}
+}
+
+// currencies is the subset of ISO 4217 receipts are accepted in. Currencies
+// without minor units use steps of similar purchasing power to the dollar.
+var currencies = map[string]Currency{
+	"USD": decimal2("USD"),
+	"CAD": decimal2("CAD"),
+	"MXN": decimal2("MXN"),
+	"EUR": decimal2("EUR"),
+	"GBP": decimal2("GBP"),
+	"CHF": decimal2("CHF"),
+	"AUD": decimal2("AUD"),
+	"NZD": decimal2("NZD"),
+	"CNY": decimal2("CNY"),
+	"INR": decimal2("INR"),
+	"BRL": decimal2("BRL"),
+	"JPY": {Code: "JPY", MinorUnits: 0, Round: 100, Quarter: 25},
+	"KRW": {Code: "KRW", MinorUnits: 0, Round: 1000, Quarter: 250},
+	"ISK": {Code: "ISK", MinorUnits: 0, Round: 100, Quarter: 25},
+	"KWD": decimal3("KWD"),
+	"BHD": decimal3("BHD"),
+	"OMR": decimal3("OMR"),
+	"JOD": decimal3("JOD"),
+	"TND": decimal3("TND"),
+}
+
+// Lookup finds a currency by its ISO 4217 code, ignoring case.
+func Lookup(code string) (Currency, bool) {
+	c, ok := currencies[strings.ToUpper(code)]
+	return c, ok
+}
+
+// MustLookup is like Lookup but panics for unknown codes.
+func MustLookup(code string) Currency {
+	c, ok := Lookup(code)
+	if !ok {
+		panic(fmt.Sprintf("currency: unknown code %q", code))
+	}
+	return c
+}
+
+var amountPatterns = map[int]*regexp.Regexp{
+	0: regexp.MustCompile(`^-?\d+$`),
+	2: regexp.MustCompile(`^-?\d+\.\d{2}$`),
+	3: regexp.MustCompile(`^-?\d+\.\d{3}$`),
+}
+
+// ValidAmount reports whether s is written with exactly the currency's minor
+// units, like "9.00" in USD, "900" in JPY or "9.000" in KWD. Negative amounts
+// are only accepted when allowNegative is set.
+func (c Currency) ValidAmount(s string, allowNegative bool) bool {
+	if !allowNegative && strings.HasPrefix(s, "-") {
+		return false
+	}
+	return amountPatterns[c.MinorUnits].MatchString(s)
+}
+
+// Format is a human readable example of an amount, such as "0.00".
+func (c Currency) Format() string {
+	if c.MinorUnits == 0 {
+		return "0"
+	}
+	return "0." + strings.Repeat("0", c.MinorUnits)
+}
+
+// IsMultiple reports whether amount is a whole multiple of step, compared in
+// minor units so float error cannot creep in.
+func (c Currency) IsMultiple(amount, step float64) bool {
+	scale := math.Pow10(c.MinorUnits)
+	units := int64(math.Round(amount * scale))
+	stepUnits := int64(math.Round(step * scale))
+	if stepUnits == 0 {
+		return false
+	}
+	return units%stepUnits == 0
+}
+
+// Rates is a versioned exchange-rate table. Each rate is the number of base
+// currency units one unit of the currency is worth.
+type Rates struct {
+	Version string             `json:"version"`
+	Base    string             `json:"base"`
+	Rates   map[string]float64 `json:"rates"`
+}
+
+// Identity is the table used when none is configured: it only knows the
+// default currency.
+func Identity() *Rates {
+	return &Rates{Version: "identity", Base: Default, Rates: map[string]float64{Default: 1}}
+}
+
+func (r *Rates) IsValid() error {
+	var err error
+
+	if r.Version == "" {
+		err = errors.Join(err, ErrRatesVersionEmpty)
+	}
+
+	if _, ok := Lookup(r.Base); !ok {
+		err = errors.Join(err, ErrRatesBaseInvalid)
+	}
+
+	for code, rate := range r.Rates {
+		if _, ok := Lookup(code); !ok || rate <= 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+			err = errors.Join(err, fmt.Errorf("%w: %s", ErrRateInvalid, code))
+		}
+	}
+
+	return err
+}
+
+// Rate returns how many base units one unit of code is worth.
+func (r *Rates) Rate(code string) (float64, error) {
+	code = strings.ToUpper(code)
+	if code == strings.ToUpper(r.Base) {
+		return 1, nil
+	}
+
+	for c, rate := range r.Rates {
+		if strings.EqualFold(c, code) {
+			return rate, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w %s in table %s", ErrNoRate, code, r.Version)
+}
+
+func LoadRates(rd io.Reader) (*Rates, error) {
+	var r Rates
+	if err := json.NewDecoder(rd).Decode(&r); err != nil {
+		return nil, fmt.Errorf("error decoding exchange rates: %w", err)
+	}
+
+	if err := r.IsValid(); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+func LoadRatesFile(path string) (*Rates, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := LoadRates(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
@@ -0,0 +1,100 @@
+package currency
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidAmount(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		input    string
+		negative bool
+		want     bool
+	}{
+		{name: "ValidAmount: should accept cents in USD", code: "USD", input: "9.00", want: true},
+		{name: "ValidAmount: should reject whole dollars in USD", code: "USD", input: "9", want: false},
+		{name: "ValidAmount: should accept whole yen in JPY", code: "JPY", input: "900", want: true},
+		{name: "ValidAmount: should reject decimals in JPY", code: "JPY", input: "900.00", want: false},
+		{name: "ValidAmount: should accept three decimals in KWD", code: "KWD", input: "9.125", want: true},
+		{name: "ValidAmount: should reject two decimals in KWD", code: "KWD", input: "9.12", want: false},
+		{name: "ValidAmount: should reject negatives by default", code: "USD", input: "-1.00", want: false},
+		{name: "ValidAmount: should accept negatives when allowed", code: "USD", input: "-1.00", negative: true, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MustLookup(tt.code).ValidAmount(tt.input, tt.negative); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsMultiple(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   string
+		amount float64
+		step   float64
+		want   bool
+	}{
+		{name: "IsMultiple: should find round dollars", code: "USD", amount: 9.00, step: 1, want: true},
+		{name: "IsMultiple: should find quarters", code: "USD", amount: 9.75, step: 0.25, want: true},
+		{name: "IsMultiple: should not be fooled by float error", code: "USD", amount: 0.1 + 0.2, step: 0.1, want: true},
+		{name: "IsMultiple: should reject cents", code: "USD", amount: 9.01, step: 0.25, want: false},
+		{name: "IsMultiple: should use the yen round step", code: "JPY", amount: 1250, step: 100, want: false},
+		{name: "IsMultiple: should use the yen quarter step", code: "JPY", amount: 1250, step: 25, want: true},
+		{name: "IsMultiple: should use three decimals", code: "KWD", amount: 2.250, step: 0.25, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MustLookup(tt.code).IsMultiple(tt.amount, tt.step); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRates(t *testing.T) {
+	rates, err := LoadRates(strings.NewReader(`{"version": "v1", "base": "USD", "rates": {"jpy": 0.0064}}`))
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	t.Run("Rate: should return 1 for the base", func(t *testing.T) {
+		if rate, err := rates.Rate("usd"); err != nil || rate != 1 {
+			t.Errorf("got %v %v, want 1", rate, err)
+		}
+	})
+
+	t.Run("Rate: should ignore case", func(t *testing.T) {
+		if rate, err := rates.Rate("JPY"); err != nil || rate != 0.0064 {
+			t.Errorf("got %v %v, want 0.0064", rate, err)
+		}
+	})
+
+	t.Run("Rate: should return error for missing rates", func(t *testing.T) {
+		if _, err := rates.Rate("EUR"); !errors.Is(err, ErrNoRate) {
+			t.Errorf("got %v, want %v", err, ErrNoRate)
+		}
+	})
+
+	t.Run("LoadRates: should validate the table", func(t *testing.T) {
+		_, err := LoadRates(strings.NewReader(`{"base": "XXX", "rates": {"EUR": -1}}`))
+		for _, want := range []error{ErrRatesVersionEmpty, ErrRatesBaseInvalid, ErrRateInvalid} {
+			if !errors.Is(err, want) {
+				t.Errorf("got %v, want %v", err, want)
+			}
+		}
+	})
+
+	t.Run("LoadRatesFile: should load the example table", func(t *testing.T) {
+		if _, err := LoadRatesFile("../../examples/config/rates.json"); err != nil {
+			t.Errorf("got %v, want nil", err)
+		}
+	})
+}
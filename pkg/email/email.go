@@ -0,0 +1,233 @@
+// Package email extracts receipt text from RFC 5322 messages, such as
+// forwarded e-receipts. It walks multipart bodies, decodes transfer encodings
+// and converts HTML parts to plain text for the parse package.
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MaxDepth bounds how deeply multipart and attached messages are walked.
+const MaxDepth = 8
+
+var (
+	ErrMessageInvalid = errors.New("message is not a valid RFC 5322 message")
+	ErrNoTextPart     = errors.New("message has no text or html part")
+	ErrTooDeep        = errors.New("message parts are nested too deeply")
+	ErrCharset        = errors.New("message charset is not supported")
+)
+
+type Message struct {
+	From    string    `json:"from"`
+	Subject string    `json:"subject"`
+	Date    time.Time `json:"date"`
+	// Text is the receipt body: the first text/plain part, or the first
+	// text/html part converted to text when there is no plain part.
+	Text string `json:"-"`
+}
+
+// Read parses a raw message and extracts its receipt text.
+func Read(r io.Reader) (*Message, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMessageInvalid, err)
+	}
+
+	m := &Message{
+		From:    msg.Header.Get("From"),
+		Subject: decodeHeader(msg.Header.Get("Subject")),
+	}
+	if date, err := msg.Header.Date(); err == nil {
+		m.Date = date
+	}
+
+	var plain, htmlText string
+	if err := walk(msg.Header, msg.Body, 0, &plain, &htmlText); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.TrimSpace(plain) != "":
+		m.Text = unforward(plain)
+	case strings.TrimSpace(htmlText) != "":
+		m.Text = unforward(HTMLToText(htmlText))
+	default:
+		return nil, ErrNoTextPart
+	}
+
+	return m, nil
+}
+
+var (
+	reForwardMarker = regexp.MustCompile(`(?i)^-+\s*(forwarded message|original message)\s*-+$|^begin forwarded message:$`)
+	reForwardHeader = regexp.MustCompile(`(?i)^(from|to|cc|date|sent|subject):`)
+)
+
+// unforward drops the introduction and header block of an inline forward,
+// and the "> " quoting some clients add, so the receipt starts the text.
+func unforward(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), ">"))
+	}
+
+	for i, line := range lines {
+		if !reForwardMarker.MatchString(line) {
+			continue
+		}
+
+		rest := lines[i+1:]
+		for len(rest) > 0 && (rest[0] == "" || reForwardHeader.MatchString(rest[0])) {
+			rest = rest[1:]
+		}
+		lines = rest
+		break
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+type header interface {
+	Get(key string) string
+}
+
+// walk collects the first text/plain and text/html bodies, descending into
+// multipart parts and attached messages.
+func walk(h header, body io.Reader, depth int, plain, htmlText *string) error {
+	if depth > MaxDepth {
+		return ErrTooDeep
+	}
+
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		// Messages without a Content-Type are plain text.
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrMessageInvalid, err)
+			}
+			if err := walk(part.Header, part, depth+1, plain, htmlText); err != nil {
+				return err
+			}
+		}
+
+	case mediaType == "message/rfc822":
+		msg, err := mail.ReadMessage(decode(h, body))
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrMessageInvalid, err)
+		}
+		return walk(msg.Header, msg.Body, depth+1, plain, htmlText)
+
+	case mediaType == "text/plain" || mediaType == "text/html":
+		// Attached files are not the receipt body.
+		if disposition, _, _ := mime.ParseMediaType(h.Get("Content-Disposition")); disposition == "attachment" {
+			return nil
+		}
+
+		target := plain
+		if mediaType == "text/html" {
+			target = htmlText
+		}
+		if *target != "" {
+			return nil
+		}
+
+		b, err := io.ReadAll(decode(h, body))
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrMessageInvalid, err)
+		}
+		text, err := toUTF8(b, params["charset"])
+		if err != nil {
+			return err
+		}
+		*target = text
+	}
+
+	return nil
+}
+
+// decode undoes the part's Content-Transfer-Encoding.
+func decode(h header, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(h.Get("Content-Transfer-Encoding"))) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	case "base64":
+		// The decoder skips the line breaks base64 bodies are wrapped with.
+		return base64.NewDecoder(base64.StdEncoding, body)
+	}
+	return body
+}
+
+func toUTF8(b []byte, charset string) (string, error) {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return string(b), nil
+	case "iso-8859-1", "latin1", "windows-1252":
+		// Latin-1 bytes are the first 256 code points; the windows-1252
+		// extras only cover punctuation receipts rarely depend on.
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		return string(runes), nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrCharset, charset)
+}
+
+func decodeHeader(s string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(s)
+	if err != nil {
+		return s
+	}
+	return decoded
+}
+
+var (
+	reHTMLDrop  = regexp.MustCompile(`(?is)<(script|style|head)\b.*?</(script|style|head)\s*>`)
+	reHTMLBreak = regexp.MustCompile(`(?i)<\s*(br|/p|/div|/tr|/li|/h[1-6]|/table)\b[^>]*>`)
+	reHTMLCell  = regexp.MustCompile(`(?i)<\s*/t[dh]\s*>`)
+	reHTMLTag   = regexp.MustCompile(`<[^>]*>`)
+	reBlankRuns = regexp.MustCompile(`[ \t\f\v\x{a0}]+`)
+)
+
+// HTMLToText renders an HTML body as plain text, keeping one line per table
+// row or block so item lines stay together with their prices.
+func HTMLToText(s string) string {
+	s = reHTMLDrop.ReplaceAllString(s, "")
+	// Source line breaks are layout, not content.
+	s = strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+	s = reHTMLBreak.ReplaceAllString(s, "\n")
+	s = reHTMLCell.ReplaceAllString(s, " ")
+	s = reHTMLTag.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+
+	var b bytes.Buffer
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(reBlankRuns.ReplaceAllString(line, " ")); line != "" {
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,205 @@
+package email
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func message(lines ...string) string {
+	return strings.Join(lines, "\r\n")
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantSubject string
+		wantText    string
+	}{
+		{
+			name: "Read: should read a plain message without a content type",
+			input: message(
+				"From: shop@example.com",
+				"Subject: Receipt",
+				"",
+				"Corner Market",
+				"Gatorade 2.25",
+			),
+			wantSubject: "Receipt",
+			wantText:    "Corner Market\nGatorade 2.25",
+		},
+		{
+			name: "Read: should prefer the plain part of an alternative",
+			input: message(
+				"Subject: =?utf-8?q?Your_receipt?=",
+				`Content-Type: multipart/alternative; boundary="b"`,
+				"",
+				"--b",
+				"Content-Type: text/html",
+				"",
+				"<p>html</p>",
+				"--b",
+				"Content-Type: text/plain; charset=utf-8",
+				"",
+				"plain",
+				"--b--",
+			),
+			wantSubject: "Your receipt",
+			wantText:    "plain",
+		},
+		{
+			name: "Read: should decode base64 parts",
+			input: message(
+				`Content-Type: text/plain; charset="us-ascii"`,
+				"Content-Transfer-Encoding: base64",
+				"",
+				"Q29ybmVyIE1hcmtldApH",
+				"YXRvcmFkZSAyLjI1",
+			),
+			wantText: "Corner Market\nGatorade 2.25",
+		},
+		{
+			name: "Read: should convert latin-1 text",
+			input: message(
+				"Content-Type: text/plain; charset=iso-8859-1",
+				"Content-Transfer-Encoding: quoted-printable",
+				"",
+				"Caf=E9 Bleu",
+			),
+			wantText: "Café Bleu",
+		},
+		{
+			name: "Read: should drop an inline forward header",
+			input: message(
+				"Subject: Fwd: Your receipt",
+				"",
+				"see below",
+				"",
+				"---------- Forwarded message ---------",
+				"From: Target <receipts@target.example>",
+				"Date: Sat, Jan 1, 2022 at 1:05 PM",
+				"Subject: Your receipt",
+				"To: <shopper@example.com>",
+				"",
+				"> Target",
+				"> Pepsi 1.25",
+			),
+			wantSubject: "Fwd: Your receipt",
+			wantText:    "Target\nPepsi 1.25",
+		},
+		{
+			name: "Read: should read an attached message and skip attached files",
+			input: message(
+				`Content-Type: multipart/mixed; boundary="outer"`,
+				"",
+				"--outer",
+				"Content-Type: text/plain",
+				"Content-Disposition: attachment; filename=notes.txt",
+				"",
+				"not the receipt",
+				"--outer",
+				"Content-Type: message/rfc822",
+				"",
+				"Subject: Your receipt",
+				"Content-Type: text/html",
+				"",
+				"<div>Target</div><div>Pepsi 1.25</div>",
+				"--outer--",
+			),
+			wantText: "Target\nPepsi 1.25\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Read(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("got %v, want nil", err)
+			}
+			if got.Subject != tt.wantSubject {
+				t.Errorf("subject: got %q, want %q", got.Subject, tt.wantSubject)
+			}
+			if got.Text != tt.wantText {
+				t.Errorf("text: got %q, want %q", got.Text, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr error
+	}{
+		{
+			name:    "Read: should return error for a message without headers",
+			input:   "no headers here",
+			wantErr: ErrMessageInvalid,
+		},
+		{
+			name: "Read: should return error without a text part",
+			input: message(
+				`Content-Type: multipart/mixed; boundary="b"`,
+				"",
+				"--b",
+				"Content-Type: image/png",
+				"",
+				"png",
+				"--b--",
+			),
+			wantErr: ErrNoTextPart,
+		},
+		{
+			name: "Read: should return error for an unknown charset",
+			input: message(
+				"Content-Type: text/plain; charset=koi8-r",
+				"",
+				"text",
+			),
+			wantErr: ErrCharset,
+		},
+		{
+			name: "Read: should return error for nesting deeper than MaxDepth",
+			input: message(
+				strings.Repeat("Content-Type: message/rfc822\r\n\r\n", MaxDepth+2),
+				"text",
+			),
+			wantErr: ErrTooDeep,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Read(strings.NewReader(tt.input)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadExample(t *testing.T) {
+	f, err := os.Open("../../examples/receipt.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	got, err := Read(f)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	want := "Target\n01/01/2022 1:01 PM\nMountain Dew 12PK $6.49\n"
+	if !strings.HasPrefix(got.Text, want) {
+		t.Errorf("got %q, want prefix %q", got.Text, want)
+	}
+	if !strings.Contains(got.Text, "Total $35.35\n") {
+		t.Errorf("got %q, want the total row on one line", got.Text)
+	}
+	if got.Date.IsZero() || got.From != "Target <receipts@target.example>" {
+		t.Errorf("got %v %q, want the message date and sender", got.Date, got.From)
+	}
+}
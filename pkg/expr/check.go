@@ -0,0 +1,147 @@
+package expr
+
+// typ is the static type of an expression. Every field and function has a
+// fixed type, so an expression is fully type checked before it ever runs.
+type typ int
+
+const (
+	typNumber typ = iota + 1
+	typString
+	typBool
+)
+
+func (t typ) String() string {
+	switch t {
+	case typNumber:
+		return "number"
+	case typString:
+		return "string"
+	case typBool:
+		return "bool"
+	}
+	return "unknown"
+}
+
+func check(n node) (typ, error) {
+	switch n := n.(type) {
+	case *numberLit:
+		return typNumber, nil
+
+	case *stringLit:
+		return typString, nil
+
+	case *boolLit:
+		return typBool, nil
+
+	case *fieldRef:
+		return n.field.typ, nil
+
+	case *unaryExpr:
+		t, err := check(n.x)
+		if err != nil {
+			return 0, err
+		}
+		want := typNumber
+		if n.op == tokNot {
+			want = typBool
+		}
+		if t != want {
+			return 0, errorf(n.pos, "operator %s needs a %s, found %s", n.op, want, t)
+		}
+		return t, nil
+
+	case *binaryExpr:
+		return checkBinary(n)
+
+	case *matchExpr:
+		t, err := check(n.x)
+		if err != nil {
+			return 0, err
+		}
+		if t != typString {
+			return 0, errorf(n.pos, "left side of matches must be a string, found %s", t)
+		}
+		return typBool, nil
+
+	case *condExpr:
+		ct, err := check(n.cond)
+		if err != nil {
+			return 0, err
+		}
+		if ct != typBool {
+			return 0, errorf(n.cond.position(), "condition must be a bool, found %s", ct)
+		}
+
+		tt, err := check(n.then)
+		if err != nil {
+			return 0, err
+		}
+		et, err := check(n.els)
+		if err != nil {
+			return 0, err
+		}
+		if tt != et {
+			return 0, errorf(n.pos, "branches of ?: have different types %s and %s", tt, et)
+		}
+		return tt, nil
+
+	case *callExpr:
+		if len(n.args) != len(n.fn.params) {
+			return 0, errorf(n.pos, "%s takes %d arguments, found %d", n.name, len(n.fn.params), len(n.args))
+		}
+		for i, arg := range n.args {
+			t, err := check(arg)
+			if err != nil {
+				return 0, err
+			}
+			if t != n.fn.params[i] {
+				return 0, errorf(arg.position(), "argument %d of %s must be a %s, found %s", i+1, n.name, n.fn.params[i], t)
+			}
+		}
+		return n.fn.result, nil
+	}
+
+	return 0, errorf(n.position(), "unsupported expression")
+}
+
+func checkBinary(n *binaryExpr) (typ, error) {
+	xt, err := check(n.x)
+	if err != nil {
+		return 0, err
+	}
+	yt, err := check(n.y)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case tokOr, tokAnd:
+		if xt != typBool || yt != typBool {
+			return 0, errorf(n.pos, "operator %s needs bools, found %s and %s", n.op, xt, yt)
+		}
+		return typBool, nil
+
+	case tokEq, tokNeq:
+		if xt != yt {
+			return 0, errorf(n.pos, "cannot compare %s with %s", xt, yt)
+		}
+		return typBool, nil
+
+	case tokLt, tokLte, tokGt, tokGte:
+		if xt != yt || xt == typBool {
+			return 0, errorf(n.pos, "operator %s needs two numbers or two strings, found %s and %s", n.op, xt, yt)
+		}
+		return typBool, nil
+
+	case tokPlus:
+		if xt != yt || xt == typBool {
+			return 0, errorf(n.pos, "operator + needs two numbers or two strings, found %s and %s", xt, yt)
+		}
+		return xt, nil
+	}
+
+	if xt != typNumber || yt != typNumber {
+		return 0, errorf(n.pos, "operator %s needs numbers, found %s and %s", n.op, xt, yt)
+	}
+	return typNumber, nil
+}
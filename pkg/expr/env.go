@@ -0,0 +1,164 @@
+package expr
+
+import (
+	"math"
+	"strings"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+)
+
+// field is a read-only view of part of a models.Receipt.
+type field struct {
+	typ typ
+	get func(*models.Receipt) value
+}
+
+// fields are the names an expression can read from the receipt it scores.
+var fields = map[string]field{
+	"retailer": {typString, func(r *models.Receipt) value {
+		return value{str: r.Retailer}
+	}},
+	"retailer.id": {typString, func(r *models.Receipt) value {
+		return value{str: r.RetailerID}
+	}},
+	"total": {typNumber, func(r *models.Receipt) value {
+		return value{num: r.Total}
+	}},
+	"currency": {typString, func(r *models.Receipt) value {
+		return value{str: r.Currency}
+	}},
+	// base.total is the total in the currency points are calculated in.
+	"base.total": {typNumber, func(r *models.Receipt) value {
+		return value{num: r.BaseAmount(r.Total)}
+	}},
+	// Item fields only look at sale lines, except items.discounts which sums
+	// the (negative) discount lines.
+	"items.count": {typNumber, func(r *models.Receipt) value {
+		var count float64
+		for _, item := range r.Items {
+			if item.IsSale() {
+				count++
+			}
+		}
+		return value{num: count}
+	}},
+	"items.units": {typNumber, func(r *models.Receipt) value {
+		var units float64
+		for _, item := range r.Items {
+			if item.IsSale() {
+				units = units + item.Units()
+			}
+		}
+		return value{num: units}
+	}},
+	"items.total": {typNumber, func(r *models.Receipt) value {
+		var sum float64
+		for _, item := range r.Items {
+			if item.IsSale() {
+				sum = sum + item.Price
+			}
+		}
+		return value{num: sum}
+	}},
+	"items.discounts": {typNumber, func(r *models.Receipt) value {
+		var sum float64
+		for _, item := range r.Items {
+			if item.Type == models.LineDiscount {
+				sum = sum + item.Price
+			}
+		}
+		return value{num: sum}
+	}},
+	"purchase.year": {typNumber, func(r *models.Receipt) value {
+		return value{num: float64(r.PurchasedAt.Year())}
+	}},
+	"purchase.month": {typNumber, func(r *models.Receipt) value {
+		return value{num: float64(r.PurchasedAt.Month())}
+	}},
+	"purchase.day": {typNumber, func(r *models.Receipt) value {
+		return value{num: float64(r.PurchasedAt.Day())}
+	}},
+	// Sunday is 0, matching time.Weekday.
+	"purchase.weekday": {typNumber, func(r *models.Receipt) value {
+		return value{num: float64(r.PurchasedAt.Weekday())}
+	}},
+	"purchase.hour": {typNumber, func(r *models.Receipt) value {
+		return value{num: float64(r.PurchasedAt.Hour())}
+	}},
+	"purchase.minute": {typNumber, func(r *models.Receipt) value {
+		return value{num: float64(r.PurchasedAt.Minute())}
+	}},
+}
+
+type function struct {
+	params []typ
+	result typ
+	call   func(s *state, args []value) (value, error)
+}
+
+func numberFn(fn func(float64) float64) function {
+	return function{
+		params: []typ{typNumber},
+		result: typNumber,
+		call: func(s *state, args []value) (value, error) {
+			return value{num: fn(args[0].num)}, nil
+		},
+	}
+}
+
+// functions are the built-in calls available to expressions.
+var functions = map[string]function{
+	"abs":   numberFn(math.Abs),
+	"ceil":  numberFn(math.Ceil),
+	"floor": numberFn(math.Floor),
+	"round": numberFn(math.Round),
+	"min": {
+		params: []typ{typNumber, typNumber},
+		result: typNumber,
+		call: func(s *state, args []value) (value, error) {
+			return value{num: math.Min(args[0].num, args[1].num)}, nil
+		},
+	},
+	"max": {
+		params: []typ{typNumber, typNumber},
+		result: typNumber,
+		call: func(s *state, args []value) (value, error) {
+			return value{num: math.Max(args[0].num, args[1].num)}, nil
+		},
+	},
+	// categoryCount counts the items whose catalog category is the argument.
+	"categoryCount": {
+		params: []typ{typString},
+		result: typNumber,
+		call: func(s *state, args []value) (value, error) {
+			var count float64
+			for _, item := range s.receipt.Items {
+				if item.IsSale() && strings.EqualFold(item.Category, args[0].str) {
+					count++
+				}
+			}
+			return value{num: count}, s.charge(len(s.receipt.Items))
+		},
+	},
+	// categoryTotal sums the prices of the items in a catalog category.
+	"categoryTotal": {
+		params: []typ{typString},
+		result: typNumber,
+		call: func(s *state, args []value) (value, error) {
+			var sum float64
+			for _, item := range s.receipt.Items {
+				if item.IsSale() && strings.EqualFold(item.Category, args[0].str) {
+					sum = sum + item.Price
+				}
+			}
+			return value{num: sum}, s.charge(len(s.receipt.Items))
+		},
+	},
+	"len": {
+		params: []typ{typString},
+		result: typNumber,
+		call: func(s *state, args []value) (value, error) {
+			return value{num: float64(len([]rune(args[0].str)))}, s.charge(len(args[0].str))
+		},
+	},
+}
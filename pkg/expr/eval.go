@@ -0,0 +1,157 @@
+package expr
+
+import (
+	"math"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+)
+
+// value holds the result of evaluating a node. Only the member matching the
+// node's static type is meaningful.
+type value struct {
+	num float64
+	str string
+	b   bool
+}
+
+type state struct {
+	receipt *models.Receipt
+	steps   int
+	budget  int
+}
+
+// charge spends n steps of the execution budget.
+func (s *state) charge(n int) error {
+	s.steps += n
+	if s.steps > s.budget {
+		return ErrBudgetExceeded
+	}
+	return nil
+}
+
+func eval(s *state, n node) (value, error) {
+	if err := s.charge(1); err != nil {
+		return value{}, err
+	}
+
+	switch n := n.(type) {
+	case *numberLit:
+		return value{num: n.value}, nil
+
+	case *stringLit:
+		return value{str: n.value}, nil
+
+	case *boolLit:
+		return value{b: n.value}, nil
+
+	case *fieldRef:
+		return n.field.get(s.receipt), nil
+
+	case *unaryExpr:
+		x, err := eval(s, n.x)
+		if err != nil {
+			return value{}, err
+		}
+		if n.op == tokNot {
+			return value{b: !x.b}, nil
+		}
+		return value{num: -x.num}, nil
+
+	case *binaryExpr:
+		return evalBinary(s, n)
+
+	case *matchExpr:
+		x, err := eval(s, n.x)
+		if err != nil {
+			return value{}, err
+		}
+		// Matching is linear in the input, so charge for its length.
+		if err := s.charge(len(x.str)); err != nil {
+			return value{}, err
+		}
+		return value{b: n.re.MatchString(x.str)}, nil
+
+	case *condExpr:
+		c, err := eval(s, n.cond)
+		if err != nil {
+			return value{}, err
+		}
+		if c.b {
+			return eval(s, n.then)
+		}
+		return eval(s, n.els)
+
+	case *callExpr:
+		args := make([]value, len(n.args))
+		for i, arg := range n.args {
+			v, err := eval(s, arg)
+			if err != nil {
+				return value{}, err
+			}
+			args[i] = v
+		}
+		return n.fn.call(s, args)
+	}
+
+	return value{}, errorf(n.position(), "unsupported expression")
+}
+
+func evalBinary(s *state, n *binaryExpr) (value, error) {
+	x, err := eval(s, n.x)
+	if err != nil {
+		return value{}, err
+	}
+
+	// Short circuit before evaluating the right side.
+	switch {
+	case n.op == tokOr && x.b:
+		return value{b: true}, nil
+	case n.op == tokAnd && !x.b:
+		return value{b: false}, nil
+	}
+
+	y, err := eval(s, n.y)
+	if err != nil {
+		return value{}, err
+	}
+
+	switch n.op {
+	case tokOr, tokAnd:
+		return value{b: y.b}, nil
+	case tokEq:
+		return value{b: x == y}, nil
+	case tokNeq:
+		return value{b: x != y}, nil
+	// Ordering is type checked, so one of num or str is zero on both sides
+	// and comparing both members orders numbers and strings alike.
+	case tokLt:
+		return value{b: x.num < y.num || x.str < y.str}, nil
+	case tokLte:
+		return value{b: x.num <= y.num && x.str <= y.str}, nil
+	case tokGt:
+		return value{b: x.num > y.num || x.str > y.str}, nil
+	case tokGte:
+		return value{b: x.num >= y.num && x.str >= y.str}, nil
+	case tokPlus:
+		if err := s.charge(len(x.str) + len(y.str)); err != nil {
+			return value{}, err
+		}
+		return value{num: x.num + y.num, str: x.str + y.str}, nil
+	case tokMinus:
+		return value{num: x.num - y.num}, nil
+	case tokStar:
+		return value{num: x.num * y.num}, nil
+	case tokSlash:
+		if y.num == 0 {
+			return value{}, errorf(n.pos, "division by zero")
+		}
+		return value{num: x.num / y.num}, nil
+	case tokPercent:
+		if y.num == 0 {
+			return value{}, errorf(n.pos, "division by zero")
+		}
+		return value{num: math.Mod(x.num, y.num)}, nil
+	}
+
+	return value{}, errorf(n.pos, "unsupported operator %s", n.op)
+}
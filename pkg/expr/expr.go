@@ -0,0 +1,165 @@
+// Package expr implements a small expression language for scoring rules.
+//
+// An expression reads fields of a models.Receipt and evaluates to a number of
+// points, for example:
+//
+//	items.count >= 10 ? 15 : 0
+//	retailer matches "(?i)walmart" && total > 50 ? 20 : 0
+//
+// The language has no loops, assignments or user defined functions. Every
+// expression is parsed and type checked when it is compiled, and evaluation
+// is bounded by a step budget.
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/points"
+)
+
+// DefaultBudget is the number of evaluation steps a program may take.
+const DefaultBudget = 10000
+
+// MaxSourceLen is the longest expression Compile accepts.
+const MaxSourceLen = 4096
+
+var (
+	ErrBudgetExceeded = errors.New("expression exceeded its execution budget")
+	ErrNotNumber      = errors.New("expression must evaluate to a number")
+	ErrOutOfRange     = errors.New("expression result is not a finite number of points")
+)
+
+// Pos is a 1-based line and column in the expression source.
+type Pos struct {
+	Line int
+	Col  int
+}
+
+// Error reports a problem at a position in the expression source.
+type Error struct {
+	Pos
+	Msg string
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Col, e.Msg)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func errorf(pos Pos, format string, args ...any) *Error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Program is a compiled expression. It is safe for concurrent use.
+type Program struct {
+	src    string
+	root   node
+	budget int
+}
+
+type Option func(*Program)
+
+// WithBudget overrides DefaultBudget.
+func WithBudget(steps int) Option {
+	return func(p *Program) {
+		p.budget = steps
+	}
+}
+
+// Compile parses and type checks src. Errors are *Error values carrying the
+// line and column of the problem.
+func Compile(src string, opts ...Option) (*Program, error) {
+	if len(src) > MaxSourceLen {
+		return nil, errorf(Pos{Line: 1, Col: 1}, "expression is longer than %d bytes", MaxSourceLen)
+	}
+
+	root, nodes, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := check(root)
+	if err != nil {
+		return nil, err
+	}
+	if t != typNumber {
+		return nil, &Error{
+			Pos: root.position(),
+			Msg: fmt.Sprintf("%s, found %s", ErrNotNumber, t),
+			Err: ErrNotNumber,
+		}
+	}
+
+	p := &Program{src: src, root: root, budget: DefaultBudget}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	// Each node runs at most once, so a program with more nodes than its
+	// budget could never finish.
+	if nodes > p.budget {
+		return nil, &Error{
+			Pos: Pos{Line: 1, Col: 1},
+			Msg: fmt.Sprintf("%s: %d nodes", ErrBudgetExceeded, nodes),
+			Err: ErrBudgetExceeded,
+		}
+	}
+
+	return p, nil
+}
+
+// MustCompile is like Compile but panics if the expression is invalid.
+func MustCompile(src string) *Program {
+	p, err := Compile(src)
+	if err != nil {
+		panic(fmt.Sprintf("expr: Compile(%q): %v", src, err))
+	}
+	return p
+}
+
+// Eval scores r. Fractional results are truncated toward zero. Results that
+// are not finite or do not fit in an int64 are an error wrapping
+// ErrOutOfRange.
+func (p *Program) Eval(r models.Receipt) (int64, error) {
+	s := &state{receipt: &r, budget: p.budget}
+
+	v, err := eval(s, p.root)
+	if err != nil {
+		return 0, err
+	}
+
+	// float64(math.MaxInt64) rounds up to 2^63, which is itself out of range.
+	n := math.Trunc(v.num)
+	if math.IsNaN(n) || n < math.MinInt64 || n >= math.MaxInt64 {
+		return 0, &Error{
+			Pos: p.root.position(),
+			Msg: fmt.Sprintf("%s: %v", ErrOutOfRange, v.num),
+			Err: ErrOutOfRange,
+		}
+	}
+
+	return int64(n), nil
+}
+
+// Rule adapts the program to a points.RuleHandlerFn. A receipt that fails to
+// evaluate, for example by dividing by zero, earns no points from the rule.
+func (p *Program) Rule() points.RuleHandlerFn {
+	return func(r models.Receipt) int64 {
+		v, err := p.Eval(r)
+		if err != nil {
+			return 0
+		}
+		return v
+	}
+}
+
+func (p *Program) String() string {
+	return p.src
+}
@@ -0,0 +1,306 @@
+package expr
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+)
+
+func TestEval(t *testing.T) {
+	receipt := models.Receipt{
+		Retailer:    "Walmart Supercenter",
+		Total:       64.50,
+		PurchasedAt: time.Date(2022, 3, 20, 14, 33, 0, 0, time.UTC),
+		Items: []models.Item{
+			{ShortDescription: "Gatorade", Price: 2.25},
+			{ShortDescription: "Doritos", Price: 3.35},
+			{ShortDescription: "Pizza", Price: 58.90},
+		},
+	}
+
+	tests := []struct {
+		name string
+		src  string
+		want int64
+	}{
+		{
+			name: "Eval: should return a number literal",
+			src:  "15",
+			want: 15,
+		},
+		{
+			name: "Eval: should pick the else branch when there are fewer than 10 items",
+			src:  "items.count >= 10 ? 15 : 0",
+			want: 0,
+		},
+		{
+			name: "Eval: should match the retailer case insensitively",
+			src:  `retailer matches "(?i)walmart" && total > 50 ? 20 : 0`,
+			want: 20,
+		},
+		{
+			name: "Eval: should respect operator precedence",
+			src:  "1 + 2 * 3 - 4 % 3",
+			want: 6,
+		},
+		{
+			name: "Eval: should read the purchase time",
+			src:  "purchase.hour == 14 && purchase.minute > 30 ? 10 : 0",
+			want: 10,
+		},
+		{
+			name: "Eval: should call functions",
+			src:  "ceil(items.total * 0.2) + max(1, len(retailer))",
+			want: 13 + 19,
+		},
+		{
+			name: "Eval: should truncate fractional results",
+			src:  "total / 10",
+			want: 6,
+		},
+		{
+			name: "Eval: should nest conditionals to the right",
+			src:  "total < 10 ? 1 : total < 100 ? 2 : 3",
+			want: 2,
+		},
+		{
+			name: "Eval: should compare strings",
+			src:  `retailer != "Target" && !(retailer < "A") ? 1 : 0`,
+			want: 1,
+		},
+		{
+			name: "Eval: should span multiple lines",
+			src:  "items.count > 2\n  ? -5\n  : 5",
+			want: -5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Compile(tt.src)
+			if err != nil {
+				t.Fatalf("got %v, want nil", err)
+			}
+
+			got, err := p.Eval(receipt)
+			if err != nil {
+				t.Fatalf("got %v, want nil", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+
+			if rule := p.Rule()(receipt); rule != tt.want {
+				t.Errorf("Rule: got %v, want %v", rule, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalCategories(t *testing.T) {
+	receipt := models.Receipt{
+		Items: []models.Item{
+			{ShortDescription: "Gatorade", Price: 2.25, Category: "beverages"},
+			{ShortDescription: "Pepsi", Price: 1.25, Category: "Beverages"},
+			{ShortDescription: "Doritos", Price: 3.35, Category: "snacks"},
+			{ShortDescription: "Paper Towels", Price: 5.00},
+		},
+	}
+
+	p := MustCompile(`categoryCount("beverages") >= 2 ? 5 * categoryCount("beverages") + ceil(categoryTotal("snacks")) : 0`)
+
+	got, err := p.Eval(receipt)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if got != 14 {
+		t.Errorf("got %v, want 14", got)
+	}
+}
+
+func TestEvalItemLines(t *testing.T) {
+	receipt := models.Receipt{
+		Items: []models.Item{
+			{ShortDescription: "Pepsi", Price: 5.97, Quantity: 3, Type: models.LineSale},
+			{ShortDescription: "Chips", Price: 2.00},
+			{ShortDescription: "Coupon", Price: -1.50, Type: models.LineDiscount},
+			{ShortDescription: "Tax", Price: 0.50, Type: models.LineTax},
+		},
+	}
+
+	tests := []struct {
+		src  string
+		want int64
+	}{
+		{src: "items.count", want: 2},
+		{src: "items.units", want: 4},
+		{src: "items.total * 100", want: 797},
+		{src: "items.discounts * 100", want: -150},
+	}
+
+	for _, tt := range tests {
+		t.Run("Eval: "+tt.src, func(t *testing.T) {
+			got, err := MustCompile(tt.src).Eval(receipt)
+			if err != nil {
+				t.Fatalf("got %v, want nil", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantPos Pos
+		wantMsg string
+	}{
+		{
+			name:    "Compile: should reject unknown fields",
+			src:     "items.size > 2 ? 1 : 0",
+			wantPos: Pos{Line: 1, Col: 1},
+			wantMsg: "unknown field",
+		},
+		{
+			name:    "Compile: should reject unknown functions",
+			src:     "loop(1)",
+			wantPos: Pos{Line: 1, Col: 1},
+			wantMsg: "unknown function",
+		},
+		{
+			name:    "Compile: should report the line and column of a type error",
+			src:     "items.count > 2\n  ? \"yes\"\n  : 0",
+			wantPos: Pos{Line: 2, Col: 3},
+			wantMsg: "different types",
+		},
+		{
+			name:    "Compile: should reject boolean results",
+			src:     "total > 50",
+			wantPos: Pos{Line: 1, Col: 7},
+			wantMsg: "must evaluate to a number",
+		},
+		{
+			name:    "Compile: should reject invalid patterns",
+			src:     `retailer matches "(" ? 1 : 0`,
+			wantPos: Pos{Line: 1, Col: 18},
+			wantMsg: "invalid pattern",
+		},
+		{
+			name:    "Compile: should reject dynamic patterns",
+			src:     `retailer matches retailer ? 1 : 0`,
+			wantPos: Pos{Line: 1, Col: 18},
+			wantMsg: "string literal",
+		},
+		{
+			name:    "Compile: should reject unexpected characters",
+			src:     "total $ 2",
+			wantPos: Pos{Line: 1, Col: 7},
+			wantMsg: "unexpected character",
+		},
+		{
+			name:    "Compile: should reject unterminated strings",
+			src:     `retailer == "Target`,
+			wantPos: Pos{Line: 1, Col: 13},
+			wantMsg: "unterminated string",
+		},
+		{
+			name:    "Compile: should reject missing operands",
+			src:     "total +",
+			wantPos: Pos{Line: 1, Col: 8},
+			wantMsg: "end of expression",
+		},
+		{
+			name:    "Compile: should reject trailing tokens",
+			src:     "1 2",
+			wantPos: Pos{Line: 1, Col: 3},
+			wantMsg: "after expression",
+		},
+		{
+			name:    "Compile: should reject wrong argument counts",
+			src:     "max(1)",
+			wantPos: Pos{Line: 1, Col: 1},
+			wantMsg: "takes 2 arguments",
+		},
+		{
+			name:    "Compile: should reject deeply nested expressions",
+			src:     strings.Repeat("(", 100) + "1" + strings.Repeat(")", 100),
+			wantPos: Pos{Line: 1, Col: 65},
+			wantMsg: "nested too deeply",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.src)
+
+			var exprErr *Error
+			if !errors.As(err, &exprErr) {
+				t.Fatalf("got %v, want *Error", err)
+			}
+			if exprErr.Pos != tt.wantPos {
+				t.Errorf("got %v, want %v", exprErr.Pos, tt.wantPos)
+			}
+			if !strings.Contains(exprErr.Msg, tt.wantMsg) {
+				t.Errorf("got %q, want it to contain %q", exprErr.Msg, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestBudget(t *testing.T) {
+	t.Run("Compile: should reject programs larger than the budget", func(t *testing.T) {
+		_, err := Compile("1 + 2 + 3 + 4", WithBudget(3))
+		if !errors.Is(err, ErrBudgetExceeded) {
+			t.Errorf("got %v, want %v", err, ErrBudgetExceeded)
+		}
+	})
+
+	t.Run("Eval: should stop when matching exhausts the budget", func(t *testing.T) {
+		p, err := Compile(`retailer matches "x" ? 1 : 0`, WithBudget(20))
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+
+		_, err = p.Eval(models.Receipt{Retailer: strings.Repeat("a", 100)})
+		if !errors.Is(err, ErrBudgetExceeded) {
+			t.Errorf("got %v, want %v", err, ErrBudgetExceeded)
+		}
+	})
+
+	t.Run("Rule: should award 0 points when evaluation fails", func(t *testing.T) {
+		p := MustCompile("10 / items.count")
+
+		if got := p.Rule()(models.Receipt{}); got != 0 {
+			t.Errorf("got %v, want 0", got)
+		}
+	})
+}
+
+func TestEvalOutOfRange(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{name: "Eval: should reject results above the int64 range", src: "9999999999 * 9999999999"},
+		{name: "Eval: should reject results below the int64 range", src: "-9999999999 * 9999999999"},
+		{name: "Eval: should reject infinite results", src: strings.Repeat("9999999999 * ", 40) + "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := MustCompile(tt.src)
+			if _, err := p.Eval(models.Receipt{}); !errors.Is(err, ErrOutOfRange) {
+				t.Errorf("got %v, want %v", err, ErrOutOfRange)
+			}
+			if got := p.Rule()(models.Receipt{}); got != 0 {
+				t.Errorf("Rule: got %v, want 0", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package expr
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokTrue
+	tokFalse
+	tokMatches
+	tokQuestion
+	tokColon
+	tokOr
+	tokAnd
+	tokEq
+	tokNeq
+	tokLt
+	tokLte
+	tokGt
+	tokGte
+	tokPlus
+	tokMinus
+	tokStar
+	tokSlash
+	tokPercent
+	tokNot
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+var tokenNames = map[tokenKind]string{
+	tokEOF:      "end of expression",
+	tokNumber:   "number",
+	tokString:   "string",
+	tokIdent:    "identifier",
+	tokTrue:     "true",
+	tokFalse:    "false",
+	tokMatches:  "matches",
+	tokQuestion: "?",
+	tokColon:    ":",
+	tokOr:       "||",
+	tokAnd:      "&&",
+	tokEq:       "==",
+	tokNeq:      "!=",
+	tokLt:       "<",
+	tokLte:      "<=",
+	tokGt:       ">",
+	tokGte:      ">=",
+	tokPlus:     "+",
+	tokMinus:    "-",
+	tokStar:     "*",
+	tokSlash:    "/",
+	tokPercent:  "%",
+	tokNot:      "!",
+	tokLParen:   "(",
+	tokRParen:   ")",
+	tokComma:    ",",
+}
+
+func (k tokenKind) String() string {
+	return tokenNames[k]
+}
+
+type token struct {
+	kind tokenKind
+	text string
+	num  float64
+	pos  Pos
+}
+
+var keywords = map[string]tokenKind{
+	"true":    tokTrue,
+	"false":   tokFalse,
+	"matches": tokMatches,
+}
+
+var operators = []struct {
+	text string
+	kind tokenKind
+}{
+	// Two character operators must come first so they win over their prefixes.
+	{"||", tokOr},
+	{"&&", tokAnd},
+	{"==", tokEq},
+	{"!=", tokNeq},
+	{"<=", tokLte},
+	{">=", tokGte},
+	{"<", tokLt},
+	{">", tokGt},
+	{"?", tokQuestion},
+	{":", tokColon},
+	{"+", tokPlus},
+	{"-", tokMinus},
+	{"*", tokStar},
+	{"/", tokSlash},
+	{"%", tokPercent},
+	{"!", tokNot},
+	{"(", tokLParen},
+	{")", tokRParen},
+	{",", tokComma},
+}
+
+type lexer struct {
+	src  string
+	off  int
+	line int
+	col  int
+}
+
+func newLexer(src string) *lexer {
+	return &lexer{src: src, line: 1, col: 1}
+}
+
+func (l *lexer) pos() Pos {
+	return Pos{Line: l.line, Col: l.col}
+}
+
+func (l *lexer) advance(n int) {
+	for _, c := range l.src[l.off : l.off+n] {
+		if c == '\n' {
+			l.line++
+			l.col = 1
+			continue
+		}
+		l.col++
+	}
+	l.off += n
+}
+
+func (l *lexer) skipSpace() {
+	for l.off < len(l.src) {
+		c, size := utf8.DecodeRuneInString(l.src[l.off:])
+		if !unicode.IsSpace(c) {
+			return
+		}
+		l.advance(size)
+	}
+}
+
+// tokens splits the whole source up front; expressions are small and this
+// keeps the parser free of lexer state.
+func (l *lexer) tokens() ([]token, error) {
+	var toks []token
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		toks = append(toks, tok)
+		if tok.kind == tokEOF {
+			return toks, nil
+		}
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipSpace()
+
+	start := l.pos()
+	if l.off >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+
+	rest := l.src[l.off:]
+	c, _ := utf8.DecodeRuneInString(rest)
+
+	switch {
+	case c >= '0' && c <= '9':
+		return l.number(start)
+	case c == '"':
+		return l.string(start)
+	case c == '_' || unicode.IsLetter(c):
+		return l.ident(start), nil
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(rest, op.text) {
+			l.advance(len(op.text))
+			return token{kind: op.kind, text: op.text, pos: start}, nil
+		}
+	}
+
+	return token{}, errorf(start, "unexpected character %q", c)
+}
+
+func (l *lexer) number(start Pos) (token, error) {
+	end := l.off
+	seenDot := false
+	for end < len(l.src) {
+		c := l.src[end]
+		if c == '.' && !seenDot {
+			seenDot = true
+			end++
+			continue
+		}
+		if c < '0' || c > '9' {
+			break
+		}
+		end++
+	}
+
+	text := l.src[l.off:end]
+	num, err := strconv.ParseFloat(text, 64)
+	if err != nil || strings.HasSuffix(text, ".") {
+		return token{}, errorf(start, "invalid number %q", text)
+	}
+
+	l.advance(len(text))
+	return token{kind: tokNumber, text: text, num: num, pos: start}, nil
+}
+
+func (l *lexer) string(start Pos) (token, error) {
+	end := l.off + 1
+	for end < len(l.src) {
+		switch l.src[end] {
+		case '\\':
+			end += 2
+			continue
+		case '\n':
+			return token{}, errorf(start, "unterminated string")
+		case '"':
+			text := l.src[l.off : end+1]
+			value, err := strconv.Unquote(text)
+			if err != nil {
+				return token{}, errorf(start, "invalid string %s", text)
+			}
+			l.advance(len(text))
+			return token{kind: tokString, text: value, pos: start}, nil
+		}
+		end++
+	}
+
+	return token{}, errorf(start, "unterminated string")
+}
+
+// ident reads a dotted identifier such as items.count; field paths are a
+// single token because the language has no general member access.
+func (l *lexer) ident(start Pos) token {
+	end := l.off
+	for end < len(l.src) {
+		c, size := utf8.DecodeRuneInString(l.src[end:])
+		if c != '_' && c != '.' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			break
+		}
+		end += size
+	}
+
+	text := l.src[l.off:end]
+	l.advance(len(text))
+
+	if kind, ok := keywords[text]; ok {
+		return token{kind: kind, text: text, pos: start}
+	}
+	return token{kind: tokIdent, text: text, pos: start}
+}
@@ -0,0 +1,343 @@
+package expr
+
+import (
+	"regexp"
+)
+
+// maxDepth bounds nesting so a hostile expression cannot exhaust the stack
+// while it is being parsed, checked or evaluated.
+const maxDepth = 64
+
+type node interface {
+	position() Pos
+}
+
+type (
+	numberLit struct {
+		pos   Pos
+		value float64
+	}
+
+	stringLit struct {
+		pos   Pos
+		value string
+	}
+
+	boolLit struct {
+		pos   Pos
+		value bool
+	}
+
+	fieldRef struct {
+		pos   Pos
+		name  string
+		field field
+	}
+
+	unaryExpr struct {
+		pos Pos
+		op  tokenKind
+		x   node
+	}
+
+	binaryExpr struct {
+		pos Pos
+		op  tokenKind
+		x   node
+		y   node
+	}
+
+	matchExpr struct {
+		pos Pos
+		x   node
+		re  *regexp.Regexp
+	}
+
+	condExpr struct {
+		pos  Pos
+		cond node
+		then node
+		els  node
+	}
+
+	callExpr struct {
+		pos  Pos
+		name string
+		fn   function
+		args []node
+	}
+)
+
+func (n *numberLit) position() Pos  { return n.pos }
+func (n *stringLit) position() Pos  { return n.pos }
+func (n *boolLit) position() Pos    { return n.pos }
+func (n *fieldRef) position() Pos   { return n.pos }
+func (n *unaryExpr) position() Pos  { return n.pos }
+func (n *binaryExpr) position() Pos { return n.pos }
+func (n *matchExpr) position() Pos  { return n.pos }
+func (n *condExpr) position() Pos   { return n.pos }
+func (n *callExpr) position() Pos   { return n.pos }
+
+type parser struct {
+	toks  []token
+	i     int
+	depth int
+	nodes int
+}
+
+func parse(src string) (node, int, error) {
+	toks, err := newLexer(src).tokens()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	p := &parser{toks: toks}
+	n, err := p.expr()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, 0, errorf(tok.pos, "unexpected %s after expression", describe(tok))
+	}
+
+	return n, p.nodes, nil
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.i]
+}
+
+func (p *parser) take() token {
+	tok := p.toks[p.i]
+	if tok.kind != tokEOF {
+		p.i++
+	}
+	return tok
+}
+
+func (p *parser) expect(kind tokenKind) (token, error) {
+	tok := p.take()
+	if tok.kind != kind {
+		return tok, errorf(tok.pos, "expected %s, found %s", kind, describe(tok))
+	}
+	return tok, nil
+}
+
+func (p *parser) enter(pos Pos) error {
+	p.depth++
+	p.nodes++
+	if p.depth > maxDepth {
+		return errorf(pos, "expression is nested too deeply")
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+// expr parses a conditional expression: or ('?' expr ':' expr)?
+func (p *parser) expr() (node, error) {
+	start := p.peek().pos
+	if err := p.enter(start); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	cond, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind != tokQuestion {
+		return cond, nil
+	}
+	q := p.take()
+
+	then, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.expect(tokColon); err != nil {
+		return nil, err
+	}
+
+	els, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+
+	return &condExpr{pos: q.pos, cond: cond, then: then, els: els}, nil
+}
+
+// precedence lists binary operators from loosest to tightest binding.
+var precedence = [][]tokenKind{
+	{tokOr},
+	{tokAnd},
+	{tokEq, tokNeq},
+	{tokLt, tokLte, tokGt, tokGte, tokMatches},
+	{tokPlus, tokMinus},
+	{tokStar, tokSlash, tokPercent},
+}
+
+func (p *parser) binary(level int) (node, error) {
+	if level == len(precedence) {
+		return p.unary()
+	}
+
+	x, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		if !contains(precedence[level], op.kind) {
+			return x, nil
+		}
+		p.take()
+
+		if err := p.enter(op.pos); err != nil {
+			return nil, err
+		}
+		y, err := p.binary(level + 1)
+		p.leave()
+		if err != nil {
+			return nil, err
+		}
+
+		if op.kind == tokMatches {
+			x, err = newMatch(op.pos, x, y)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		x = &binaryExpr{pos: op.pos, op: op.kind, x: x, y: y}
+	}
+}
+
+// newMatch requires the pattern to be a string literal so it is compiled,
+// and rejected if invalid, when the expression is loaded.
+func newMatch(pos Pos, x, pattern node) (node, error) {
+	lit, ok := pattern.(*stringLit)
+	if !ok {
+		return nil, errorf(pattern.position(), "right side of matches must be a string literal")
+	}
+
+	re, err := regexp.Compile(lit.value)
+	if err != nil {
+		return nil, errorf(lit.pos, "invalid pattern: %v", err)
+	}
+
+	return &matchExpr{pos: pos, x: x, re: re}, nil
+}
+
+func (p *parser) unary() (node, error) {
+	tok := p.peek()
+	if tok.kind != tokNot && tok.kind != tokMinus {
+		return p.primary()
+	}
+	p.take()
+
+	if err := p.enter(tok.pos); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	return &unaryExpr{pos: tok.pos, op: tok.kind, x: x}, nil
+}
+
+func (p *parser) primary() (node, error) {
+	tok := p.take()
+	p.nodes++
+
+	switch tok.kind {
+	case tokNumber:
+		return &numberLit{pos: tok.pos, value: tok.num}, nil
+
+	case tokString:
+		return &stringLit{pos: tok.pos, value: tok.text}, nil
+
+	case tokTrue, tokFalse:
+		return &boolLit{pos: tok.pos, value: tok.kind == tokTrue}, nil
+
+	case tokLParen:
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen); err != nil {
+			return nil, err
+		}
+		return x, nil
+
+	case tokIdent:
+		if p.peek().kind == tokLParen {
+			return p.call(tok)
+		}
+
+		f, ok := fields[tok.text]
+		if !ok {
+			return nil, errorf(tok.pos, "unknown field %q", tok.text)
+		}
+		return &fieldRef{pos: tok.pos, name: tok.text, field: f}, nil
+	}
+
+	return nil, errorf(tok.pos, "unexpected %s", describe(tok))
+}
+
+func (p *parser) call(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, errorf(name.pos, "unknown function %q", name.text)
+	}
+	p.take() // (
+
+	var args []node
+	for p.peek().kind != tokRParen {
+		if len(args) > 0 {
+			if _, err := p.expect(tokComma); err != nil {
+				return nil, err
+			}
+		}
+
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.take() // )
+
+	return &callExpr{pos: name.pos, name: name.text, fn: fn, args: args}, nil
+}
+
+func contains(kinds []tokenKind, kind tokenKind) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func describe(tok token) string {
+	switch tok.kind {
+	case tokNumber, tokIdent:
+		return "'" + tok.text + "'"
+	case tokString:
+		return "string"
+	case tokEOF:
+		return tok.kind.String()
+	}
+	return "'" + tok.kind.String() + "'"
+}
@@ -0,0 +1,87 @@
+// Package jsonl appends records to JSON lines files and replays them.
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Replay calls decode with each line of f, a JSON lines file at path, and
+// leaves f ready for appending. A final line without a newline was torn by a
+// crash during a write: it is truncated with a warning when it cannot be
+// decoded, and completed otherwise, so the next append starts a line of its
+// own. Any other line that cannot be decoded is an error.
+func Replay(f *os.File, path string, decode func(line []byte) error) error {
+	reader := bufio.NewReader(f)
+	var offset int64
+	for line := 1; ; line++ {
+		b, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		torn := err != nil && len(b) > 0
+
+		if len(bytes.TrimSpace(b)) > 0 {
+			if derr := decode(b); derr != nil {
+				if !torn {
+					return fmt.Errorf("%s:%d: %w", path, line, derr)
+				}
+				slog.Warn("Truncating a torn last line", "path", path, "line", line, "err", derr)
+				if err := f.Truncate(offset); err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+				return nil
+			}
+		}
+		if torn {
+			if _, err := f.Write([]byte{'\n'}); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		if err != nil {
+			return nil
+		}
+		offset += int64(len(b))
+	}
+}
+
+// Append writes v to f, a JSON lines file opened for appending, as one line
+// and syncs it. When the line cannot be written and synced in full, f is
+// truncated back to its size before the write, so no partial line is left
+// for later lines to follow.
+func Append(f *os.File, v any) error {
+	return appendLine(f, v)
+}
+
+// file is the part of *os.File Append uses.
+type file interface {
+	io.Writer
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+func appendLine(f file, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(b, '\n')); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		return errors.Join(err, f.Truncate(info.Size()))
+	}
+	return nil
+}
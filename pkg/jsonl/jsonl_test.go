@@ -0,0 +1,120 @@
+package jsonl
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		wantLines int
+		wantData  string
+		wantErr   bool
+	}{
+		{name: "Replay: should decode every line", data: "{\"n\":1}\n\n{\"n\":2}\n", wantLines: 2, wantData: "{\"n\":1}\n\n{\"n\":2}\n"},
+		{name: "Replay: should truncate a torn last line", data: "{\"n\":1}\n{\"n\":", wantLines: 1, wantData: "{\"n\":1}\n"},
+		{name: "Replay: should complete a last line missing its newline", data: "{\"n\":1}\n{\"n\":2}", wantLines: 2, wantData: "{\"n\":1}\n{\"n\":2}\n"},
+		{name: "Replay: should return error for a corrupt line", data: "{\"n\":\n{\"n\":2}\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "records.jsonl")
+			if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			lines := 0
+			err = Replay(f, path, func(line []byte) error {
+				var v struct{ N int }
+				if err := json.Unmarshal(line, &v); err != nil {
+					return err
+				}
+				lines++
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if lines != tt.wantLines {
+				t.Errorf("got %d lines, want %d", lines, tt.wantLines)
+			}
+			if got, _ := os.ReadFile(path); string(got) != tt.wantData {
+				t.Errorf("got %q, want %q", got, tt.wantData)
+			}
+		})
+	}
+}
+
+func TestAppend(t *testing.T) {
+	tests := []struct {
+		name     string
+		short    int
+		syncErr  error
+		wantErr  bool
+		wantData string
+	}{
+		{name: "Append: should write a line", wantData: "{\"n\":1}\n{\"n\":2}\n"},
+		{name: "Append: should truncate a partial line", short: 3, wantErr: true, wantData: "{\"n\":1}\n"},
+		{name: "Append: should truncate a line that cannot be synced", syncErr: errors.New("disk gone"), wantErr: true, wantData: "{\"n\":1}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "records.jsonl")
+			f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if err := Append(f, map[string]int{"n": 1}); err != nil {
+				t.Fatal(err)
+			}
+
+			err = appendLine(&failingFile{File: f, short: tt.short, syncErr: tt.syncErr}, map[string]int{"n": 2})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got %v, want error %v", err, tt.wantErr)
+			}
+			if got, _ := os.ReadFile(path); string(got) != tt.wantData {
+				t.Errorf("got %q, want %q", got, tt.wantData)
+			}
+		})
+	}
+}
+
+// failingFile writes only short bytes of a write when short is set, and
+// fails to sync with syncErr.
+type failingFile struct {
+	*os.File
+	short   int
+	syncErr error
+}
+
+func (f *failingFile) Write(b []byte) (int, error) {
+	if f.short == 0 {
+		return f.File.Write(b)
+	}
+	n, _ := f.File.Write(b[:f.short])
+	return n, errors.New("no space left on device")
+}
+
+func (f *failingFile) Sync() error {
+	if f.syncErr != nil {
+		return f.syncErr
+	}
+	return f.File.Sync()
+}
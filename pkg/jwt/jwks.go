@@ -0,0 +1,316 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrKeySetInvalid      = errors.New("jwks must be a JSON object with a keys array")
+	ErrKeyTypeUnsupported = errors.New("jwk key type must be RSA, EC on P-256, or oct")
+	ErrKeyInvalid         = errors.New("jwk is missing or has malformed key parameters")
+)
+
+// Key is a verification key from a JWKS.
+type Key struct {
+	ID  string
+	Alg string // Optional; when set tokens must use it.
+
+	// Public is an *rsa.PublicKey, an *ecdsa.PublicKey or, for HS256, the
+	// shared secret as []byte.
+	Public any
+}
+
+// algorithm is the only signing algorithm each key type may verify, so an
+// RSA public key can never be used as an HMAC secret.
+func (k Key) algorithm() string {
+	switch k.Public.(type) {
+	case *rsa.PublicKey:
+		return AlgRS256
+	case *ecdsa.PublicKey:
+		return AlgES256
+	case []byte:
+		return AlgHS256
+	}
+	return ""
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// KeySet is a parsed JWKS.
+type KeySet struct {
+	Keys []Key
+}
+
+// ParseKeySet parses a JWKS document. Keys marked for encryption are
+// skipped.
+func ParseKeySet(data []byte) (*KeySet, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrKeySetInvalid, err)
+	}
+	if doc.Keys == nil {
+		return nil, ErrKeySetInvalid
+	}
+
+	set := &KeySet{}
+	for _, j := range doc.Keys {
+		if j.Use == "enc" {
+			continue
+		}
+		pub, err := j.public()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", j.Kid, err)
+		}
+		set.Keys = append(set.Keys, Key{ID: j.Kid, Alg: j.Alg, Public: pub})
+	}
+
+	return set, nil
+}
+
+func (j jwk) public() (any, error) {
+	b64 := base64.RawURLEncoding
+
+	switch j.Kty {
+	case "RSA":
+		n, nerr := b64.DecodeString(j.N)
+		e, eerr := b64.DecodeString(j.E)
+		if nerr != nil || eerr != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, ErrKeyInvalid
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		if j.Crv != "P-256" {
+			return nil, fmt.Errorf("%w: curve %q", ErrKeyTypeUnsupported, j.Crv)
+		}
+		x, xerr := b64.DecodeString(j.X)
+		y, yerr := b64.DecodeString(j.Y)
+		if xerr != nil || yerr != nil || len(x) != 32 || len(y) != 32 {
+			return nil, ErrKeyInvalid
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, ErrKeyInvalid
+		}
+		return pub, nil
+
+	case "oct":
+		k, err := b64.DecodeString(j.K)
+		if err != nil || len(k) == 0 {
+			return nil, ErrKeyInvalid
+		}
+		return k, nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrKeyTypeUnsupported, j.Kty)
+}
+
+// find returns the key for a token header. Tokens without a kid match the
+// only key of their algorithm.
+func (s *KeySet) find(kid, alg string) (Key, bool) {
+	var found []Key
+	for _, k := range s.Keys {
+		if k.algorithm() != alg || (k.Alg != "" && k.Alg != alg) {
+			continue
+		}
+		if kid != "" && k.ID == kid {
+			return k, true
+		}
+		found = append(found, k)
+	}
+
+	if kid == "" && len(found) == 1 {
+		return found[0], true
+	}
+	return Key{}, false
+}
+
+// KeySource loads a JWKS from a file or URL and caches it for a TTL. A token
+// signed with a key not in the cache triggers a refresh, at most once per
+// MinRefresh, so rotated keys are picked up without waiting for the TTL.
+// Fetches happen outside the lock, and concurrent requests for a refresh
+// share the fetch in flight.
+type KeySource struct {
+	fetch func(ctx context.Context) ([]byte, error)
+	ttl   time.Duration
+	now   func() time.Time
+
+	mu       sync.Mutex
+	set      *KeySet
+	err      error // Of the last fetch.
+	fetched  time.Time
+	inflight *fetchCall
+}
+
+// fetchCall is a fetch in flight; err is set before done is closed.
+type fetchCall struct {
+	done chan struct{}
+	err  error
+}
+
+// MinRefresh bounds how often unknown key IDs can force a refresh.
+const MinRefresh = 30 * time.Second
+
+// FetchTimeout bounds a fetch, which no longer ends with the request that
+// started it.
+const FetchTimeout = 10 * time.Second
+
+// NewFileSource reads the JWKS in path, rereading it once ttl has passed.
+func NewFileSource(path string, ttl time.Duration) *KeySource {
+	return &KeySource{
+		fetch: func(context.Context) ([]byte, error) { return os.ReadFile(path) },
+		ttl:   ttl,
+		now:   time.Now,
+	}
+}
+
+// NewURLSource fetches the JWKS at url with client, or http.DefaultClient
+// when nil.
+func NewURLSource(url string, ttl time.Duration, client *http.Client) *KeySource {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &KeySource{
+		fetch: func(ctx context.Context) ([]byte, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return nil, err
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("error fetching jwks: %s", resp.Status)
+			}
+			return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		},
+		ttl: ttl,
+		now: time.Now,
+	}
+}
+
+// Load fetches the key set now, so configuration errors surface at startup.
+func (s *KeySource) Load(ctx context.Context) error {
+	return s.refresh(ctx)
+}
+
+// refresh replaces the cached keys, or waits for the fetch already in flight.
+// Failed attempts count as fetches, so an unavailable JWKS is retried once per
+// TTL rather than on every request, even before any keys are cached.
+//
+// The fetch is shared, so it runs without the cancellation of the request
+// that started it: a client that disconnects only stops its own wait, rather
+// than failing the fetch for every waiter until the next retry.
+func (s *KeySource) refresh(ctx context.Context) error {
+	s.mu.Lock()
+	call := s.inflight
+	start := call == nil
+	if start {
+		call = &fetchCall{done: make(chan struct{})}
+		s.inflight = call
+		s.fetched = s.now()
+	}
+	s.mu.Unlock()
+
+	if start {
+		go s.run(context.WithoutCancel(ctx), call)
+	}
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run fetches the key set for call and caches it.
+func (s *KeySource) run(ctx context.Context, call *fetchCall) {
+	ctx, cancel := context.WithTimeout(ctx, FetchTimeout)
+	defer cancel()
+
+	var set *KeySet
+	data, err := s.fetch(ctx)
+	if err == nil {
+		set, err = ParseKeySet(data)
+	}
+
+	s.mu.Lock()
+	if err == nil {
+		s.set = set
+	}
+	s.err = err
+	s.inflight = nil
+	s.mu.Unlock()
+
+	call.err = err
+	close(call.done)
+}
+
+// cached returns the cached keys, when they were fetched and the error of
+// the last fetch.
+func (s *KeySource) cached() (*KeySet, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.set, s.fetched, s.err
+}
+
+// key returns the verification key for a token header. When a refresh fails
+// the cached keys stay in use.
+func (s *KeySource) key(ctx context.Context, kid, alg string) (Key, error) {
+	set, fetched, err := s.cached()
+	if (set == nil && err == nil) || s.now().Sub(fetched) >= s.ttl {
+		err = s.refresh(ctx)
+		set, fetched, _ = s.cached()
+	}
+	if set == nil {
+		return Key{}, fmt.Errorf("error loading jwks: %w", err)
+	}
+
+	if k, ok := set.find(kid, alg); ok {
+		return k, nil
+	}
+
+	if s.now().Sub(fetched) >= MinRefresh {
+		if err := s.refresh(ctx); err == nil {
+			set, _, _ = s.cached()
+			if k, ok := set.find(kid, alg); ok {
+				return k, nil
+			}
+		}
+	}
+
+	return Key{}, fmt.Errorf("%w: %q", ErrKeyNotFound, kid)
+}
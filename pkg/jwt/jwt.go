@@ -0,0 +1,256 @@
+// Package jwt validates JSON Web Tokens signed with RS256, ES256 or HS256
+// against a JSON Web Key Set.
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgHS256 = "HS256"
+)
+
+var (
+	ErrTokenMalformed    = errors.New("token is not a signed JWT")
+	ErrAlgUnsupported    = errors.New("token algorithm must be RS256, ES256 or HS256")
+	ErrKeyNotFound       = errors.New("token signing key is not in the key set")
+	ErrSignatureInvalid  = errors.New("token signature is invalid")
+	ErrExpiryMissing     = errors.New("token has no expiry")
+	ErrTokenExpired      = errors.New("token has expired")
+	ErrTokenNotYetValid  = errors.New("token is not valid yet")
+	ErrIssuerInvalid     = errors.New("token issuer is not accepted")
+	ErrAudienceInvalid   = errors.New("token audience is not accepted")
+	ErrSubjectEmpty      = errors.New("token has no subject")
+	ErrValidatorRequired = errors.New("validator requires a key source, issuer and audience")
+)
+
+// NumericDate is a JWT time, in seconds since the epoch.
+type NumericDate struct {
+	time.Time
+}
+
+func (d *NumericDate) UnmarshalJSON(b []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(b, &seconds); err != nil {
+		return err
+	}
+	whole, frac := math.Modf(seconds)
+	d.Time = time.Unix(int64(whole), int64(frac*1e9))
+	return nil
+}
+
+func (d NumericDate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Unix())
+}
+
+// Audience is the aud claim, which may be a single string or an array.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = Audience{one}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// Claims are the registered claims the server uses.
+type Claims struct {
+	Subject   string       `json:"sub"`
+	Issuer    string       `json:"iss"`
+	Audience  Audience     `json:"aud"`
+	ExpiresAt *NumericDate `json:"exp,omitempty"`
+	NotBefore *NumericDate `json:"nbf,omitempty"`
+	IssuedAt  *NumericDate `json:"iat,omitempty"`
+
+	// AuthorizedParty is the client the token was issued to.
+	AuthorizedParty string `json:"azp,omitempty"`
+	// Scope is a space-separated list of scopes.
+	Scope string `json:"scope,omitempty"`
+	// Tenant binds the token to one tenant.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+type Option func(*Validator)
+
+// WithLeeway tolerates clock skew between the server and the token issuer.
+func WithLeeway(d time.Duration) Option {
+	return func(v *Validator) {
+		v.leeway = d
+	}
+}
+
+// WithClock replaces time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(v *Validator) {
+		v.now = now
+	}
+}
+
+// Validator checks tokens against a key set and the accepted issuer and
+// audience. It is safe for concurrent use.
+type Validator struct {
+	keys     *KeySource
+	issuer   string
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+func NewValidator(keys *KeySource, issuer, audience string, opts ...Option) (*Validator, error) {
+	if keys == nil || issuer == "" || audience == "" {
+		return nil, ErrValidatorRequired
+	}
+
+	v := &Validator{
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+		leeway:   time.Minute,
+		now:      time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	return v, nil
+}
+
+// Validate verifies a compact JWS token and returns its claims.
+func (v *Validator) Validate(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, err
+	}
+	if h.Alg != AlgRS256 && h.Alg != AlgES256 && h.Alg != AlgHS256 {
+		return nil, fmt.Errorf("%w: %q", ErrAlgUnsupported, h.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTokenMalformed, err)
+	}
+
+	key, err := v.keys.key(ctx, h.Kid, h.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := verify(key, h.Alg, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	// Claims are only read once the signature is known to be good.
+	var c Claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, err
+	}
+	if err := v.check(c); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (v *Validator) check(c Claims) error {
+	now := v.now()
+
+	var err error
+
+	switch {
+	case c.ExpiresAt == nil:
+		err = errors.Join(err, ErrExpiryMissing)
+	case !now.Before(c.ExpiresAt.Add(v.leeway)):
+		err = errors.Join(err, ErrTokenExpired)
+	}
+
+	if c.NotBefore != nil && now.Add(v.leeway).Before(c.NotBefore.Time) {
+		err = errors.Join(err, ErrTokenNotYetValid)
+	}
+
+	if c.Issuer != v.issuer {
+		err = errors.Join(err, fmt.Errorf("%w: %q", ErrIssuerInvalid, c.Issuer))
+	}
+
+	if !slices.Contains(c.Audience, v.audience) {
+		err = errors.Join(err, ErrAudienceInvalid)
+	}
+
+	if c.Subject == "" {
+		err = errors.Join(err, ErrSubjectEmpty)
+	}
+
+	return err
+}
+
+func decodeSegment(segment string, val any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrTokenMalformed, err)
+	}
+	if err := json.Unmarshal(b, val); err != nil {
+		return fmt.Errorf("%w: %w", ErrTokenMalformed, err)
+	}
+	return nil
+}
+
+func verify(key Key, alg, signed string, sig []byte) error {
+	sum := sha256.Sum256([]byte(signed))
+
+	ok := false
+	switch pub := key.Public.(type) {
+	case *rsa.PublicKey:
+		ok = alg == AlgRS256 && rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil
+
+	case *ecdsa.PublicKey:
+		// ES256 signatures are r and s as two 32-byte big-endian integers.
+		if alg == AlgES256 && len(sig) == 64 {
+			r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+			ok = ecdsa.Verify(pub, sum[:], r, s)
+		}
+
+	case []byte:
+		mac := hmac.New(sha256.New, pub)
+		mac.Write([]byte(signed))
+		ok = alg == AlgHS256 && hmac.Equal(mac.Sum(nil), sig)
+	}
+
+	if !ok {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
@@ -0,0 +1,392 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var b64 = base64.RawURLEncoding
+
+type testKeys struct {
+	rsa    *rsa.PrivateKey
+	ec     *ecdsa.PrivateKey
+	secret []byte
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ek, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKeys{rsa: rk, ec: ek, secret: []byte("a shared secret of thirty-two bytes")}
+}
+
+func (k testKeys) jwks() []byte {
+	pad := func(n *big.Int) string {
+		b := make([]byte, 32)
+		return b64.EncodeToString(n.FillBytes(b))
+	}
+
+	doc := map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa-1", "n": b64.EncodeToString(k.rsa.N.Bytes()), "e": b64.EncodeToString(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": pad(k.ec.X), "y": pad(k.ec.Y)},
+		{"kty": "oct", "kid": "hs-1", "alg": "HS256", "k": b64.EncodeToString(k.secret)},
+		{"kty": "RSA", "kid": "enc-1", "use": "enc", "n": "", "e": ""},
+	}}
+	b, _ := json.Marshal(doc)
+	return b
+}
+
+// sign builds a token, signing with the test key for alg.
+func (k testKeys) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+	sum := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	var err error
+	switch alg {
+	case AlgRS256:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, sum[:])
+	case AlgES256:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k.ec, sum[:])
+		if err == nil {
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	case AlgHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func TestValidator(t *testing.T) {
+	keys := newTestKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, keys.jwks(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	v, err := NewValidator(NewFileSource(path, time.Hour), "https://id.example.com", "receipts", WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := func(edit func(c map[string]any)) map[string]any {
+		c := map[string]any{
+			"sub": "user-1",
+			"iss": "https://id.example.com",
+			"aud": "receipts",
+			"exp": now.Add(time.Hour).Unix(),
+			"nbf": now.Add(-time.Hour).Unix(),
+		}
+		if edit != nil {
+			edit(c)
+		}
+		return c
+	}
+
+	for _, alg := range []struct{ alg, kid string }{{AlgRS256, "rsa-1"}, {AlgES256, "ec-1"}, {AlgHS256, "hs-1"}} {
+		t.Run("Validate: should accept "+alg.alg, func(t *testing.T) {
+			c, err := v.Validate(context.Background(), keys.sign(t, alg.alg, alg.kid, claims(nil)))
+			if err != nil {
+				t.Fatalf("got %v, want nil", err)
+			}
+			if c.Subject != "user-1" {
+				t.Errorf("got %q, want user-1", c.Subject)
+			}
+		})
+	}
+
+	t.Run("Validate: should accept a token without a kid and an audience array", func(t *testing.T) {
+		token := keys.sign(t, AlgES256, "", claims(func(c map[string]any) { c["aud"] = []string{"other", "receipts"} }))
+		if _, err := v.Validate(context.Background(), token); err != nil {
+			t.Errorf("got %v, want nil", err)
+		}
+	})
+
+	tampered := strings.Split(keys.sign(t, AlgRS256, "rsa-1", claims(nil)), ".")
+	tampered[1] = b64.EncodeToString([]byte(`{"sub":"admin","iss":"https://id.example.com","aud":"receipts","exp":9999999999}`))
+
+	// An HS256 token keyed with the RSA public key must not verify against it.
+	confused := testKeys{secret: keys.rsa.N.Bytes()}.sign(t, AlgHS256, "rsa-1", claims(nil))
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "Validate: should return error for a malformed token", token: "abc.def", wantErr: ErrTokenMalformed},
+		{name: "Validate: should return error for alg none", token: b64.EncodeToString([]byte(`{"alg":"none"}`)) + "." + b64.EncodeToString([]byte(`{"sub":"x"}`)) + ".", wantErr: ErrAlgUnsupported},
+		{name: "Validate: should return error for a tampered token", token: strings.Join(tampered, "."), wantErr: ErrSignatureInvalid},
+		{name: "Validate: should return error for an HS256 token using an RSA key", token: confused, wantErr: ErrKeyNotFound},
+		{name: "Validate: should return error for an unknown kid", token: keys.sign(t, AlgRS256, "rsa-2", claims(nil)), wantErr: ErrKeyNotFound},
+		{name: "Validate: should return error for an expired token", token: keys.sign(t, AlgRS256, "rsa-1", claims(func(c map[string]any) { c["exp"] = now.Add(-2 * time.Minute).Unix() })), wantErr: ErrTokenExpired},
+		{name: "Validate: should return error for a token without expiry", token: keys.sign(t, AlgRS256, "rsa-1", claims(func(c map[string]any) { delete(c, "exp") })), wantErr: ErrExpiryMissing},
+		{name: "Validate: should return error for a token not valid yet", token: keys.sign(t, AlgRS256, "rsa-1", claims(func(c map[string]any) { c["nbf"] = now.Add(2 * time.Minute).Unix() })), wantErr: ErrTokenNotYetValid},
+		{name: "Validate: should return error for another issuer", token: keys.sign(t, AlgRS256, "rsa-1", claims(func(c map[string]any) { c["iss"] = "https://evil.example.com" })), wantErr: ErrIssuerInvalid},
+		{name: "Validate: should return error for another audience", token: keys.sign(t, AlgRS256, "rsa-1", claims(func(c map[string]any) { c["aud"] = "billing" })), wantErr: ErrAudienceInvalid},
+		{name: "Validate: should return error for a token without subject", token: keys.sign(t, AlgRS256, "rsa-1", claims(func(c map[string]any) { delete(c, "sub") })), wantErr: ErrSubjectEmpty},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := v.Validate(context.Background(), tt.token); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("Validate: should allow clock skew within the leeway", func(t *testing.T) {
+		token := keys.sign(t, AlgRS256, "rsa-1", claims(func(c map[string]any) { c["exp"] = now.Add(-30 * time.Second).Unix() }))
+		if _, err := v.Validate(context.Background(), token); err != nil {
+			t.Errorf("got %v, want nil", err)
+		}
+	})
+
+	t.Run("NewValidator: should require an issuer and audience", func(t *testing.T) {
+		if _, err := NewValidator(NewFileSource(path, time.Hour), "", "receipts"); !errors.Is(err, ErrValidatorRequired) {
+			t.Errorf("got %v, want %v", err, ErrValidatorRequired)
+		}
+	})
+}
+
+func TestKeySource(t *testing.T) {
+	old, rotated := newTestKeys(t), newTestKeys(t)
+
+	var fetches atomic.Int32
+	var current atomic.Pointer[[]byte]
+	jwks := old.jwks()
+	current.Store(&jwks)
+	down := atomic.Bool{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if down.Load() {
+			http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		rw.Write(*current.Load())
+	}))
+	defer srv.Close()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	source := NewURLSource(srv.URL, 10*time.Minute, srv.Client())
+	source.now = clock
+
+	v, err := NewValidator(source, "iss", "aud", WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := func() map[string]any {
+		return map[string]any{"sub": "user-1", "iss": "iss", "aud": "aud", "exp": now.Add(time.Hour).Unix()}
+	}
+	validate := func(k testKeys) error {
+		_, err := v.Validate(context.Background(), k.sign(t, AlgES256, "ec-1", claims()))
+		return err
+	}
+
+	t.Run("KeySource: should cache the key set", func(t *testing.T) {
+		for range 3 {
+			if err := validate(old); err != nil {
+				t.Fatalf("got %v, want nil", err)
+			}
+		}
+		if got := fetches.Load(); got != 1 {
+			t.Errorf("got %d fetches, want 1", got)
+		}
+	})
+
+	t.Run("KeySource: should refetch for a rotated key at most once per MinRefresh", func(t *testing.T) {
+		rotatedJWKS := rotated.jwks()
+		current.Store(&rotatedJWKS)
+
+		if err := validate(rotated); !errors.Is(err, ErrSignatureInvalid) {
+			t.Errorf("within MinRefresh: got %v, want %v", err, ErrSignatureInvalid)
+		}
+		if got := fetches.Load(); got != 1 {
+			t.Errorf("within MinRefresh: got %d fetches, want 1", got)
+		}
+
+		// The kid is unchanged, so only the TTL picks up the new key.
+		now = now.Add(10 * time.Minute)
+		if err := validate(rotated); err != nil {
+			t.Errorf("after TTL: got %v, want nil", err)
+		}
+		if got := fetches.Load(); got != 2 {
+			t.Errorf("after TTL: got %d fetches, want 2", got)
+		}
+	})
+
+	t.Run("KeySource: should refetch for an unknown kid", func(t *testing.T) {
+		now = now.Add(MinRefresh)
+		_, err := v.Validate(context.Background(), rotated.sign(t, AlgRS256, "rsa-9", claims()))
+		if !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("got %v, want %v", err, ErrKeyNotFound)
+		}
+		if got := fetches.Load(); got != 3 {
+			t.Errorf("got %d fetches, want 3", got)
+		}
+	})
+
+	t.Run("KeySource: should keep cached keys when the URL fails", func(t *testing.T) {
+		down.Store(true)
+		now = now.Add(time.Hour)
+		if err := validate(rotated); err != nil {
+			t.Errorf("got %v, want nil", err)
+		}
+	})
+
+	t.Run("KeySource: should retry an unavailable key set once per TTL", func(t *testing.T) {
+		s := NewURLSource(srv.URL, 10*time.Minute, srv.Client())
+		s.now = clock
+
+		before := fetches.Load()
+		for range 3 {
+			if _, err := s.key(context.Background(), "ec-1", AlgES256); err == nil {
+				t.Fatal("got nil, want error")
+			}
+		}
+		if got := fetches.Load() - before; got != 1 {
+			t.Errorf("got %d fetches, want 1", got)
+		}
+	})
+
+	t.Run("KeySource: should share a fetch in flight without holding the lock", func(t *testing.T) {
+		var calls atomic.Int32
+		started, release := make(chan struct{}), make(chan struct{})
+		s := &KeySource{
+			fetch: func(context.Context) ([]byte, error) {
+				if calls.Add(1) == 1 {
+					close(started)
+				}
+				<-release
+				return old.jwks(), nil
+			},
+			ttl: 10 * time.Minute,
+			now: clock,
+		}
+		go s.Load(context.Background())
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if _, err := s.key(ctx, "ec-1", AlgES256); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+		}
+
+		done := make(chan error)
+		go func() {
+			_, err := s.key(context.Background(), "ec-1", AlgES256)
+			done <- err
+		}()
+		close(release)
+		if err := <-done; err != nil {
+			t.Errorf("got %v, want nil", err)
+		}
+		if got := calls.Load(); got != 1 {
+			t.Errorf("got %d fetches, want 1", got)
+		}
+	})
+
+	t.Run("KeySource: should finish a shared fetch when its first caller gives up", func(t *testing.T) {
+		started, release := make(chan struct{}), make(chan struct{})
+		s := &KeySource{
+			fetch: func(ctx context.Context) ([]byte, error) {
+				close(started)
+				<-release
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+				return old.jwks(), nil
+			},
+			ttl: 10 * time.Minute,
+			now: clock,
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		first := make(chan error)
+		go func() {
+			_, err := s.key(ctx, "ec-1", AlgES256)
+			first <- err
+		}()
+		<-started
+		cancel()
+		if err := <-first; !errors.Is(err, context.Canceled) {
+			t.Errorf("first caller: got %v, want %v", err, context.Canceled)
+		}
+
+		done := make(chan error)
+		go func() {
+			_, err := s.key(context.Background(), "ec-1", AlgES256)
+			done <- err
+		}()
+		close(release)
+		if err := <-done; err != nil {
+			t.Errorf("waiter: got %v, want nil", err)
+		}
+	})
+
+	t.Run("Load: should return error when the URL fails", func(t *testing.T) {
+		if err := NewURLSource(srv.URL, time.Minute, srv.Client()).Load(context.Background()); err == nil {
+			t.Error("got nil, want error")
+		}
+	})
+}
+
+func TestParseKeySet(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr error
+	}{
+		{name: "ParseKeySet: should return error without keys", json: `{}`, wantErr: ErrKeySetInvalid},
+		{name: "ParseKeySet: should return error for an unsupported key type", json: `{"keys": [{"kty": "OKP", "kid": "a"}]}`, wantErr: ErrKeyTypeUnsupported},
+		{name: "ParseKeySet: should return error for an unsupported curve", json: `{"keys": [{"kty": "EC", "crv": "P-384"}]}`, wantErr: ErrKeyTypeUnsupported},
+		{name: "ParseKeySet: should return error for a point off the curve", json: `{"keys": [{"kty": "EC", "crv": "P-256", "x": "` + b64.EncodeToString(make([]byte, 32)) + `", "y": "` + b64.EncodeToString(make([]byte, 32)) + `"}]}`, wantErr: ErrKeyInvalid},
+		{name: "ParseKeySet: should return error for an RSA key without a modulus", json: `{"keys": [{"kty": "RSA", "e": "AQAB"}]}`, wantErr: ErrKeyInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseKeySet([]byte(tt.json)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+// Package logging builds the server's structured logger. Records logged
+// with a request's context carry its request ID, tenant and trace, and
+// attributes holding personal data can be redacted by name.
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/requestid"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/tenant"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/trace"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Redacted replaces the value of redacted attributes.
+const Redacted = "[REDACTED]"
+
+var ErrFormatInvalid = errors.New("log format must be text or json")
+
+type Options struct {
+	Level  slog.Level
+	Format string
+	// Redact names the attributes whose values are never logged, such as
+	// "retailer". Names match in any group and regardless of case.
+	Redact []string
+}
+
+// New returns a logger writing to w.
+func New(w io.Writer, opts Options) (*slog.Logger, error) {
+	hopts := &slog.HandlerOptions{Level: opts.Level}
+	if len(opts.Redact) > 0 {
+		hopts.ReplaceAttr = Redact(opts.Redact...)
+	}
+
+	var h slog.Handler
+	switch opts.Format {
+	case FormatText, "":
+		h = slog.NewTextHandler(w, hopts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, hopts)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrFormatInvalid, opts.Format)
+	}
+
+	return slog.New(NewContextHandler(h)), nil
+}
+
+// ParseRedact splits a comma-separated list of attribute names.
+func ParseRedact(s string) []string {
+	var keys []string
+	for _, key := range strings.Split(s, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Redact returns a slog.HandlerOptions.ReplaceAttr that hides the values of
+// the attributes named in keys.
+func Redact(keys ...string) func(groups []string, a slog.Attr) slog.Attr {
+	redact := map[string]bool{}
+	for _, key := range keys {
+		redact[strings.ToLower(key)] = true
+	}
+
+	return func(_ []string, a slog.Attr) slog.Attr {
+		if redact[strings.ToLower(a.Key)] {
+			return slog.String(a.Key, Redacted)
+		}
+		return a
+	}
+}
+
+// ContextHandler adds the request ID, tenant and span of the record's
+// context, at the top level even when the logger has groups.
+type ContextHandler struct {
+	handler slog.Handler
+	// base is handler before its first group, and groups replays the groups
+	// and attributes added since onto base.
+	base   slog.Handler
+	groups []func(slog.Handler) slog.Handler
+}
+
+func NewContextHandler(h slog.Handler) ContextHandler {
+	return ContextHandler{handler: h, base: h}
+}
+
+func (h ContextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h ContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	var attrs []slog.Attr
+	if id, ok := requestid.FromContext(ctx); ok {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	if id, ok := tenant.FromContext(ctx); ok {
+		attrs = append(attrs, slog.String("tenant", id))
+	}
+	if sc := trace.FromContext(ctx).Context(); sc.IsValid() {
+		attrs = append(attrs, slog.String("trace_id", sc.TraceID.String()), slog.String("span_id", sc.SpanID.String()))
+	}
+
+	if len(attrs) == 0 {
+		return h.handler.Handle(ctx, r)
+	}
+	if len(h.groups) == 0 {
+		r.AddAttrs(attrs...)
+		return h.handler.Handle(ctx, r)
+	}
+
+	handler := h.base.WithAttrs(attrs)
+	for _, group := range h.groups {
+		handler = group(handler)
+	}
+	return handler.Handle(ctx, r)
+}
+
+func (h ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h.handler = h.handler.WithAttrs(attrs)
+	if len(h.groups) == 0 {
+		h.base = h.handler
+		return h
+	}
+	h.groups = append(slices.Clip(h.groups), func(sh slog.Handler) slog.Handler { return sh.WithAttrs(attrs) })
+	return h
+}
+
+func (h ContextHandler) WithGroup(name string) slog.Handler {
+	h.handler = h.handler.WithGroup(name)
+	h.groups = append(slices.Clip(h.groups), func(sh slog.Handler) slog.Handler { return sh.WithGroup(name) })
+	return h
+}
@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/requestid"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/tenant"
+)
+
+func TestNew(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, Options{Format: "xml"}); !errors.Is(err, ErrFormatInvalid) {
+		t.Fatal("got", err, "want", ErrFormatInvalid)
+	}
+
+	var buf bytes.Buffer
+	log, err := New(&buf, Options{Redact: ParseRedact(" Retailer, ,user_id")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := requestid.NewContext(tenant.NewContext(context.Background(), "acme"), "req-1")
+	log.With("retailer", "Target").WithGroup("receipt").InfoContext(ctx, "Processed receipt", "USER_ID", "u1", "points", 28)
+	line := buf.String()
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "New: should redact attributes named in any case", want: "receipt.USER_ID=" + Redacted},
+		{name: "New: should redact attributes added with With", want: "retailer=" + Redacted},
+		{name: "New: should keep other attributes", want: "receipt.points=28"},
+		{name: "New: should add the request ID outside groups", want: " request_id=req-1"},
+		{name: "New: should add the tenant", want: " tenant=acme"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(line, tt.want) {
+				t.Errorf("got %s, want %s", line, tt.want)
+			}
+		})
+	}
+}
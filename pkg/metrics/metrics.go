@@ -0,0 +1,349 @@
+// Package metrics keeps counters, histograms and gauges and writes them in
+// the Prometheus text exposition format, so the server can be scraped
+// without a client library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are latency buckets in seconds, from 5ms to 10s.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metric is one metric family.
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metric families and writes them sorted by name. It is safe
+// for concurrent use.
+type Registry struct {
+	mu      sync.Mutex
+	names   []string
+	metrics map[string]metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]metric{}}
+}
+
+// register adds a family. Names are fixed by the code, so a duplicate is a
+// programming error and panics.
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.metrics[name]; ok {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.metrics[name] = m
+	r.names = append(r.names, name)
+	slices.Sort(r.names)
+}
+
+// WriteTo writes every family in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := make([]metric, len(r.names))
+	for i, name := range r.names {
+		families[i] = r.metrics[name]
+	}
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range families {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler serves the registry to Prometheus.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", ContentType)
+		r.WriteTo(rw)
+	})
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// family is the name, help and label names shared by a family's series.
+type family struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (f family) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+}
+
+// sample writes one line. extra is appended to the family's labels, for
+// histogram buckets.
+func (f family) sample(w *bufio.Writer, suffix string, values []string, extra string, v float64) {
+	w.WriteString(f.name + suffix)
+
+	if len(values) > 0 || extra != "" {
+		w.WriteByte('{')
+		for i, value := range values {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(f.labels[i] + `="` + escapeLabel(value) + `"`)
+		}
+		if extra != "" {
+			if len(values) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extra)
+		}
+		w.WriteByte('}')
+	}
+
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func (f family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// Counter is a family of counters, one per combination of label values.
+type Counter struct {
+	family
+
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	value  float64
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{family: family{name: name, help: help, typ: "counter", labels: labels}, series: map[string]*counterSeries{}}
+	r.register(name, c)
+	return c
+}
+
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v, which must not be negative, to the counter for values.
+func (c *Counter) Add(v float64, values ...string) {
+	key := c.key(values)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{values: slices.Clone(values)}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.header(w)
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		c.sample(w, "", s.values, "", s.value)
+	}
+}
+
+// Histogram is a family of histograms, one per combination of label values.
+type Histogram struct {
+	family
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64 // Per bucket, not cumulative.
+	count  uint64
+	sum    float64
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		family:  family{name: name, help: help, typ: "histogram", labels: labels},
+		buckets: slices.Sorted(slices.Values(buckets)),
+		series:  map[string]*histogramSeries{},
+	}
+	r.register(name, h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, values ...string) {
+	key := h.key(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: slices.Clone(values), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.header(w)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			h.sample(w, "_bucket", s.values, `le="`+formatFloat(le)+`"`, float64(cumulative))
+		}
+		h.sample(w, "_bucket", s.values, `le="+Inf"`, float64(s.count))
+		h.sample(w, "_sum", s.values, "", s.sum)
+		h.sample(w, "_count", s.values, "", float64(s.count))
+	}
+}
+
+// Sample is one value of a GaugeFunc, with one value per label.
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// GaugeFunc reads its samples when the registry is written, for values kept
+// elsewhere such as the size of a store.
+type GaugeFunc struct {
+	family
+	fn func() []Sample
+}
+
+// NewGaugeFunc registers a gauge read from fn on every scrape. fn must be
+// safe for concurrent use.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() []Sample, labels ...string) *GaugeFunc {
+	g := &GaugeFunc{family: family{name: name, help: help, typ: "gauge", labels: labels}, fn: fn}
+	r.register(name, g)
+	return g
+}
+
+// NewCounterFunc registers a counter kept elsewhere, read from fn on every
+// scrape.
+func (r *Registry) NewCounterFunc(name, help string, fn func() []Sample, labels ...string) *GaugeFunc {
+	g := &GaugeFunc{family: family{name: name, help: help, typ: "counter", labels: labels}, fn: fn}
+	r.register(name, g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	samples := g.fn()
+	slices.SortFunc(samples, func(a, b Sample) int {
+		return slices.Compare(a.Labels, b.Labels)
+	})
+
+	g.header(w)
+	for _, s := range samples {
+		g.key(s.Labels)
+		g.sample(w, "", s.Labels, "", s.Value)
+	}
+}
+
+// RegisterRuntime adds Go runtime gauges: goroutines, heap and GC stats.
+func (r *Registry) RegisterRuntime() {
+	memStats := func(fn func(*runtime.MemStats) float64) func() []Sample {
+		return func() []Sample {
+			var ms runtime.MemStats
+			runtime.ReadMemStats(&ms)
+			return []Sample{{Value: fn(&ms)}}
+		}
+	}
+
+	r.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() []Sample {
+		return []Sample{{Value: float64(runtime.NumGoroutine())}}
+	})
+	r.NewGaugeFunc("go_info", "Information about the Go environment.", func() []Sample {
+		return []Sample{{Labels: []string{runtime.Version()}, Value: 1}}
+	}, "version")
+	r.NewGaugeFunc("go_memstats_alloc_bytes", "Bytes of allocated heap objects.", memStats(func(ms *runtime.MemStats) float64 { return float64(ms.HeapAlloc) }))
+	r.NewGaugeFunc("go_memstats_heap_inuse_bytes", "Bytes in in-use heap spans.", memStats(func(ms *runtime.MemStats) float64 { return float64(ms.HeapInuse) }))
+	r.NewGaugeFunc("go_memstats_sys_bytes", "Bytes of memory obtained from the OS.", memStats(func(ms *runtime.MemStats) float64 { return float64(ms.Sys) }))
+	r.NewCounterFunc("go_gc_cycles_total", "Completed GC cycles.", memStats(func(ms *runtime.MemStats) float64 { return float64(ms.NumGC) }))
+	r.NewCounterFunc("go_gc_pause_seconds_total", "Total GC stop-the-world pause time.", memStats(func(ms *runtime.MemStats) float64 { return float64(ms.PauseTotalNs) / 1e9 }))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+
+	requests := reg.NewCounter("requests_total", "Requests.\nBy route.", "route", "code")
+	requests.Inc("POST /receipts/process", "200")
+	requests.Add(2, "POST /receipts/process", "200")
+	requests.Inc(`GET "quoted"\path`, "404")
+
+	latency := reg.NewHistogram("latency_seconds", "Latency.", []float64{1, 0.1}, "route")
+	latency.Observe(0.05, "a")
+	latency.Observe(0.1, "a")
+	latency.Observe(3, "a")
+
+	reg.NewGaugeFunc("stored", "Stored.", func() []Sample {
+		return []Sample{{Labels: []string{"globex"}, Value: 2}, {Labels: []string{"acme"}, Value: 1.5}}
+	}, "tenant")
+
+	var b strings.Builder
+	if _, err := reg.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="a",le="0.1"} 2
+latency_seconds_bucket{route="a",le="1"} 2
+latency_seconds_bucket{route="a",le="+Inf"} 3
+latency_seconds_sum{route="a"} 3.15
+latency_seconds_count{route="a"} 3
+# HELP requests_total Requests.\nBy route.
+# TYPE requests_total counter
+requests_total{route="GET \"quoted\"\\path",code="404"} 1
+requests_total{route="POST /receipts/process",code="200"} 3
+# HELP stored Stored.
+# TYPE stored gauge
+stored{tenant="acme"} 1.5
+stored{tenant="globex"} 2
+`
+	if got := b.String(); got != want {
+		t.Errorf("WriteTo: got\n%s\nwant\n%s", got, want)
+	}
+
+	t.Run("Handler: should serve the text format", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		reg.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		if got := rec.Header().Get("Content-Type"); got != ContentType {
+			t.Errorf("got %q, want %q", got, ContentType)
+		}
+		if rec.Body.String() != want {
+			t.Errorf("got\n%s\nwant\n%s", rec.Body.String(), want)
+		}
+	})
+
+	t.Run("NewCounter: should panic for a duplicate name", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Error("got no panic, want one")
+			}
+		}()
+		reg.NewCounter("requests_total", "Again.")
+	})
+
+	t.Run("RegisterRuntime: should add Go runtime stats", func(t *testing.T) {
+		reg := NewRegistry()
+		reg.RegisterRuntime()
+		var b strings.Builder
+		reg.WriteTo(&b)
+		for _, name := range []string{"go_goroutines ", "go_info{version=", "go_memstats_alloc_bytes ", "# TYPE go_gc_cycles_total counter"} {
+			if !strings.Contains(b.String(), name) {
+				t.Errorf("got\n%s\nwant %s", b.String(), name)
+			}
+		}
+	})
+}
@@ -8,18 +8,129 @@ import (
 var (
 	ErrInvalidInput = errors.New("The receipt is invalid.")
 	ErrNotFound     = errors.New("No receipt found for that ID.")
+
+	ErrRetailerNotFound = errors.New("No retailer found for that ID.")
+	ErrRuleSetNotFound  = errors.New("No rule set found for that version.")
+	ErrConflict         = errors.New("The request conflicts with the current state.")
+
+	ErrUnauthorized = errors.New("Valid credentials are required.")
+	ErrForbidden    = errors.New("The credentials do not allow this operation.")
+
+	ErrTenantNotFound = errors.New("No tenant found for this request.")
+	ErrQuotaExceeded  = errors.New("The quota for this request has been exceeded.")
+	ErrRateLimited    = errors.New("Too many requests, try again later.")
+
+	ErrBodyTooLarge     = errors.New("The request body is too large.")
+	ErrMediaTypeInvalid = errors.New("The request body must be JSON.")
+)
+
+// LineType says what a receipt line is. Only sale lines are products.
+type LineType string
+
+const (
+	LineSale     LineType = "sale"
+	LineDiscount LineType = "discount"
+	LineTax      LineType = "tax"
+	LineFee      LineType = "fee"
 )
 
+func (t LineType) IsValid() bool {
+	switch t {
+	case LineSale, LineDiscount, LineTax, LineFee:
+		return true
+	}
+	return false
+}
+
 type Item struct {
 	ShortDescription string
-	Price            float64
+	Price            float64 // For the whole line, negative for discounts.
+	Quantity         float64
+	UnitPrice        float64
+	Type             LineType
+	SKU              string // From the product catalog, empty when the item did not match.
+	Category         string
+}
+
+// IsSale reports whether the line is a product sale. Items built without a
+// Type are sales.
+func (i Item) IsSale() bool {
+	return i.Type == "" || i.Type == LineSale
+}
+
+// Units is the number of units sold on the line; a line without a Quantity
+// is one unit.
+func (i Item) Units() float64 {
+	if i.Quantity == 0 {
+		return 1
+	}
+	return i.Quantity
 }
 
 type Receipt struct {
 	Id          string
+	TenantID    string // Empty in deployments without tenants.
+	ClientID    string // Client that submitted the receipt, empty without authentication.
+	UserID      string // User credited by a bearer token, if any.
 	Retailer    string
+	RetailerID  string // Canonical retailer, empty when the name is not in the registry.
 	Items       []Item
-	PurchasedAt time.Time
+	PurchasedAt time.Time // In the store's time zone, so the wall clock matches the receipt.
+	Timezone    string    // IANA name of PurchasedAt's zone, which JSON keeps only as an offset.
 	Total       float64
 	Points      int64
+	Breakdown   []RulePoints // Points each rule awarded, in rule order.
+	RuleVersion string       // Rule set the receipt was scored with.
+
+	Currency     string  // ISO 4217 code the amounts are in.
+	ExchangeRate float64 // Points currency units per unit of Currency.
+	RateVersion  string  // Exchange-rate table ExchangeRate came from.
+}
+
+// RulePoints is the points one rule awarded to a receipt.
+type RulePoints struct {
+	Name   string `json:"name"`
+	Points int64  `json:"points"`
+}
+
+// BaseAmount converts an amount on the receipt to the currency points are
+// calculated in. A receipt without an exchange rate is already in it.
+func (r Receipt) BaseAmount(amount float64) float64 {
+	if r.ExchangeRate == 0 {
+		return amount
+	}
+	return amount * r.ExchangeRate
+}
+
+// ReasonCode says why points were adjusted by hand.
+type ReasonCode string
+
+const (
+	ReasonGoodwill      ReasonCode = "goodwill"
+	ReasonMissingPoints ReasonCode = "missing_points"
+	ReasonBadReceipt    ReasonCode = "bad_receipt"
+	ReasonFraud         ReasonCode = "fraud"
+	ReasonCorrection    ReasonCode = "correction"
+)
+
+func (c ReasonCode) IsValid() bool {
+	switch c {
+	case ReasonGoodwill, ReasonMissingPoints, ReasonBadReceipt, ReasonFraud, ReasonCorrection:
+		return true
+	}
+	return false
+}
+
+// Adjustment is points a support agent granted to or took back from a user.
+// It is applied to the user's ledger, never to a receipt's Points.
+type Adjustment struct {
+	Id        string     `json:"id"`
+	TenantID  string     `json:"tenantId,omitempty"`
+	UserID    string     `json:"userId"`
+	ReceiptID string     `json:"receiptId,omitempty"` // Receipt the adjustment is for, if any.
+	Points    int64      `json:"points"`              // Negative to take points back.
+	Reason    ReasonCode `json:"reason"`
+	AgentID   string     `json:"agentId"`
+	Note      string     `json:"note,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
 }
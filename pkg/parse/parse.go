@@ -0,0 +1,360 @@
+// Package parse extracts receipt fields from plain text, such as the output
+// of on-device OCR. Parsing is heuristic, so every extracted field carries a
+// confidence between 0 and 1.
+package parse
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/currency"
+)
+
+var ErrEmptyText = errors.New("receipt text cannot be empty")
+
+// Field is an extracted value. A field that was not found has an empty
+// Value and a Confidence of 0.
+type Field struct {
+	Value      string  `json:"value"`
+	Confidence float64 `json:"confidence"`
+}
+
+type Item struct {
+	ShortDescription Field  `json:"shortDescription"`
+	Price            Field  `json:"price"`
+	Quantity         Field  `json:"quantity"`
+	UnitPrice        Field  `json:"unitPrice"`
+	Type             string `json:"type"`
+}
+
+type Receipt struct {
+	Retailer     Field  `json:"retailer"`
+	PurchaseDate Field  `json:"purchaseDate"`
+	PurchaseTime Field  `json:"purchaseTime"`
+	Total        Field  `json:"total"`
+	Items        []Item `json:"items"`
+}
+
+// amounts are the expressions that depend on how many decimal places a
+// currency writes amounts with.
+type amounts struct {
+	minorUnits int
+	// line is a line ending in an amount, optionally followed by a one
+	// letter tax flag such as "T" or "N".
+	line *regexp.Regexp
+	// quantity is "3 @ 1.99", alone or before the line amount.
+	quantity *regexp.Regexp
+}
+
+var amountsByMinorUnits = map[int]*amounts{
+	0: newAmounts(0),
+	2: newAmounts(2),
+	3: newAmounts(3),
+}
+
+func newAmounts(minorUnits int) *amounts {
+	// Amounts without decimals may group thousands with commas, like
+	// "1,200" yen. Otherwise the comma is an OCR'd decimal point.
+	digits := `\d{1,3}(?:,\d{3})+|\d{1,7}`
+	if minorUnits > 0 {
+		digits = fmt.Sprintf(`\d{1,6}[.,]\d{%d}`, minorUnits)
+	}
+	amount := `(-?)[$€£¥]?\s?(` + digits + `)(-?)`
+
+	return &amounts{
+		minorUnits: minorUnits,
+		line:       regexp.MustCompile(`^(.*?)\s+` + amount + `(?:\s+[A-Z]{1,2})?$`),
+		quantity:   regexp.MustCompile(`(?i)^(.*?)\s*(\d{1,4}(?:\.\d{1,3})?)\s*(?:@|x|ea\b)\s*[$€£¥]?\s?(` + digits + `)(?:\s*(?:ea|each|/ea))?$`),
+	}
+}
+
+var (
+	reTotal        = regexp.MustCompile(`(?i)^(grand\s+)?total\b`)
+	reAmountDue    = regexp.MustCompile(`(?i)\b(amount|balance)\s+due\b`)
+	reSkip         = regexp.MustCompile(`(?i)\b(sub\s?-?total|change|cash|tender(ed)?|visa|mastercard|amex|debit|credit|card|balance|payment|you saved|items sold|auth)\b`)
+	reTax          = regexp.MustCompile(`(?i)\b(tax|vat|gst|hst)\b`)
+	reFee          = regexp.MustCompile(`(?i)\b(fee|deposit|bottle dep|crv)\b`)
+	reDiscount     = regexp.MustCompile(`(?i)\b(coupon|discount|savings|promo|off|markdown)\b`)
+	reNotRetailer  = regexp.MustCompile(`(?i)(welcome|receipt|thank|tel\b|phone|www\.|\.com|^\d|\d{3}[-.\s]\d{3}[-.\s]\d{4}|\b(st|street|ave|avenue|rd|road|blvd|suite)\b)`)
+	reDescription  = regexp.MustCompile(`[^\w\s\-]+`)
+	reRetailerName = regexp.MustCompile(`[^\w\s\-&]+`)
+	reSpace        = regexp.MustCompile(`\s+`)
+
+	reTimeOfDay = regexp.MustCompile(`\b(\d{1,2}):(\d{2})(?::\d{2})?\s*([AaPp]\.?[Mm]\.?)?`)
+)
+
+var dateLayouts = []struct {
+	re         *regexp.Regexp
+	layout     string
+	confidence float64
+}{
+	{regexp.MustCompile(`\b(\d{4}-\d{2}-\d{2})\b`), "2006-01-02", 0.95},
+	{regexp.MustCompile(`\b(\d{1,2}/\d{1,2}/\d{4})\b`), "1/2/2006", 0.8},
+	{regexp.MustCompile(`\b(\d{1,2}/\d{1,2}/\d{2})\b`), "1/2/06", 0.7},
+	{regexp.MustCompile(`\b(\d{1,2}-\d{1,2}-\d{4})\b`), "1-2-2006", 0.75},
+	{regexp.MustCompile(`(?i)\b([A-Z][a-z]{2}\s+\d{1,2},?\s+\d{4})\b`), "Jan 2 2006", 0.9},
+	{regexp.MustCompile(`(?i)\b(\d{1,2}\s+[A-Z][a-z]{2}\s+\d{4})\b`), "2 Jan 2006", 0.9},
+}
+
+// Text extracts a receipt in currency cur from plain text, reading amounts
+// with the currency's minor units. It only fails for empty input; fields it
+// cannot find are left empty for validation to report.
+func Text(text string, cur currency.Currency) (*Receipt, error) {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		if line = strings.TrimSpace(reSpace.ReplaceAllString(line, " ")); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if len(lines) == 0 {
+		return nil, ErrEmptyText
+	}
+
+	a, ok := amountsByMinorUnits[cur.MinorUnits]
+	if !ok {
+		a = amountsByMinorUnits[2]
+	}
+
+	r := &Receipt{Items: []Item{}}
+	r.Retailer = a.findRetailer(lines)
+	r.PurchaseDate = findDate(lines)
+	r.PurchaseTime = findTime(lines)
+	r.Total = a.findTotal(lines)
+	r.Items = a.findItems(lines)
+
+	return r, nil
+}
+
+func (a *amounts) findRetailer(lines []string) Field {
+	for i, line := range lines {
+		if i >= 5 {
+			break
+		}
+		if reNotRetailer.MatchString(line) || a.line.MatchString(line) {
+			continue
+		}
+
+		name := strings.TrimSpace(reSpace.ReplaceAllString(reRetailerName.ReplaceAllString(line, " "), " "))
+		if !strings.ContainsFunc(name, isLetter) {
+			continue
+		}
+
+		// The first line is usually the store name; later lines and names
+		// that needed cleaning are less certain.
+		confidence := 0.9 - 0.1*float64(i)
+		if name != line {
+			confidence = confidence - 0.1
+		}
+		return Field{Value: name, Confidence: round(confidence)}
+	}
+
+	return Field{}
+}
+
+func isLetter(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+func findDate(lines []string) Field {
+	for _, line := range lines {
+		for _, d := range dateLayouts {
+			m := d.re.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+
+			value := strings.ReplaceAll(m[1], ",", "")
+			value = reSpace.ReplaceAllString(value, " ")
+			t, err := time.Parse(d.layout, normalizeMonth(value))
+			if err != nil {
+				continue
+			}
+			return Field{Value: t.Format(time.DateOnly), Confidence: d.confidence}
+		}
+	}
+
+	return Field{}
+}
+
+// normalizeMonth title-cases month abbreviations such as "JAN" so
+// time.Parse accepts them.
+func normalizeMonth(s string) string {
+	words := strings.Fields(s)
+	for i, w := range words {
+		if len(w) == 3 && strings.ContainsFunc(w, isLetter) {
+			words[i] = strings.ToUpper(w[:1]) + strings.ToLower(w[1:])
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func findTime(lines []string) Field {
+	for _, line := range lines {
+		m := reTimeOfDay.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		hour, _ := strconv.Atoi(m[1])
+		minute, _ := strconv.Atoi(m[2])
+		meridiem := strings.ToLower(strings.ReplaceAll(m[3], ".", ""))
+
+		confidence := 0.85
+		switch meridiem {
+		case "am":
+			if hour == 12 {
+				hour = 0
+			}
+			confidence = 0.9
+		case "pm":
+			if hour != 12 {
+				hour = hour + 12
+			}
+			confidence = 0.9
+		}
+
+		if hour > 23 || minute > 59 {
+			continue
+		}
+		return Field{Value: fmt.Sprintf("%02d:%02d", hour, minute), Confidence: confidence}
+	}
+
+	return Field{}
+}
+
+func (a *amounts) findTotal(lines []string) Field {
+	var due Field
+	var largest float64
+
+	for _, line := range lines {
+		m := a.line.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		value, v := a.parse(m[2], m[3], m[4])
+
+		switch {
+		case reTotal.MatchString(line):
+			return Field{Value: value, Confidence: 0.95}
+		case reAmountDue.MatchString(line) && due.Value == "":
+			due = Field{Value: value, Confidence: 0.85}
+		}
+
+		if v > largest {
+			largest = v
+		}
+	}
+
+	if due.Value != "" {
+		return due
+	}
+
+	// Without a labelled total the largest amount is the best guess.
+	if largest > 0 {
+		return Field{Value: strconv.FormatFloat(largest, 'f', a.minorUnits, 64), Confidence: 0.4}
+	}
+	return Field{}
+}
+
+func (a *amounts) findItems(lines []string) []Item {
+	items := []Item{}
+
+	// A quantity line such as "3 @ 1.99" applies to the item line after it.
+	var pending *Item
+
+	for _, line := range lines {
+		if reTotal.MatchString(line) || reAmountDue.MatchString(line) || reSkip.MatchString(line) {
+			pending = nil
+			continue
+		}
+
+		if m := a.quantity.FindStringSubmatch(line); m != nil && strings.TrimSpace(m[1]) == "" {
+			unitPrice, _ := a.parse("", m[3], "")
+			pending = &Item{
+				Quantity:  Field{Value: m[2], Confidence: 0.85},
+				UnitPrice: Field{Value: unitPrice, Confidence: 0.85},
+			}
+			continue
+		}
+
+		m := a.line.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		desc := m[1]
+		priceValue, _ := a.parse(m[2], m[3], m[4])
+
+		item := Item{Type: "sale"}
+		if pending != nil {
+			item.Quantity, item.UnitPrice = pending.Quantity, pending.UnitPrice
+			pending = nil
+		}
+
+		// "PEPSI 3 @ 1.99 5.97" carries its quantity on the same line.
+		if q := a.quantity.FindStringSubmatch(desc); q != nil && strings.TrimSpace(q[1]) != "" {
+			desc = q[1]
+			unitPrice, _ := a.parse("", q[3], "")
+			item.Quantity = Field{Value: q[2], Confidence: 0.9}
+			item.UnitPrice = Field{Value: unitPrice, Confidence: 0.9}
+		}
+
+		switch {
+		case strings.HasPrefix(priceValue, "-") || reDiscount.MatchString(desc):
+			item.Type = "discount"
+			if !strings.HasPrefix(priceValue, "-") {
+				priceValue = "-" + priceValue
+			}
+		case reTax.MatchString(desc):
+			item.Type = "tax"
+		case reFee.MatchString(desc):
+			item.Type = "fee"
+		}
+
+		cleaned := strings.TrimSpace(reSpace.ReplaceAllString(reDescription.ReplaceAllString(desc, " "), " "))
+		if cleaned == "" || !strings.ContainsFunc(cleaned, isLetter) {
+			continue
+		}
+
+		confidence := 0.9
+		if cleaned != strings.TrimSpace(desc) {
+			confidence = 0.75
+		}
+		item.ShortDescription = Field{Value: cleaned, Confidence: confidence}
+		item.Price = Field{Value: priceValue, Confidence: 0.9}
+
+		// A unit price on a discount line is negative like its total.
+		if item.Type == "discount" && item.UnitPrice.Value != "" && !strings.HasPrefix(item.UnitPrice.Value, "-") {
+			item.UnitPrice.Value = "-" + item.UnitPrice.Value
+		}
+
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// parse normalizes an amount match to "-1.99" form. OCR often puts the
+// minus sign after the number and uses commas as decimal points; amounts
+// without decimals drop their thousands separators instead.
+func (a *amounts) parse(leading, digits, trailing string) (string, float64) {
+	value := strings.ReplaceAll(digits, ",", ".")
+	if a.minorUnits == 0 {
+		value = strings.ReplaceAll(digits, ",", "")
+	}
+	if leading == "-" || trailing == "-" {
+		value = "-" + value
+	}
+
+	v, _ := strconv.ParseFloat(value, 64)
+	return value, v
+}
+
+func round(v float64) float64 {
+	return float64(int(v*100+0.5)) / 100
+}
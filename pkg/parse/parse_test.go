@@ -0,0 +1,213 @@
+package parse
+
+import (
+	"cmp"
+	"errors"
+	"testing"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/currency"
+)
+
+var usd = currency.MustLookup("USD")
+
+const target = `TARGET
+Store #1234
+123 Main St, Springfield
+01/01/2022 01:01 PM
+MOUNTAIN DEW 12PK 6.49 T
+EMILS CHEESE PIZZA $12.25
+KNORR CREAMY CHICKEN 1.26
+DORITOS NACHO CHEESE 3.35
+KLARBRUNN 12-PK 12 FL OZ 12.00
+SUBTOTAL 35.35
+TAX 0.00
+TOTAL $35.35
+VISA 35.35
+CHANGE 0.00`
+
+const corner = `M&M Corner Market
+Mar 20, 2022  14:33
+3 @ 2.25
+GATORADE 6.75
+Gatorade 1 @ 2.25 2.25
+COUPON 1.00-
+BALANCE DUE 8.00`
+
+const ramen = `Ramen Ichiban
+2024-04-01 12:10
+TONKOTSU RAMEN 2 @ 980 1,960
+GYOZA ¥450
+TAX 241
+TOTAL ¥2,651`
+
+const souq = `Souq Stores
+2024-04-01 18:45
+DATES 1KG 2.750
+COFFEE 3,125
+DISCOUNT 0.500-
+TOTAL 5.375`
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		currency string
+		want     Receipt
+	}{
+		{
+			name:  "Text: should parse a receipt with a labelled total",
+			input: target,
+			want: Receipt{
+				Retailer:     Field{Value: "TARGET", Confidence: 0.9},
+				PurchaseDate: Field{Value: "2022-01-01", Confidence: 0.8},
+				PurchaseTime: Field{Value: "13:01", Confidence: 0.9},
+				Total:        Field{Value: "35.35", Confidence: 0.95},
+				Items: []Item{
+					{ShortDescription: Field{"MOUNTAIN DEW 12PK", 0.9}, Price: Field{"6.49", 0.9}, Type: "sale"},
+					{ShortDescription: Field{"EMILS CHEESE PIZZA", 0.9}, Price: Field{"12.25", 0.9}, Type: "sale"},
+					{ShortDescription: Field{"KNORR CREAMY CHICKEN", 0.9}, Price: Field{"1.26", 0.9}, Type: "sale"},
+					{ShortDescription: Field{"DORITOS NACHO CHEESE", 0.9}, Price: Field{"3.35", 0.9}, Type: "sale"},
+					{ShortDescription: Field{"KLARBRUNN 12-PK 12 FL OZ", 0.9}, Price: Field{"12.00", 0.9}, Type: "sale"},
+					{ShortDescription: Field{"TAX", 0.9}, Price: Field{"0.00", 0.9}, Type: "tax"},
+				},
+			},
+		},
+		{
+			name:  "Text: should parse quantities, discounts and an amount due",
+			input: corner,
+			want: Receipt{
+				Retailer:     Field{Value: "M&M Corner Market", Confidence: 0.9},
+				PurchaseDate: Field{Value: "2022-03-20", Confidence: 0.9},
+				PurchaseTime: Field{Value: "14:33", Confidence: 0.85},
+				Total:        Field{Value: "8.00", Confidence: 0.85},
+				Items: []Item{
+					{ShortDescription: Field{"GATORADE", 0.9}, Price: Field{"6.75", 0.9}, Quantity: Field{"3", 0.85}, UnitPrice: Field{"2.25", 0.85}, Type: "sale"},
+					{ShortDescription: Field{"Gatorade", 0.9}, Price: Field{"2.25", 0.9}, Quantity: Field{"1", 0.9}, UnitPrice: Field{"2.25", 0.9}, Type: "sale"},
+					{ShortDescription: Field{"COUPON", 0.9}, Price: Field{"-1.00", 0.9}, Type: "discount"},
+				},
+			},
+		},
+		{
+			name:  "Text: should clean OCR noise with lower confidence",
+			input: "*Corner Shop*\n2022-06-05 9:05 am\nMILK (2%) 3.49\nBREAD 2,99\nFEE BAG 0.10",
+			want: Receipt{
+				Retailer:     Field{Value: "Corner Shop", Confidence: 0.8},
+				PurchaseDate: Field{Value: "2022-06-05", Confidence: 0.95},
+				PurchaseTime: Field{Value: "09:05", Confidence: 0.9},
+				Total:        Field{Value: "3.49", Confidence: 0.4},
+				Items: []Item{
+					{ShortDescription: Field{"MILK 2", 0.75}, Price: Field{"3.49", 0.9}, Type: "sale"},
+					{ShortDescription: Field{"BREAD", 0.9}, Price: Field{"2.99", 0.9}, Type: "sale"},
+					{ShortDescription: Field{"FEE BAG", 0.9}, Price: Field{"0.10", 0.9}, Type: "fee"},
+				},
+			},
+		},
+		{
+			name:     "Text: should parse amounts without decimals in JPY",
+			input:    ramen,
+			currency: "JPY",
+			want: Receipt{
+				Retailer:     Field{Value: "Ramen Ichiban", Confidence: 0.9},
+				PurchaseDate: Field{Value: "2024-04-01", Confidence: 0.95},
+				PurchaseTime: Field{Value: "12:10", Confidence: 0.85},
+				Total:        Field{Value: "2651", Confidence: 0.95},
+				Items: []Item{
+					{ShortDescription: Field{"TONKOTSU RAMEN", 0.9}, Price: Field{"1960", 0.9}, Quantity: Field{"2", 0.9}, UnitPrice: Field{"980", 0.9}, Type: "sale"},
+					{ShortDescription: Field{"GYOZA", 0.9}, Price: Field{"450", 0.9}, Type: "sale"},
+					{ShortDescription: Field{"TAX", 0.9}, Price: Field{"241", 0.9}, Type: "tax"},
+				},
+			},
+		},
+		{
+			name:     "Text: should parse amounts with three decimals in KWD",
+			input:    souq,
+			currency: "KWD",
+			want: Receipt{
+				Retailer:     Field{Value: "Souq Stores", Confidence: 0.9},
+				PurchaseDate: Field{Value: "2024-04-01", Confidence: 0.95},
+				PurchaseTime: Field{Value: "18:45", Confidence: 0.85},
+				Total:        Field{Value: "5.375", Confidence: 0.95},
+				Items: []Item{
+					{ShortDescription: Field{"DATES 1KG", 0.9}, Price: Field{"2.750", 0.9}, Type: "sale"},
+					{ShortDescription: Field{"COFFEE", 0.9}, Price: Field{"3.125", 0.9}, Type: "sale"},
+					{ShortDescription: Field{"DISCOUNT", 0.9}, Price: Field{"-0.500", 0.9}, Type: "discount"},
+				},
+			},
+		},
+		{
+			name:     "Text: should not read two decimal amounts in KWD",
+			input:    "Souq Stores\nCOFFEE 3.12\nTOTAL 3.12",
+			currency: "KWD",
+			want:     Receipt{Retailer: Field{Value: "Souq Stores", Confidence: 0.9}, Items: []Item{}},
+		},
+		{
+			name:  "Text: should leave missing fields empty",
+			input: "thank you for shopping",
+			want:  Receipt{Items: []Item{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Text(tt.input, currency.MustLookup(cmp.Or(tt.currency, currency.Default)))
+			if err != nil {
+				t.Fatalf("got %v, want nil", err)
+			}
+
+			if got.Retailer != tt.want.Retailer {
+				t.Errorf("retailer: got %v, want %v", got.Retailer, tt.want.Retailer)
+			}
+			if got.PurchaseDate != tt.want.PurchaseDate {
+				t.Errorf("purchase date: got %v, want %v", got.PurchaseDate, tt.want.PurchaseDate)
+			}
+			if got.PurchaseTime != tt.want.PurchaseTime {
+				t.Errorf("purchase time: got %v, want %v", got.PurchaseTime, tt.want.PurchaseTime)
+			}
+			if got.Total != tt.want.Total {
+				t.Errorf("total: got %v, want %v", got.Total, tt.want.Total)
+			}
+			if len(got.Items) != len(tt.want.Items) {
+				t.Fatalf("items: got %v, want %v", got.Items, tt.want.Items)
+			}
+			for i := range got.Items {
+				if got.Items[i] != tt.want.Items[i] {
+					t.Errorf("item %d: got %v, want %v", i, got.Items[i], tt.want.Items[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTextDates(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "Text: should parse ISO dates", input: "2022-01-02", want: "2022-01-02"},
+		{name: "Text: should parse US dates", input: "1/2/2022", want: "2022-01-02"},
+		{name: "Text: should parse two digit years", input: "01/02/22", want: "2022-01-02"},
+		{name: "Text: should parse dashed US dates", input: "01-02-2022", want: "2022-01-02"},
+		{name: "Text: should parse upper case month names", input: "JAN 2, 2022", want: "2022-01-02"},
+		{name: "Text: should parse day first month names", input: "2 Jan 2022", want: "2022-01-02"},
+		{name: "Text: should skip impossible dates", input: "13/45/2022", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Text(tt.input, usd)
+			if err != nil {
+				t.Fatalf("got %v, want nil", err)
+			}
+			if got.PurchaseDate.Value != tt.want {
+				t.Errorf("got %q, want %q", got.PurchaseDate.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextEmpty(t *testing.T) {
+	if _, err := Text(" \n\t\n", usd); !errors.Is(err, ErrEmptyText) {
+		t.Errorf("got %v, want %v", err, ErrEmptyText)
+	}
+}
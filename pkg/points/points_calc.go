@@ -1,12 +1,15 @@
 package points
 
 import (
+	"context"
 	"math"
 	"strings"
 	"time"
 	"unicode"
 
+	"github.com/FourSigma/receipt-processor-challenge/pkg/currency"
 	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/trace"
 )
 
 type RuleHandlerFn func(models.Receipt) int64
@@ -21,6 +24,73 @@ func Calculate(r models.Receipt, fns ...RuleHandlerFn) int64 {
 	return points
 }
 
+// Rule names a handler so its points can be reported separately.
+type Rule struct {
+	Name    string
+	Handler RuleHandlerFn
+}
+
+// RuleResult is an alias so receipts can keep the breakdown they were
+// scored with.
+type RuleResult = models.RulePoints
+
+// Total sums a breakdown.
+func Total(results []RuleResult) int64 {
+	var points int64
+
+	for _, result := range results {
+		points = points + result.Points
+	}
+
+	return points
+}
+
+// Hook observes every rule Breakdown runs, with the points it awarded and
+// how long it took, such as to export metrics. It must be safe for
+// concurrent use.
+type Hook func(rule string, points int64, elapsed time.Duration)
+
+type hookKey struct{}
+
+// WithHook returns a context whose BreakdownContext calls report to h.
+func WithHook(ctx context.Context, h Hook) context.Context {
+	return context.WithValue(ctx, hookKey{}, h)
+}
+
+// Breakdown calculates the points each rule awards to the receipt, in rule order.
+func Breakdown(r models.Receipt, rules ...Rule) []RuleResult {
+	return BreakdownContext(context.Background(), r, rules...)
+}
+
+// BreakdownContext is Breakdown with a span per rule when ctx is traced, and
+// each rule reported to the Hook in ctx, if any. Rules are only timed for a
+// hook.
+func BreakdownContext(ctx context.Context, r models.Receipt, rules ...Rule) []RuleResult {
+	results := make([]RuleResult, 0, len(rules))
+	observe, _ := ctx.Value(hookKey{}).(Hook)
+
+	for _, rule := range rules {
+		_, span := trace.Start(ctx, "RuleHandlerFn", trace.String("rule.name", rule.Name))
+		var points int64
+		if observe != nil {
+			start := time.Now()
+			points = Calculate(r, rule.Handler)
+			observe(rule.Name, points, time.Since(start))
+		} else {
+			points = Calculate(r, rule.Handler)
+		}
+		span.SetAttributes(trace.Int64("rule.points", points))
+		span.End()
+
+		results = append(results, RuleResult{
+			Name:   rule.Name,
+			Points: points,
+		})
+	}
+
+	return results
+}
+
 // One point for every alphanumeric character in the retailer name.
 func RuleAlphanumeric(r models.Receipt) int64 {
 	var points int64
@@ -35,35 +105,61 @@ func RuleAlphanumeric(r models.Receipt) int64 {
 	return points
 }
 
-// 50 points if the total is a round dollar amount with no cents
+// 50 points if the total is a round dollar amount with no cents.
+// Other currencies use their own round amount, such as 100 yen.
 func RuleRoundDollar(r models.Receipt) int64 {
-	if r.Total == float64(int64(r.Total)) {
+	cur := receiptCurrency(r)
+	if cur.IsMultiple(r.Total, cur.Round) {
 		return 50
 	}
 	return 0
 }
 
-// 25 points if the total is a multiple of 0.25
+// 25 points if the total is a multiple of 0.25.
+// Other currencies use their own quarter step, such as 25 yen.
 func RuleMultipleOfQuarter(r models.Receipt) int64 {
-	if math.Mod(r.Total*4, 1) == 0 {
+	cur := receiptCurrency(r)
+	if cur.IsMultiple(r.Total, cur.Quarter) {
 		return 25
 	}
 	return 0
 }
 
-// 5 points for every two item on the models.Receipt
+func receiptCurrency(r models.Receipt) currency.Currency {
+	if cur, ok := currency.Lookup(r.Currency); ok {
+		return cur
+	}
+	return currency.MustLookup(currency.Default)
+}
+
+// 5 points for every two item on the models.Receipt.
+// Pairs are counted by sale lines, not units: "3 @ 1.99" is one item, and
+// discount, tax and fee lines are not items.
 func RuleItemPair(r models.Receipt) int64 {
-	return int64(len(r.Items)/2) * 5
+	var lines int64
+
+	for _, item := range r.Items {
+		if item.IsSale() {
+			lines = lines + 1
+		}
+	}
+
+	return lines / 2 * 5
 }
 
 // If the trimmed length of the item description is a multiple of 3, multiply the price by 0.2 and round up to the nearest integer.
 // The result is the number of points earned.
+// Only sale lines count, and the price is the line price rather than the unit price,
+// converted to the points currency.
 func RuleItemDescription(r models.Receipt) int64 {
 	var points int64
 
 	for _, item := range r.Items {
+		if !item.IsSale() {
+			continue
+		}
 		if len(strings.TrimSpace(item.ShortDescription))%3 == 0 {
-			points = points + int64(math.Ceil(item.Price*0.2))
+			points = points + int64(math.Ceil(r.BaseAmount(item.Price)*0.2))
 		}
 	}
 
@@ -1,6 +1,7 @@
 package points
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -68,6 +69,42 @@ func TestRules(t *testing.T) {
 			input:   models.Receipt{Total: 34.00},
 			want:    25,
 		},
+		{
+			handler: RuleRoundDollar,
+			name:    "RuleRoundDollar: should return 0 for yen that are not a multiple of 100",
+			input:   models.Receipt{Total: 1250, Currency: "JPY"},
+			want:    0,
+		},
+		{
+			handler: RuleRoundDollar,
+			name:    "RuleRoundDollar: should return 50 for a round yen amount",
+			input:   models.Receipt{Total: 1200, Currency: "JPY"},
+			want:    50,
+		},
+		{
+			handler: RuleMultipleOfQuarter,
+			name:    "RuleMultipleOfQuarter: should return 25 for a multiple of 25 yen",
+			input:   models.Receipt{Total: 1275, Currency: "JPY"},
+			want:    25,
+		},
+		{
+			handler: RuleMultipleOfQuarter,
+			name:    "RuleMultipleOfQuarter: should return 25 for a quarter dinar",
+			input:   models.Receipt{Total: 3.250, Currency: "KWD"},
+			want:    25,
+		},
+		{
+			handler: RuleItemDescription,
+			name:    "RuleItemDescription: should convert the price to the points currency",
+			input: models.Receipt{
+				Currency:     "JPY",
+				ExchangeRate: 0.0064,
+				Items: []models.Item{
+					{ShortDescription: "tes", Price: 1500}, // 9.60 USD * 0.2 = 1.92
+				},
+			},
+			want: 2,
+		},
 		{
 			handler: RuleItemPair,
 			name:    "RuleItemPair: should return 0",
@@ -101,6 +138,42 @@ func TestRules(t *testing.T) {
 			},
 			want: 5,
 		},
+		{
+			handler: RuleItemPair,
+			name:    "RuleItemPair: should count lines rather than units",
+			input: models.Receipt{
+				Items: []models.Item{
+					{ShortDescription: "test", Price: 30, Quantity: 3, UnitPrice: 10},
+					{ShortDescription: "test", Price: 10},
+				},
+			},
+			want: 5,
+		},
+		{
+			handler: RuleItemPair,
+			name:    "RuleItemPair: should not count discount, tax or fee lines",
+			input: models.Receipt{
+				Items: []models.Item{
+					{ShortDescription: "test", Price: 10, Type: models.LineSale},
+					{ShortDescription: "coupon", Price: -1, Type: models.LineDiscount},
+					{ShortDescription: "tax", Price: 1, Type: models.LineTax},
+					{ShortDescription: "fee", Price: 1, Type: models.LineFee},
+				},
+			},
+			want: 0,
+		},
+		{
+			handler: RuleItemDescription,
+			name:    "RuleItemDescription: should use the line price and skip discount lines",
+			input: models.Receipt{
+				Items: []models.Item{
+					{ShortDescription: "tes", Price: 30, Quantity: 3, UnitPrice: 10, Type: models.LineSale},
+					{ShortDescription: "tes", Price: -10, Type: models.LineDiscount},
+					{ShortDescription: "tax", Price: 10, Type: models.LineTax},
+				},
+			},
+			want: 6,
+		},
 		{
 			handler: RuleItemDescription,
 			name:    "RuleItemDescription: should return 0 because len(test) is not a multiple of 3",
@@ -202,6 +275,102 @@ func TestRules(t *testing.T) {
 	}
 }
 
+func TestRulesUseStoreWallClock(t *testing.T) {
+	losAngeles, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		handler RuleHandlerFn
+		name    string
+		input   models.Receipt
+		want    int64
+	}{
+		{
+			handler: RuleOddDay,
+			name:    "RuleOddDay: should use the local day when UTC has moved to the next day",
+			input: models.Receipt{
+				// 2022-01-01 21:00 in Los Angeles.
+				PurchasedAt: time.Date(2022, 1, 2, 5, 0, 0, 0, time.UTC).In(losAngeles),
+			},
+			want: 6,
+		},
+		{
+			handler: RuleTimeOfPurchase,
+			name:    "RuleTimeOfPurchase: should use the local hour",
+			input: models.Receipt{
+				// 2022-01-01 14:30 in Los Angeles.
+				PurchasedAt: time.Date(2022, 1, 1, 22, 30, 0, 0, time.UTC).In(losAngeles),
+			},
+			want: 10,
+		},
+		{
+			handler: RuleTimeOfPurchase,
+			name:    "RuleTimeOfPurchase: should use the local hour on a daylight saving day",
+			input: models.Receipt{
+				// 2022-03-13 15:00 PDT, the day clocks sprang forward.
+				PurchasedAt: time.Date(2022, 3, 13, 22, 0, 0, 0, time.UTC).In(losAngeles),
+			},
+			want: 10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.handler(tt.input)
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreakdown(t *testing.T) {
+	receipt := models.Receipt{
+		Retailer: "Target",
+		Total:    35.00,
+	}
+
+	got := Breakdown(receipt,
+		Rule{Name: "alphanumeric", Handler: RuleAlphanumeric},
+		Rule{Name: "round-dollar", Handler: RuleRoundDollar},
+		Rule{Name: "item-pair", Handler: RuleItemPair},
+	)
+
+	want := []RuleResult{
+		{Name: "alphanumeric", Points: 6},
+		{Name: "round-dollar", Points: 50},
+		{Name: "item-pair", Points: 0},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %v, want %v", got[i], want[i])
+		}
+	}
+}
+
+func TestBreakdownHook(t *testing.T) {
+	var got []RuleResult
+	ctx := WithHook(context.Background(), func(rule string, points int64, elapsed time.Duration) {
+		got = append(got, RuleResult{Name: rule, Points: points})
+	})
+
+	BreakdownContext(ctx, models.Receipt{Retailer: "Target", Total: 35.00},
+		Rule{Name: "alphanumeric", Handler: RuleAlphanumeric},
+		Rule{Name: "round-dollar", Handler: RuleRoundDollar},
+	)
+
+	want := []RuleResult{{Name: "alphanumeric", Points: 6}, {Name: "round-dollar", Points: 50}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
 func MustParseTime(t *testing.T, s string) time.Time {
 	t.Helper()
 
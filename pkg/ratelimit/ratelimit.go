@@ -0,0 +1,314 @@
+// Package ratelimit limits how often callers may use each route with token
+// buckets, and counts daily quotas. Bucket and counter state live in a Store
+// so that several servers can share it.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLimitInvalid   = errors.New("rate limit must be written as requests/unit, such as 10/s, 600/m or 1000/h")
+	ErrPolicyInvalid  = errors.New("rate limits must be written as route=limit pairs separated by commas")
+	ErrRouteDuplicate = errors.New("rate limit route is listed twice")
+)
+
+// DefaultRoute is the policy entry for routes without their own limit.
+const DefaultRoute = "*"
+
+// Limit allows Requests per Per, in bursts of up to Requests.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+var units = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+}
+
+// ParseLimit parses a limit such as "10/s", "600/m", "1000/h" or "5/d".
+func ParseLimit(s string) (Limit, error) {
+	n, unit, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("%w: %q", ErrLimitInvalid, s)
+	}
+
+	requests, err := strconv.Atoi(n)
+	per, known := units[unit]
+	if err != nil || requests <= 0 || !known {
+		return Limit{}, fmt.Errorf("%w: %q", ErrLimitInvalid, s)
+	}
+
+	return Limit{Requests: requests, Per: per}, nil
+}
+
+func (l Limit) String() string {
+	for unit, per := range units {
+		if per == l.Per {
+			return strconv.Itoa(l.Requests) + "/" + unit
+		}
+	}
+	return strconv.Itoa(l.Requests) + "/" + l.Per.String()
+}
+
+// rate is the number of tokens added to the bucket per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// Policy is the limit of each route, keyed by its mux pattern such as
+// "POST /receipts/process", with DefaultRoute for every other route.
+type Policy map[string]Limit
+
+// ParsePolicy parses comma-separated route=limit pairs, such as
+// "POST /receipts/process=10/m, *=100/m". An empty string is no limits.
+func ParsePolicy(s string) (Policy, error) {
+	p := Policy{}
+	if strings.TrimSpace(s) == "" {
+		return p, nil
+	}
+
+	var err error
+	for _, entry := range strings.Split(s, ",") {
+		route, limit, ok := strings.Cut(entry, "=")
+		route = strings.TrimSpace(route)
+		if !ok || route == "" {
+			err = errors.Join(err, fmt.Errorf("%w: %q", ErrPolicyInvalid, entry))
+			continue
+		}
+		l, lerr := ParseLimit(limit)
+		if lerr != nil {
+			err = errors.Join(err, fmt.Errorf("%s: %w", route, lerr))
+			continue
+		}
+		if _, ok := p[route]; ok {
+			err = errors.Join(err, fmt.Errorf("%w: %q", ErrRouteDuplicate, route))
+			continue
+		}
+		p[route] = l
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p Policy) String() string {
+	routes := make([]string, 0, len(p))
+	for route := range p {
+		routes = append(routes, route)
+	}
+	slices.Sort(routes)
+
+	entries := make([]string, len(routes))
+	for i, route := range routes {
+		entries[i] = route + "=" + p[route].String()
+	}
+	return strings.Join(entries, ",")
+}
+
+// For returns the limit of route, or the default limit.
+func (p Policy) For(route string) (Limit, bool) {
+	if l, ok := p[route]; ok {
+		return l, true
+	}
+	l, ok := p[DefaultRoute]
+	return l, ok
+}
+
+// Result is the state of a bucket after a request took a token from it.
+type Result struct {
+	Allowed   bool
+	Limit     Limit
+	Remaining int
+	// RetryAfter is how long until a token is available, when not Allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Store keeps bucket and counter state. Implementations must be safe for
+// concurrent use, and a Store shared by several servers limits them together.
+type Store interface {
+	// Take removes a token from the bucket at key, which holds up to
+	// l.Requests tokens and refills at l.Requests per l.Per.
+	Take(ctx context.Context, key string, l Limit, now time.Time) (Result, error)
+	// Incr adds one to the counter at key and returns the new count. A
+	// counter whose expiry has passed by now restarts from zero and expires
+	// at expires.
+	Incr(ctx context.Context, key string, now, expires time.Time) (int, error)
+	// Decr takes one from the counter at key, undoing an Incr. A counter
+	// that has expired by now is left alone.
+	Decr(ctx context.Context, key string, now time.Time) error
+}
+
+// NewMemoryStore keeps state in memory, for a single server.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]bucket{}, counters: map[string]counter{}}
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // When the bucket can be dropped.
+}
+
+type counter struct {
+	n       int
+	expires time.Time
+}
+
+type MemoryStore struct {
+	mu       sync.Mutex
+	buckets  map[string]bucket
+	counters map[string]counter
+	pruned   time.Time
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, l Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+
+	capacity, rate := float64(l.Requests), l.rate()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = bucket{tokens: capacity, updated: now}
+	}
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+elapsed*rate)
+		b.updated = now
+	}
+
+	res := Result{Limit: l}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = seconds((capacity - b.tokens) / rate)
+
+	b.full = now.Add(res.Reset)
+	s.buckets[key] = b
+
+	return res, nil
+}
+
+func (s *MemoryStore) Incr(_ context.Context, key string, now, expires time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+
+	c := s.counters[key]
+	if !now.Before(c.expires) {
+		c = counter{expires: expires}
+	}
+	c.n++
+	s.counters[key] = c
+
+	return c.n, nil
+}
+
+func (s *MemoryStore) Decr(_ context.Context, key string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.counters[key]
+	if ok && now.Before(c.expires) && c.n > 0 {
+		c.n--
+		s.counters[key] = c
+	}
+	return nil
+}
+
+// prune drops full buckets and expired counters, which are the same as
+// missing ones, at most once a minute.
+func (s *MemoryStore) prune(now time.Time) {
+	if now.Sub(s.pruned) < time.Minute {
+		return
+	}
+	s.pruned = now
+
+	for key, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+	for key, c := range s.counters {
+		if !now.Before(c.expires) {
+			delete(s.counters, key)
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
+
+type Option func(*Limiter)
+
+// WithStore replaces the in-memory store, for example with one shared by
+// several servers.
+func WithStore(store Store) Option {
+	return func(l *Limiter) {
+		l.store = store
+	}
+}
+
+// WithClock replaces time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(l *Limiter) {
+		l.now = now
+	}
+}
+
+// Limiter applies a Policy, with a bucket per route and caller.
+type Limiter struct {
+	policy Policy
+	store  Store
+	now    func() time.Time
+}
+
+func New(policy Policy, opts ...Option) *Limiter {
+	l := &Limiter{
+		policy: policy,
+		store:  NewMemoryStore(),
+		now:    time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// Allow takes a token from the bucket of caller on route. It reports false
+// when the route has no limit.
+func (l *Limiter) Allow(ctx context.Context, route, caller string) (Result, bool, error) {
+	limit, ok := l.policy.For(route)
+	if !ok {
+		return Result{}, false, nil
+	}
+
+	res, err := l.store.Take(ctx, route+"|"+caller, limit, l.now())
+	if err != nil {
+		return Result{}, true, err
+	}
+	return res, true, nil
+}
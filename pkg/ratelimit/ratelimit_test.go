@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		want    string
+		wantErr error
+	}{
+		{name: "ParsePolicy: should parse nothing as no limits", policy: " ", want: ""},
+		{name: "ParsePolicy: should parse routes and a default", policy: "POST /receipts/process=10/m, *=100/h", want: "*=100/h,POST /receipts/process=10/m"},
+		{name: "ParsePolicy: should return error without a limit", policy: "POST /receipts/process", wantErr: ErrPolicyInvalid},
+		{name: "ParsePolicy: should return error for an unknown unit", policy: "*=10/w", wantErr: ErrLimitInvalid},
+		{name: "ParsePolicy: should return error for a zero limit", policy: "*=0/s", wantErr: ErrLimitInvalid},
+		{name: "ParsePolicy: should return error for a duplicate route", policy: "*=1/s,*=2/s", wantErr: ErrRouteDuplicate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePolicy(tt.policy)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("got %q, want %q", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	policy := Policy{"POST /receipts/process": {Requests: 2, Per: time.Minute}}
+	l := New(policy, WithClock(func() time.Time { return now }))
+	ctx := context.Background()
+
+	take := func(route, caller string) Result {
+		t.Helper()
+		res, limited, err := l.Allow(ctx, route, caller)
+		if err != nil || !limited {
+			t.Fatalf("got %v %v, want a limited route", limited, err)
+		}
+		return res
+	}
+
+	t.Run("Allow: should allow a burst up to the limit", func(t *testing.T) {
+		if res := take("POST /receipts/process", "acme"); !res.Allowed || res.Remaining != 1 {
+			t.Errorf("got %+v, want allowed with 1 remaining", res)
+		}
+		if res := take("POST /receipts/process", "acme"); !res.Allowed || res.Remaining != 0 || res.Reset != time.Minute {
+			t.Errorf("got %+v, want allowed with 0 remaining", res)
+		}
+	})
+
+	t.Run("Allow: should reject an empty bucket until a token refills", func(t *testing.T) {
+		if res := take("POST /receipts/process", "acme"); res.Allowed || res.RetryAfter != 30*time.Second {
+			t.Errorf("got %+v, want rejected for 30s", res)
+		}
+		now = now.Add(30 * time.Second)
+		if res := take("POST /receipts/process", "acme"); !res.Allowed {
+			t.Errorf("got %+v, want allowed", res)
+		}
+	})
+
+	t.Run("Allow: should keep callers apart", func(t *testing.T) {
+		if res := take("POST /receipts/process", "globex"); !res.Allowed {
+			t.Errorf("got %+v, want allowed", res)
+		}
+	})
+
+	t.Run("Allow: should not limit routes without a limit", func(t *testing.T) {
+		if _, limited, _ := l.Allow(ctx, "GET /receipts/{id}/points", "acme"); limited {
+			t.Error("got limited, want unlimited")
+		}
+	})
+
+	t.Run("Allow: should use the default for other routes", func(t *testing.T) {
+		policy[DefaultRoute] = Limit{Requests: 1, Per: time.Hour}
+		take("GET /receipts/{id}/points", "acme")
+		if res := take("GET /receipts/{id}/points", "acme"); res.Allowed || res.RetryAfter != time.Hour {
+			t.Errorf("got %+v, want rejected for an hour", res)
+		}
+	})
+}
+
+func TestMemoryStoreIncr(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 23, 0, 0, 0, time.UTC)
+	midnight := time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)
+
+	for want := 1; want <= 3; want++ {
+		if got, _ := s.Incr(ctx, "acme", now, midnight); got != want {
+			t.Errorf("Incr: got %d, want %d", got, want)
+		}
+	}
+
+	if err := s.Decr(ctx, "acme", now); err != nil {
+		t.Fatalf("Decr: got %v, want nil", err)
+	}
+	if got, _ := s.Incr(ctx, "acme", now, midnight); got != 3 {
+		t.Errorf("Incr: got %d after Decr, want 3", got)
+	}
+
+	if got, _ := s.Incr(ctx, "acme", midnight, midnight.Add(24*time.Hour)); got != 1 {
+		t.Errorf("Incr: got %d after expiry, want 1", got)
+	}
+}
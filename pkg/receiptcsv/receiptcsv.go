@@ -0,0 +1,278 @@
+// Package receiptcsv reads receipts from spreadsheets with one row per item,
+// grouped into receipts by a key column, and writes scored receipts back out.
+package receiptcsv
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/currency"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+)
+
+var (
+	ErrColumnMissing   = errors.New("csv is missing a required column")
+	ErrColumnDuplicate = errors.New("csv maps two fields to the same column")
+	ErrMappingInvalid  = errors.New("column mapping must name the key, retailer, date, time, total, description and price columns")
+)
+
+// Mapping names the header column each field is read from. Names are matched
+// ignoring case and surrounding spaces. Optional fields may be left empty.
+type Mapping struct {
+	Key          string `json:"key"`
+	Retailer     string `json:"retailer"`
+	PurchaseDate string `json:"purchaseDate"`
+	PurchaseTime string `json:"purchaseTime"`
+	Total        string `json:"total"`
+	Timezone     string `json:"timezone,omitempty"`
+	Currency     string `json:"currency,omitempty"`
+
+	ShortDescription string `json:"shortDescription"`
+	Price            string `json:"price"`
+	Quantity         string `json:"quantity,omitempty"`
+	UnitPrice        string `json:"unitPrice,omitempty"`
+	Type             string `json:"type,omitempty"`
+}
+
+// DefaultMapping uses the JSON field names of a receipt request, with the
+// receipt key in a "receipt" column.
+func DefaultMapping() Mapping {
+	return Mapping{
+		Key:              "receipt",
+		Retailer:         "retailer",
+		PurchaseDate:     "purchaseDate",
+		PurchaseTime:     "purchaseTime",
+		Total:            "total",
+		Timezone:         "timezone",
+		Currency:         "currency",
+		ShortDescription: "shortDescription",
+		Price:            "price",
+		Quantity:         "quantity",
+		UnitPrice:        "unitPrice",
+		Type:             "type",
+	}
+}
+
+func (m Mapping) IsValid() error {
+	for _, required := range []string{m.Key, m.Retailer, m.PurchaseDate, m.PurchaseTime, m.Total, m.ShortDescription, m.Price} {
+		if strings.TrimSpace(required) == "" {
+			return ErrMappingInvalid
+		}
+	}
+	return nil
+}
+
+type field struct {
+	column   string
+	required bool
+	value    *string
+}
+
+// fields pairs each mapped column with where its value is stored in r.
+func (m Mapping) fields(r *Row) []field {
+	return []field{
+		{m.Key, true, &r.Key},
+		{m.Retailer, true, &r.Retailer},
+		{m.PurchaseDate, true, &r.PurchaseDate},
+		{m.PurchaseTime, true, &r.PurchaseTime},
+		{m.Total, true, &r.Total},
+		{m.Timezone, false, &r.Timezone},
+		{m.Currency, false, &r.Currency},
+		{m.ShortDescription, true, &r.ShortDescription},
+		{m.Price, true, &r.Price},
+		{m.Quantity, false, &r.Quantity},
+		{m.UnitPrice, false, &r.UnitPrice},
+		{m.Type, false, &r.Type},
+	}
+}
+
+// Row is one item line and the receipt it belongs to.
+type Row struct {
+	// Line is the row's line number in the file, counting the header as 1.
+	Line int
+
+	Key          string
+	Retailer     string
+	PurchaseDate string
+	PurchaseTime string
+	Total        string
+	Timezone     string
+	Currency     string
+
+	ShortDescription string
+	Price            string
+	Quantity         string
+	UnitPrice        string
+	Type             string
+}
+
+// Group is the rows of one receipt, in file order.
+type Group struct {
+	Key  string
+	Rows []Row
+}
+
+// Read reads every row of a CSV file with a header row. Rows are not
+// validated beyond having the mapped columns.
+func Read(r io.Reader, m Mapping) ([]Row, error) {
+	if err := m.IsValid(); err != nil {
+		return nil, err
+	}
+
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading csv header: %w", err)
+	}
+
+	cols := map[string]int{}
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	// indexes holds the column of each field, in fields order, or -1.
+	var indexes []int
+	used := map[int]string{}
+	var missing []string
+	for _, f := range m.fields(&Row{}) {
+		i, ok := cols[strings.ToLower(strings.TrimSpace(f.column))]
+		if f.column == "" || !ok {
+			if f.required {
+				missing = append(missing, f.column)
+			}
+			indexes = append(indexes, -1)
+			continue
+		}
+		if other, ok := used[i]; ok {
+			return nil, fmt.Errorf("%w: %s and %s", ErrColumnDuplicate, other, f.column)
+		}
+		used[i] = f.column
+		indexes = append(indexes, i)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrColumnMissing, strings.Join(missing, ", "))
+	}
+
+	var rows []Row
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading csv: %w", err)
+		}
+
+		// Skip blank spreadsheet rows.
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		line, _ := cr.FieldPos(0)
+		row := Row{Line: line}
+		for j, f := range m.fields(&row) {
+			if i := indexes[j]; i >= 0 && i < len(record) {
+				*f.value = strings.TrimSpace(record[i])
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+// GroupRows groups rows by key in the order each key first appears.
+func GroupRows(rows []Row) []Group {
+	var groups []Group
+	index := map[string]int{}
+
+	for _, row := range rows {
+		i, ok := index[row.Key]
+		if !ok {
+			i = len(groups)
+			index[row.Key] = i
+			groups = append(groups, Group{Key: row.Key})
+		}
+		groups[i].Rows = append(groups[i].Rows, row)
+	}
+
+	return groups
+}
+
+// Header is the export header before one column per rule.
+var Header = []string{
+	"id", "retailer", "retailerId", "purchasedAt", "currency", "total", "items", "points", "ruleVersion",
+}
+
+// Write writes one row per receipt with its points and one column of points
+// per rule. Rule columns are the union of the receipts' breakdowns, in the
+// order rules are first seen; a rule a receipt was not scored with is blank.
+func Write(w io.Writer, receipts []models.Receipt) error {
+	var rules []string
+	seen := map[string]bool{}
+	for _, r := range receipts {
+		for _, b := range r.Breakdown {
+			if !seen[b.Name] {
+				seen[b.Name] = true
+				rules = append(rules, b.Name)
+			}
+		}
+	}
+
+	cw := csv.NewWriter(w)
+
+	header := append([]string{}, Header...)
+	for _, rule := range rules {
+		header = append(header, "rule:"+rule)
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, r := range receipts {
+		record := []string{
+			r.Id,
+			r.Retailer,
+			r.RetailerID,
+			r.PurchasedAt.Format(time.RFC3339),
+			r.Currency,
+			formatAmount(r.Total, r.Currency),
+			strconv.Itoa(len(r.Items)),
+			strconv.FormatInt(r.Points, 10),
+			r.RuleVersion,
+		}
+
+		byRule := map[string]int64{}
+		for _, b := range r.Breakdown {
+			byRule[b.Name] = b.Points
+		}
+		for _, rule := range rules {
+			if p, ok := byRule[rule]; ok {
+				record = append(record, strconv.FormatInt(p, 10))
+			} else {
+				record = append(record, "")
+			}
+		}
+
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatAmount(amount float64, code string) string {
+	cur, ok := currency.Lookup(code)
+	if !ok {
+		cur = currency.MustLookup(currency.Default)
+	}
+	return strconv.FormatFloat(amount, 'f', cur.MinorUnits, 64)
+}
@@ -0,0 +1,127 @@
+package receiptcsv
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+)
+
+func TestRead(t *testing.T) {
+	t.Run("Read: should read rows with the default mapping", func(t *testing.T) {
+		rows, err := Read(strings.NewReader(
+			"receipt,retailer,purchaseDate,purchaseTime,total,shortDescription,price\n"+
+				"a,Target,2022-01-01,13:01,6.49,Pepsi,6.49\n"+
+				",,,,,,\n"+
+				"b,Walgreens,2022-01-02,08:13,1.25, Dasani ,1.25\n"), DefaultMapping())
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+
+		want := []Row{
+			{Line: 2, Key: "a", Retailer: "Target", PurchaseDate: "2022-01-01", PurchaseTime: "13:01", Total: "6.49", ShortDescription: "Pepsi", Price: "6.49"},
+			{Line: 4, Key: "b", Retailer: "Walgreens", PurchaseDate: "2022-01-02", PurchaseTime: "08:13", Total: "1.25", ShortDescription: "Dasani", Price: "1.25"},
+		}
+		if len(rows) != len(want) {
+			t.Fatalf("got %v, want %v", rows, want)
+		}
+		for i := range rows {
+			if rows[i] != want[i] {
+				t.Errorf("got %+v, want %+v", rows[i], want[i])
+			}
+		}
+	})
+
+	t.Run("Read: should use a custom mapping", func(t *testing.T) {
+		mapping := DefaultMapping()
+		mapping.Key = "Order ID"
+		mapping.ShortDescription = "Item"
+		mapping.Quantity = "Qty"
+
+		rows, err := Read(strings.NewReader(
+			"order id,Retailer,PurchaseDate,PurchaseTime,Total,Item,Price,Qty\n"+
+				"42,Target,2022-01-01,13:01,2.50,Pepsi,2.50,2\n"), mapping)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if len(rows) != 1 || rows[0].Key != "42" || rows[0].ShortDescription != "Pepsi" || rows[0].Quantity != "2" {
+			t.Errorf("got %+v, want the mapped row", rows)
+		}
+	})
+
+	tests := []struct {
+		name    string
+		input   string
+		mapping Mapping
+		wantErr error
+	}{
+		{
+			name:    "Read: should return error if a required column is missing",
+			input:   "receipt,retailer,purchaseDate,purchaseTime,total,price\n",
+			mapping: DefaultMapping(),
+			wantErr: ErrColumnMissing,
+		},
+		{
+			name:    "Read: should return error if two fields share a column",
+			input:   "receipt,retailer,purchaseDate,purchaseTime,total,shortDescription,price\n",
+			mapping: Mapping{Key: "receipt", Retailer: "retailer", PurchaseDate: "purchaseDate", PurchaseTime: "purchaseTime", Total: "total", ShortDescription: "shortDescription", Price: "price", UnitPrice: "price"},
+			wantErr: ErrColumnDuplicate,
+		},
+		{
+			name:    "Read: should return error if the mapping is incomplete",
+			input:   "receipt\n",
+			mapping: Mapping{Key: "receipt"},
+			wantErr: ErrMappingInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Read(strings.NewReader(tt.input), tt.mapping); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGroupRows(t *testing.T) {
+	groups := GroupRows([]Row{{Line: 2, Key: "b"}, {Line: 3, Key: "a"}, {Line: 4, Key: "b"}})
+
+	if len(groups) != 2 || groups[0].Key != "b" || groups[1].Key != "a" {
+		t.Fatalf("got %+v, want b then a", groups)
+	}
+	if len(groups[0].Rows) != 2 || groups[0].Rows[1].Line != 4 {
+		t.Errorf("got %+v, want lines 2 and 4", groups[0].Rows)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var b strings.Builder
+	err := Write(&b, []models.Receipt{
+		{
+			Id: "1", Retailer: "Target", RetailerID: "target", Currency: "USD", Total: 9,
+			PurchasedAt: time.Date(2022, 1, 1, 13, 1, 0, 0, time.UTC),
+			Items:       []models.Item{{ShortDescription: "Pepsi"}},
+			Points:      81, RuleVersion: "default",
+			Breakdown: []models.RulePoints{{Name: "alphanumeric", Points: 6}, {Name: "round-dollar", Points: 75}},
+		},
+		{
+			Id: "2", Retailer: "Lawson", Currency: "JPY", Total: 1500,
+			PurchasedAt: time.Date(2022, 1, 2, 8, 13, 0, 0, time.UTC),
+			Points:      6, RuleVersion: "v2",
+			Breakdown: []models.RulePoints{{Name: "alphanumeric", Points: 6}, {Name: "odd-day", Points: 0}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	want := "id,retailer,retailerId,purchasedAt,currency,total,items,points,ruleVersion,rule:alphanumeric,rule:round-dollar,rule:odd-day\n" +
+		"1,Target,target,2022-01-01T13:01:00Z,USD,9.00,1,81,default,6,75,\n" +
+		"2,Lawson,,2022-01-02T08:13:00Z,JPY,1500,0,6,v2,6,,0\n"
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
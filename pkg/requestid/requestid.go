@@ -0,0 +1,36 @@
+// Package requestid identifies requests across logs and responses.
+package requestid
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+// Header carries the request ID in requests and responses.
+const Header = "X-Request-ID"
+
+// reValid limits client-supplied IDs to characters that are safe to log.
+var reValid = regexp.MustCompile(`^[A-Za-z0-9._:\-]{1,128}$`)
+
+// New returns a random request ID.
+func New() string {
+	return uuid.NewString()
+}
+
+// Valid reports whether a client-supplied ID can be used as is.
+func Valid(id string) bool {
+	return reValid.MatchString(id)
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok
+}
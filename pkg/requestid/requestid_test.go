@@ -0,0 +1,25 @@
+package requestid
+
+import "testing"
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		want bool
+	}{
+		{name: "Valid: should accept a UUID", id: New(), want: true},
+		{name: "Valid: should accept trace-style IDs", id: "web-1:abc.42_x", want: true},
+		{name: "Valid: should reject an empty ID", id: "", want: false},
+		{name: "Valid: should reject spaces and newlines", id: "a b\nc", want: false},
+		{name: "Valid: should reject long IDs", id: string(make([]byte, 129)), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Valid(tt.id); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+// Package retailer resolves the retailer names printed on receipts, such as
+// "TARGET " or "Target Store #123", to a canonical retailer.
+package retailer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/jsonl"
+)
+
+var (
+	ErrNotFound        = errors.New("retailer not found")
+	ErrIdEmpty         = errors.New("retailer id cannot be empty")
+	ErrIdInvalid       = errors.New("retailer id must be lowercase letters, digits and dashes")
+	ErrNameEmpty       = errors.New("retailer name cannot be empty")
+	ErrAliasInvalid    = errors.New("retailer alias must be a valid regular expression")
+	ErrTimezoneInvalid = errors.New("retailer timezone must be an IANA time zone name")
+	ErrRegistryWrite   = errors.New("retailer change could not be saved")
+)
+
+var (
+	reId = regexp.MustCompile(`^[a-z0-9][a-z0-9\-]*$`)
+
+	// reStoreSuffix matches store numbers such as "#123", "Store 123" or
+	// "Store No. 123" at the end of a name. "No" and "number" must be words of
+	// their own, so "Casino 5" keeps its name.
+	reStoreSuffix = regexp.MustCompile(`(\s+(store|shop|location|branch|unit))?(\s*#|\s+(no\.?|number))\s*\d+$|\s+(store|shop|location|branch|unit)\s+\d+$`)
+	reSpace       = regexp.MustCompile(`\s+`)
+)
+
+type Retailer struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Aliases are regular expressions matched against the normalized
+	// submitted name, for example "^tgt( |$)".
+	Aliases  []string `json:"aliases,omitempty"`
+	Category string   `json:"category,omitempty"`
+	Timezone string   `json:"timezone,omitempty"`
+	Region   string   `json:"region,omitempty"`
+}
+
+func (r Retailer) IsValid() error {
+	var err error
+
+	if r.ID == "" {
+		err = errors.Join(err, ErrIdEmpty)
+	} else if !reId.MatchString(r.ID) {
+		err = errors.Join(err, ErrIdInvalid)
+	}
+
+	if strings.TrimSpace(r.Name) == "" {
+		err = errors.Join(err, ErrNameEmpty)
+	}
+
+	for _, alias := range r.Aliases {
+		if _, rerr := regexp.Compile(alias); rerr != nil {
+			err = errors.Join(err, fmt.Errorf("%w: %w", ErrAliasInvalid, rerr))
+		}
+	}
+
+	// Local would make scoring depend on the host's zone.
+	if r.Timezone == "Local" {
+		err = errors.Join(err, fmt.Errorf("%w: %q", ErrTimezoneInvalid, r.Timezone))
+	} else if r.Timezone != "" {
+		if _, lerr := time.LoadLocation(r.Timezone); lerr != nil {
+			err = errors.Join(err, fmt.Errorf("%w: %w", ErrTimezoneInvalid, lerr))
+		}
+	}
+
+	return err
+}
+
+// Normalize lowercases name, drops a trailing store number and collapses
+// whitespace, so "  TARGET  Store #123" becomes "target".
+func Normalize(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = reSpace.ReplaceAllString(name, " ")
+	name = reStoreSuffix.ReplaceAllString(name, "")
+	return strings.TrimSpace(name)
+}
+
+type entry struct {
+	retailer Retailer
+	name     string
+	aliases  []*regexp.Regexp
+}
+
+// record is a line of a registry file: a retailer that was put, or the ID
+// of one that was deleted.
+type record struct {
+	Put    *Retailer `json:"put,omitempty"`
+	Delete string    `json:"delete,omitempty"`
+}
+
+// Registry holds the known retailers. It is safe for concurrent use.
+type Registry struct {
+	mu        sync.RWMutex
+	retailers map[string]entry
+	file      *os.File
+}
+
+func NewRegistry() *Registry {
+	return &Registry{retailers: map[string]entry{}}
+}
+
+// OpenRegistry returns a registry holding base's retailers, or none when
+// base is nil, with the changes in a JSON lines file at path replayed over
+// them. Later changes are appended to the file before they take effect, so
+// changes made through Put and Delete outlive the process.
+func OpenRegistry(path string, base *Registry) (*Registry, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	g := NewRegistry()
+	if base != nil {
+		base.mu.RLock()
+		maps.Copy(g.retailers, base.retailers)
+		base.mu.RUnlock()
+	}
+
+	err = jsonl.Replay(f, path, func(line []byte) error {
+		var r record
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		if r.Put == nil {
+			delete(g.retailers, r.Delete)
+			return nil
+		}
+		e, err := newEntry(*r.Put)
+		if err != nil {
+			return err
+		}
+		g.retailers[e.retailer.ID] = e
+		return nil
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	g.file = f
+	return g, nil
+}
+
+// newEntry validates r and compiles its aliases.
+func newEntry(r Retailer) (entry, error) {
+	if err := r.IsValid(); err != nil {
+		return entry{}, err
+	}
+
+	e := entry{retailer: r, name: Normalize(r.Name)}
+	for _, alias := range r.Aliases {
+		e.aliases = append(e.aliases, regexp.MustCompile(alias))
+	}
+	return e, nil
+}
+
+// write appends r to the registry's file, if any. g.mu must be held.
+func (g *Registry) write(r record) error {
+	if g.file == nil {
+		return nil
+	}
+	if err := jsonl.Append(g.file, r); err != nil {
+		return fmt.Errorf("%w: %w", ErrRegistryWrite, err)
+	}
+	return nil
+}
+
+// Close closes the registry's file, if any.
+func (g *Registry) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.file == nil {
+		return nil
+	}
+	return g.file.Close()
+}
+
+// Put adds a retailer or replaces the one with the same ID.
+func (g *Registry) Put(r Retailer) error {
+	e, err := newEntry(r)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.write(record{Put: &r}); err != nil {
+		return err
+	}
+	g.retailers[r.ID] = e
+	return nil
+}
+
+func (g *Registry) Get(id string) (Retailer, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	e, ok := g.retailers[id]
+	if !ok {
+		return Retailer{}, ErrNotFound
+	}
+	return e.retailer, nil
+}
+
+func (g *Registry) Delete(id string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.retailers[id]; !ok {
+		return ErrNotFound
+	}
+	if err := g.write(record{Delete: id}); err != nil {
+		return err
+	}
+	delete(g.retailers, id)
+	return nil
+}
+
+// List returns every retailer ordered by ID.
+func (g *Registry) List() []Retailer {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	list := make([]Retailer, 0, len(g.retailers))
+	for _, e := range g.retailers {
+		list = append(list, e.retailer)
+	}
+
+	slices.SortFunc(list, func(a, b Retailer) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return list
+}
+
+// Resolve finds the retailer for a submitted name. A retailer whose
+// normalized name or ID equals the normalized input wins over alias matches;
+// among several matches of the same kind the lowest ID wins.
+func (g *Registry) Resolve(name string) (Retailer, bool) {
+	normalized := Normalize(name)
+	if normalized == "" {
+		return Retailer{}, false
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var exact, match *Retailer
+	for id, e := range g.retailers {
+		if e.name == normalized || id == normalized {
+			if exact == nil || id < exact.ID {
+				r := e.retailer
+				exact = &r
+			}
+			continue
+		}
+
+		if exact != nil || (match != nil && match.ID < id) {
+			continue
+		}
+		for _, alias := range e.aliases {
+			if alias.MatchString(normalized) {
+				r := e.retailer
+				match = &r
+				break
+			}
+		}
+	}
+
+	if exact != nil {
+		return *exact, true
+	}
+	if match == nil {
+		return Retailer{}, false
+	}
+	return *match, true
+}
+
+// Load decodes a JSON array of retailers into a new registry.
+func Load(r io.Reader) (*Registry, error) {
+	var retailers []Retailer
+	if err := json.NewDecoder(r).Decode(&retailers); err != nil {
+		return nil, fmt.Errorf("error decoding retailers: %w", err)
+	}
+
+	g := NewRegistry()
+	for i, rt := range retailers {
+		if err := g.Put(rt); err != nil {
+			return nil, fmt.Errorf("retailer %d (%q): %w", i, rt.ID, err)
+		}
+	}
+
+	return g, nil
+}
+
+func LoadFile(path string) (*Registry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	g, err := Load(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return g, nil
+}
@@ -0,0 +1,262 @@
+package retailer
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "Normalize: should lowercase and trim",
+			input: "TARGET ",
+			want:  "target",
+		},
+		{
+			name:  "Normalize: should drop a store number",
+			input: "Target Store #123",
+			want:  "target",
+		},
+		{
+			name:  "Normalize: should drop a bare store number",
+			input: "Walmart Supercenter #5260",
+			want:  "walmart supercenter",
+		},
+		{
+			name:  "Normalize: should drop a numbered store suffix",
+			input: "Walgreens  Store 04211",
+			want:  "walgreens",
+		},
+		{
+			name:  "Normalize: should keep digits that are part of the name",
+			input: "7-Eleven No. 34121",
+			want:  "7-eleven",
+		},
+		{
+			name:  "Normalize: should keep a name ending in no",
+			input: "Casino 5",
+			want:  "casino 5",
+		},
+		{
+			name:  "Normalize: should keep a name ending in no before a number",
+			input: "Domino 12",
+			want:  "domino 12",
+		},
+		{
+			name:  "Normalize: should drop a store number after a name ending in no",
+			input: "Casino No. 5",
+			want:  "casino",
+		},
+		{
+			name:  "Normalize: should drop a store number with number",
+			input: "Domino number 12",
+			want:  "domino",
+		},
+		{
+			name:  "Normalize: should collapse whitespace",
+			input: "M&M   Corner\tMarket",
+			want:  "m&m corner market",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Normalize(tt.input); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryResolve(t *testing.T) {
+	registry, err := Load(strings.NewReader(`[
+		{"id": "target", "name": "Target", "aliases": ["^tgt\\b", "^super ?target"], "category": "general", "timezone": "America/Chicago"},
+		{"id": "walmart", "name": "Walmart", "aliases": ["^wal-?mart"], "category": "general"},
+		{"id": "walgreens", "name": "Walgreens", "category": "pharmacy"}
+	]`))
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	tests := []struct {
+		name   string
+		input  string
+		wantId string
+	}{
+		{name: "Resolve: should match the canonical name", input: "Target", wantId: "target"},
+		{name: "Resolve: should match regardless of case and spacing", input: "TARGET ", wantId: "target"},
+		{name: "Resolve: should match with a store number", input: "Target Store #123", wantId: "target"},
+		{name: "Resolve: should match an alias", input: "SuperTarget", wantId: "target"},
+		{name: "Resolve: should match an alias with extra words", input: "Wal-Mart Supercenter #12", wantId: "walmart"},
+		{name: "Resolve: should match the id", input: "walgreens", wantId: "walgreens"},
+		{name: "Resolve: should not match unknown names", input: "Costco", wantId: ""},
+		{name: "Resolve: should not match blank names", input: "   ", wantId: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := registry.Resolve(tt.input)
+			if ok != (tt.wantId != "") || got.ID != tt.wantId {
+				t.Errorf("got %q (%v), want %q", got.ID, ok, tt.wantId)
+			}
+		})
+	}
+}
+
+func TestRegistryResolveTies(t *testing.T) {
+	registry, err := Load(strings.NewReader(`[
+		{"id": "market-b", "name": "Corner Market"},
+		{"id": "market-c", "name": "corner market"},
+		{"id": "market-a", "name": "CORNER  MARKET", "aliases": ["^corner"]},
+		{"id": "corner-z", "name": "Zed", "aliases": ["^corner"]}
+	]`))
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	t.Run("Resolve: should pick the lowest id among equal names", func(t *testing.T) {
+		for range 20 {
+			if got, _ := registry.Resolve("Corner Market #4"); got.ID != "market-a" {
+				t.Fatalf("got %q, want market-a", got.ID)
+			}
+		}
+	})
+
+	t.Run("Resolve: should pick the lowest id among alias matches", func(t *testing.T) {
+		for range 20 {
+			if got, _ := registry.Resolve("Corner Shop"); got.ID != "corner-z" {
+				t.Fatalf("got %q, want corner-z", got.ID)
+			}
+		}
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+
+	t.Run("Put: should validate the retailer", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			input   Retailer
+			wantErr error
+		}{
+			{name: "Put: should return error if id is empty", input: Retailer{Name: "Target"}, wantErr: ErrIdEmpty},
+			{name: "Put: should return error if id is invalid", input: Retailer{ID: "Tar get", Name: "Target"}, wantErr: ErrIdInvalid},
+			{name: "Put: should return error if name is empty", input: Retailer{ID: "target"}, wantErr: ErrNameEmpty},
+			{name: "Put: should return error if an alias is invalid", input: Retailer{ID: "target", Name: "Target", Aliases: []string{"("}}, wantErr: ErrAliasInvalid},
+			{name: "Put: should return error if timezone is unknown", input: Retailer{ID: "target", Name: "Target", Timezone: "Nowhere"}, wantErr: ErrTimezoneInvalid},
+			{name: "Put: should return error if timezone is the host's", input: Retailer{ID: "target", Name: "Target", Timezone: "Local"}, wantErr: ErrTimezoneInvalid},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if err := registry.Put(tt.input); !errors.Is(err, tt.wantErr) {
+					t.Errorf("got %v, want %v", err, tt.wantErr)
+				}
+			})
+		}
+	})
+
+	t.Run("Put: should add and replace retailers", func(t *testing.T) {
+		for _, r := range []Retailer{
+			{ID: "walmart", Name: "Walmart"},
+			{ID: "target", Name: "Target"},
+			{ID: "target", Name: "Target", Region: "us"},
+		} {
+			if err := registry.Put(r); err != nil {
+				t.Fatalf("got %v, want nil", err)
+			}
+		}
+
+		list := registry.List()
+		if len(list) != 2 || list[0].ID != "target" || list[1].ID != "walmart" {
+			t.Errorf("got %v, want target and walmart", list)
+		}
+
+		got, err := registry.Get("target")
+		if err != nil || got.Region != "us" {
+			t.Errorf("got %v %v, want the replaced retailer", got, err)
+		}
+	})
+
+	t.Run("Delete: should remove retailers", func(t *testing.T) {
+		if err := registry.Delete("walmart"); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if err := registry.Delete("walmart"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("got %v, want %v", err, ErrNotFound)
+		}
+		if _, ok := registry.Resolve("Walmart"); ok {
+			t.Errorf("got a match, want none after delete")
+		}
+	})
+}
+
+func TestOpenRegistry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retailers.jsonl")
+	base := NewRegistry()
+	for _, r := range []Retailer{{ID: "target", Name: "Target"}, {ID: "walmart", Name: "Walmart"}} {
+		if err := base.Put(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	registry, err := OpenRegistry(path, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{
+		registry.Put(Retailer{ID: "target", Name: "Target", Region: "us"}),
+		registry.Put(Retailer{ID: "costco", Name: "Costco"}),
+		registry.Delete("walmart"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	registry.Close()
+
+	t.Run("OpenRegistry: should replay changes over the base retailers", func(t *testing.T) {
+		registry, err := OpenRegistry(path, base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer registry.Close()
+
+		var ids []string
+		for _, r := range registry.List() {
+			ids = append(ids, r.ID)
+		}
+		if !slices.Equal(ids, []string{"costco", "target"}) {
+			t.Errorf("got %v, want costco and target", ids)
+		}
+		if got, _ := registry.Get("target"); got.Region != "us" {
+			t.Errorf("got %+v, want the replaced retailer", got)
+		}
+		if _, err := base.Get("walmart"); err != nil {
+			t.Errorf("got %v, want the base left as it was", err)
+		}
+	})
+
+	t.Run("Put: should return error when the change cannot be saved", func(t *testing.T) {
+		registry, err := OpenRegistry(path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		registry.file.Close()
+
+		if err := registry.Put(Retailer{ID: "aldi", Name: "Aldi"}); !errors.Is(err, ErrRegistryWrite) {
+			t.Errorf("got %v, want %v", err, ErrRegistryWrite)
+		}
+		if _, err := registry.Get("aldi"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("got %v, want %v", err, ErrNotFound)
+		}
+	})
+}
@@ -0,0 +1,424 @@
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/jsonl"
+)
+
+var (
+	ErrVersionNotFound = errors.New("rule set version not found")
+	ErrVersionExists   = errors.New("rule set version already exists")
+	ErrRegistryWrite   = errors.New("rule set change could not be saved")
+	ErrChangeInvalid   = errors.New("rule set change is invalid")
+)
+
+// Version is a rule set kept by a Registry, with who created it and when it
+// was, or will be, activated.
+type Version struct {
+	RuleSet     RuleSet    `json:"ruleSet"`
+	CreatedBy   string     `json:"createdBy,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	Active      bool       `json:"active"`
+	ActivatedAt *time.Time `json:"activatedAt,omitempty"`
+	// ScheduledAt is when the version will be activated, if it is pending.
+	ScheduledAt *time.Time `json:"scheduledAt,omitempty"`
+}
+
+// Change is an entry of a Registry's audit trail.
+type Change struct {
+	Time    time.Time `json:"time"`
+	Actor   string    `json:"actor,omitempty"`
+	Action  string    `json:"action"`
+	Version string    `json:"version"`
+	// Previous is the version that was active before an activation or
+	// load.
+	Previous string `json:"previous,omitempty"`
+	// At is when a scheduled activation takes effect.
+	At *time.Time `json:"at,omitempty"`
+}
+
+// Audit trail actions.
+const (
+	ActionCreate   = "create"
+	ActionSchedule = "schedule"
+	ActionActivate = "activate"
+	// ActionLoad is a rule set loaded from the rules file at startup that
+	// differs from the one loaded before, which activates it.
+	ActionLoad = "load"
+)
+
+type RegistryOption func(*Registry)
+
+// WithRegistryClock replaces time.Now.
+func WithRegistryClock(now func() time.Time) RegistryOption {
+	return func(g *Registry) {
+		g.now = now
+	}
+}
+
+type version struct {
+	Version
+	compiled *Compiled
+}
+
+// record is a line of a registry file: a change, with the rule set of a
+// create or load.
+type record struct {
+	Change
+	RuleSet *RuleSet `json:"ruleSet,omitempty"`
+}
+
+// Registry keeps the versions of a rule set and which one is active, so
+// versions can be created and activated while receipts are scored. At most
+// one activation is scheduled at a time; it takes effect on the first call
+// to Active at or after its time. It is safe for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	versions map[string]*version
+	order    []string
+	changes  []Change
+	pending  *Change
+	loaded   string // The version last loaded from the rules file.
+	now      func() time.Time
+	file     *os.File
+
+	active atomic.Pointer[Compiled]
+	// due is the pending activation's time in Unix nanoseconds, or zero, so
+	// Active need not lock.
+	due atomic.Int64
+}
+
+// NewRegistry returns a registry whose only version, active is active.
+func NewRegistry(active *Compiled, opts ...RegistryOption) *Registry {
+	g := &Registry{versions: map[string]*version{}, now: time.Now}
+
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	now := g.now()
+	g.versions[active.Version] = &version{
+		Version:  Version{RuleSet: active.Source, CreatedAt: now, Active: true, ActivatedAt: &now},
+		compiled: active,
+	}
+	g.order = append(g.order, active.Version)
+	g.active.Store(active)
+
+	return g
+}
+
+// OpenRegistry is NewRegistry with the changes in a JSON lines file at path
+// replayed, including a pending scheduled activation. Later changes are
+// appended to the file before they take effect. A version created in the
+// file with the same name as active is skipped, so active stays as loaded.
+//
+// active is the rule set of the rules file. When its version differs from
+// the one loaded at the previous startup, the file was redeployed: it is
+// activated over the replayed changes, cancelling a pending activation, and
+// the load is recorded. Otherwise the replayed active version stands, so
+// activations made while running survive a restart.
+func OpenRegistry(path string, active *Compiled, opts ...RegistryOption) (*Registry, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	g := NewRegistry(active, opts...)
+
+	err = jsonl.Replay(f, path, func(line []byte) error {
+		var r record
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		return g.replay(r)
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	g.file = f
+	if g.loaded != active.Version {
+		g.mu.Lock()
+		err = g.load(active)
+		g.mu.Unlock()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return g, nil
+}
+
+// load records that active was loaded from the rules file and activates it.
+// g.mu must be held.
+func (g *Registry) load(active *Compiled) error {
+	g.promote()
+
+	change := Change{Time: g.now(), Action: ActionLoad, Version: active.Version}
+	if prev := g.active.Load().Version; prev != active.Version {
+		change.Previous = prev
+	}
+	if err := g.write(record{Change: change, RuleSet: &active.Source}); err != nil {
+		return err
+	}
+
+	g.loaded = active.Version
+	g.activate(change)
+	return nil
+}
+
+// replay applies a change read from the registry's file, after the scheduled
+// activation it was made after, if any.
+func (g *Registry) replay(r record) error {
+	g.promoteAt(r.Time)
+
+	switch r.Action {
+	case ActionCreate:
+		if r.RuleSet == nil || r.RuleSet.Version != r.Version {
+			return fmt.Errorf("%w: create of %q without its rule set", ErrChangeInvalid, r.Version)
+		}
+		if _, ok := g.versions[r.Version]; ok {
+			return nil
+		}
+		compiled, err := r.RuleSet.Compile()
+		if err != nil {
+			return err
+		}
+		g.create(r.Change, *r.RuleSet, compiled)
+	case ActionLoad:
+		// A rules file loaded before may no longer be the one loaded now,
+		// so the load keeps its rule set.
+		if r.RuleSet == nil || r.RuleSet.Version != r.Version {
+			return fmt.Errorf("%w: load of %q without its rule set", ErrChangeInvalid, r.Version)
+		}
+		if _, ok := g.versions[r.Version]; !ok {
+			compiled, err := r.RuleSet.Compile()
+			if err != nil {
+				return err
+			}
+			g.add(*r.RuleSet, compiled, "", r.Time)
+		}
+		g.loaded = r.Version
+		g.activate(r.Change)
+	case ActionSchedule, ActionActivate:
+		if _, ok := g.versions[r.Version]; !ok {
+			return fmt.Errorf("%w: %q", ErrVersionNotFound, r.Version)
+		}
+		if r.Action == ActionSchedule && r.At == nil {
+			return fmt.Errorf("%w: schedule of %q without a time", ErrChangeInvalid, r.Version)
+		}
+		if r.Action == ActionSchedule {
+			g.schedule(r.Change)
+		} else {
+			g.activate(r.Change)
+		}
+	default:
+		return fmt.Errorf("%w: unknown action %q", ErrChangeInvalid, r.Action)
+	}
+
+	return nil
+}
+
+// write appends r to the registry's file, if any. g.mu must be held.
+func (g *Registry) write(r record) error {
+	if g.file == nil {
+		return nil
+	}
+
+	if err := jsonl.Append(g.file, r); err != nil {
+		return fmt.Errorf("%w: %w", ErrRegistryWrite, err)
+	}
+	return nil
+}
+
+// Close closes the registry's file, if any.
+func (g *Registry) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.file == nil {
+		return nil
+	}
+	return g.file.Close()
+}
+
+// Active returns the active rule set, first applying a scheduled activation
+// that is due.
+func (g *Registry) Active() *Compiled {
+	if due := g.due.Load(); due != 0 && g.now().UnixNano() >= due {
+		g.mu.Lock()
+		g.promote()
+		g.mu.Unlock()
+	}
+	return g.active.Load()
+}
+
+// promote applies the pending activation if it is due. g.mu must be held.
+// The activation is not written to the registry's file, as replaying the
+// schedule applies it again.
+func (g *Registry) promote() {
+	g.promoteAt(g.now())
+}
+
+// promoteAt applies the pending activation if it is due by now. g.mu must be
+// held.
+func (g *Registry) promoteAt(now time.Time) {
+	if g.pending == nil || now.Before(*g.pending.At) {
+		return
+	}
+	change := *g.pending
+	change.Time = *change.At
+	change.Action = ActionActivate
+	change.At = nil
+	g.activate(change)
+}
+
+// activate makes change.Version active and records change. A load carries
+// its own Previous. g.mu must be held.
+func (g *Registry) activate(change Change) {
+	if change.Action != ActionLoad {
+		change.Previous = g.active.Load().Version
+	}
+
+	for _, v := range g.versions {
+		v.Active = false
+		v.ScheduledAt = nil
+	}
+	v := g.versions[change.Version]
+	v.Active = true
+	v.ActivatedAt = &change.Time
+
+	g.active.Store(v.compiled)
+	g.pending = nil
+	g.due.Store(0)
+	g.changes = append(g.changes, change)
+}
+
+// List returns every version in the order they were created.
+func (g *Registry) List() []Version {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.promote()
+
+	list := make([]Version, 0, len(g.order))
+	for _, name := range g.order {
+		list = append(list, g.versions[name].Version)
+	}
+	return list
+}
+
+func (g *Registry) Get(name string) (Version, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.promote()
+
+	v, ok := g.versions[name]
+	if !ok {
+		return Version{}, fmt.Errorf("%w: %q", ErrVersionNotFound, name)
+	}
+	return v.Version, nil
+}
+
+// Create compiles rs and adds it as an inactive version. Versions cannot be
+// replaced, so a version always names the same rules.
+func (g *Registry) Create(rs RuleSet, actor string) (Version, error) {
+	compiled, err := rs.Compile()
+	if err != nil {
+		return Version{}, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.versions[rs.Version]; ok {
+		return Version{}, fmt.Errorf("%w: %q", ErrVersionExists, rs.Version)
+	}
+
+	change := Change{Time: g.now(), Actor: actor, Action: ActionCreate, Version: rs.Version}
+	if err := g.write(record{Change: change, RuleSet: &rs}); err != nil {
+		return Version{}, err
+	}
+	g.create(change, rs, compiled)
+
+	return g.versions[rs.Version].Version, nil
+}
+
+// create adds rs as an inactive version and records change. g.mu must be
+// held.
+func (g *Registry) create(change Change, rs RuleSet, compiled *Compiled) {
+	g.add(rs, compiled, change.Actor, change.Time)
+	g.changes = append(g.changes, change)
+}
+
+// add adds rs as an inactive version. g.mu must be held.
+func (g *Registry) add(rs RuleSet, compiled *Compiled, createdBy string, createdAt time.Time) {
+	g.versions[rs.Version] = &version{
+		Version:  Version{RuleSet: rs, CreatedBy: createdBy, CreatedAt: createdAt},
+		compiled: compiled,
+	}
+	g.order = append(g.order, rs.Version)
+}
+
+// Activate makes the version active at once when at is zero or not in the
+// future, and otherwise schedules it for at, replacing any activation
+// already scheduled. Activating at once cancels a scheduled activation. The
+// switch is atomic: a receipt is scored entirely by one version.
+func (g *Registry) Activate(name string, at time.Time, actor string) (Version, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.promote()
+
+	v, ok := g.versions[name]
+	if !ok {
+		return Version{}, fmt.Errorf("%w: %q", ErrVersionNotFound, name)
+	}
+
+	now := g.now()
+	if at.IsZero() || !at.After(now) {
+		change := Change{Time: now, Actor: actor, Action: ActionActivate, Version: name}
+		if err := g.write(record{Change: change}); err != nil {
+			return Version{}, err
+		}
+		g.activate(change)
+		return v.Version, nil
+	}
+
+	change := Change{Time: now, Actor: actor, Action: ActionSchedule, Version: name, At: &at}
+	if err := g.write(record{Change: change}); err != nil {
+		return Version{}, err
+	}
+	g.schedule(change)
+
+	return v.Version, nil
+}
+
+// schedule makes change.Version the pending activation at change.At,
+// replacing any other, and records change. g.mu must be held.
+func (g *Registry) schedule(change Change) {
+	if g.pending != nil {
+		g.versions[g.pending.Version].ScheduledAt = nil
+	}
+	g.pending = &Change{Actor: change.Actor, Version: change.Version, At: change.At}
+	g.due.Store(change.At.UnixNano())
+	g.versions[change.Version].ScheduledAt = change.At
+	g.changes = append(g.changes, change)
+}
+
+// Changes returns the audit trail, oldest first.
+func (g *Registry) Changes() []Change {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.promote()
+
+	return slices.Clone(g.changes)
+}
@@ -0,0 +1,287 @@
+// Package rules describes versioned sets of scoring rules as data. A rule is
+// either one of the built-in handlers from package points or an expression
+// compiled by package expr.
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/expr"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/points"
+)
+
+// Builtins maps the names usable in Definition.Builtin to their handlers.
+var Builtins = map[string]points.RuleHandlerFn{
+	"alphanumeric":        points.RuleAlphanumeric,
+	"round-dollar":        points.RuleRoundDollar,
+	"multiple-of-quarter": points.RuleMultipleOfQuarter,
+	"item-pair":           points.RuleItemPair,
+	"item-description":    points.RuleItemDescription,
+	"odd-day":             points.RuleOddDay,
+	"time-of-purchase":    points.RuleTimeOfPurchase,
+}
+
+var (
+	ErrVersionEmpty   = errors.New("rule set version cannot be empty")
+	ErrRulesEmpty     = errors.New("rule set must have at least one rule")
+	ErrNameEmpty      = errors.New("rule name cannot be empty")
+	ErrNameDuplicate  = errors.New("rule names must be unique")
+	ErrKindInvalid    = errors.New("rule must set exactly one of builtin or expr")
+	ErrBuiltinUnknown = errors.New("unknown builtin rule")
+	ErrExprInvalid    = errors.New("rule expression is invalid")
+
+	ErrCampaignNameEmpty     = errors.New("campaign name cannot be empty")
+	ErrCampaignNameDuplicate = errors.New("campaign names must be unique")
+	ErrCampaignWindowInvalid = errors.New("campaign must end after it starts")
+	ErrCampaignRulesEmpty    = errors.New("campaign must have at least one rule")
+)
+
+// Definition is a single rule as it appears in a rule set file.
+type Definition struct {
+	Name    string `json:"name"`
+	Builtin string `json:"builtin,omitempty"`
+	Expr    string `json:"expr,omitempty"`
+}
+
+// RuleSet is a named version of the rules used to score receipts.
+type RuleSet struct {
+	Version   string       `json:"version"`
+	Rules     []Definition `json:"rules"`
+	Campaigns []Campaign   `json:"campaigns,omitempty"`
+}
+
+// Campaign is a promotion: extra rules for receipts purchased from Start up
+// to End. Its rules are reported as "campaign/rule" in breakdowns.
+type Campaign struct {
+	Name  string       `json:"name"`
+	Start time.Time    `json:"start"`
+	End   time.Time    `json:"end"`
+	Rules []Definition `json:"rules"`
+}
+
+// Default is the rule set described in the README.
+func Default() RuleSet {
+	return RuleSet{
+		Version: "default",
+		Rules: []Definition{
+			{Name: "alphanumeric", Builtin: "alphanumeric"},
+			{Name: "round-dollar", Builtin: "round-dollar"},
+			{Name: "multiple-of-quarter", Builtin: "multiple-of-quarter"},
+			{Name: "item-pair", Builtin: "item-pair"},
+			{Name: "item-description", Builtin: "item-description"},
+			{Name: "odd-day", Builtin: "odd-day"},
+			{Name: "time-of-purchase", Builtin: "time-of-purchase"},
+		},
+	}
+}
+
+// Load decodes a JSON rule set. The result has not been compiled yet.
+func Load(r io.Reader) (RuleSet, error) {
+	var rs RuleSet
+	if err := json.NewDecoder(r).Decode(&rs); err != nil {
+		return RuleSet{}, fmt.Errorf("error decoding rule set: %w", err)
+	}
+	return rs, nil
+}
+
+// LoadFile loads and compiles the rule set stored at path.
+func LoadFile(path string) (*Compiled, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rs, err := Load(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	c, err := rs.Compile()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return c, nil
+}
+
+// Compile validates the rule set and compiles its expressions. Expression
+// errors wrap *expr.Error so callers can report their line and column.
+func (rs RuleSet) Compile() (*Compiled, error) {
+	var err error
+
+	if rs.Version == "" {
+		err = errors.Join(err, ErrVersionEmpty)
+	}
+
+	if len(rs.Rules) == 0 {
+		err = errors.Join(err, ErrRulesEmpty)
+	}
+
+	c := &Compiled{Version: rs.Version, Source: rs}
+	seen := map[string]bool{}
+
+	for i, def := range rs.Rules {
+		if def.Name == "" {
+			err = errors.Join(err, fmt.Errorf("rule %d: %w", i, ErrNameEmpty))
+			continue
+		}
+
+		if seen[def.Name] {
+			err = errors.Join(err, fmt.Errorf("rule %q: %w", def.Name, ErrNameDuplicate))
+		}
+		seen[def.Name] = true
+
+		handler, herr := def.handler()
+		if herr != nil {
+			err = errors.Join(err, fmt.Errorf("rule %q: %w", def.Name, herr))
+			continue
+		}
+
+		c.Rules = append(c.Rules, points.Rule{Name: def.Name, Handler: handler})
+	}
+
+	campaigns := map[string]bool{}
+	for i, campaign := range rs.Campaigns {
+		compiled, cerr := campaign.compile()
+		if campaign.Name == "" {
+			cerr = errors.Join(cerr, ErrCampaignNameEmpty)
+		} else if campaigns[campaign.Name] {
+			cerr = errors.Join(cerr, ErrCampaignNameDuplicate)
+		}
+		campaigns[campaign.Name] = true
+
+		if cerr != nil {
+			err = errors.Join(err, fmt.Errorf("campaign %d %q: %w", i, campaign.Name, cerr))
+			continue
+		}
+		c.Campaigns = append(c.Campaigns, compiled)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (campaign Campaign) compile() (CompiledCampaign, error) {
+	var err error
+
+	if !campaign.End.After(campaign.Start) {
+		err = errors.Join(err, ErrCampaignWindowInvalid)
+	}
+
+	if len(campaign.Rules) == 0 {
+		err = errors.Join(err, ErrCampaignRulesEmpty)
+	}
+
+	c := CompiledCampaign{Name: campaign.Name, Start: campaign.Start, End: campaign.End}
+	seen := map[string]bool{}
+	for _, def := range campaign.Rules {
+		if def.Name == "" {
+			err = errors.Join(err, ErrNameEmpty)
+			continue
+		}
+		if seen[def.Name] {
+			err = errors.Join(err, fmt.Errorf("rule %q: %w", def.Name, ErrNameDuplicate))
+		}
+		seen[def.Name] = true
+
+		handler, herr := def.handler()
+		if herr != nil {
+			err = errors.Join(err, fmt.Errorf("rule %q: %w", def.Name, herr))
+			continue
+		}
+		c.Rules = append(c.Rules, points.Rule{Name: campaign.Name + "/" + def.Name, Handler: handler})
+	}
+
+	return c, err
+}
+
+// MustCompile is like Compile but panics if the rule set is invalid.
+func MustCompile(rs RuleSet) *Compiled {
+	c, err := rs.Compile()
+	if err != nil {
+		panic(fmt.Sprintf("rules: Compile(%q): %v", rs.Version, err))
+	}
+	return c
+}
+
+func (def Definition) handler() (points.RuleHandlerFn, error) {
+	switch {
+	case def.Builtin != "" && def.Expr == "":
+		fn, ok := Builtins[def.Builtin]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrBuiltinUnknown, def.Builtin)
+		}
+		return fn, nil
+
+	case def.Expr != "" && def.Builtin == "":
+		p, err := expr.Compile(def.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrExprInvalid, err)
+		}
+		return p.Rule(), nil
+	}
+
+	return nil, ErrKindInvalid
+}
+
+// Compiled is a validated rule set ready to score receipts.
+type Compiled struct {
+	Version   string
+	Rules     []points.Rule
+	Campaigns []CompiledCampaign
+	// Source is the rule set it was compiled from.
+	Source RuleSet
+}
+
+type CompiledCampaign struct {
+	Name       string
+	Start, End time.Time
+	Rules      []points.Rule
+}
+
+// Active reports whether a purchase at t falls in the campaign.
+func (c CompiledCampaign) Active(t time.Time) bool {
+	return !t.Before(c.Start) && t.Before(c.End)
+}
+
+// Handlers returns the rule handlers in order, for use with points.Calculate.
+func (c *Compiled) Handlers() []points.RuleHandlerFn {
+	fns := make([]points.RuleHandlerFn, 0, len(c.Rules))
+	for _, rule := range c.Rules {
+		fns = append(fns, rule.Handler)
+	}
+	return fns
+}
+
+// Calculate is the total of Breakdown.
+func (c *Compiled) Calculate(r models.Receipt) int64 {
+	return points.Total(c.Breakdown(r))
+}
+
+// Breakdown scores the receipt with the rules, followed by the rules of the
+// campaigns active when it was purchased.
+func (c *Compiled) Breakdown(r models.Receipt) []points.RuleResult {
+	return c.BreakdownContext(context.Background(), r)
+}
+
+// BreakdownContext is Breakdown with a span per rule when ctx is traced.
+func (c *Compiled) BreakdownContext(ctx context.Context, r models.Receipt) []points.RuleResult {
+	results := points.BreakdownContext(ctx, r, c.Rules...)
+	for _, campaign := range c.Campaigns {
+		if campaign.Active(r.PurchasedAt) {
+			results = append(results, points.BreakdownContext(ctx, r, campaign.Rules...)...)
+		}
+	}
+	return results
+}
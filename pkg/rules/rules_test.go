@@ -0,0 +1,421 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/expr"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+)
+
+func TestDefault(t *testing.T) {
+	c := MustCompile(Default())
+
+	receipt := models.Receipt{
+		Retailer:    "M&M Corner Market",
+		Total:       9.00,
+		PurchasedAt: time.Date(2022, 3, 20, 14, 33, 0, 0, time.UTC),
+		Items: []models.Item{
+			{ShortDescription: "Gatorade", Price: 2.25},
+			{ShortDescription: "Gatorade", Price: 2.25},
+			{ShortDescription: "Gatorade", Price: 2.25},
+			{ShortDescription: "Gatorade", Price: 2.25},
+		},
+	}
+
+	if got := c.Calculate(receipt); got != 109 {
+		t.Errorf("got %v, want 109", got)
+	}
+
+	var sum int64
+	for _, result := range c.Breakdown(receipt) {
+		sum = sum + result.Points
+	}
+	if sum != 109 {
+		t.Errorf("breakdown sums to %v, want 109", sum)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	src := `{
+		"version": "2024-06",
+		"rules": [
+			{"name": "alphanumeric", "builtin": "alphanumeric"},
+			{"name": "big-basket", "expr": "items.count >= 10 ? 15 : 0"}
+		]
+	}`
+
+	rs, err := Load(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	c, err := rs.Compile()
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	if c.Version != "2024-06" || len(c.Rules) != 2 {
+		t.Errorf("got %+v, want version 2024-06 with 2 rules", c)
+	}
+
+	if got := c.Calculate(models.Receipt{Retailer: "Target", Items: make([]models.Item, 10)}); got != 21 {
+		t.Errorf("got %v, want 21", got)
+	}
+}
+
+func TestCampaigns(t *testing.T) {
+	src := `{
+		"version": "2024-06",
+		"rules": [{"name": "alphanumeric", "builtin": "alphanumeric"}],
+		"campaigns": [{
+			"name": "summer",
+			"start": "2024-06-01T00:00:00Z",
+			"end": "2024-09-01T00:00:00Z",
+			"rules": [{"name": "bonus", "expr": "50"}]
+		}]
+	}`
+
+	rs, err := Load(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	c, err := rs.Compile()
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	tests := []struct {
+		name      string
+		purchased time.Time
+		want      int64
+	}{
+		{name: "Breakdown: should add campaign rules from the start", purchased: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), want: 56},
+		{name: "Breakdown: should add campaign rules in the window", purchased: time.Date(2024, 7, 4, 12, 0, 0, 0, time.UTC), want: 56},
+		{name: "Breakdown: should not add campaign rules before the start", purchased: time.Date(2024, 5, 31, 23, 59, 0, 0, time.UTC), want: 6},
+		{name: "Breakdown: should not add campaign rules from the end", purchased: time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC), want: 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := models.Receipt{Retailer: "Target", PurchasedAt: tt.purchased}
+			if got := c.Calculate(r); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("Breakdown: should name campaign rules after the campaign", func(t *testing.T) {
+		results := c.Breakdown(models.Receipt{Retailer: "Target", PurchasedAt: time.Date(2024, 7, 4, 0, 0, 0, 0, time.UTC)})
+		if len(results) != 2 || results[1].Name != "summer/bonus" {
+			t.Errorf("got %+v, want alphanumeric and summer/bonus", results)
+		}
+	})
+}
+
+func TestCompileErrors(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	bonus := []Definition{{Name: "bonus", Expr: "50"}}
+	base := []Definition{{Name: "a", Builtin: "alphanumeric"}}
+
+	tests := []struct {
+		name    string
+		input   RuleSet
+		wantErr error
+	}{
+		{
+			name:    "Compile: should return error if version is empty",
+			input:   RuleSet{Rules: []Definition{{Name: "a", Builtin: "alphanumeric"}}},
+			wantErr: ErrVersionEmpty,
+		},
+		{
+			name:    "Compile: should return error if there are no rules",
+			input:   RuleSet{Version: "v1"},
+			wantErr: ErrRulesEmpty,
+		},
+		{
+			name:    "Compile: should return error if a rule has no name",
+			input:   RuleSet{Version: "v1", Rules: []Definition{{Builtin: "alphanumeric"}}},
+			wantErr: ErrNameEmpty,
+		},
+		{
+			name: "Compile: should return error if rule names repeat",
+			input: RuleSet{Version: "v1", Rules: []Definition{
+				{Name: "a", Builtin: "alphanumeric"},
+				{Name: "a", Builtin: "odd-day"},
+			}},
+			wantErr: ErrNameDuplicate,
+		},
+		{
+			name:    "Compile: should return error if a rule sets both builtin and expr",
+			input:   RuleSet{Version: "v1", Rules: []Definition{{Name: "a", Builtin: "odd-day", Expr: "1"}}},
+			wantErr: ErrKindInvalid,
+		},
+		{
+			name:    "Compile: should return error if the builtin is unknown",
+			input:   RuleSet{Version: "v1", Rules: []Definition{{Name: "a", Builtin: "bonus"}}},
+			wantErr: ErrBuiltinUnknown,
+		},
+		{
+			name:    "Compile: should return error if the expression is invalid",
+			input:   RuleSet{Version: "v1", Rules: []Definition{{Name: "a", Expr: "total >"}}},
+			wantErr: ErrExprInvalid,
+		},
+		{
+			name:    "Compile: should return error if a campaign has no name",
+			input:   RuleSet{Version: "v1", Rules: base, Campaigns: []Campaign{{Start: start, End: start.AddDate(0, 1, 0), Rules: bonus}}},
+			wantErr: ErrCampaignNameEmpty,
+		},
+		{
+			name: "Compile: should return error if campaign names repeat",
+			input: RuleSet{Version: "v1", Rules: base, Campaigns: []Campaign{
+				{Name: "summer", Start: start, End: start.AddDate(0, 1, 0), Rules: bonus},
+				{Name: "summer", Start: start, End: start.AddDate(0, 2, 0), Rules: bonus},
+			}},
+			wantErr: ErrCampaignNameDuplicate,
+		},
+		{
+			name:    "Compile: should return error if a campaign ends before it starts",
+			input:   RuleSet{Version: "v1", Rules: base, Campaigns: []Campaign{{Name: "summer", Start: start, End: start, Rules: bonus}}},
+			wantErr: ErrCampaignWindowInvalid,
+		},
+		{
+			name:    "Compile: should return error if a campaign has no rules",
+			input:   RuleSet{Version: "v1", Rules: base, Campaigns: []Campaign{{Name: "summer", Start: start, End: start.AddDate(0, 1, 0)}}},
+			wantErr: ErrCampaignRulesEmpty,
+		},
+		{
+			name:    "Compile: should return error if a campaign rule is invalid",
+			input:   RuleSet{Version: "v1", Rules: base, Campaigns: []Campaign{{Name: "summer", Start: start, End: start.AddDate(0, 1, 0), Rules: []Definition{{Name: "x", Builtin: "bonus"}}}}},
+			wantErr: ErrBuiltinUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.input.Compile()
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("Compile: should keep the expression error position", func(t *testing.T) {
+		_, err := RuleSet{Version: "v1", Rules: []Definition{{Name: "a", Expr: "1 +\n  true"}}}.Compile()
+
+		var exprErr *expr.Error
+		if !errors.As(err, &exprErr) {
+			t.Fatalf("got %v, want *expr.Error", err)
+		}
+		if exprErr.Line != 1 || exprErr.Col != 3 {
+			t.Errorf("got %v, want line 1, column 3", exprErr.Pos)
+		}
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g := NewRegistry(MustCompile(Default()), WithRegistryClock(func() time.Time { return now }))
+
+	v2 := RuleSet{Version: "v2", Rules: []Definition{{Name: "flat", Expr: "10"}}}
+	v3 := RuleSet{Version: "v3", Rules: []Definition{{Name: "flat", Expr: "20"}}}
+
+	if _, err := g.Create(v2, "user:ana"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Create(v3, "user:ana"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Create: should refuse to replace a version", func(t *testing.T) {
+		if _, err := g.Create(v2, "user:ana"); !errors.Is(err, ErrVersionExists) {
+			t.Error("got", err, "want", ErrVersionExists)
+		}
+	})
+
+	t.Run("Create: should refuse an invalid rule set", func(t *testing.T) {
+		if _, err := g.Create(RuleSet{Version: "bad"}, "user:ana"); !errors.Is(err, ErrRulesEmpty) {
+			t.Error("got", err, "want", ErrRulesEmpty)
+		}
+	})
+
+	t.Run("Activate: should refuse an unknown version", func(t *testing.T) {
+		if _, err := g.Activate("v9", time.Time{}, "user:ana"); !errors.Is(err, ErrVersionNotFound) {
+			t.Error("got", err, "want", ErrVersionNotFound)
+		}
+	})
+
+	t.Run("Activate: should switch versions at once", func(t *testing.T) {
+		v, err := g.Activate("v2", time.Time{}, "user:ana")
+		if err != nil || !v.Active {
+			t.Fatal("got", v, err, "want an active version")
+		}
+		if got := g.Active().Version; got != "v2" {
+			t.Errorf("got %s, want v2", got)
+		}
+	})
+
+	t.Run("Activate: should schedule a future activation", func(t *testing.T) {
+		at := now.Add(time.Hour)
+		v, err := g.Activate("v3", at, "user:bo")
+		if err != nil || v.Active || v.ScheduledAt == nil || !v.ScheduledAt.Equal(at) {
+			t.Fatal("got", v, err, "want v3 scheduled")
+		}
+		if got := g.Active().Version; got != "v2" {
+			t.Errorf("got %s before the schedule, want v2", got)
+		}
+
+		now = at
+		if got := g.Active().Version; got != "v3" {
+			t.Errorf("got %s at the schedule, want v3", got)
+		}
+	})
+
+	t.Run("Changes: should record who changed what", func(t *testing.T) {
+		var got []string
+		for _, c := range g.Changes() {
+			got = append(got, c.Action+" "+c.Version+" "+c.Actor+" "+c.Previous)
+		}
+		want := []string{
+			"create v2 user:ana ",
+			"create v3 user:ana ",
+			"activate v2 user:ana default",
+			"schedule v3 user:bo ",
+			"activate v3 user:bo v2",
+		}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+	})
+
+	t.Run("List: should list versions in creation order", func(t *testing.T) {
+		var got []string
+		for _, v := range g.List() {
+			got = append(got, fmt.Sprintf("%s:%t", v.RuleSet.Version, v.Active))
+		}
+		if want := "default:false v2:false v3:true"; strings.Join(got, " ") != want {
+			t.Errorf("got %s, want %s", strings.Join(got, " "), want)
+		}
+	})
+}
+
+func TestOpenRegistry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rulesets.jsonl")
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := WithRegistryClock(func() time.Time { return now })
+
+	g, err := OpenRegistry(path, MustCompile(Default()), clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rs := range []RuleSet{
+		{Version: "v2", Rules: []Definition{{Name: "flat", Expr: "10"}}},
+		{Version: "v3", Rules: []Definition{{Name: "flat", Expr: "20"}}},
+		{Version: "v4", Rules: []Definition{{Name: "flat", Expr: "30"}}},
+	} {
+		if _, err := g.Create(rs, "user:ana"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := g.Activate("v2", time.Time{}, "user:ana"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Activate("v3", now.Add(time.Hour), "user:bo"); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * time.Hour)
+	if _, err := g.Activate("v4", now.Add(time.Hour), "user:bo"); err != nil {
+		t.Fatal(err)
+	}
+	want := g.Changes()
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenRegistry(path, MustCompile(Default()), clock)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	defer reopened.Close()
+
+	t.Run("OpenRegistry: should replay versions, activations and the changes trail", func(t *testing.T) {
+		if got := reopened.Active().Version; got != "v3" {
+			t.Errorf("got %s, want v3", got)
+		}
+		if got := reopened.Changes(); !reflect.DeepEqual(got, want) {
+			t.Errorf("got\n%+v\nwant\n%+v", got, want)
+		}
+	})
+
+	t.Run("OpenRegistry: should keep a pending activation", func(t *testing.T) {
+		v, err := reopened.Get("v4")
+		if err != nil || v.ScheduledAt == nil {
+			t.Fatal("got", v, err, "want v4 scheduled")
+		}
+
+		now = now.Add(time.Hour)
+		if got := reopened.Active().Version; got != "v4" {
+			t.Errorf("got %s at the schedule, want v4", got)
+		}
+	})
+
+	t.Run("OpenRegistry: should activate a redeployed rules file over replayed changes", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "rulesets.jsonl")
+		deployed := MustCompile(RuleSet{Version: "v9", Rules: []Definition{{Name: "flat", Expr: "90"}}})
+		open := func(active *Compiled) *Registry {
+			t.Helper()
+			g, err := OpenRegistry(path, active, clock)
+			if err != nil {
+				t.Fatalf("got %v, want nil", err)
+			}
+			t.Cleanup(func() { g.Close() })
+			return g
+		}
+
+		g := open(MustCompile(Default()))
+		if _, err := g.Create(RuleSet{Version: "v2", Rules: []Definition{{Name: "flat", Expr: "10"}}}, "user:ana"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := g.Activate("v2", time.Time{}, "user:ana"); err != nil {
+			t.Fatal(err)
+		}
+		g.Close()
+
+		if got := open(MustCompile(Default())).Active().Version; got != "v2" {
+			t.Errorf("same file: got %s, want v2", got)
+		}
+
+		g = open(deployed)
+		if got := g.Active().Version; got != "v9" {
+			t.Errorf("new file: got %s, want v9", got)
+		}
+		changes := g.Changes()
+		if last := changes[len(changes)-1]; last.Action != ActionLoad || last.Version != "v9" || last.Previous != "v2" {
+			t.Errorf("new file: got %+v, want a load of v9 after v2", last)
+		}
+		g.Close()
+
+		if got := open(deployed).Active().Version; got != "v9" {
+			t.Errorf("new file again: got %s, want v9", got)
+		}
+		if got := open(MustCompile(Default())).Active().Version; got != "default" {
+			t.Errorf("rolled back file: got %s, want default", got)
+		}
+	})
+
+	t.Run("OpenRegistry: should return error for a change to an unknown version", func(t *testing.T) {
+		bad := filepath.Join(t.TempDir(), "bad.jsonl")
+		if err := os.WriteFile(bad, []byte(`{"time":"2024-01-01T12:00:00Z","action":"activate","version":"v9"}`+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenRegistry(bad, MustCompile(Default())); !errors.Is(err, ErrVersionNotFound) {
+			t.Errorf("got %v, want %v", err, ErrVersionNotFound)
+		}
+	})
+}
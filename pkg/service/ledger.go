@@ -0,0 +1,97 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/jsonl"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+)
+
+// Ledger keeps points adjustments. Adjustments are never changed or removed;
+// a mistake is corrected with another adjustment. Implementations must be
+// safe for concurrent use.
+type Ledger interface {
+	AddAdjustment(a models.Adjustment) error
+	ListAdjustments() []models.Adjustment
+}
+
+func NewMemoryLedger() *MemoryLedger {
+	return &MemoryLedger{}
+}
+
+// MemoryLedger keeps adjustments in memory, in the order they were made.
+type MemoryLedger struct {
+	mu          sync.RWMutex
+	adjustments []models.Adjustment
+}
+
+func (l *MemoryLedger) AddAdjustment(a models.Adjustment) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.adjustments = append(l.adjustments, a)
+	return nil
+}
+
+func (l *MemoryLedger) ListAdjustments() []models.Adjustment {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return slices.Clone(l.adjustments)
+}
+
+// FileLedger keeps adjustments in memory and appends each one to a JSON
+// lines file, which is replayed when the ledger is opened.
+type FileLedger struct {
+	mu     sync.Mutex
+	memory *MemoryLedger
+	file   *os.File
+}
+
+func OpenFileLedger(path string) (*FileLedger, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &FileLedger{memory: NewMemoryLedger(), file: f}
+
+	err = jsonl.Replay(f, path, func(line []byte) error {
+		var a models.Adjustment
+		if err := json.Unmarshal(line, &a); err != nil {
+			return err
+		}
+		return l.memory.AddAdjustment(a)
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *FileLedger) AddAdjustment(a models.Adjustment) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := jsonl.Append(l.file, a); err != nil {
+		return err
+	}
+
+	return l.memory.AddAdjustment(a)
+}
+
+func (l *FileLedger) ListAdjustments() []models.Adjustment {
+	return l.memory.ListAdjustments()
+}
+
+func (l *FileLedger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/jsonl"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+)
+
+const (
+	// DefaultMaxReviews is how many reviews a queue holds by default.
+	DefaultMaxReviews = 1000
+
+	// MaxReviewMessageBytes is how much of a message a review keeps. The
+	// rest is dropped and the review marked Truncated.
+	MaxReviewMessageBytes = 64 << 10
+)
+
+var ErrReviewQueueFull = errors.New("review queue is full")
+
+// ReviewQueue holds up to max reviews, or any number when max is 0. A queue
+// opened with OpenFileReviewQueue appends each change to a JSON lines file,
+// which is replayed when the queue is opened.
+type ReviewQueue struct {
+	mu      sync.RWMutex
+	reviews map[string]Review
+	max     int
+	file    *os.File
+}
+
+func NewReviewQueue(max int) *ReviewQueue {
+	return &ReviewQueue{reviews: map[string]Review{}, max: max}
+}
+
+// reviewRecord is a line of a review queue file: a queued review, or the Id
+// of a deleted one.
+type reviewRecord struct {
+	Review  *Review `json:"review,omitempty"`
+	Deleted string  `json:"deleted,omitempty"`
+}
+
+func OpenFileReviewQueue(path string, max int) (*ReviewQueue, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	q := NewReviewQueue(max)
+
+	err = jsonl.Replay(f, path, func(line []byte) error {
+		var r reviewRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		if r.Review != nil {
+			q.reviews[r.Review.Id] = *r.Review
+		}
+		delete(q.reviews, r.Deleted)
+		return nil
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	q.file = f
+	return q, nil
+}
+
+// Add queues r, truncating its message to MaxReviewMessageBytes. A full
+// queue is ErrReviewQueueFull, reported as an exceeded quota.
+func (q *ReviewQueue) Add(r Review) error {
+	if len(r.Message) > MaxReviewMessageBytes {
+		n := MaxReviewMessageBytes
+		for n > 0 && !utf8.RuneStart(r.Message[n]) {
+			n--
+		}
+		r.Message, r.Truncated = r.Message[:n], true
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.max > 0 && len(q.reviews) >= q.max {
+		return fmt.Errorf("%w: %w", models.ErrQuotaExceeded, ErrReviewQueueFull)
+	}
+	if err := q.write(reviewRecord{Review: &r}); err != nil {
+		return err
+	}
+	q.reviews[r.Id] = r
+
+	return nil
+}
+
+func (q *ReviewQueue) Get(id string) (Review, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	r, ok := q.reviews[id]
+	if !ok {
+		return Review{}, fmt.Errorf("review not found")
+	}
+
+	return r, nil
+}
+
+func (q *ReviewQueue) Delete(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.reviews[id]; !ok {
+		return fmt.Errorf("review not found")
+	}
+	if err := q.write(reviewRecord{Deleted: id}); err != nil {
+		return err
+	}
+	delete(q.reviews, id)
+
+	return nil
+}
+
+// write appends r to the queue's file, if any. The caller holds q.mu.
+func (q *ReviewQueue) write(r reviewRecord) error {
+	if q.file == nil {
+		return nil
+	}
+
+	return jsonl.Append(q.file, r)
+}
+
+func (q *ReviewQueue) Len() int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	return len(q.reviews)
+}
+
+// List returns the queued reviews, oldest first.
+func (q *ReviewQueue) List() []Review {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	reviews := make([]Review, 0, len(q.reviews))
+	for _, r := range q.reviews {
+		reviews = append(reviews, r)
+	}
+	slices.SortFunc(reviews, func(a, b Review) int {
+		if c := a.ReceivedAt.Compare(b.ReceivedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Id, b.Id)
+	})
+
+	return reviews
+}
+
+func (q *ReviewQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.file == nil {
+		return nil
+	}
+	return q.file.Close()
+}
@@ -4,26 +4,74 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"math"
 	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/FourSigma/receipt-processor-challenge/pkg/audit"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/auth"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/catalog"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/currency"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/email"
 	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/parse"
 	"github.com/FourSigma/receipt-processor-challenge/pkg/points"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/ratelimit"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/receiptcsv"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/requestid"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/retailer"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/rules"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/simulate"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/tenant"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/trace"
 	"github.com/google/uuid"
 )
 
+// Store keeps processed receipts. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	StoreReceipt(r models.Receipt) error
+	// AddReceipt stores a new receipt unless the store already holds max
+	// receipts, returning ErrReceiptLimit. Zero max is no limit. The check
+	// and the write are atomic.
+	AddReceipt(r models.Receipt, max int) error
+	GetReceipt(id string) (models.Receipt, error)
+	ListReceipts() []models.Receipt
+	CountReceipts() int
+}
+
+func NewMemoryStore() *RecepitStore {
+	return &RecepitStore{store: map[string]models.Receipt{}}
+}
+
+// RecepitStore keeps receipts in memory.
 type RecepitStore struct {
 	mu    sync.RWMutex
 	store map[string]models.Receipt
 }
 
-func (s *RecepitStore) StoreReceipt(r models.Receipt) {
+func (s *RecepitStore) StoreReceipt(r models.Receipt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.store[r.Id] = r
+	return nil
+}
+
+func (s *RecepitStore) AddReceipt(r models.Receipt, max int) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if max > 0 && len(s.store) >= max {
+		return ErrReceiptLimit
+	}
 	s.store[r.Id] = r
+	return nil
 }
 
 func (s *RecepitStore) GetReceipt(id string) (models.Receipt, error) {
@@ -38,32 +86,229 @@ func (s *RecepitStore) GetReceipt(id string) (models.Receipt, error) {
 	return r, nil
 }
 
-func NewService() *Service {
-	return &Service{
-		store: &RecepitStore{store: map[string]models.Receipt{}},
+func (s *RecepitStore) ListReceipts() []models.Receipt {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	receipts := make([]models.Receipt, 0, len(s.store))
+	for _, r := range s.store {
+		receipts = append(receipts, r)
+	}
+
+	return receipts
+}
+
+func (s *RecepitStore) CountReceipts() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.store)
+}
+
+// Review is a submitted message that could not be turned into a receipt,
+// kept with the reason so it can be handled manually.
+type Review struct {
+	Id         string         `json:"id"`
+	ReceivedAt time.Time      `json:"receivedAt"`
+	From       string         `json:"from,omitempty"`
+	Subject    string         `json:"subject,omitempty"`
+	Reason     string         `json:"reason"`
+	Parsed     *parse.Receipt `json:"parsed,omitempty"`
+	Message    string         `json:"message"`
+	// Truncated is set when Message was cut to MaxReviewMessageBytes.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type Option func(*Service)
+
+// WithRetailers replaces the empty registry submitted retailer names are
+// resolved against.
+func WithRetailers(registry *retailer.Registry) Option {
+	return func(s *Service) {
+		s.retailers = registry
+	}
+}
+
+// WithCatalog categorizes receipt items by matching them against the catalog.
+func WithCatalog(c *catalog.Catalog) Option {
+	return func(s *Service) {
+		s.catalog = c
+	}
+}
+
+// WithRates sets the exchange-rate table used to convert receipt amounts to
+// the currency points are calculated in. Without it only USD is accepted.
+func WithRates(rates *currency.Rates) Option {
+	return func(s *Service) {
+		s.rates = rates
+	}
+}
+
+// WithStore replaces the in-memory receipt store.
+func WithStore(store Store) Option {
+	return func(s *Service) {
+		s.store = store
+	}
+}
+
+// WithRules replaces the default rule set receipts are scored with.
+func WithRules(compiled *rules.Compiled) Option {
+	return func(s *Service) {
+		s.rules = rules.NewRegistry(compiled)
+	}
+}
+
+// WithRuleRegistry replaces the registry of rule set versions, such as with
+// one opened from a file.
+func WithRuleRegistry(registry *rules.Registry) Option {
+	return func(s *Service) {
+		s.rules = registry
+	}
+}
+
+// WithTenant makes the service one tenant's partition: it stores receipts for
+// that tenant only, within its limits, and refuses requests for any other.
+func WithTenant(t tenant.Tenant) Option {
+	return func(s *Service) {
+		s.tenant = t.ID
+		s.limits = t.Limits
+	}
+}
+
+// Observer is told the outcome of every receipt submission, such as to
+// export metrics. It must be safe for concurrent use.
+type Observer interface {
+	ReceiptProcessed(tenant string, r models.Receipt)
+	ReceiptRejected(tenant string, err error)
+}
+
+// RuleObserver is an Observer that also sees each rule a receipt is scored
+// with, such as to time rules.
+type RuleObserver interface {
+	Observer
+	ObserveRule(rule string, points int64, elapsed time.Duration)
+}
+
+// WithObserver reports submissions to o, and the rules receipts are scored
+// with when o is a RuleObserver.
+func WithObserver(o Observer) Option {
+	return func(s *Service) {
+		s.observer = o
+	}
+}
+
+// WithDailyQuota limits each client, or each user when the request has
+// one, to n receipts per UTC day, counted in store. A tenant's
+// DailyReceipts limit takes precedence.
+func WithDailyQuota(n int, store ratelimit.Store) Option {
+	return func(s *Service) {
+		s.dailyQuota = n
+		s.counters = store
+	}
+}
+
+// WithLogger replaces slog.Default.
+func WithLogger(log *slog.Logger) Option {
+	return func(s *Service) {
+		s.log = log
+	}
+}
+
+// WithLedger replaces the in-memory ledger of points adjustments.
+func WithLedger(ledger Ledger) Option {
+	return func(s *Service) {
+		s.ledger = ledger
+	}
+}
+
+// WithReviews replaces the in-memory review queue.
+func WithReviews(q *ReviewQueue) Option {
+	return func(s *Service) {
+		s.reviews = q
+	}
+}
+
+// WithAudit records state changes in log, which tenants' services may
+// share. Without it they are kept in memory.
+func WithAudit(log *audit.Log) Option {
+	return func(s *Service) {
+		s.audit = log
+	}
+}
+
+func NewService(opts ...Option) *Service {
+	s := &Service{
+		store:     NewMemoryStore(),
+		ledger:    NewMemoryLedger(),
+		adjusting: &sync.Mutex{},
+		reviews:   NewReviewQueue(DefaultMaxReviews),
+		rules:     rules.NewRegistry(rules.MustCompile(rules.Default())),
+		retailers: retailer.NewRegistry(),
+		rates:     currency.Identity(),
+		counters:  ratelimit.NewMemoryStore(),
+		log:       slog.Default(),
+		audit:     audit.NewLog(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
 	}
+
+	return s
 }
 
 type Service struct {
-	store *RecepitStore
+	store     Store
+	ledger    Ledger
+	adjusting *sync.Mutex
+	reviews   *ReviewQueue
+	rules     *rules.Registry
+	retailers *retailer.Registry
+	catalog   *catalog.Catalog
+	rates     *currency.Rates
+
+	tenant string
+	limits tenant.Limits
+
+	dailyQuota int
+	counters   ratelimit.Store
+
+	observer Observer
+	log      *slog.Logger
+	audit    *audit.Log
 }
 
 type ReqProcessReceipt struct {
-	Retailer     string `json:"retailer"`
-	PurchaseDate string `json:"purchaseDate"`
-	PurchaseTime string `json:"purchaseTime"`
-	Items        []struct {
-		ShortDescription string `json:"shortDescription"`
-		Price            string `json:"price"`
-	} `json:"items"`
-	Total string `json:"total"`
+	Retailer     string           `json:"retailer"`
+	PurchaseDate string           `json:"purchaseDate"`
+	PurchaseTime string           `json:"purchaseTime"`
+	Items        []ReqReceiptItem `json:"items"`
+	Total        string           `json:"total"`
+	// Timezone is an optional IANA zone name, such as America/Chicago, for
+	// the store's local purchase date and time. It defaults to UTC.
+	Timezone string `json:"timezone,omitempty"`
+	// Currency is an optional ISO 4217 code. Amounts are written with the
+	// currency's minor units, so "900" in JPY and "9.000" in KWD. It defaults
+	// to USD.
+	Currency string `json:"currency,omitempty"`
+}
+
+// ReqReceiptItem is one line of a receipt. Quantity, UnitPrice and Type are
+// optional: a line without them is a single sale item.
+type ReqReceiptItem struct {
+	ShortDescription string `json:"shortDescription"`
+	// Price is the amount charged for the whole line. Discount lines are
+	// negative.
+	Price     string `json:"price"`
+	Quantity  string `json:"quantity,omitempty"`
+	UnitPrice string `json:"unitPrice,omitempty"`
+	Type      string `json:"type,omitempty"`
 }
 
 var (
 	reReceiptRetailer             = regexp.MustCompile("^[\\w\\s\\-&]+$")
-	reReceiptTotal                = regexp.MustCompile("^\\d+\\.\\d{2}$")
 	reReceiptItemShortDescription = regexp.MustCompile("^[\\w\\s\\-]+$")
-	reReceiptItemPrice            = regexp.MustCompile("^\\d+\\.\\d{2}$")
+	reReceiptItemQuantity         = regexp.MustCompile("^\\d+(\\.\\d{1,3})?$")
 )
 
 var (
@@ -80,9 +325,74 @@ var (
 	ErrItemShortDescriptionInvalid = errors.New("item short description must be alphanumeric")
 	ErrItemPriceEmpty              = errors.New("item price cannot be empty")
 	ErrItemPriceInvalid            = errors.New("item price must be in the format of 0.00")
+	ErrTimezoneInvalid             = errors.New("timezone must be an IANA time zone name")
+	ErrItemTypeInvalid             = errors.New("item type must be one of sale, discount, tax or fee")
+	ErrItemPriceNegative           = errors.New("item price can only be negative for discount lines")
+	ErrItemDiscountPositive        = errors.New("discount item price cannot be positive")
+	ErrItemQuantityInvalid         = errors.New("item quantity must be a positive number with up to 3 decimals")
+	ErrItemUnitPriceInvalid        = errors.New("item unit price must be in the format of 0.00")
+	ErrItemPriceMismatch           = errors.New("item price must equal quantity times unit price")
+	ErrCurrencyInvalid             = errors.New("currency must be a supported ISO 4217 code")
+	ErrCurrencyUnsupported         = errors.New("currency has no exchange rate to the points currency")
 )
 
+// ReceiptErrors are the sentinels a rejected receipt is reported by.
+var ReceiptErrors = []error{
+	ErrRetailerEmpty, ErrRetailerInvalid, ErrPurchaseDateEmpty, ErrPurchaseDateInvalid,
+	ErrPurchaseTimeEmpty, ErrPurchaseTimeInvalid, ErrItemsEmpty, ErrTotalEmpty, ErrTotalInvalid,
+	ErrItemShortDescriptionEmpty, ErrItemShortDescriptionInvalid, ErrItemPriceEmpty, ErrItemPriceInvalid,
+	ErrTimezoneInvalid, ErrItemTypeInvalid, ErrItemPriceNegative, ErrItemDiscountPositive,
+	ErrItemQuantityInvalid, ErrItemUnitPriceInvalid, ErrItemPriceMismatch, ErrCurrencyInvalid,
+	ErrCurrencyUnsupported, ErrTenantMismatch, ErrItemLimit, ErrReceiptLimit, ErrDailyQuota,
+}
+
+// Causes returns the ReceiptErrors that err wraps.
+func Causes(err error) []error {
+	var causes []error
+	for _, sentinel := range ReceiptErrors {
+		if errors.Is(err, sentinel) {
+			causes = append(causes, sentinel)
+		}
+	}
+	return causes
+}
+
+// amountError adds the expected format to err for currencies that do not use
+// two decimal places, whose sentinel messages assume 0.00.
+func amountError(err error, cur currency.Currency) error {
+	if cur.MinorUnits == 2 {
+		return err
+	}
+	return fmt.Errorf("%w: %s amounts are written as %s", err, cur.Code, cur.Format())
+}
+
+// requestCurrency is the request's currency, falling back to the default for
+// unknown codes so amounts can still be checked.
+func requestCurrency(code string) currency.Currency {
+	if code == "" {
+		code = currency.Default
+	}
+	if cur, ok := currency.Lookup(code); ok {
+		return cur
+	}
+	return currency.MustLookup(currency.Default)
+}
+
 func (r ReqProcessReceipt) IsValid() error {
+	err := r.isValidReceipt()
+
+	cur := requestCurrency(r.Currency)
+	for _, item := range r.Items {
+		if ierr := item.IsValidIn(cur); ierr != nil {
+			err = errors.Join(err, ierr)
+		}
+	}
+	return err
+}
+
+// isValidReceipt checks everything but the items themselves, so errors can be
+// reported against the receipt and each item separately.
+func (r ReqProcessReceipt) isValidReceipt() error {
 	var err error
 
 	if r.Retailer == "" {
@@ -111,6 +421,22 @@ func (r ReqProcessReceipt) IsValid() error {
 		err = errors.Join(err, fmt.Errorf("%w: %w", ErrPurchaseTimeInvalid, terr))
 	}
 
+	// Local would make scoring depend on the host's zone.
+	if r.Timezone == "Local" {
+		err = errors.Join(err, fmt.Errorf("%w: %q", ErrTimezoneInvalid, r.Timezone))
+	} else if r.Timezone != "" {
+		if _, lerr := time.LoadLocation(r.Timezone); lerr != nil {
+			err = errors.Join(err, fmt.Errorf("%w: %w", ErrTimezoneInvalid, lerr))
+		}
+	}
+
+	if r.Currency != "" {
+		if _, ok := currency.Lookup(r.Currency); !ok {
+			err = errors.Join(err, ErrCurrencyInvalid)
+		}
+	}
+	cur := requestCurrency(r.Currency)
+
 	if len(r.Items) == 0 {
 		err = errors.Join(err, ErrItemsEmpty)
 	}
@@ -119,28 +445,75 @@ func (r ReqProcessReceipt) IsValid() error {
 		err = errors.Join(err, ErrTotalEmpty)
 	}
 
-	if !reReceiptTotal.MatchString(r.Total) {
-		err = errors.Join(err, ErrTotalInvalid)
+	if !cur.ValidAmount(r.Total, false) {
+		err = errors.Join(err, amountError(ErrTotalInvalid, cur))
 	}
 
-	for _, item := range r.Items {
-		if item.ShortDescription == "" {
-			err = errors.Join(err, ErrItemShortDescriptionEmpty)
-		}
+	return err
+}
 
-		if item.Price == "" {
-			err = errors.Join(err, ErrItemPriceEmpty)
-		}
+// IsValid checks the item as a line of a receipt in the default currency.
+func (item ReqReceiptItem) IsValid() error {
+	return item.IsValidIn(currency.MustLookup(currency.Default))
+}
+
+// IsValidIn checks the item as a line of a receipt in cur.
+func (item ReqReceiptItem) IsValidIn(cur currency.Currency) error {
+	var err error
+
+	if item.ShortDescription == "" {
+		err = errors.Join(err, ErrItemShortDescriptionEmpty)
+	}
+
+	if item.Price == "" {
+		err = errors.Join(err, ErrItemPriceEmpty)
+	}
+
+	if !reReceiptItemShortDescription.MatchString(item.ShortDescription) {
+		err = errors.Join(err, ErrItemShortDescriptionInvalid)
+	}
+
+	if !cur.ValidAmount(item.Price, true) {
+		err = errors.Join(err, amountError(ErrItemPriceInvalid, cur))
+	}
+
+	lineType := models.LineType(item.Type)
+	if item.Type == "" {
+		lineType = models.LineSale
+	}
+	if !lineType.IsValid() {
+		err = errors.Join(err, ErrItemTypeInvalid)
+	}
 
-		if !reReceiptItemShortDescription.MatchString(item.ShortDescription) {
-			err = errors.Join(err, ErrItemShortDescriptionInvalid)
+	price, perr := strconv.ParseFloat(item.Price, 64)
+	if perr == nil {
+		if lineType == models.LineDiscount && price > 0 {
+			err = errors.Join(err, ErrItemDiscountPositive)
 		}
+		if lineType != models.LineDiscount && price < 0 {
+			err = errors.Join(err, ErrItemPriceNegative)
+		}
+	}
 
-		if !reReceiptItemPrice.MatchString(item.Price) {
-			err = errors.Join(err, ErrItemPriceInvalid)
+	quantity := 1.0
+	if item.Quantity != "" {
+		var qerr error
+		quantity, qerr = strconv.ParseFloat(item.Quantity, 64)
+		if qerr != nil || !reReceiptItemQuantity.MatchString(item.Quantity) || quantity <= 0 {
+			err = errors.Join(err, ErrItemQuantityInvalid)
 		}
+	}
 
+	if item.UnitPrice != "" {
+		unitPrice, uerr := strconv.ParseFloat(item.UnitPrice, 64)
+		scale := math.Pow10(cur.MinorUnits)
+		if uerr != nil || !cur.ValidAmount(item.UnitPrice, true) {
+			err = errors.Join(err, amountError(ErrItemUnitPriceInvalid, cur))
+		} else if perr == nil && math.Round(quantity*unitPrice*scale) != math.Round(price*scale) {
+			err = errors.Join(err, ErrItemPriceMismatch)
+		}
 	}
+
 	return err
 }
 
@@ -158,12 +531,23 @@ func ConvertReqToReceiptTwo(req ReqProcessReceipt) (models.Receipt, error) {
 	}
 
 	receipt.Total = total
+	receipt.Currency = requestCurrency(req.Currency).Code
+
+	loc := time.UTC
+	if req.Timezone != "" {
+		loc, err = time.LoadLocation(req.Timezone)
+		if err != nil {
+			return models.Receipt{}, fmt.Errorf("error loading timezone: %w", err)
+		}
+	}
 
 	const timeFormat = "2006-01-02 15:04"
-	receipt.PurchasedAt, err = time.Parse(timeFormat, req.PurchaseDate+" "+req.PurchaseTime)
+	wall, err := time.Parse(timeFormat, req.PurchaseDate+" "+req.PurchaseTime)
 	if err != nil {
 		return models.Receipt{}, fmt.Errorf("error parsing purchase date and time: %w", err)
 	}
+	receipt.PurchasedAt = localTime(wall, loc)
+	receipt.Timezone = loc.String()
 
 	for _, item := range req.Items {
 		price, err := strconv.ParseFloat(item.Price, 64)
@@ -171,92 +555,1417 @@ func ConvertReqToReceiptTwo(req ReqProcessReceipt) (models.Receipt, error) {
 			return models.Receipt{}, fmt.Errorf("error parsing item price: %w", err)
 		}
 
-		receipt.Items = append(receipt.Items, models.Item{
+		line := models.Item{
 			ShortDescription: item.ShortDescription,
 			Price:            price,
-		})
+			Quantity:         1,
+			UnitPrice:        price,
+			Type:             models.LineSale,
+		}
+
+		if item.Type != "" {
+			line.Type = models.LineType(item.Type)
+		}
+
+		if item.Quantity != "" {
+			line.Quantity, err = strconv.ParseFloat(item.Quantity, 64)
+			if err != nil {
+				return models.Receipt{}, fmt.Errorf("error parsing item quantity: %w", err)
+			}
+			line.UnitPrice = price / line.Quantity
+		}
+
+		if item.UnitPrice != "" {
+			line.UnitPrice, err = strconv.ParseFloat(item.UnitPrice, 64)
+			if err != nil {
+				return models.Receipt{}, fmt.Errorf("error parsing item unit price: %w", err)
+			}
+		}
+
+		receipt.Items = append(receipt.Items, line)
 	}
 
 	return receipt, nil
 }
 
+// localTime reads the wall clock of wall, a UTC time, in loc. A wall clock
+// that falls in a daylight saving gap was printed by a clock that had not
+// sprung forward yet, so it is read with the offset from before the gap:
+// 02:30 on a spring-forward day becomes 03:30. A wall clock that repeats when
+// clocks fall back is read as the earlier of the two instants.
+func localTime(wall time.Time, loc *time.Location) time.Time {
+	t := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), 0, 0, loc)
+	if t.Hour() == wall.Hour() && t.Minute() == wall.Minute() {
+		return t
+	}
+
+	// Clocks spring forward, so the earlier offset is the smaller one.
+	_, early := t.Add(-12 * time.Hour).Zone()
+	_, late := t.Add(12 * time.Hour).Zone()
+	return wall.Add(-time.Duration(min(early, late)) * time.Second).In(loc)
+}
+
+// categorize sets the SKU and category of each item found in the catalog.
+func (s Service) categorize(r *models.Receipt) {
+	if s.catalog == nil {
+		return
+	}
+
+	for i, item := range r.Items {
+		if !item.IsSale() {
+			continue
+		}
+		if m, ok := s.catalog.Match(item.ShortDescription); ok {
+			r.Items[i].SKU = m.SKU
+			r.Items[i].Category = m.Category
+		}
+	}
+}
+
+var (
+	ErrTenantMismatch = errors.New("request is for another tenant")
+	ErrItemLimit      = errors.New("receipt has more items than the tenant allows")
+	ErrReceiptLimit   = errors.New("tenant has stored the most receipts it allows")
+	ErrDailyQuota     = errors.New("caller has submitted the most receipts allowed today")
+
+	ErrRulesNotLoaded   = errors.New("no scoring rules are loaded")
+	ErrStoreUnavailable = errors.New("receipt store is unavailable")
+)
+
+// serves reports whether a request is for this service's tenant. Requests
+// without a tenant are served by a service without one.
+func (s Service) serves(ctx context.Context) bool {
+	id, _ := tenant.FromContext(ctx)
+	return id == s.tenant
+}
+
+// visible reports whether the caller may read a receipt: none of another
+// tenant's, then a user's own receipts, a client's own receipts, any receipt
+// for admins, and every receipt when authentication is disabled.
+func (s Service) visible(ctx context.Context, r models.Receipt) bool {
+	if !s.serves(ctx) || r.TenantID != s.tenant {
+		return false
+	}
+
+	p, ok := auth.FromContext(ctx)
+	switch {
+	case !ok || p.Has(auth.ScopeAdmin):
+		return true
+	case p.UserID != "":
+		return p.UserID == r.UserID
+	}
+	return p.ClientID == r.ClientID
+}
+
 type RespProcessReceipt struct {
 	Id string `json:"id"`
 }
 
 func (s Service) ProcessReceipt(ctx context.Context, req ReqProcessReceipt) (*RespProcessReceipt, error) {
-	if err := req.IsValid(); err != nil {
-		return nil, fmt.Errorf("invalid request - %w %w", models.ErrInvalidInput, err)
+	r, err := s.submitReceipt(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &RespProcessReceipt{Id: r.Id}, nil
+}
+
+// submitReceipt processes a receipt, tracing, observing and logging the
+// outcome, and returns the stored receipt.
+func (s Service) submitReceipt(ctx context.Context, req ReqProcessReceipt) (models.Receipt, error) {
+	ctx, span := trace.Start(ctx, "ProcessReceipt")
+	defer span.End()
+
+	r, err := s.processReceipt(ctx, req)
+	span.RecordError(err)
+
+	if s.observer != nil {
+		if err != nil {
+			s.observer.ReceiptRejected(s.tenant, err)
+		} else {
+			s.observer.ReceiptProcessed(s.tenant, r)
+		}
 	}
 
-	receipt, err := ConvertReqToReceiptTwo(req)
 	if err != nil {
-		return nil, fmt.Errorf("error converting request to receipt: %w", err)
+		s.logRejected(ctx, err)
+		return models.Receipt{}, err
 	}
+	span.SetAttributes(trace.String("receipt.id", r.Id), trace.Int64("receipt.points", r.Points))
+	s.log.InfoContext(ctx, "Processed receipt",
+		"receipt_id", r.Id, "retailer", r.Retailer, "points", r.Points,
+		"client_id", r.ClientID, "user_id", r.UserID)
+	return r, nil
+}
 
-	rules := []points.RuleHandlerFn{
-		points.RuleAlphanumeric,
-		points.RuleRoundDollar,
-		points.RuleMultipleOfQuarter,
-		points.RuleItemPair,
-		points.RuleItemDescription,
-		points.RuleOddDay,
-		points.RuleTimeOfPurchase,
+// logRejected logs a receipt refused because of the request at info level,
+// and one the service failed to process as an error.
+func (s Service) logRejected(ctx context.Context, err error) {
+	for _, clientErr := range []error{models.ErrInvalidInput, models.ErrForbidden, models.ErrQuotaExceeded} {
+		if errors.Is(err, clientErr) {
+			s.log.InfoContext(ctx, "Rejected receipt", "err", err)
+			return
+		}
 	}
+	s.log.ErrorContext(ctx, "Failed to process receipt", "err", err)
+}
 
-	receipt.Points = points.Calculate(receipt, rules...)
+func (s Service) processReceipt(ctx context.Context, req ReqProcessReceipt) (models.Receipt, error) {
+	if !s.serves(ctx) {
+		return models.Receipt{}, fmt.Errorf("%w: %w", models.ErrForbidden, ErrTenantMismatch)
+	}
 
-	s.store.StoreReceipt(receipt)
+	if max := s.limits.MaxItems; max > 0 && len(req.Items) > max {
+		return models.Receipt{}, fmt.Errorf("%w: %w: %d", models.ErrInvalidInput, ErrItemLimit, max)
+	}
+	// Checked again by the store, which is the check concurrent submissions
+	// cannot race; this one only saves scoring a receipt that cannot be kept.
+	limit := s.limits.MaxReceipts
+	if limit > 0 && s.store.CountReceipts() >= limit {
+		return models.Receipt{}, fmt.Errorf("%w: %w: %d", models.ErrQuotaExceeded, ErrReceiptLimit, limit)
+	}
 
-	return &RespProcessReceipt{Id: receipt.Id}, nil
-}
+	scored, err := s.ScoreReceipt(ctx, req)
+	if err != nil {
+		return models.Receipt{}, err
+	}
+	scored.Receipt.TenantID = s.tenant
 
-var (
-	ErrIdEmpty   = errors.New("id cannot be empty")
-	ErrIdInvalid = errors.New("id must must be a non-whitespace character")
+	refund := func() {}
+	if p, ok := auth.FromContext(ctx); ok {
+		scored.Receipt.ClientID = p.ClientID
+		scored.Receipt.UserID = p.UserID
 
-	reReceiptId = regexp.MustCompile("^\\S+$")
-)
+		refund, err = s.spendQuota(ctx, p)
+		if err != nil {
+			return models.Receipt{}, err
+		}
+	}
 
-type ReqGetPoints struct {
-	Id string `json:"id"`
+	r := scored.Receipt
+	abort, err := s.record(ctx, AuditReceiptSubmit, "receipt/"+r.Id, nil, map[string]string{
+		"points":      strconv.FormatInt(r.Points, 10),
+		"total":       strconv.FormatFloat(r.Total, 'f', 2, 64),
+		"ruleVersion": r.RuleVersion,
+	})
+	if err != nil {
+		refund()
+		return models.Receipt{}, err
+	}
+
+	_, span := trace.Start(ctx, "StoreReceipt")
+	err = s.store.AddReceipt(r, limit)
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		refund()
+		abort(err)
+	}
+	if errors.Is(err, ErrReceiptLimit) {
+		return models.Receipt{}, fmt.Errorf("%w: %w: %d", models.ErrQuotaExceeded, err, limit)
+	}
+	if err != nil {
+		return models.Receipt{}, fmt.Errorf("error storing receipt: %w", err)
+	}
+
+	return r, nil
 }
 
-func (r ReqGetPoints) IsValid() error {
-	var err error
-	if r.Id == "" {
-		err = errors.Join(err, ErrIdEmpty)
+// spendQuota counts a submission against the caller's daily quota, and
+// returns a refund to call if the receipt is then not stored, so only stored
+// receipts spend the quota. Only valid receipts count, and only authenticated
+// callers have a quota. Like rate limits, the quota fails open: when the
+// counter store fails the submission is allowed and the error logged.
+func (s Service) spendQuota(ctx context.Context, p auth.Principal) (refund func(), err error) {
+	refund = func() {}
+
+	quota := s.dailyQuota
+	if s.limits.DailyReceipts > 0 {
+		quota = s.limits.DailyReceipts
+	}
+	if quota <= 0 {
+		return refund, nil
 	}
 
-	if !reReceiptId.MatchString(r.Id) {
-		err = errors.Join(err, ErrIdInvalid)
-	} else {
-		// Check for invalid UUID
-		if _, perr := uuid.Parse(r.Id); perr != nil {
-			err = errors.Join(perr, ErrIdInvalid)
-		}
+	caller := "client:" + p.ClientID
+	if p.UserID != "" {
+		caller = "user:" + p.UserID
 	}
 
-	return err
+	now := time.Now().UTC()
+	day := now.Format(time.DateOnly)
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	key := "quota|" + s.tenant + "|" + caller + "|" + day
+
+	n, err := s.counters.Incr(ctx, key, now, midnight)
+	if err != nil {
+		s.log.WarnContext(ctx, "Failed to count daily quota, allowing the receipt", "err", err)
+		return refund, nil
+	}
+	refund = func() {
+		if err := s.counters.Decr(ctx, key, time.Now().UTC()); err != nil {
+			s.log.WarnContext(ctx, "Failed to refund daily quota", "err", err)
+		}
+	}
+	if n > quota {
+		refund()
+		return nil, fmt.Errorf("%w: %w: %d", models.ErrQuotaExceeded, ErrDailyQuota, quota)
+	}
+	return refund, nil
 }
 
-type RespGetPoints struct {
-	Points int64 `json:"points"`
+type RespScoreReceipt struct {
+	Receipt models.Receipt `json:"receipt"`
 }
 
-func (s Service) GetPoints(ctx context.Context, req ReqGetPoints) (*RespGetPoints, error) {
-	if err := req.IsValid(); err != nil {
-		return nil, fmt.Errorf("%w: %w", models.ErrInvalidInput, err)
+// ScoreReceipt validates and scores a receipt exactly like ProcessReceipt,
+// without storing it.
+func (s Service) ScoreReceipt(ctx context.Context, req ReqProcessReceipt) (*RespScoreReceipt, error) {
+	_, span := trace.Start(ctx, "IsValid")
+	err := req.IsValid()
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		return nil, fmt.Errorf("invalid request - %w %w", models.ErrInvalidInput, err)
 	}
 
-	r, err := s.store.GetReceipt(req.Id)
+	canonical, resolved := s.retailers.Resolve(req.Retailer)
+
+	// The request's zone wins over the registry's.
+	if req.Timezone == "" && resolved {
+		req.Timezone = canonical.Timezone
+	}
+
+	_, span = trace.Start(ctx, "ConvertReqToReceiptTwo")
+	receipt, err := ConvertReqToReceiptTwo(req)
+	span.RecordError(err)
+	span.End()
 	if err != nil {
-		return nil, fmt.Errorf("%w: %w", models.ErrNotFound, err)
+		return nil, fmt.Errorf("error converting request to receipt: %w", err)
 	}
 
-	resp := &RespGetPoints{
-		Points: r.Points,
+	if resolved {
+		receipt.RetailerID = canonical.ID
 	}
 
-	return resp, nil
+	receipt.ExchangeRate, err = s.rates.Rate(receipt.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w: %w", models.ErrInvalidInput, ErrCurrencyUnsupported, err)
+	}
+	receipt.RateVersion = s.rates.Version
+
+	s.categorize(&receipt)
+
+	if o, ok := s.observer.(RuleObserver); ok {
+		ctx = points.WithHook(ctx, o.ObserveRule)
+	}
+	active := s.rules.Active()
+	receipt.Breakdown = active.BreakdownContext(ctx, receipt)
+	receipt.Points = points.Total(receipt.Breakdown)
+	receipt.RuleVersion = active.Version
+
+	return &RespScoreReceipt{Receipt: receipt}, nil
+}
+
+// ReqProcessReceiptText is a receipt as plain text, such as OCR output.
+type ReqProcessReceiptText struct {
+	Text     string `json:"text"`
+	Timezone string `json:"timezone,omitempty"`
+	Currency string `json:"currency,omitempty"`
+}
+
+type RespProcessReceiptText struct {
+	Id string `json:"id"`
+	// Parsed is what was extracted from the text, with a confidence for
+	// each field.
+	Parsed *parse.Receipt `json:"parsed"`
+}
+
+// ConvertParsedToReq builds a request from parsed text so it is validated and
+// scored like any other receipt.
+func ConvertParsedToReq(p *parse.Receipt) ReqProcessReceipt {
+	req := ReqProcessReceipt{
+		Retailer:     p.Retailer.Value,
+		PurchaseDate: p.PurchaseDate.Value,
+		PurchaseTime: p.PurchaseTime.Value,
+		Total:        p.Total.Value,
+		Items:        make([]ReqReceiptItem, 0, len(p.Items)),
+	}
+
+	for _, item := range p.Items {
+		req.Items = append(req.Items, ReqReceiptItem{
+			ShortDescription: item.ShortDescription.Value,
+			Price:            item.Price.Value,
+			Quantity:         item.Quantity.Value,
+			UnitPrice:        item.UnitPrice.Value,
+			Type:             item.Type,
+		})
+	}
+
+	return req
+}
+
+func (s Service) ProcessReceiptText(ctx context.Context, req ReqProcessReceiptText) (*RespProcessReceiptText, error) {
+	parsed, err := parse.Text(req.Text, requestCurrency(req.Currency))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", models.ErrInvalidInput, err)
+	}
+
+	preq := ConvertParsedToReq(parsed)
+	preq.Timezone = req.Timezone
+	preq.Currency = req.Currency
+
+	resp, err := s.ProcessReceipt(ctx, preq)
+	if errors.Is(err, models.ErrInvalidInput) {
+		return nil, &ParsedError{Parsed: parsed, Err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &RespProcessReceiptText{Id: resp.Id, Parsed: parsed}, nil
+}
+
+// ParsedError is a receipt parsed from text that failed validation. It keeps
+// what was extracted, with confidences, so the caller can see which fields
+// to fix.
+type ParsedError struct {
+	Parsed *parse.Receipt
+	Err    error
+}
+
+func (e *ParsedError) Error() string { return e.Err.Error() }
+func (e *ParsedError) Unwrap() error { return e.Err }
+
+var ErrMessageEmpty = errors.New("message cannot be empty")
+
+// ReqProcessReceiptEmail is a raw RFC 5322 message, such as a forwarded
+// e-receipt.
+type ReqProcessReceiptEmail struct {
+	Message  string `json:"message"`
+	Timezone string `json:"timezone,omitempty"`
+	Currency string `json:"currency,omitempty"`
+}
+
+// RespProcessReceiptEmail has the receipt Id when the message was processed,
+// or the ReviewId and Reason when it was queued for manual review.
+type RespProcessReceiptEmail struct {
+	Id       string         `json:"id,omitempty"`
+	ReviewId string         `json:"reviewId,omitempty"`
+	Reason   string         `json:"reason,omitempty"`
+	Parsed   *parse.Receipt `json:"parsed,omitempty"`
+}
+
+// ProcessReceiptEmail processes the receipt in a message. Messages that
+// cannot be read, parsed or validated are queued for review instead of
+// failing. When the review queue is full they are refused with
+// ErrReviewQueueFull, so the sender can retry, rather than dropped.
+func (s Service) ProcessReceiptEmail(ctx context.Context, req ReqProcessReceiptEmail) (*RespProcessReceiptEmail, error) {
+	if strings.TrimSpace(req.Message) == "" {
+		return nil, fmt.Errorf("%w: %w", models.ErrInvalidInput, ErrMessageEmpty)
+	}
+
+	review := Review{
+		Id:         uuid.NewString(),
+		ReceivedAt: time.Now(),
+		Message:    req.Message,
+	}
+	queue := func(err error) (*RespProcessReceiptEmail, error) {
+		review.Reason = err.Error()
+		abort, err := s.record(ctx, AuditReviewQueue, "review/"+review.Id, nil, map[string]string{"reason": review.Reason})
+		if err != nil {
+			return nil, err
+		}
+		if err := s.reviews.Add(review); err != nil {
+			abort(err)
+			return nil, err
+		}
+		s.log.InfoContext(ctx, "Queued email for review", "review_id", review.Id, "reason", review.Reason)
+		return &RespProcessReceiptEmail{ReviewId: review.Id, Reason: review.Reason, Parsed: review.Parsed}, nil
+	}
+
+	msg, err := email.Read(strings.NewReader(req.Message))
+	if err != nil {
+		return queue(err)
+	}
+	review.From, review.Subject = msg.From, msg.Subject
+
+	review.Parsed, err = parse.Text(msg.Text, requestCurrency(req.Currency))
+	if err != nil {
+		return queue(err)
+	}
+
+	preq := ConvertParsedToReq(review.Parsed)
+	preq.Timezone = req.Timezone
+	preq.Currency = req.Currency
+
+	resp, err := s.ProcessReceipt(ctx, preq)
+	if errors.Is(err, models.ErrInvalidInput) {
+		return queue(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &RespProcessReceiptEmail{Id: resp.Id, Parsed: review.Parsed}, nil
+}
+
+var (
+	ErrImportKeyEmpty    = errors.New("receipt key cannot be empty")
+	ErrImportRowMismatch = errors.New("rows of a receipt must have the same retailer, purchase date and time, total, timezone and currency")
+)
+
+type ReqImportReceipts struct {
+	CSV string `json:"csv"`
+	// Mapping defaults to receiptcsv.DefaultMapping when empty.
+	Mapping receiptcsv.Mapping `json:"mapping"`
+}
+
+type ImportedReceipt struct {
+	Key    string `json:"key"`
+	Id     string `json:"id"`
+	Points int64  `json:"points"`
+}
+
+// ImportRowError is a problem with one CSV row. Receipt level problems are
+// reported on the receipt's first row.
+type ImportRowError struct {
+	Line  int    `json:"line"`
+	Key   string `json:"key"`
+	Error string `json:"error"`
+	Err   error  `json:"-"`
+}
+
+type RespImportReceipts struct {
+	Imported []ImportedReceipt `json:"imported"`
+	Errors   []ImportRowError  `json:"errors"`
+}
+
+// ImportReceipts processes receipts from a CSV file with one row per item.
+// Receipts with any invalid row are skipped; the others are processed.
+func (s Service) ImportReceipts(ctx context.Context, req ReqImportReceipts) (*RespImportReceipts, error) {
+	mapping := req.Mapping
+	if mapping == (receiptcsv.Mapping{}) {
+		mapping = receiptcsv.DefaultMapping()
+	}
+
+	rows, err := receiptcsv.Read(strings.NewReader(req.CSV), mapping)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", models.ErrInvalidInput, err)
+	}
+
+	resp := &RespImportReceipts{Imported: []ImportedReceipt{}, Errors: []ImportRowError{}}
+	for _, group := range receiptcsv.GroupRows(rows) {
+		preq, errs := importRequest(group)
+		if len(errs) > 0 {
+			resp.Errors = append(resp.Errors, errs...)
+			continue
+		}
+
+		r, err := s.submitReceipt(ctx, preq)
+		if err != nil {
+			resp.Errors = append(resp.Errors, importRowError(group.Rows[0], err))
+			continue
+		}
+		resp.Imported = append(resp.Imported, ImportedReceipt{Key: group.Key, Id: r.Id, Points: r.Points})
+	}
+
+	return resp, nil
+}
+
+func importRowError(row receiptcsv.Row, err error) ImportRowError {
+	return ImportRowError{Line: row.Line, Key: row.Key, Error: err.Error(), Err: err}
+}
+
+// importRequest builds a request from a receipt's rows and validates each
+// row, so errors point at the line to fix.
+func importRequest(group receiptcsv.Group) (ReqProcessReceipt, []ImportRowError) {
+	var errs []ImportRowError
+
+	first := group.Rows[0]
+	req := ReqProcessReceipt{
+		Retailer:     first.Retailer,
+		PurchaseDate: first.PurchaseDate,
+		PurchaseTime: first.PurchaseTime,
+		Total:        first.Total,
+		Timezone:     first.Timezone,
+		Currency:     first.Currency,
+	}
+
+	for _, row := range group.Rows {
+		req.Items = append(req.Items, ReqReceiptItem{
+			ShortDescription: row.ShortDescription,
+			Price:            row.Price,
+			Quantity:         row.Quantity,
+			UnitPrice:        row.UnitPrice,
+			Type:             row.Type,
+		})
+	}
+
+	if group.Key == "" {
+		for _, row := range group.Rows {
+			errs = append(errs, importRowError(row, ErrImportKeyEmpty))
+		}
+		return req, errs
+	}
+
+	if err := req.isValidReceipt(); err != nil {
+		errs = append(errs, importRowError(first, err))
+	}
+
+	cur := requestCurrency(req.Currency)
+	for i, row := range group.Rows {
+		var err error
+		if row.Retailer != first.Retailer || row.PurchaseDate != first.PurchaseDate || row.PurchaseTime != first.PurchaseTime ||
+			row.Total != first.Total || row.Timezone != first.Timezone || row.Currency != first.Currency {
+			err = errors.Join(err, ErrImportRowMismatch)
+		}
+		if ierr := req.Items[i].IsValidIn(cur); ierr != nil {
+			err = errors.Join(err, ierr)
+		}
+		if err != nil {
+			errs = append(errs, importRowError(row, err))
+		}
+	}
+
+	return req, errs
+}
+
+type ReqExportReceipts struct{}
+
+type RespExportReceipts struct {
+	Receipts []models.Receipt
+}
+
+// ExportReceipts returns the stored receipts the caller may read, ordered by
+// purchase time.
+func (s Service) ExportReceipts(ctx context.Context, req ReqExportReceipts) (*RespExportReceipts, error) {
+	receipts := slices.DeleteFunc(s.store.ListReceipts(), func(r models.Receipt) bool {
+		return !s.visible(ctx, r)
+	})
+	slices.SortFunc(receipts, func(a, b models.Receipt) int {
+		if c := a.PurchasedAt.Compare(b.PurchasedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Id, b.Id)
+	})
+
+	return &RespExportReceipts{Receipts: receipts}, nil
+}
+
+var (
+	ErrIdEmpty   = errors.New("id cannot be empty")
+	ErrIdInvalid = errors.New("id must must be a non-whitespace character")
+
+	reReceiptId = regexp.MustCompile("^\\S+$")
+)
+
+type ReqGetPoints struct {
+	Id string `json:"id"`
+}
+
+func (r ReqGetPoints) IsValid() error {
+	var err error
+	if r.Id == "" {
+		err = errors.Join(err, ErrIdEmpty)
+	}
+
+	if !reReceiptId.MatchString(r.Id) {
+		err = errors.Join(err, ErrIdInvalid)
+	} else {
+		// Check for invalid UUID
+		if _, perr := uuid.Parse(r.Id); perr != nil {
+			err = errors.Join(perr, ErrIdInvalid)
+		}
+	}
+
+	return err
+}
+
+type RespGetPoints struct {
+	Points int64 `json:"points"`
+}
+
+func (s Service) GetPoints(ctx context.Context, req ReqGetPoints) (*RespGetPoints, error) {
+	if err := req.IsValid(); err != nil {
+		return nil, fmt.Errorf("%w: %w", models.ErrInvalidInput, err)
+	}
+
+	r, err := s.store.GetReceipt(req.Id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", models.ErrNotFound, err)
+	}
+
+	// Other clients' receipts are reported as missing so their IDs cannot be
+	// probed.
+	if !s.visible(ctx, r) {
+		return nil, models.ErrNotFound
+	}
+
+	resp := &RespGetPoints{
+		Points: r.Points,
+	}
+
+	return resp, nil
+}
+
+var (
+	ErrAdjustmentZero         = errors.New("points cannot be zero")
+	ErrAdjustmentReason       = errors.New("reason must be goodwill, missing_points, bad_receipt, fraud or correction")
+	ErrAdjustmentAgentEmpty   = errors.New("agent id cannot be empty")
+	ErrAdjustmentAgentInvalid = errors.New("agent id does not match the caller")
+	ErrAdjustmentUserEmpty    = errors.New("user id cannot be empty without a receipt")
+	ErrAdjustmentUserMismatch = errors.New("user id does not match the receipt's user")
+	ErrAdjustmentReceiptUser  = errors.New("receipt is not credited to a user")
+	ErrAdjustmentExceeds      = errors.New("cannot take back more points than the receipt has")
+)
+
+type ReqAdjustPoints struct {
+	UserID string `json:"userId"`
+	// ReceiptID links the adjustment to a receipt credited to a user, whose
+	// user it is applied to when UserID is empty.
+	ReceiptID string `json:"receiptId"`
+	// Points are granted when positive and taken back when negative.
+	Points int64             `json:"points"`
+	Reason models.ReasonCode `json:"reason"`
+	// AgentID is the authenticated caller, such as "client:support". It is
+	// only taken from the request when authentication is disabled.
+	AgentID string `json:"agentId"`
+	Note    string `json:"note"`
+}
+
+func (r ReqAdjustPoints) IsValid() error {
+	var err error
+	if r.Points == 0 {
+		err = errors.Join(err, ErrAdjustmentZero)
+	}
+	if !r.Reason.IsValid() {
+		err = errors.Join(err, ErrAdjustmentReason)
+	}
+	if strings.TrimSpace(r.AgentID) == "" {
+		err = errors.Join(err, ErrAdjustmentAgentEmpty)
+	}
+	if r.ReceiptID == "" && strings.TrimSpace(r.UserID) == "" {
+		err = errors.Join(err, ErrAdjustmentUserEmpty)
+	}
+	if r.ReceiptID != "" {
+		err = errors.Join(err, ReqGetPoints{Id: r.ReceiptID}.IsValid())
+	}
+	return err
+}
+
+type RespAdjustPoints struct {
+	models.Adjustment
+	// Balance is the user's balance after the adjustment.
+	Balance int64 `json:"balance"`
+}
+
+// AdjustPoints records points granted or taken back by a support agent and
+// applies them to the user's ledger. The receipt's calculated points are
+// left as they are; a receipt's adjusted points cannot go below zero.
+func (s Service) AdjustPoints(ctx context.Context, req ReqAdjustPoints) (*RespAdjustPoints, error) {
+	if !s.serves(ctx) {
+		return nil, fmt.Errorf("%w: %w", models.ErrForbidden, ErrTenantMismatch)
+	}
+	if agent := actor(ctx); agent != "" {
+		if req.AgentID != "" && req.AgentID != agent {
+			return nil, fmt.Errorf("%w: %w", models.ErrForbidden, ErrAdjustmentAgentInvalid)
+		}
+		req.AgentID = agent
+	}
+	if err := req.IsValid(); err != nil {
+		return nil, fmt.Errorf("%w: %w", models.ErrInvalidInput, err)
+	}
+
+	s.adjusting.Lock()
+	defer s.adjusting.Unlock()
+
+	adjustments := s.ledger.ListAdjustments()
+	if req.ReceiptID != "" {
+		r, err := s.store.GetReceipt(req.ReceiptID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", models.ErrNotFound, err)
+		}
+		if r.TenantID != s.tenant {
+			return nil, models.ErrNotFound
+		}
+
+		switch {
+		case r.UserID == "":
+			return nil, fmt.Errorf("%w: %w", models.ErrInvalidInput, ErrAdjustmentReceiptUser)
+		case req.UserID == "":
+			req.UserID = r.UserID
+		case req.UserID != r.UserID:
+			return nil, fmt.Errorf("%w: %w", models.ErrInvalidInput, ErrAdjustmentUserMismatch)
+		}
+		if r.Points+adjusted(adjustments, r.Id)+req.Points < 0 {
+			return nil, fmt.Errorf("%w: %w", models.ErrInvalidInput, ErrAdjustmentExceeds)
+		}
+	}
+
+	before := s.balance(req.UserID, adjustments)
+	a := models.Adjustment{
+		Id:        uuid.NewString(),
+		TenantID:  s.tenant,
+		UserID:    req.UserID,
+		ReceiptID: req.ReceiptID,
+		Points:    req.Points,
+		Reason:    req.Reason,
+		AgentID:   req.AgentID,
+		Note:      req.Note,
+		CreatedAt: time.Now().UTC(),
+	}
+	after := before + a.Points
+
+	summary := map[string]string{
+		"balance":    strconv.FormatInt(after, 10),
+		"adjustment": a.Id,
+		"points":     strconv.FormatInt(a.Points, 10),
+		"reason":     string(a.Reason),
+		"agent":      a.AgentID,
+	}
+	if a.ReceiptID != "" {
+		summary["receipt"] = a.ReceiptID
+	}
+	abort, err := s.record(ctx, AuditPointsAdjust, "user/"+a.UserID, map[string]string{"balance": strconv.FormatInt(before, 10)}, summary)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ledger.AddAdjustment(a); err != nil {
+		abort(err)
+		return nil, fmt.Errorf("error storing adjustment: %w", err)
+	}
+
+	s.log.InfoContext(ctx, "Adjusted points",
+		"adjustment_id", a.Id, "user_id", a.UserID, "receipt_id", a.ReceiptID,
+		"points", a.Points, "reason", a.Reason, "agent_id", a.AgentID)
+	return &RespAdjustPoints{Adjustment: a, Balance: after}, nil
+}
+
+// balance is the points of a user's receipts plus their adjustments.
+func (s Service) balance(userID string, adjustments []models.Adjustment) int64 {
+	var total int64
+	for _, r := range s.store.ListReceipts() {
+		if r.UserID == userID && r.TenantID == s.tenant {
+			total += r.Points
+		}
+	}
+	for _, a := range adjustments {
+		if a.UserID == userID {
+			total += a.Points
+		}
+	}
+	return total
+}
+
+// adjusted sums the adjustments linked to a receipt.
+func adjusted(adjustments []models.Adjustment, receiptID string) int64 {
+	var total int64
+	for _, a := range adjustments {
+		if a.ReceiptID == receiptID {
+			total += a.Points
+		}
+	}
+	return total
+}
+
+type ReqGetLedger struct {
+	UserID string `json:"userId"`
+}
+
+// LedgerEntry is a change to a user's balance: a receipt's calculated
+// points, or an adjustment.
+type LedgerEntry struct {
+	Time         time.Time         `json:"time"`
+	Points       int64             `json:"points"`
+	ReceiptID    string            `json:"receiptId,omitempty"`
+	AdjustmentID string            `json:"adjustmentId,omitempty"`
+	Reason       models.ReasonCode `json:"reason,omitempty"`
+	AgentID      string            `json:"agentId,omitempty"`
+}
+
+type RespGetLedger struct {
+	UserID  string        `json:"userId"`
+	Balance int64         `json:"balance"`
+	Entries []LedgerEntry `json:"entries"`
+}
+
+// GetLedger lists a user's receipts and adjustments, oldest first, with the
+// resulting balance. Receipts are dated by their purchase time.
+func (s Service) GetLedger(ctx context.Context, req ReqGetLedger) (*RespGetLedger, error) {
+	if !s.serves(ctx) {
+		return nil, fmt.Errorf("%w: %w", models.ErrForbidden, ErrTenantMismatch)
+	}
+	if strings.TrimSpace(req.UserID) == "" {
+		return nil, fmt.Errorf("%w: %w", models.ErrInvalidInput, ErrAdjustmentUserEmpty)
+	}
+
+	resp := &RespGetLedger{UserID: req.UserID, Entries: []LedgerEntry{}}
+	for _, r := range s.store.ListReceipts() {
+		if r.UserID == req.UserID && r.TenantID == s.tenant {
+			resp.Entries = append(resp.Entries, LedgerEntry{Time: r.PurchasedAt, Points: r.Points, ReceiptID: r.Id})
+		}
+	}
+	for _, a := range s.ledger.ListAdjustments() {
+		if a.UserID == req.UserID {
+			resp.Entries = append(resp.Entries, LedgerEntry{
+				Time:         a.CreatedAt,
+				Points:       a.Points,
+				ReceiptID:    a.ReceiptID,
+				AdjustmentID: a.Id,
+				Reason:       a.Reason,
+				AgentID:      a.AgentID,
+			})
+		}
+	}
+	slices.SortStableFunc(resp.Entries, func(a, b LedgerEntry) int { return a.Time.Compare(b.Time) })
+
+	for _, e := range resp.Entries {
+		resp.Balance += e.Points
+	}
+	return resp, nil
+}
+
+type ReqGetBreakdown struct {
+	Id string `json:"id"`
+}
+
+type RespGetBreakdown struct {
+	Id          string `json:"id"`
+	RuleVersion string `json:"ruleVersion"`
+	// Points is the calculated score, which adjustments never change.
+	Points      int64               `json:"points"`
+	Rules       []models.RulePoints `json:"rules"`
+	Adjustments []models.Adjustment `json:"adjustments"`
+	// AdjustedPoints is Points plus the adjustments.
+	AdjustedPoints int64 `json:"adjustedPoints"`
+}
+
+// GetBreakdown shows how a receipt was scored and the adjustments made to
+// it since.
+func (s Service) GetBreakdown(ctx context.Context, req ReqGetBreakdown) (*RespGetBreakdown, error) {
+	if err := (ReqGetPoints{Id: req.Id}).IsValid(); err != nil {
+		return nil, fmt.Errorf("%w: %w", models.ErrInvalidInput, err)
+	}
+
+	r, err := s.store.GetReceipt(req.Id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", models.ErrNotFound, err)
+	}
+	if !s.visible(ctx, r) {
+		return nil, models.ErrNotFound
+	}
+
+	resp := &RespGetBreakdown{
+		Id:             r.Id,
+		RuleVersion:    r.RuleVersion,
+		Points:         r.Points,
+		Rules:          r.Breakdown,
+		Adjustments:    []models.Adjustment{},
+		AdjustedPoints: r.Points,
+	}
+	for _, a := range s.ledger.ListAdjustments() {
+		if a.ReceiptID == r.Id {
+			resp.Adjustments = append(resp.Adjustments, a)
+			resp.AdjustedPoints += a.Points
+		}
+	}
+	return resp, nil
+}
+
+type ReqSimulate struct {
+	// Base defaults to the rule set the service is scoring with.
+	Base      *rules.RuleSet `json:"base,omitempty"`
+	Candidate rules.RuleSet  `json:"candidate"`
+	Top       int            `json:"top,omitempty"`
+}
+
+type RespSimulate struct {
+	simulate.Report
+}
+
+// Simulate replays every stored receipt through the base and candidate rule
+// sets without changing the points already awarded.
+func (s Service) Simulate(ctx context.Context, req ReqSimulate) (*RespSimulate, error) {
+	base := s.rules.Active()
+	if req.Base != nil {
+		var err error
+		if base, err = req.Base.Compile(); err != nil {
+			return nil, fmt.Errorf("%w: base: %w", models.ErrInvalidInput, err)
+		}
+	}
+
+	candidate, err := req.Candidate.Compile()
+	if err != nil {
+		return nil, fmt.Errorf("%w: candidate: %w", models.ErrInvalidInput, err)
+	}
+
+	report := simulate.Run(s.store.ListReceipts(), base, candidate, req.Top)
+
+	return &RespSimulate{Report: report}, nil
+}
+
+type ReqListRetailers struct{}
+
+type RespListRetailers struct {
+	Retailers []retailer.Retailer `json:"retailers"`
+}
+
+func (s Service) ListRetailers(ctx context.Context, req ReqListRetailers) (*RespListRetailers, error) {
+	return &RespListRetailers{Retailers: s.retailers.List()}, nil
+}
+
+type ReqGetRetailer struct {
+	Id string `json:"id"`
+}
+
+type RespGetRetailer struct {
+	retailer.Retailer
+}
+
+func (s Service) GetRetailer(ctx context.Context, req ReqGetRetailer) (*RespGetRetailer, error) {
+	r, err := s.retailers.Get(req.Id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", models.ErrRetailerNotFound, err)
+	}
+
+	return &RespGetRetailer{Retailer: r}, nil
+}
+
+type ReqPutRetailer struct {
+	retailer.Retailer
+}
+
+type RespPutRetailer struct {
+	retailer.Retailer
+}
+
+// PutRetailer creates the retailer or replaces the one with the same ID.
+func (s Service) PutRetailer(ctx context.Context, req ReqPutRetailer) (*RespPutRetailer, error) {
+	var before map[string]string
+	if r, err := s.retailers.Get(req.ID); err == nil {
+		before = map[string]string{"name": r.Name}
+	}
+	if err := req.IsValid(); err != nil {
+		return nil, fmt.Errorf("%w: %w", models.ErrInvalidInput, err)
+	}
+
+	abort, err := s.record(ctx, AuditRetailerPut, "retailer/"+req.ID, before, map[string]string{"name": req.Name})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.retailers.Put(req.Retailer); err != nil {
+		abort(err)
+		if errors.Is(err, retailer.ErrRegistryWrite) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %w", models.ErrInvalidInput, err)
+	}
+
+	return &RespPutRetailer{Retailer: req.Retailer}, nil
+}
+
+type ReqDeleteRetailer struct {
+	Id string `json:"id"`
+}
+
+type RespDeleteRetailer struct{}
+
+func (s Service) DeleteRetailer(ctx context.Context, req ReqDeleteRetailer) (*RespDeleteRetailer, error) {
+	r, err := s.retailers.Get(req.Id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", models.ErrRetailerNotFound, err)
+	}
+
+	abort, err := s.record(ctx, AuditRetailerDelete, "retailer/"+req.Id, map[string]string{"name": r.Name}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.retailers.Delete(req.Id); err != nil {
+		abort(err)
+		if errors.Is(err, retailer.ErrRegistryWrite) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %w", models.ErrRetailerNotFound, err)
+	}
+
+	return &RespDeleteRetailer{}, nil
+}
+
+var ErrResolveNameEmpty = errors.New("name cannot be empty")
+
+type ReqResolveRetailer struct {
+	Name string `json:"name"`
+}
+
+func (r ReqResolveRetailer) IsValid() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return ErrResolveNameEmpty
+	}
+	return nil
+}
+
+type RespResolveRetailer struct {
+	Normalized string             `json:"normalized"`
+	Retailer   *retailer.Retailer `json:"retailer"`
+}
+
+// ResolveRetailer shows which retailer, if any, a submitted name maps to.
+func (s Service) ResolveRetailer(ctx context.Context, req ReqResolveRetailer) (*RespResolveRetailer, error) {
+	if err := req.IsValid(); err != nil {
+		return nil, fmt.Errorf("%w: %w", models.ErrInvalidInput, err)
+	}
+
+	resp := &RespResolveRetailer{Normalized: retailer.Normalize(req.Name)}
+	if r, ok := s.retailers.Resolve(req.Name); ok {
+		resp.Retailer = &r
+	}
+
+	return resp, nil
+}
+
+type ReqListReviews struct{}
+
+type RespListReviews struct {
+	Reviews []Review `json:"reviews"`
+}
+
+func (s Service) ListReviews(ctx context.Context, req ReqListReviews) (*RespListReviews, error) {
+	return &RespListReviews{Reviews: s.reviews.List()}, nil
+}
+
+type ReqGetReview struct {
+	Id string `json:"id"`
+}
+
+type RespGetReview struct {
+	Review
+}
+
+func (s Service) GetReview(ctx context.Context, req ReqGetReview) (*RespGetReview, error) {
+	r, err := s.reviews.Get(req.Id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", models.ErrNotFound, err)
+	}
+
+	return &RespGetReview{Review: r}, nil
+}
+
+type ReqDeleteReview struct {
+	Id string `json:"id"`
+}
+
+type RespDeleteReview struct{}
+
+// DeleteReview removes a review once it has been handled.
+func (s Service) DeleteReview(ctx context.Context, req ReqDeleteReview) (*RespDeleteReview, error) {
+	r, err := s.reviews.Get(req.Id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", models.ErrNotFound, err)
+	}
+
+	abort, err := s.record(ctx, AuditReviewDelete, "review/"+req.Id, map[string]string{"reason": r.Reason}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.reviews.Delete(req.Id); err != nil {
+		abort(err)
+		return nil, fmt.Errorf("%w: %w", models.ErrNotFound, err)
+	}
+
+	return &RespDeleteReview{}, nil
+}
+
+type ReqListRuleSets struct{}
+
+type RespListRuleSets struct {
+	Versions []rules.Version `json:"versions"`
+}
+
+// ListRuleSets lists the rule set versions in the order they were created.
+func (s Service) ListRuleSets(ctx context.Context, req ReqListRuleSets) (*RespListRuleSets, error) {
+	return &RespListRuleSets{Versions: s.rules.List()}, nil
+}
+
+type ReqGetRuleSet struct {
+	Version string `json:"version"`
+}
+
+type RespGetRuleSet struct {
+	rules.Version
+}
+
+func (s Service) GetRuleSet(ctx context.Context, req ReqGetRuleSet) (*RespGetRuleSet, error) {
+	v, err := s.rules.Get(req.Version)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", models.ErrRuleSetNotFound, err)
+	}
+	return &RespGetRuleSet{Version: v}, nil
+}
+
+type ReqCreateRuleSet struct {
+	rules.RuleSet
+}
+
+type RespCreateRuleSet struct {
+	rules.Version
+}
+
+// CreateRuleSet adds an inactive rule set version. Existing versions cannot
+// be replaced.
+func (s Service) CreateRuleSet(ctx context.Context, req ReqCreateRuleSet) (*RespCreateRuleSet, error) {
+	// Checked before the change is recorded, so that the audit log only
+	// holds changes expected to succeed; Create checks again.
+	if _, err := req.Compile(); err != nil {
+		return nil, fmt.Errorf("%w: %w", models.ErrInvalidInput, err)
+	}
+	if _, err := s.rules.Get(req.Version); err == nil {
+		return nil, fmt.Errorf("%w: %w: %q", models.ErrConflict, rules.ErrVersionExists, req.Version)
+	}
+
+	abort, err := s.record(ctx, AuditRuleSetCreate, "ruleset/"+req.Version, nil, map[string]string{"version": req.Version})
+	if err != nil {
+		return nil, err
+	}
+	v, err := s.rules.Create(req.RuleSet, actor(ctx))
+	if err != nil {
+		abort(err)
+	}
+	switch {
+	case errors.Is(err, rules.ErrVersionExists):
+		return nil, fmt.Errorf("%w: %w", models.ErrConflict, err)
+	case errors.Is(err, rules.ErrRegistryWrite):
+		return nil, err
+	case err != nil:
+		return nil, fmt.Errorf("%w: %w", models.ErrInvalidInput, err)
+	}
+
+	s.log.InfoContext(ctx, "Created rule set", "version", req.Version, "actor", actor(ctx))
+	return &RespCreateRuleSet{Version: v}, nil
+}
+
+type ReqValidateRuleSet struct {
+	rules.RuleSet
+}
+
+type RespValidateRuleSet struct {
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// ValidateRuleSet compiles a rule set without keeping it, and lists what is
+// wrong with it.
+func (s Service) ValidateRuleSet(ctx context.Context, req ReqValidateRuleSet) (*RespValidateRuleSet, error) {
+	if _, err := req.Compile(); err != nil {
+		return &RespValidateRuleSet{Errors: strings.Split(err.Error(), "\n")}, nil
+	}
+	return &RespValidateRuleSet{Valid: true}, nil
+}
+
+type ReqActivateRuleSet struct {
+	Version string `json:"-"`
+	// At schedules the activation. It is immediate when At is empty or has
+	// passed.
+	At time.Time `json:"at"`
+}
+
+type RespActivateRuleSet struct {
+	rules.Version
+}
+
+// ActivateRuleSet switches receipts to a rule set version, now or at
+// req.At.
+func (s Service) ActivateRuleSet(ctx context.Context, req ReqActivateRuleSet) (*RespActivateRuleSet, error) {
+	if _, err := s.rules.Get(req.Version); err != nil {
+		return nil, fmt.Errorf("%w: %w", models.ErrRuleSetNotFound, err)
+	}
+
+	before := map[string]string{"version": s.rules.Active().Version}
+	action, after := AuditRuleSetActivate, map[string]string{"version": req.Version}
+	if req.At.After(time.Now()) {
+		action, after["at"] = AuditRuleSetSchedule, req.At.UTC().Format(time.RFC3339)
+	}
+	abort, err := s.record(ctx, action, "ruleset/"+req.Version, before, after)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := s.rules.Activate(req.Version, req.At, actor(ctx))
+	if err != nil {
+		abort(err)
+	}
+	if errors.Is(err, rules.ErrVersionNotFound) {
+		return nil, fmt.Errorf("%w: %w", models.ErrRuleSetNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if v.Active {
+		s.log.InfoContext(ctx, "Activated rule set", "version", req.Version, "actor", actor(ctx))
+	} else {
+		s.log.InfoContext(ctx, "Scheduled rule set activation", "version", req.Version, "at", req.At, "actor", actor(ctx))
+	}
+	return &RespActivateRuleSet{Version: v}, nil
+}
+
+type ReqListRuleChanges struct{}
+
+type RespListRuleChanges struct {
+	Changes []rules.Change `json:"changes"`
+}
+
+// ListRuleChanges returns the audit trail of rule set changes, oldest
+// first.
+func (s Service) ListRuleChanges(ctx context.Context, req ReqListRuleChanges) (*RespListRuleChanges, error) {
+	return &RespListRuleChanges{Changes: s.rules.Changes()}, nil
+}
+
+// actor names the caller of ctx in audit trails.
+func actor(ctx context.Context) string {
+	p, ok := auth.FromContext(ctx)
+	switch {
+	case !ok:
+		return ""
+	case p.UserID != "":
+		return "user:" + p.UserID
+	default:
+		return "client:" + p.ClientID
+	}
+}
+
+// Audit log actions.
+const (
+	AuditReceiptSubmit   = "receipt.submit"
+	AuditReviewQueue     = "review.queue"
+	AuditReviewDelete    = "review.delete"
+	AuditRetailerPut     = "retailer.put"
+	AuditRetailerDelete  = "retailer.delete"
+	AuditRuleSetCreate   = "ruleset.create"
+	AuditRuleSetActivate = "ruleset.activate"
+	AuditRuleSetSchedule = "ruleset.schedule"
+	AuditPointsAdjust    = "points.adjust"
+	// AuditAbort follows an entry whose change failed after it was recorded.
+	AuditAbort = "change.abort"
+)
+
+// record appends a change the caller of ctx is about to make to the audit
+// log. The entry is written before the change is made, so a change that
+// cannot be recorded is not made and retrying it does not make it twice. If
+// the change then fails, abort records that it was not made.
+func (s Service) record(ctx context.Context, action, target string, before, after map[string]string) (abort func(error), err error) {
+	id, _ := requestid.FromContext(ctx)
+	e, err := s.audit.Append(audit.Entry{
+		Tenant:    s.tenant,
+		Actor:     actor(ctx),
+		Action:    action,
+		Target:    target,
+		Before:    before,
+		After:     after,
+		RequestID: id,
+	})
+	if err != nil {
+		s.log.ErrorContext(ctx, "Failed to record audit entry", "action", action, "target", target, "err", err)
+		return nil, fmt.Errorf("error recording audit entry: %w", err)
+	}
+
+	return func(cause error) {
+		_, err := s.audit.Append(audit.Entry{
+			Tenant:    s.tenant,
+			Actor:     e.Actor,
+			Action:    AuditAbort,
+			Target:    target,
+			After:     map[string]string{"seq": strconv.FormatInt(e.Seq, 10), "action": action, "err": cause.Error()},
+			RequestID: id,
+		})
+		if err != nil {
+			s.log.ErrorContext(ctx, "Failed to record aborted change", "action", action, "target", target, "seq", e.Seq, "err", err)
+		}
+	}, nil
+}
+
+var ErrAuditLimitInvalid = errors.New("limit cannot be negative")
+
+// ReqListAudit filters the audit log. Empty fields match every entry.
+type ReqListAudit struct {
+	Actor  string
+	Action string
+	Target string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+func (r ReqListAudit) IsValid() error {
+	if r.Limit < 0 {
+		return ErrAuditLimitInvalid
+	}
+	return nil
+}
+
+type RespListAudit struct {
+	Entries []audit.Entry `json:"entries"`
+}
+
+// ListAudit returns the service's tenant's audit entries, oldest first.
+func (s Service) ListAudit(ctx context.Context, req ReqListAudit) (*RespListAudit, error) {
+	if err := req.IsValid(); err != nil {
+		return nil, fmt.Errorf("%w: %w", models.ErrInvalidInput, err)
+	}
+
+	entries := s.audit.Query(audit.Filter{
+		Tenant: s.tenant,
+		Actor:  req.Actor,
+		Action: req.Action,
+		Target: req.Target,
+		Since:  req.Since,
+		Until:  req.Until,
+		Limit:  req.Limit,
+	})
+	if entries == nil {
+		entries = []audit.Entry{}
+	}
+	return &RespListAudit{Entries: entries}, nil
+}
+
+type ReqStats struct{}
+
+type RespStats struct {
+	Tenant      string `json:"tenant,omitempty"`
+	RuleVersion string `json:"ruleVersion"`
+	Receipts    int    `json:"receipts"`
+	Reviews     int    `json:"reviews"`
+}
+
+// Stats counts the service's stored receipts and queued reviews.
+func (s Service) Stats(ctx context.Context, req ReqStats) (*RespStats, error) {
+	return &RespStats{
+		Tenant:      s.tenant,
+		RuleVersion: s.rules.Active().Version,
+		Receipts:    s.store.CountReceipts(),
+		Reviews:     s.reviews.Len(),
+	}, nil
+}
+
+// Pinger is a Store that can report whether it is usable, such as a file
+// store whose file is still open.
+type Pinger interface {
+	Ping() error
+}
+
+type ReqReady struct{}
+
+type RespReady struct{}
+
+// Ready reports whether the service can take receipts: its rules are loaded
+// and its store, when it is a Pinger, answers.
+func (s Service) Ready(ctx context.Context, req ReqReady) (*RespReady, error) {
+	if active := s.rules.Active(); active == nil || len(active.Rules) == 0 {
+		return nil, ErrRulesNotLoaded
+	}
+	if p, ok := s.store.(Pinger); ok {
+		if err := p.Ping(); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+		}
+	}
+	return &RespReady{}, nil
 }
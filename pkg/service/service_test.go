@@ -3,9 +3,26 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/FourSigma/receipt-processor-challenge/pkg/audit"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/auth"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/catalog"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/currency"
 	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/parse"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/points"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/ratelimit"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/receiptcsv"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/retailer"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/rules"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/tenant"
 )
 
 func TestServiceProcessRecepit(t *testing.T) {
@@ -18,10 +35,7 @@ func TestServiceProcessRecepit(t *testing.T) {
 				PurchaseDate: "2022-03-20",
 				PurchaseTime: "14:33",
 				Total:        "9.00",
-				Items: []struct {
-					ShortDescription string `json:"shortDescription"`
-					Price            string `json:"price"`
-				}{
+				Items: []ReqReceiptItem{
 					{ShortDescription: "Gatorade", Price: "2.25"},
 					{ShortDescription: "Gatorade", Price: "2.25"},
 					{ShortDescription: "Gatorade", Price: "2.25"},
@@ -51,10 +65,7 @@ func TestServiceProcessRecepit(t *testing.T) {
 				PurchaseDate: "2022-01-01",
 				PurchaseTime: "13:01",
 				Total:        "35.35",
-				Items: []struct {
-					ShortDescription string `json:"shortDescription"`
-					Price            string `json:"price"`
-				}{
+				Items: []ReqReceiptItem{
 					{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
 					{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},
 					{ShortDescription: "Knorr Creamy Chicken", Price: "1.26"},
@@ -115,20 +126,14 @@ func TestServiceProcessRecepit(t *testing.T) {
 			},
 			{
 				name: "Validation: should return error if item.shortDescription is empty",
-				inputFn: ReqProcessReceipt{Items: []struct {
-					ShortDescription string `json:"shortDescription"`
-					Price            string `json:"price"`
-				}{
+				inputFn: ReqProcessReceipt{Items: []ReqReceiptItem{
 					{},
 				}},
 				wantErr: ErrItemShortDescriptionEmpty,
 			},
 			{
 				name: "Validation: should return error if item.Price is empty",
-				inputFn: ReqProcessReceipt{Items: []struct {
-					ShortDescription string `json:"shortDescription"`
-					Price            string `json:"price"`
-				}{
+				inputFn: ReqProcessReceipt{Items: []ReqReceiptItem{
 					{},
 				}},
 				wantErr: ErrItemPriceEmpty,
@@ -144,6 +149,16 @@ func TestServiceProcessRecepit(t *testing.T) {
 				wantErr: ErrPurchaseTimeInvalid,
 			},
 
+			{
+				name:    "Validation: should return error when timezone is unknown",
+				inputFn: ReqProcessReceipt{Timezone: "Mars/Olympus_Mons"},
+				wantErr: ErrTimezoneInvalid,
+			},
+			{
+				name:    "Validation: should return error when timezone is the host's",
+				inputFn: ReqProcessReceipt{Timezone: "Local"},
+				wantErr: ErrTimezoneInvalid,
+			},
 			{
 				name:    "Validation: should return error when retailer is not alphanumeric",
 				inputFn: ReqProcessReceipt{Retailer: "Target&$^#/[]"},
@@ -156,20 +171,64 @@ func TestServiceProcessRecepit(t *testing.T) {
 			},
 			{
 				name: "Validation: should return error if item.shortDescription is invalid",
-				inputFn: ReqProcessReceipt{Items: []struct {
-					ShortDescription string `json:"shortDescription"`
-					Price            string `json:"price"`
-				}{
+				inputFn: ReqProcessReceipt{Items: []ReqReceiptItem{
 					{ShortDescription: "item1!@#"},
 				}},
 				wantErr: ErrItemShortDescriptionInvalid,
 			},
+			{
+				name:    "Validation: should return error if item.type is unknown",
+				inputFn: ReqProcessReceipt{Items: []ReqReceiptItem{{Type: "refund"}}},
+				wantErr: ErrItemTypeInvalid,
+			},
+			{
+				name:    "Validation: should return error if a sale line is negative",
+				inputFn: ReqProcessReceipt{Items: []ReqReceiptItem{{Price: "-1.00"}}},
+				wantErr: ErrItemPriceNegative,
+			},
+			{
+				name:    "Validation: should return error if a discount line is positive",
+				inputFn: ReqProcessReceipt{Items: []ReqReceiptItem{{Price: "1.00", Type: "discount"}}},
+				wantErr: ErrItemDiscountPositive,
+			},
+			{
+				name:    "Validation: should return error if item.quantity is zero",
+				inputFn: ReqProcessReceipt{Items: []ReqReceiptItem{{Price: "1.00", Quantity: "0"}}},
+				wantErr: ErrItemQuantityInvalid,
+			},
+			{
+				name:    "Validation: should return error if item.quantity is malformed",
+				inputFn: ReqProcessReceipt{Items: []ReqReceiptItem{{Price: "1.00", Quantity: "1.2345"}}},
+				wantErr: ErrItemQuantityInvalid,
+			},
+			{
+				name:    "Validation: should return error if item.unitPrice is invalid",
+				inputFn: ReqProcessReceipt{Items: []ReqReceiptItem{{Price: "1.00", UnitPrice: "1"}}},
+				wantErr: ErrItemUnitPriceInvalid,
+			},
+			{
+				name:    "Validation: should return error if price is not quantity times unit price",
+				inputFn: ReqProcessReceipt{Items: []ReqReceiptItem{{Price: "5.00", Quantity: "3", UnitPrice: "1.99"}}},
+				wantErr: ErrItemPriceMismatch,
+			},
+			{
+				name:    "Validation: should return error if currency is unknown",
+				inputFn: ReqProcessReceipt{Currency: "XYZ"},
+				wantErr: ErrCurrencyInvalid,
+			},
+			{
+				name:    "Validation: should return error if a JPY total has decimals",
+				inputFn: ReqProcessReceipt{Currency: "JPY", Total: "900.00"},
+				wantErr: ErrTotalInvalid,
+			},
+			{
+				name:    "Validation: should return error if a KWD price has two decimals",
+				inputFn: ReqProcessReceipt{Currency: "KWD", Items: []ReqReceiptItem{{Price: "1.25"}}},
+				wantErr: ErrItemPriceInvalid,
+			},
 			{
 				name: "Validation: should return error if item.Price is invalid",
-				inputFn: ReqProcessReceipt{Items: []struct {
-					ShortDescription string `json:"shortDescription"`
-					Price            string `json:"price"`
-				}{
+				inputFn: ReqProcessReceipt{Items: []ReqReceiptItem{
 					{Price: "343.343"},
 				}},
 				wantErr: ErrItemPriceInvalid,
@@ -208,6 +267,37 @@ func TestServiceGetPoints(t *testing.T) {
 		}
 	})
 
+	t.Run("GetPoints: should only return the client's own receipts", func(t *testing.T) {
+		service := NewService()
+		acme := auth.NewContext(context.Background(), auth.Principal{ClientID: "acme", Scopes: []auth.Scope{auth.ScopeSubmit, auth.ScopeRead}})
+		globex := auth.NewContext(context.Background(), auth.Principal{ClientID: "globex", Scopes: []auth.Scope{auth.ScopeRead}})
+		admin := auth.NewContext(context.Background(), auth.Principal{ClientID: "ops", Scopes: []auth.Scope{auth.ScopeAdmin}})
+
+		resp, err := service.ProcessReceipt(acme, ReqProcessReceipt{
+			Retailer:     "Target",
+			PurchaseDate: "2022-01-01",
+			PurchaseTime: "13:01",
+			Total:        "1.25",
+			Items:        []ReqReceiptItem{{ShortDescription: "Pepsi", Price: "1.25"}},
+		})
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if r, _ := service.store.GetReceipt(resp.Id); r.ClientID != "acme" {
+			t.Errorf("got client %q, want acme", r.ClientID)
+		}
+
+		req := ReqGetPoints{Id: resp.Id}
+		for _, ctx := range []context.Context{acme, admin, context.Background()} {
+			if _, err := service.GetPoints(ctx, req); err != nil {
+				t.Errorf("got %v, want nil", err)
+			}
+		}
+		if _, err := service.GetPoints(globex, req); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("got %v, want %v", err, models.ErrNotFound)
+		}
+	})
+
 	t.Run("GetPoints: sad valdiation path", func(t *testing.T) {
 		service := NewService()
 		tests := []struct {
@@ -242,3 +332,1186 @@ func TestServiceGetPoints(t *testing.T) {
 		}
 	})
 }
+
+func TestServiceSimulate(t *testing.T) {
+	service := NewService()
+	ctx := context.Background()
+
+	service.store.StoreReceipt(models.Receipt{Id: "a", Retailer: "Target", Total: 10.00})
+	service.store.StoreReceipt(models.Receipt{Id: "b", Retailer: "Target", Total: 10.10})
+
+	t.Run("Simulate: should compare stored receipts against the active rules", func(t *testing.T) {
+		resp, err := service.Simulate(ctx, ReqSimulate{
+			Candidate: rules.RuleSet{
+				Version: "v2",
+				Rules:   []rules.Definition{{Name: "flat", Expr: "10"}},
+			},
+		})
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+
+		if resp.BaseVersion != "default" || resp.Receipts != 2 {
+			t.Errorf("got %+v, want 2 receipts against the default rules", resp.Report)
+		}
+		if resp.CandidateTotal != 20 {
+			t.Errorf("got %v, want 20", resp.CandidateTotal)
+		}
+	})
+
+	t.Run("Simulate: should return invalid input for a bad candidate", func(t *testing.T) {
+		_, err := service.Simulate(ctx, ReqSimulate{Candidate: rules.RuleSet{}})
+		if !errors.Is(err, models.ErrInvalidInput) {
+			t.Errorf("Error not wrapped with ErrrInvalidInput: %v", err)
+		}
+		if !errors.Is(err, rules.ErrVersionEmpty) {
+			t.Errorf("got %v, want %v", err, rules.ErrVersionEmpty)
+		}
+	})
+}
+
+func TestConvertReqToReceiptTimezone(t *testing.T) {
+	tests := []struct {
+		name     string
+		date     string
+		time     string
+		timezone string
+		wantWall string
+		wantUTC  string
+	}{
+		{
+			name:     "Timezone: should default to UTC",
+			date:     "2022-03-20",
+			time:     "14:33",
+			wantWall: "2022-03-20 14:33 UTC",
+			wantUTC:  "2022-03-20 14:33",
+		},
+		{
+			name:     "Timezone: should keep the wall clock in the store's zone",
+			date:     "2022-03-20",
+			time:     "14:33",
+			timezone: "Asia/Tokyo",
+			wantWall: "2022-03-20 14:33 JST",
+			wantUTC:  "2022-03-20 05:33",
+		},
+		{
+			name:     "Timezone: should move a time skipped by spring forward ahead an hour",
+			date:     "2022-03-13",
+			time:     "02:30",
+			timezone: "America/New_York",
+			wantWall: "2022-03-13 03:30 EDT",
+			wantUTC:  "2022-03-13 07:30",
+		},
+		{
+			name:     "Timezone: should read a time repeated by fall back as the earlier instant",
+			date:     "2022-11-06",
+			time:     "01:30",
+			timezone: "America/New_York",
+			wantWall: "2022-11-06 01:30 EDT",
+			wantUTC:  "2022-11-06 05:30",
+		},
+		{
+			name:     "Timezone: should read the hour after fall back normally",
+			date:     "2022-11-06",
+			time:     "02:30",
+			timezone: "America/New_York",
+			wantWall: "2022-11-06 02:30 EST",
+			wantUTC:  "2022-11-06 07:30",
+		},
+		{
+			name:     "Timezone: should handle spring forward in Europe",
+			date:     "2022-03-27",
+			time:     "01:15",
+			timezone: "Europe/London",
+			wantWall: "2022-03-27 02:15 BST",
+			wantUTC:  "2022-03-27 01:15",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receipt, err := ConvertReqToReceiptTwo(ReqProcessReceipt{
+				PurchaseDate: tt.date,
+				PurchaseTime: tt.time,
+				Timezone:     tt.timezone,
+				Total:        "1.00",
+			})
+			if err != nil {
+				t.Fatalf("got %v, want nil", err)
+			}
+
+			if got := receipt.PurchasedAt.Format("2006-01-02 15:04 MST"); got != tt.wantWall {
+				t.Errorf("got %v, want %v", got, tt.wantWall)
+			}
+			if got := receipt.PurchasedAt.UTC().Format("2006-01-02 15:04"); got != tt.wantUTC {
+				t.Errorf("got %v UTC, want %v UTC", got, tt.wantUTC)
+			}
+		})
+	}
+}
+
+func TestServiceRetailerTimezone(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry := retailer.NewRegistry()
+	if err := registry.Put(retailer.Retailer{ID: "target", Name: "Target", Timezone: chicago.String()}); err != nil {
+		t.Fatal(err)
+	}
+	service := NewService(WithRetailers(registry))
+	ctx := context.Background()
+
+	req := ReqProcessReceipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "15:01",
+		Total:        "1.25",
+		Items: []ReqReceiptItem{
+			{ShortDescription: "Pepsi - 12-oz", Price: "1.25"},
+		},
+	}
+
+	t.Run("ProcessRecepit: should use the retailer's default timezone", func(t *testing.T) {
+		resp, err := service.ProcessReceipt(ctx, req)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+
+		r, err := service.store.GetReceipt(resp.Id)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if r.PurchasedAt.Location().String() != chicago.String() || r.PurchasedAt.Hour() != 15 {
+			t.Errorf("got %v, want 15:01 in America/Chicago", r.PurchasedAt)
+		}
+	})
+
+	t.Run("ProcessRecepit: should prefer the request's timezone", func(t *testing.T) {
+		req := req
+		req.Timezone = "America/Denver"
+
+		resp, err := service.ProcessReceipt(ctx, req)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+
+		r, err := service.store.GetReceipt(resp.Id)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if r.PurchasedAt.Location().String() != "America/Denver" {
+			t.Errorf("got %v, want America/Denver", r.PurchasedAt.Location())
+		}
+	})
+}
+
+func TestServiceRetailers(t *testing.T) {
+	service := NewService()
+	ctx := context.Background()
+
+	_, err := service.PutRetailer(ctx, ReqPutRetailer{Retailer: retailer.Retailer{
+		ID:       "target",
+		Name:     "Target",
+		Category: "general",
+		Timezone: "America/Chicago",
+	}})
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	t.Run("ProcessRecepit: should store the canonical retailer", func(t *testing.T) {
+		resp, err := service.ProcessReceipt(ctx, ReqProcessReceipt{
+			Retailer:     "TARGET Store 123",
+			PurchaseDate: "2022-01-01",
+			PurchaseTime: "13:01",
+			Total:        "1.25",
+			Items: []ReqReceiptItem{
+				{ShortDescription: "Pepsi - 12-oz", Price: "1.25"},
+			},
+		})
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+
+		r, err := service.store.GetReceipt(resp.Id)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if r.RetailerID != "target" {
+			t.Errorf("got %q, want target", r.RetailerID)
+		}
+		if r.Retailer != "TARGET Store 123" {
+			t.Errorf("got %q, want the submitted name to be kept", r.Retailer)
+		}
+		if r.PurchasedAt.Location().String() != "America/Chicago" {
+			t.Errorf("got %v, want the retailer's timezone", r.PurchasedAt.Location())
+		}
+	})
+
+	t.Run("PutRetailer: should return invalid input for a bad retailer", func(t *testing.T) {
+		_, err := service.PutRetailer(ctx, ReqPutRetailer{Retailer: retailer.Retailer{ID: "Bad Id"}})
+		if !errors.Is(err, models.ErrInvalidInput) {
+			t.Errorf("Error not wrapped with ErrrInvalidInput: %v", err)
+		}
+		if !errors.Is(err, retailer.ErrIdInvalid) {
+			t.Errorf("got %v, want %v", err, retailer.ErrIdInvalid)
+		}
+	})
+
+	t.Run("ResolveRetailer: should report the matched retailer", func(t *testing.T) {
+		resp, err := service.ResolveRetailer(ctx, ReqResolveRetailer{Name: "Target #7"})
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if resp.Normalized != "target" || resp.Retailer == nil || resp.Retailer.ID != "target" {
+			t.Errorf("got %+v, want target", resp)
+		}
+	})
+
+	t.Run("DeleteRetailer: should return not found for unknown retailers", func(t *testing.T) {
+		if _, err := service.DeleteRetailer(ctx, ReqDeleteRetailer{Id: "target"}); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+
+		_, err := service.GetRetailer(ctx, ReqGetRetailer{Id: "target"})
+		if !errors.Is(err, models.ErrRetailerNotFound) {
+			t.Errorf("got %v, want %v", err, models.ErrRetailerNotFound)
+		}
+	})
+}
+
+func TestServiceCatalog(t *testing.T) {
+	c, err := catalog.New([]catalog.Product{
+		{SKU: "BEV-001", Name: "Gatorade", Category: "beverages"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := NewService(WithCatalog(c))
+	ctx := context.Background()
+
+	resp, err := service.ProcessReceipt(ctx, ReqProcessReceipt{
+		Retailer:     "Walgreens",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "08:13",
+		Total:        "3.65",
+		Items: []ReqReceiptItem{
+			{ShortDescription: "Gatorade 32oz", Price: "2.25"},
+			{ShortDescription: "Dasani", Price: "1.40"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	r, err := service.store.GetReceipt(resp.Id)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	t.Run("ProcessRecepit: should categorize matching items", func(t *testing.T) {
+		if r.Items[0].SKU != "BEV-001" || r.Items[0].Category != "beverages" {
+			t.Errorf("got %+v, want BEV-001 beverages", r.Items[0])
+		}
+	})
+
+	t.Run("ProcessRecepit: should leave unmatched items uncategorized", func(t *testing.T) {
+		if r.Items[1].SKU != "" || r.Items[1].Category != "" {
+			t.Errorf("got %+v, want no category", r.Items[1])
+		}
+	})
+}
+
+func TestServiceItemLines(t *testing.T) {
+	req := ReqProcessReceipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "13:13",
+		Total:        "6.48",
+		Items: []ReqReceiptItem{
+			{ShortDescription: "Pepsi", Price: "5.97", Quantity: "3", UnitPrice: "1.99"},
+			{ShortDescription: "Bananas", Price: "1.26", Quantity: "2.1", UnitPrice: "0.60"},
+			{ShortDescription: "Coupon", Price: "-1.00", Type: "discount"},
+			{ShortDescription: "Bag fee", Price: "0.10", Type: "fee"},
+			{ShortDescription: "Sales tax", Price: "0.15", Type: "tax"},
+		},
+	}
+
+	t.Run("IsValid: should accept quantity, discount, fee and tax lines", func(t *testing.T) {
+		if err := req.IsValid(); err != nil {
+			t.Errorf("got %v, want nil", err)
+		}
+	})
+
+	t.Run("ConvertReqToReceiptTwo: should keep quantities and line types", func(t *testing.T) {
+		r, err := ConvertReqToReceiptTwo(req)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+
+		want := []models.Item{
+			{ShortDescription: "Pepsi", Price: 5.97, Quantity: 3, UnitPrice: 1.99, Type: models.LineSale},
+			{ShortDescription: "Bananas", Price: 1.26, Quantity: 2.1, UnitPrice: 0.60, Type: models.LineSale},
+			{ShortDescription: "Coupon", Price: -1.00, Quantity: 1, UnitPrice: -1.00, Type: models.LineDiscount},
+			{ShortDescription: "Bag fee", Price: 0.10, Quantity: 1, UnitPrice: 0.10, Type: models.LineFee},
+			{ShortDescription: "Sales tax", Price: 0.15, Quantity: 1, UnitPrice: 0.15, Type: models.LineTax},
+		}
+		for i := range want {
+			if r.Items[i] != want[i] {
+				t.Errorf("got %+v, want %+v", r.Items[i], want[i])
+			}
+		}
+	})
+
+	t.Run("ConvertReqToReceiptTwo: should derive the unit price from the quantity", func(t *testing.T) {
+		r, err := ConvertReqToReceiptTwo(ReqProcessReceipt{
+			PurchaseDate: "2022-01-02",
+			PurchaseTime: "13:13",
+			Total:        "3.00",
+			Items:        []ReqReceiptItem{{ShortDescription: "Pepsi", Price: "3.00", Quantity: "2"}},
+		})
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if r.Items[0].UnitPrice != 1.50 {
+			t.Errorf("got %v, want 1.50", r.Items[0].UnitPrice)
+		}
+	})
+}
+
+func TestServiceCurrency(t *testing.T) {
+	rates, err := currency.LoadRates(strings.NewReader(`{"version": "2024-06-01", "base": "USD", "rates": {"JPY": 0.0064}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := NewService(WithRates(rates))
+	ctx := context.Background()
+
+	req := ReqProcessReceipt{
+		Retailer:     "Lawson",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "08:13",
+		Total:        "1500",
+		Currency:     "jpy",
+		Items: []ReqReceiptItem{
+			{ShortDescription: "Onigiri", Price: "1500", Quantity: "3", UnitPrice: "500"},
+		},
+	}
+
+	t.Run("ProcessRecepit: should convert and score in yen", func(t *testing.T) {
+		resp, err := service.ProcessReceipt(ctx, req)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+
+		r, err := service.store.GetReceipt(resp.Id)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+
+		if r.Currency != "JPY" || r.ExchangeRate != 0.0064 || r.RateVersion != "2024-06-01" {
+			t.Errorf("got %v %v %v, want JPY at 0.0064 from 2024-06-01", r.Currency, r.ExchangeRate, r.RateVersion)
+		}
+
+		// 6 alphanumeric + 50 round (a multiple of 100 yen) + 25 quarter (a
+		// multiple of 25 yen) = 81; "Onigiri" is not a multiple of 3 characters.
+		if r.Points != 81 {
+			t.Errorf("got %v, want 81", r.Points)
+		}
+	})
+
+	t.Run("ProcessReceiptText: should parse amounts in yen", func(t *testing.T) {
+		resp, err := service.ProcessReceiptText(ctx, ReqProcessReceiptText{
+			Text:     "Lawson\n2022-01-02 08:13\nOnigiri 3 @ 500 1,500\nTOTAL ¥1,500",
+			Currency: "JPY",
+		})
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if resp.Parsed.Total.Value != "1500" {
+			t.Errorf("got %v, want 1500", resp.Parsed.Total.Value)
+		}
+	})
+
+	t.Run("ProcessRecepit: should reject currencies without a rate", func(t *testing.T) {
+		req := req
+		req.Currency = "EUR"
+		req.Total = "15.00"
+		req.Items = []ReqReceiptItem{{ShortDescription: "Croissant", Price: "15.00"}}
+
+		_, err := service.ProcessReceipt(ctx, req)
+		if !errors.Is(err, models.ErrInvalidInput) {
+			t.Errorf("Error not wrapped with ErrrInvalidInput: %v", err)
+		}
+		if !errors.Is(err, ErrCurrencyUnsupported) {
+			t.Errorf("got %v, want %v", err, ErrCurrencyUnsupported)
+		}
+	})
+}
+
+func TestServiceProcessReceiptText(t *testing.T) {
+	service := NewService()
+	ctx := context.Background()
+
+	t.Run("ProcessReceiptText: should score parsed text like a receipt", func(t *testing.T) {
+		resp, err := service.ProcessReceiptText(ctx, ReqProcessReceiptText{
+			Text: "M&M Corner Market\n03/20/2022 2:33 PM\nGatorade 2.25\nGatorade 2.25\nGatorade 2.25\nGatorade 2.25\nTOTAL 9.00",
+		})
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+
+		if resp.Parsed.Retailer.Value != "M&M Corner Market" || len(resp.Parsed.Items) != 4 {
+			t.Errorf("got %+v, want the parsed receipt", resp.Parsed)
+		}
+
+		r, err := service.store.GetReceipt(resp.Id)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if r.Points != 109 {
+			t.Errorf("got %v, want 109", r.Points)
+		}
+	})
+
+	t.Run("ProcessReceiptText: should validate what was parsed", func(t *testing.T) {
+		_, err := service.ProcessReceiptText(ctx, ReqProcessReceiptText{Text: "Corner Market\nTOTAL 9.00"})
+		if !errors.Is(err, models.ErrInvalidInput) {
+			t.Errorf("Error not wrapped with ErrrInvalidInput: %v", err)
+		}
+		if !errors.Is(err, ErrPurchaseDateEmpty) || !errors.Is(err, ErrItemsEmpty) {
+			t.Errorf("got %v, want %v and %v", err, ErrPurchaseDateEmpty, ErrItemsEmpty)
+		}
+	})
+
+	t.Run("ProcessReceiptText: should reject empty text", func(t *testing.T) {
+		_, err := service.ProcessReceiptText(ctx, ReqProcessReceiptText{})
+		if !errors.Is(err, models.ErrInvalidInput) || !errors.Is(err, parse.ErrEmptyText) {
+			t.Errorf("got %v, want %v", err, parse.ErrEmptyText)
+		}
+	})
+}
+
+func TestServiceProcessReceiptEmail(t *testing.T) {
+	service := NewService()
+	ctx := context.Background()
+
+	t.Run("ProcessReceiptEmail: should process a readable message", func(t *testing.T) {
+		resp, err := service.ProcessReceiptEmail(ctx, ReqProcessReceiptEmail{
+			Message: "Subject: Receipt\r\n\r\nM&M Corner Market\n2022-03-20 14:33\nGatorade 2.25\nGatorade 2.25\nGatorade 2.25\nGatorade 2.25\nTotal 9.00\n",
+		})
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if resp.Id == "" || resp.ReviewId != "" {
+			t.Fatalf("got %+v, want a receipt id", resp)
+		}
+
+		r, err := service.store.GetReceipt(resp.Id)
+		if err != nil || r.Points != 109 {
+			t.Errorf("got %v %v, want 109 points", r.Points, err)
+		}
+	})
+
+	t.Run("ProcessReceiptEmail: should queue unparseable messages for review", func(t *testing.T) {
+		message := "From: friend@example.com\r\nSubject: Lunch?\r\n\r\nAre you free tomorrow?\n"
+		resp, err := service.ProcessReceiptEmail(ctx, ReqProcessReceiptEmail{Message: message})
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if resp.Id != "" || resp.ReviewId == "" || !strings.Contains(resp.Reason, ErrItemsEmpty.Error()) {
+			t.Fatalf("got %+v, want a review with the validation error", resp)
+		}
+
+		review, err := service.GetReview(ctx, ReqGetReview{Id: resp.ReviewId})
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if review.Message != message || review.From != "friend@example.com" || review.Subject != "Lunch?" {
+			t.Errorf("got %+v, want the original message", review)
+		}
+
+		list, _ := service.ListReviews(ctx, ReqListReviews{})
+		if len(list.Reviews) != 1 {
+			t.Errorf("got %d reviews, want 1", len(list.Reviews))
+		}
+
+		if _, err := service.DeleteReview(ctx, ReqDeleteReview{Id: resp.ReviewId}); err != nil {
+			t.Errorf("got %v, want nil", err)
+		}
+		if _, err := service.GetReview(ctx, ReqGetReview{Id: resp.ReviewId}); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("got %v, want %v", err, models.ErrNotFound)
+		}
+	})
+
+	t.Run("ProcessReceiptEmail: should queue malformed messages for review", func(t *testing.T) {
+		resp, err := service.ProcessReceiptEmail(ctx, ReqProcessReceiptEmail{Message: "not a message"})
+		if err != nil || resp.ReviewId == "" {
+			t.Errorf("got %+v %v, want a review", resp, err)
+		}
+	})
+
+	t.Run("ProcessReceiptEmail: should reject an empty message", func(t *testing.T) {
+		_, err := service.ProcessReceiptEmail(ctx, ReqProcessReceiptEmail{})
+		if !errors.Is(err, models.ErrInvalidInput) || !errors.Is(err, ErrMessageEmpty) {
+			t.Errorf("got %v, want %v", err, ErrMessageEmpty)
+		}
+	})
+}
+
+func TestReviewQueue(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("ProcessReceiptEmail: should refuse messages once the queue is full", func(t *testing.T) {
+		service := NewService(WithReviews(NewReviewQueue(1)))
+		if _, err := service.ProcessReceiptEmail(ctx, ReqProcessReceiptEmail{Message: "not a message"}); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+
+		_, err := service.ProcessReceiptEmail(ctx, ReqProcessReceiptEmail{Message: "not a message"})
+		if !errors.Is(err, models.ErrQuotaExceeded) || !errors.Is(err, ErrReviewQueueFull) {
+			t.Errorf("got %v, want %v", err, ErrReviewQueueFull)
+		}
+	})
+
+	t.Run("Add: should truncate long messages at a character boundary", func(t *testing.T) {
+		q := NewReviewQueue(0)
+		message := "x" + strings.Repeat("é", MaxReviewMessageBytes)
+		if err := q.Add(Review{Id: "long", Message: message}); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+
+		r, _ := q.Get("long")
+		if !r.Truncated || len(r.Message) != MaxReviewMessageBytes-1 || !strings.HasPrefix(message, r.Message) {
+			t.Errorf("got %d bytes (truncated %v), want %d", len(r.Message), r.Truncated, MaxReviewMessageBytes-1)
+		}
+	})
+
+	t.Run("OpenFileReviewQueue: should replay queued and deleted reviews", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "reviews.jsonl")
+		q, err := OpenFileReviewQueue(path, 10)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		for _, id := range []string{"a", "b"} {
+			if err := q.Add(Review{Id: id, Reason: "unreadable", Message: "not a message"}); err != nil {
+				t.Fatalf("got %v, want nil", err)
+			}
+		}
+		if err := q.Delete("a"); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if err := q.Close(); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+
+		reopened, err := OpenFileReviewQueue(path, 10)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		defer reopened.Close()
+
+		list := reopened.List()
+		if len(list) != 1 || list[0].Id != "b" || list[0].Message != "not a message" {
+			t.Errorf("got %+v, want review b", list)
+		}
+	})
+}
+
+func TestServiceImportReceipts(t *testing.T) {
+	service := NewService()
+	ctx := context.Background()
+
+	csv := "receipt,retailer,purchaseDate,purchaseTime,total,shortDescription,price,quantity,unitPrice\n" +
+		"a,M&M Corner Market,2022-03-20,14:33,9.00,Gatorade,9.00,4,2.25\n" +
+		"b,Target,2022-01-01,13:01,7.00,Pepsi,6.49,,\n" +
+		"b,Target,2022-01-01,13:02,7.00,Chips!,0.51,,\n" +
+		"c,Walgreens,2022-13-01,08:13,1.00,Dasani,1.00,,\n"
+
+	resp, err := service.ImportReceipts(ctx, ReqImportReceipts{CSV: csv})
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	t.Run("ImportReceipts: should process valid receipts", func(t *testing.T) {
+		// 109 for the four Gatorades less the item pairs, since they are one line.
+		if len(resp.Imported) != 1 || resp.Imported[0].Key != "a" || resp.Imported[0].Points != 99 {
+			t.Errorf("got %+v, want receipt a with 99 points", resp.Imported)
+		}
+	})
+
+	t.Run("ImportReceipts: should report errors on the rows to fix", func(t *testing.T) {
+		want := []struct {
+			line int
+			err  error
+		}{
+			{4, ErrImportRowMismatch},
+			{4, ErrItemShortDescriptionInvalid},
+			{5, ErrPurchaseDateInvalid},
+		}
+		if len(resp.Errors) != 2 {
+			t.Fatalf("got %+v, want errors on lines 4 and 5", resp.Errors)
+		}
+		for _, w := range want {
+			found := false
+			for _, e := range resp.Errors {
+				if e.Line == w.line && errors.Is(e.Err, w.err) {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("got %+v, want %v on line %d", resp.Errors, w.err, w.line)
+			}
+		}
+	})
+
+	t.Run("ImportReceipts: should report receipts without reading them back", func(t *testing.T) {
+		service := NewService(WithStore(unreadableStore{NewMemoryStore()}))
+		resp, err := service.ImportReceipts(ctx, ReqImportReceipts{CSV: csv})
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if len(resp.Imported) != 1 || resp.Imported[0].Points != 99 || len(resp.Errors) != 2 {
+			t.Errorf("got %+v, want receipt a with 99 points and 2 errors", resp)
+		}
+	})
+
+	t.Run("ImportReceipts: should reject a file without the mapped columns", func(t *testing.T) {
+		_, err := service.ImportReceipts(ctx, ReqImportReceipts{CSV: "id,store\n1,Target\n"})
+		if !errors.Is(err, models.ErrInvalidInput) || !errors.Is(err, receiptcsv.ErrColumnMissing) {
+			t.Errorf("got %v, want %v", err, receiptcsv.ErrColumnMissing)
+		}
+	})
+
+	t.Run("ExportReceipts: should return receipts with their breakdown", func(t *testing.T) {
+		export, err := service.ExportReceipts(ctx, ReqExportReceipts{})
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if len(export.Receipts) != 1 {
+			t.Fatalf("got %d receipts, want 1", len(export.Receipts))
+		}
+
+		r := export.Receipts[0]
+		if r.RuleVersion != "default" || len(r.Breakdown) != 7 || points.Total(r.Breakdown) != r.Points {
+			t.Errorf("got %v %v, want the default rules adding up to %v", r.RuleVersion, r.Breakdown, r.Points)
+		}
+	})
+}
+
+// unreadableStore stores receipts but fails to read them.
+type unreadableStore struct {
+	Store
+}
+
+func (unreadableStore) GetReceipt(string) (models.Receipt, error) {
+	return models.Receipt{}, errors.New("disk unreadable")
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.jsonl")
+	ctx := context.Background()
+
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	service := NewService(WithStore(store))
+	if _, err := service.Ready(ctx, ReqReady{}); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	resp, err := service.ProcessReceipt(ctx, ReqProcessReceipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Total:        "1.25",
+		Timezone:     "America/Chicago",
+		Items:        []ReqReceiptItem{{ShortDescription: "Pepsi", Price: "1.25"}},
+	})
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	t.Run("Ready: should fail once the store is closed", func(t *testing.T) {
+		if _, err := service.Ready(ctx, ReqReady{}); !errors.Is(err, ErrStoreUnavailable) {
+			t.Errorf("got %v, want %v", err, ErrStoreUnavailable)
+		}
+	})
+
+	t.Run("OpenFileStore: should replay stored receipts", func(t *testing.T) {
+		reopened, err := OpenFileStore(path)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		defer reopened.Close()
+
+		points, err := NewService(WithStore(reopened)).GetPoints(ctx, ReqGetPoints{Id: resp.Id})
+		if err != nil || points.Points != 37 {
+			t.Errorf("got %v %v, want 37 points", points, err)
+		}
+
+		r, _ := reopened.GetReceipt(resp.Id)
+		if r.PurchasedAt.Hour() != 13 || len(r.Breakdown) != 7 {
+			t.Errorf("got %v %v, want the stored wall clock and breakdown", r.PurchasedAt, r.Breakdown)
+		}
+		if r.PurchasedAt.Location().String() != "America/Chicago" {
+			t.Errorf("got %v, want the receipt's time zone", r.PurchasedAt.Location())
+		}
+	})
+
+	t.Run("OpenFileStore: should truncate a torn last line", func(t *testing.T) {
+		torn := filepath.Join(t.TempDir(), "torn.jsonl")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(torn, append(data, `{"Id":"torn","Ret`...), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		store, err := OpenFileStore(torn)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		defer store.Close()
+		if store.CountReceipts() != 1 {
+			t.Errorf("got %d receipts, want 1", store.CountReceipts())
+		}
+		if got, _ := os.ReadFile(torn); string(got) != string(data) {
+			t.Errorf("got %q, want the complete lines", got)
+		}
+	})
+
+	t.Run("OpenFileStore: should keep a last line missing only its newline", func(t *testing.T) {
+		unterminated := filepath.Join(t.TempDir(), "unterminated.jsonl")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(unterminated, data[:len(data)-1], 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		store, err := OpenFileStore(unterminated)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		defer store.Close()
+		if got, _ := os.ReadFile(unterminated); string(got) != string(data) {
+			t.Errorf("got %q, want the line completed", got)
+		}
+	})
+
+	t.Run("OpenFileStore: should return error for a corrupt file", func(t *testing.T) {
+		corrupt := filepath.Join(t.TempDir(), "corrupt.jsonl")
+		if err := os.WriteFile(corrupt, []byte("{\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenFileStore(corrupt); err == nil {
+			t.Error("got nil, want error")
+		}
+	})
+}
+
+func TestServiceTenant(t *testing.T) {
+	req := ReqProcessReceipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Total:        "2.50",
+		Items: []ReqReceiptItem{
+			{ShortDescription: "Pepsi", Price: "1.25"},
+			{ShortDescription: "Pepsi", Price: "1.25"},
+		},
+	}
+	acme := tenant.NewContext(context.Background(), "acme")
+	globex := tenant.NewContext(context.Background(), "globex")
+
+	service := NewService(WithTenant(tenant.Tenant{ID: "acme", Limits: tenant.Limits{MaxReceipts: 1, MaxItems: 2}}))
+
+	resp, err := service.ProcessReceipt(acme, req)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	t.Run("ProcessReceipt: should record the tenant", func(t *testing.T) {
+		if r, _ := service.store.GetReceipt(resp.Id); r.TenantID != "acme" {
+			t.Errorf("got tenant %q, want acme", r.TenantID)
+		}
+	})
+
+	t.Run("GetPoints: should hide receipts from other tenants", func(t *testing.T) {
+		if _, err := service.GetPoints(acme, ReqGetPoints{Id: resp.Id}); err != nil {
+			t.Errorf("got %v, want nil", err)
+		}
+		for _, ctx := range []context.Context{globex, context.Background()} {
+			if _, err := service.GetPoints(ctx, ReqGetPoints{Id: resp.Id}); !errors.Is(err, models.ErrNotFound) {
+				t.Errorf("got %v, want %v", err, models.ErrNotFound)
+			}
+		}
+	})
+
+	t.Run("ExportReceipts: should export nothing for other tenants", func(t *testing.T) {
+		got, err := service.ExportReceipts(globex, ReqExportReceipts{})
+		if err != nil || len(got.Receipts) != 0 {
+			t.Errorf("got %v %v, want no receipts", got, err)
+		}
+	})
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		items   int
+		wantErr error
+	}{
+		{name: "ProcessReceipt: should return error for another tenant", ctx: globex, items: 1, wantErr: ErrTenantMismatch},
+		{name: "ProcessReceipt: should return error over the item limit", ctx: acme, items: 3, wantErr: ErrItemLimit},
+		{name: "ProcessReceipt: should return error over the receipt limit", ctx: acme, items: 1, wantErr: models.ErrQuotaExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := req
+			r.Items = make([]ReqReceiptItem, tt.items)
+			for i := range r.Items {
+				r.Items[i] = ReqReceiptItem{ShortDescription: "Pepsi", Price: "1.00"}
+			}
+			r.Total = fmt.Sprintf("%d.00", tt.items)
+			if _, err := service.ProcessReceipt(tt.ctx, r); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("ProcessReceipt: should keep concurrent submissions within the receipt limit", func(t *testing.T) {
+		service := NewService(WithTenant(tenant.Tenant{ID: "acme", Limits: tenant.Limits{MaxReceipts: 3}}))
+
+		var wg sync.WaitGroup
+		for range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				service.ProcessReceipt(acme, req)
+			}()
+		}
+		wg.Wait()
+
+		if got := service.store.CountReceipts(); got != 3 {
+			t.Errorf("got %d receipts, want 3", got)
+		}
+	})
+}
+
+func TestServiceDailyQuota(t *testing.T) {
+	req := ReqProcessReceipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Total:        "1.25",
+		Items:        []ReqReceiptItem{{ShortDescription: "Pepsi", Price: "1.25"}},
+	}
+	client := auth.NewContext(context.Background(), auth.Principal{ClientID: "acme"})
+	alice := auth.NewContext(context.Background(), auth.Principal{ClientID: "acme", UserID: "alice"})
+	bob := auth.NewContext(context.Background(), auth.Principal{ClientID: "acme", UserID: "bob"})
+
+	service := NewService(WithDailyQuota(1, ratelimit.NewMemoryStore()))
+
+	invalid := req
+	invalid.Retailer = ""
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		req     ReqProcessReceipt
+		wantErr error
+	}{
+		{name: "ProcessReceipt: should not count invalid receipts", ctx: client, req: invalid, wantErr: models.ErrInvalidInput},
+		{name: "ProcessReceipt: should allow a client's first receipt", ctx: client, req: req},
+		{name: "ProcessReceipt: should return error over a client's quota", ctx: client, req: req, wantErr: ErrDailyQuota},
+		{name: "ProcessReceipt: should count users apart from their client", ctx: alice, req: req},
+		{name: "ProcessReceipt: should return error over a user's quota", ctx: alice, req: req, wantErr: models.ErrQuotaExceeded},
+		{name: "ProcessReceipt: should count each user apart", ctx: bob, req: req},
+		{name: "ProcessReceipt: should not limit unauthenticated requests", ctx: context.Background(), req: req},
+		{name: "ProcessReceipt: should still not limit unauthenticated requests", ctx: context.Background(), req: req},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.ProcessReceipt(tt.ctx, tt.req); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("ProcessReceipt: should prefer the tenant's quota", func(t *testing.T) {
+		acme := tenant.NewContext(alice, "acme")
+		service := NewService(
+			WithDailyQuota(1, ratelimit.NewMemoryStore()),
+			WithTenant(tenant.Tenant{ID: "acme", Limits: tenant.Limits{DailyReceipts: 2}}),
+		)
+		for range 2 {
+			if _, err := service.ProcessReceipt(acme, req); err != nil {
+				t.Fatalf("got %v, want nil", err)
+			}
+		}
+		if _, err := service.ProcessReceipt(acme, req); !errors.Is(err, ErrDailyQuota) {
+			t.Errorf("got %v, want %v", err, ErrDailyQuota)
+		}
+	})
+
+	t.Run("ProcessReceipt: should not spend the quota on a receipt that is not stored", func(t *testing.T) {
+		store := &flakyStore{Store: NewMemoryStore(), fail: true}
+		service := NewService(WithDailyQuota(1, ratelimit.NewMemoryStore()), WithStore(store))
+		if _, err := service.ProcessReceipt(alice, req); err == nil {
+			t.Fatal("got nil, want error")
+		}
+
+		store.fail = false
+		if _, err := service.ProcessReceipt(alice, req); err != nil {
+			t.Errorf("got %v, want nil", err)
+		}
+	})
+
+	t.Run("ProcessReceipt: should allow receipts when the counter store fails", func(t *testing.T) {
+		service := NewService(WithDailyQuota(1, failingCounters{ratelimit.NewMemoryStore()}))
+		for range 2 {
+			if _, err := service.ProcessReceipt(alice, req); err != nil {
+				t.Errorf("got %v, want nil", err)
+			}
+		}
+	})
+}
+
+// flakyStore fails to add receipts while fail is set.
+type flakyStore struct {
+	Store
+	fail bool
+}
+
+func (s *flakyStore) AddReceipt(r models.Receipt, max int) error {
+	if s.fail {
+		return errors.New("disk full")
+	}
+	return s.Store.AddReceipt(r, max)
+}
+
+// failingCounters fails to count.
+type failingCounters struct {
+	ratelimit.Store
+}
+
+func (failingCounters) Incr(context.Context, string, time.Time, time.Time) (int, error) {
+	return 0, errors.New("counter store unavailable")
+}
+
+func TestServiceAudit(t *testing.T) {
+	req := ReqProcessReceipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Total:        "1.25",
+		Items:        []ReqReceiptItem{{ShortDescription: "Pepsi", Price: "1.25"}},
+	}
+	alice := auth.NewContext(context.Background(), auth.Principal{ClientID: "acme", UserID: "alice"})
+
+	t.Run("ProcessReceipt: should not store a receipt or spend the quota when it cannot be recorded", func(t *testing.T) {
+		closed, err := audit.OpenFile(filepath.Join(t.TempDir(), "audit.jsonl"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		closed.Close()
+
+		counters, store := ratelimit.NewMemoryStore(), NewMemoryStore()
+		service := NewService(WithDailyQuota(1, counters), WithStore(store), WithAudit(closed))
+		if _, err := service.ProcessReceipt(alice, req); err == nil {
+			t.Fatal("got nil, want error")
+		}
+		if n := store.CountReceipts(); n != 0 {
+			t.Errorf("got %d receipts, want 0", n)
+		}
+
+		service = NewService(WithDailyQuota(1, counters), WithStore(store))
+		if _, err := service.ProcessReceipt(alice, req); err != nil {
+			t.Errorf("got %v, want nil", err)
+		}
+	})
+
+	t.Run("ProcessReceipt: should record that a receipt was not stored", func(t *testing.T) {
+		log := audit.NewLog()
+		service := NewService(WithStore(&flakyStore{Store: NewMemoryStore(), fail: true}), WithAudit(log))
+		if _, err := service.ProcessReceipt(alice, req); err == nil {
+			t.Fatal("got nil, want error")
+		}
+
+		entries := log.Query(audit.Filter{})
+		if len(entries) != 2 {
+			t.Fatalf("got %d entries, want 2", len(entries))
+		}
+		submit, abort := entries[0], entries[1]
+		if submit.Action != AuditReceiptSubmit || abort.Action != AuditAbort || abort.Target != submit.Target ||
+			abort.After["action"] != AuditReceiptSubmit || abort.After["seq"] != "1" || abort.Actor != "user:alice" {
+			t.Errorf("got %+v then %+v, want a submit then its abort", submit, abort)
+		}
+	})
+
+	t.Run("CreateRuleSet: should not record a rule set that is refused", func(t *testing.T) {
+		log := audit.NewLog()
+		service := NewService(WithAudit(log))
+		for _, rs := range []rules.RuleSet{
+			{Version: "default", Rules: []rules.Definition{{Name: "alphanumeric", Builtin: "alphanumeric"}}},
+			{Version: "bad", Rules: []rules.Definition{{Name: "x", Builtin: "unknown"}}},
+		} {
+			if _, err := service.CreateRuleSet(context.Background(), ReqCreateRuleSet{RuleSet: rs}); err == nil {
+				t.Errorf("%s: got nil, want error", rs.Version)
+			}
+		}
+		if _, n := log.Head(); n != 0 {
+			t.Errorf("got %d entries, want 0", n)
+		}
+	})
+}
+
+func TestServiceAdjustPoints(t *testing.T) {
+	req := ReqProcessReceipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Total:        "1.25",
+		Items:        []ReqReceiptItem{{ShortDescription: "Pepsi", Price: "1.25"}},
+	}
+	alice := auth.NewContext(context.Background(), auth.Principal{ClientID: "acme", UserID: "alice"})
+	client := auth.NewContext(context.Background(), auth.Principal{ClientID: "acme"})
+
+	ledger := NewMemoryLedger()
+	service := NewService(WithLedger(ledger))
+
+	aliceReceipt, err := service.ProcessReceipt(alice, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientReceipt, err := service.ProcessReceipt(client, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calculated, err := service.GetPoints(alice, ReqGetPoints{Id: aliceReceipt.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	earned := calculated.Points
+
+	tests := []struct {
+		name        string
+		req         ReqAdjustPoints
+		wantErr     error
+		wantUser    string
+		wantBalance int64
+	}{
+		{name: "AdjustPoints: should return error for zero points", req: ReqAdjustPoints{UserID: "alice", Reason: models.ReasonGoodwill, AgentID: "agent-7"}, wantErr: ErrAdjustmentZero},
+		{name: "AdjustPoints: should return error for an unknown reason", req: ReqAdjustPoints{UserID: "alice", Points: 10, Reason: "bored", AgentID: "agent-7"}, wantErr: ErrAdjustmentReason},
+		{name: "AdjustPoints: should return error without an agent", req: ReqAdjustPoints{UserID: "alice", Points: 10, Reason: models.ReasonGoodwill}, wantErr: ErrAdjustmentAgentEmpty},
+		{name: "AdjustPoints: should return error without a user or receipt", req: ReqAdjustPoints{Points: 10, Reason: models.ReasonGoodwill, AgentID: "agent-7"}, wantErr: ErrAdjustmentUserEmpty},
+		{name: "AdjustPoints: should return error for an unknown receipt", req: ReqAdjustPoints{ReceiptID: "7fb1377b-b223-49d9-a31a-5a02701dd310", Points: 10, Reason: models.ReasonGoodwill, AgentID: "agent-7"}, wantErr: models.ErrNotFound},
+		{name: "AdjustPoints: should return error for a receipt of another user", req: ReqAdjustPoints{UserID: "bob", ReceiptID: aliceReceipt.Id, Points: 10, Reason: models.ReasonGoodwill, AgentID: "agent-7"}, wantErr: ErrAdjustmentUserMismatch},
+		{name: "AdjustPoints: should return error for a receipt without a user", req: ReqAdjustPoints{ReceiptID: clientReceipt.Id, Points: 10, Reason: models.ReasonGoodwill, AgentID: "agent-7"}, wantErr: ErrAdjustmentReceiptUser},
+		{name: "AdjustPoints: should return error crediting a client's receipt to a named user", req: ReqAdjustPoints{UserID: "bob", ReceiptID: clientReceipt.Id, Points: 5, Reason: models.ReasonMissingPoints, AgentID: "agent-7"}, wantErr: ErrAdjustmentReceiptUser},
+		{name: "AdjustPoints: should grant goodwill points", req: ReqAdjustPoints{UserID: "alice", Points: 50, Reason: models.ReasonGoodwill, AgentID: "agent-7"}, wantUser: "alice", wantBalance: earned + 50},
+		{name: "AdjustPoints: should take back a receipt's points for its user", req: ReqAdjustPoints{ReceiptID: aliceReceipt.Id, Points: -earned, Reason: models.ReasonBadReceipt, AgentID: "agent-7"}, wantUser: "alice", wantBalance: 50},
+		{name: "AdjustPoints: should return error taking back more than the receipt has", req: ReqAdjustPoints{ReceiptID: aliceReceipt.Id, Points: -1, Reason: models.ReasonFraud, AgentID: "agent-7"}, wantErr: ErrAdjustmentExceeds},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := service.AdjustPoints(context.Background(), tt.req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if resp.UserID != tt.wantUser || resp.Balance != tt.wantBalance {
+				t.Errorf("got %s with %d, want %s with %d", resp.UserID, resp.Balance, tt.wantUser, tt.wantBalance)
+			}
+		})
+	}
+
+	t.Run("AdjustPoints: should take the agent from the caller", func(t *testing.T) {
+		support := auth.NewContext(context.Background(), auth.Principal{ClientID: "support"})
+
+		if _, err := service.AdjustPoints(support, ReqAdjustPoints{UserID: "carol", Points: 5, Reason: models.ReasonGoodwill, AgentID: "agent-7"}); !errors.Is(err, ErrAdjustmentAgentInvalid) || !errors.Is(err, models.ErrForbidden) {
+			t.Errorf("got %v, want %v", err, ErrAdjustmentAgentInvalid)
+		}
+		for _, agent := range []string{"", "client:support"} {
+			resp, err := service.AdjustPoints(support, ReqAdjustPoints{UserID: "carol", Points: 5, Reason: models.ReasonGoodwill, AgentID: agent})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.AgentID != "client:support" {
+				t.Errorf("got agent %q, want client:support", resp.AgentID)
+			}
+		}
+	})
+
+	t.Run("GetBreakdown: should show adjustments apart from the calculated points", func(t *testing.T) {
+		resp, err := service.GetBreakdown(alice, ReqGetBreakdown{Id: aliceReceipt.Id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Points != earned || resp.AdjustedPoints != 0 || len(resp.Adjustments) != 1 || len(resp.Rules) == 0 {
+			t.Errorf("got %+v, want %d points adjusted to 0", resp, earned)
+		}
+		if got, _ := service.GetPoints(alice, ReqGetPoints{Id: aliceReceipt.Id}); got.Points != earned {
+			t.Errorf("got %d points, want the calculated %d", got.Points, earned)
+		}
+	})
+
+	t.Run("GetLedger: should list receipts and adjustments", func(t *testing.T) {
+		resp, err := service.GetLedger(context.Background(), ReqGetLedger{UserID: "alice"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Balance != 50 || len(resp.Entries) != 3 || resp.Entries[0].ReceiptID != aliceReceipt.Id {
+			t.Errorf("got %+v, want a balance of 50 from 3 entries", resp)
+		}
+	})
+
+	t.Run("ListAudit: should record who adjusted a balance", func(t *testing.T) {
+		resp, err := service.ListAudit(context.Background(), ReqListAudit{Action: AuditPointsAdjust, Target: "user/alice"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Entries) != 2 || resp.Entries[1].After["reason"] != "bad_receipt" || resp.Entries[1].Before["balance"] != fmt.Sprint(earned+50) {
+			t.Errorf("got %+v, want 2 adjustments of alice's balance", resp.Entries)
+		}
+	})
+
+	t.Run("OpenFileLedger: should replay adjustments", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "adjustments.jsonl")
+		file, err := OpenFileLedger(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range ledger.ListAdjustments() {
+			if err := file.AddAdjustment(a); err != nil {
+				t.Fatal(err)
+			}
+		}
+		file.Close()
+
+		reopened, err := OpenFileLedger(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reopened.Close()
+		if got, want := len(reopened.ListAdjustments()), len(ledger.ListAdjustments()); got != want {
+			t.Errorf("got %d adjustments, want %d", got, want)
+		}
+	})
+}
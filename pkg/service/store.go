@@ -0,0 +1,107 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/jsonl"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+)
+
+// FileStore keeps receipts in memory and appends each one to a JSON lines
+// file, which is replayed when the store is opened. A later line for the same
+// receipt replaces the earlier one, and a torn last line left by a crash is
+// dropped.
+type FileStore struct {
+	mu     sync.Mutex
+	memory *RecepitStore
+	file   *os.File
+}
+
+func OpenFileStore(path string) (*FileStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{memory: NewMemoryStore(), file: f}
+
+	err = jsonl.Replay(f, path, func(line []byte) error {
+		var r models.Receipt
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		r.PurchasedAt = inZone(r.PurchasedAt, r.Timezone)
+		return s.memory.StoreReceipt(r)
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// inZone returns t in the named zone. JSON keeps only t's offset, so the zone
+// is restored to get daylight saving right for times derived from t. Without
+// the zone t keeps its offset, which still has the receipt's wall clock.
+func inZone(t time.Time, name string) time.Time {
+	if name == "" {
+		return t
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return t
+	}
+	return t.In(loc)
+}
+
+func (s *FileStore) StoreReceipt(r models.Receipt) error {
+	return s.AddReceipt(r, 0)
+}
+
+// AddReceipt holds the write lock across the count, so no other write can
+// slip in between the check and the append.
+func (s *FileStore) AddReceipt(r models.Receipt, max int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if max > 0 && s.memory.CountReceipts() >= max {
+		return ErrReceiptLimit
+	}
+	if err := jsonl.Append(s.file, r); err != nil {
+		return err
+	}
+
+	return s.memory.StoreReceipt(r)
+}
+
+func (s *FileStore) GetReceipt(id string) (models.Receipt, error) {
+	return s.memory.GetReceipt(id)
+}
+
+func (s *FileStore) ListReceipts() []models.Receipt {
+	return s.memory.ListReceipts()
+}
+
+func (s *FileStore) CountReceipts() int {
+	return s.memory.CountReceipts()
+}
+
+// Ping reports whether the file is still open.
+func (s *FileStore) Ping() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.file.Stat()
+	return err
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
@@ -0,0 +1,251 @@
+// Package simulate replays receipts through two rule sets and reports how the
+// candidate rule set would change the points awarded.
+package simulate
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/rules"
+)
+
+// DefaultTop is the number of most changed receipts a report lists.
+const DefaultTop = 10
+
+// bucketEdges are the upper bounds of the delta histogram buckets. A delta d
+// falls in the first bucket whose edge is >= d; the last bucket is open ended.
+var bucketEdges = []int64{-101, -51, -11, -1, 0, 10, 50, 100}
+
+type Bucket struct {
+	// Min and Max bound the bucket inclusively. Nil means unbounded.
+	Min   *int64 `json:"min"`
+	Max   *int64 `json:"max"`
+	Count int    `json:"count"`
+}
+
+func (b Bucket) String() string {
+	switch {
+	case b.Min == nil:
+		return fmt.Sprintf("<= %d", *b.Max)
+	case b.Max == nil:
+		return fmt.Sprintf(">= %d", *b.Min)
+	case *b.Min == *b.Max:
+		return fmt.Sprintf("%d", *b.Min)
+	}
+	return fmt.Sprintf("%d to %d", *b.Min, *b.Max)
+}
+
+type ReceiptDelta struct {
+	Id        string `json:"id"`
+	Retailer  string `json:"retailer"`
+	Base      int64  `json:"base"`
+	Candidate int64  `json:"candidate"`
+	Delta     int64  `json:"delta"`
+}
+
+type RuleTotal struct {
+	Name      string `json:"name"`
+	Base      int64  `json:"base"`
+	Candidate int64  `json:"candidate"`
+	Delta     int64  `json:"delta"`
+}
+
+type Report struct {
+	BaseVersion      string `json:"baseVersion"`
+	CandidateVersion string `json:"candidateVersion"`
+
+	Receipts       int   `json:"receipts"`
+	Changed        int   `json:"changed"`
+	BaseTotal      int64 `json:"baseTotal"`
+	CandidateTotal int64 `json:"candidateTotal"`
+
+	MeanDelta float64 `json:"meanDelta"`
+	MinDelta  int64   `json:"minDelta"`
+	MaxDelta  int64   `json:"maxDelta"`
+	P50Delta  int64   `json:"p50Delta"`
+	P90Delta  int64   `json:"p90Delta"`
+	P99Delta  int64   `json:"p99Delta"`
+
+	Distribution []Bucket       `json:"distribution"`
+	TopChanges   []ReceiptDelta `json:"topChanges"`
+
+	// Rules lists every rule in either set: base rules first, in order,
+	// followed by rules only the candidate has.
+	Rules []RuleTotal `json:"rules"`
+}
+
+// Run scores every receipt with both rule sets. top limits the number of most
+// changed receipts reported; zero or less uses DefaultTop.
+func Run(receipts []models.Receipt, base, candidate *rules.Compiled, top int) Report {
+	if top <= 0 {
+		top = DefaultTop
+	}
+
+	report := Report{
+		BaseVersion:      base.Version,
+		CandidateVersion: candidate.Version,
+		Receipts:         len(receipts),
+		Distribution:     newBuckets(),
+		TopChanges:       []ReceiptDelta{},
+	}
+
+	ruleIndex := map[string]int{}
+	ruleTotal := func(name string) *RuleTotal {
+		i, ok := ruleIndex[name]
+		if !ok {
+			i = len(report.Rules)
+			ruleIndex[name] = i
+			report.Rules = append(report.Rules, RuleTotal{Name: name})
+		}
+		return &report.Rules[i]
+	}
+	for _, rule := range base.Rules {
+		ruleTotal(rule.Name)
+	}
+	for _, rule := range candidate.Rules {
+		ruleTotal(rule.Name)
+	}
+
+	deltas := make([]ReceiptDelta, 0, len(receipts))
+
+	for _, r := range receipts {
+		var basePoints, candidatePoints int64
+
+		for _, result := range base.Breakdown(r) {
+			ruleTotal(result.Name).Base += result.Points
+			basePoints += result.Points
+		}
+		for _, result := range candidate.Breakdown(r) {
+			ruleTotal(result.Name).Candidate += result.Points
+			candidatePoints += result.Points
+		}
+
+		d := ReceiptDelta{
+			Id:        r.Id,
+			Retailer:  r.Retailer,
+			Base:      basePoints,
+			Candidate: candidatePoints,
+			Delta:     candidatePoints - basePoints,
+		}
+		deltas = append(deltas, d)
+
+		report.BaseTotal += basePoints
+		report.CandidateTotal += candidatePoints
+		if d.Delta != 0 {
+			report.Changed++
+		}
+		report.Distribution[bucketFor(d.Delta)].Count++
+	}
+
+	for i := range report.Rules {
+		report.Rules[i].Delta = report.Rules[i].Candidate - report.Rules[i].Base
+	}
+
+	if len(deltas) == 0 {
+		return report
+	}
+
+	slices.SortFunc(deltas, func(a, b ReceiptDelta) int {
+		return cmp.Compare(a.Delta, b.Delta)
+	})
+
+	report.MeanDelta = float64(report.CandidateTotal-report.BaseTotal) / float64(len(deltas))
+	report.MinDelta = deltas[0].Delta
+	report.MaxDelta = deltas[len(deltas)-1].Delta
+	report.P50Delta = percentile(deltas, 0.50)
+	report.P90Delta = percentile(deltas, 0.90)
+	report.P99Delta = percentile(deltas, 0.99)
+
+	// Most changed first, by magnitude; ties keep a stable order by id.
+	slices.SortStableFunc(deltas, func(a, b ReceiptDelta) int {
+		if c := cmp.Compare(abs(b.Delta), abs(a.Delta)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Id, b.Id)
+	})
+	for _, d := range deltas {
+		if len(report.TopChanges) == top || d.Delta == 0 {
+			break
+		}
+		report.TopChanges = append(report.TopChanges, d)
+	}
+
+	return report
+}
+
+func newBuckets() []Bucket {
+	buckets := make([]Bucket, 0, len(bucketEdges)+1)
+
+	var lo *int64
+	for _, edge := range bucketEdges {
+		hi := edge
+		buckets = append(buckets, Bucket{Min: lo, Max: &hi})
+
+		next := edge + 1
+		lo = &next
+	}
+
+	return append(buckets, Bucket{Min: lo})
+}
+
+func bucketFor(delta int64) int {
+	for i, edge := range bucketEdges {
+		if delta <= edge {
+			return i
+		}
+	}
+	return len(bucketEdges)
+}
+
+// percentile uses the nearest-rank method on deltas sorted ascending.
+func percentile(sorted []ReceiptDelta, p float64) int64 {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1].Delta
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// WriteText renders the report as aligned plain text.
+func (r Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Base rules:\t%s\t\n", r.BaseVersion)
+	fmt.Fprintf(tw, "Candidate rules:\t%s\t\n", r.CandidateVersion)
+	fmt.Fprintf(tw, "Receipts:\t%d\t\n", r.Receipts)
+	fmt.Fprintf(tw, "Changed:\t%d\t\n", r.Changed)
+	fmt.Fprintf(tw, "Total points:\t%d -> %d (%+d)\t\n", r.BaseTotal, r.CandidateTotal, r.CandidateTotal-r.BaseTotal)
+	fmt.Fprintf(tw, "Delta mean/min/max:\t%.2f / %d / %d\t\n", r.MeanDelta, r.MinDelta, r.MaxDelta)
+	fmt.Fprintf(tw, "Delta p50/p90/p99:\t%d / %d / %d\t\n", r.P50Delta, r.P90Delta, r.P99Delta)
+
+	fmt.Fprintf(tw, "\nDelta\tReceipts\t\n")
+	for _, b := range r.Distribution {
+		fmt.Fprintf(tw, "%s\t%d\t\n", b, b.Count)
+	}
+
+	fmt.Fprintf(tw, "\nRule\tBase\tCandidate\tDelta\t\n")
+	for _, rule := range r.Rules {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%+d\t\n", rule.Name, rule.Base, rule.Candidate, rule.Delta)
+	}
+
+	if len(r.TopChanges) > 0 {
+		fmt.Fprintf(tw, "\nReceipt\tRetailer\tBase\tCandidate\tDelta\t\n")
+		for _, d := range r.TopChanges {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%+d\t\n", d.Id, d.Retailer, d.Base, d.Candidate, d.Delta)
+		}
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,109 @@
+package simulate
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/FourSigma/receipt-processor-challenge/pkg/models"
+	"github.com/FourSigma/receipt-processor-challenge/pkg/rules"
+)
+
+func TestRun(t *testing.T) {
+	base := rules.MustCompile(rules.RuleSet{
+		Version: "v1",
+		Rules: []rules.Definition{
+			{Name: "alphanumeric", Builtin: "alphanumeric"},
+			{Name: "round-dollar", Builtin: "round-dollar"},
+		},
+	})
+
+	candidate := rules.MustCompile(rules.RuleSet{
+		Version: "v2",
+		Rules: []rules.Definition{
+			{Name: "alphanumeric", Builtin: "alphanumeric"},
+			{Name: "big-total", Expr: "total > 100 ? 200 : 0"},
+		},
+	})
+
+	receipts := []models.Receipt{
+		{Id: "a", Retailer: "Target", Total: 10.00},  // 56 -> 6
+		{Id: "b", Retailer: "Target", Total: 10.50},  // 6 -> 6
+		{Id: "c", Retailer: "Target", Total: 150.00}, // 56 -> 206
+		{Id: "d", Retailer: "Target", Total: 120.25}, // 6 -> 206
+	}
+
+	report := Run(receipts, base, candidate, 2)
+
+	t.Run("Run: should total points for both rule sets", func(t *testing.T) {
+		if report.BaseTotal != 124 || report.CandidateTotal != 424 {
+			t.Errorf("got %v -> %v, want 124 -> 424", report.BaseTotal, report.CandidateTotal)
+		}
+		if report.Receipts != 4 || report.Changed != 3 {
+			t.Errorf("got %v receipts, %v changed, want 4 and 3", report.Receipts, report.Changed)
+		}
+		if report.MinDelta != -50 || report.MaxDelta != 200 || report.P50Delta != 0 {
+			t.Errorf("got min %v, max %v, p50 %v, want -50, 200, 0", report.MinDelta, report.MaxDelta, report.P50Delta)
+		}
+	})
+
+	t.Run("Run: should bucket the deltas", func(t *testing.T) {
+		counts := map[string]int{}
+		for _, b := range report.Distribution {
+			counts[b.String()] = b.Count
+		}
+
+		want := map[string]int{"-50 to -11": 1, "0": 1, "51 to 100": 0, ">= 101": 2}
+		for bucket, count := range want {
+			if counts[bucket] != count {
+				t.Errorf("bucket %s: got %v, want %v", bucket, counts[bucket], count)
+			}
+		}
+	})
+
+	t.Run("Run: should list the most changed receipts first", func(t *testing.T) {
+		if len(report.TopChanges) != 2 {
+			t.Fatalf("got %v, want 2 changes", report.TopChanges)
+		}
+		if report.TopChanges[0].Id != "d" || report.TopChanges[1].Id != "c" {
+			t.Errorf("got %v, want d then c", report.TopChanges)
+		}
+	})
+
+	t.Run("Run: should total points per rule across both sets", func(t *testing.T) {
+		want := []RuleTotal{
+			{Name: "alphanumeric", Base: 24, Candidate: 24, Delta: 0},
+			{Name: "round-dollar", Base: 100, Candidate: 0, Delta: -100},
+			{Name: "big-total", Base: 0, Candidate: 400, Delta: 400},
+		}
+		if len(report.Rules) != len(want) {
+			t.Fatalf("got %v, want %v", report.Rules, want)
+		}
+		for i := range want {
+			if report.Rules[i] != want[i] {
+				t.Errorf("got %v, want %v", report.Rules[i], want[i])
+			}
+		}
+	})
+
+	t.Run("WriteText: should render every section", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := report.WriteText(&buf); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		for _, want := range []string{"Candidate rules:", "big-total", ">= 101"} {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("got %q, want it to contain %q", buf.String(), want)
+			}
+		}
+	})
+}
+
+func TestRunEmpty(t *testing.T) {
+	c := rules.MustCompile(rules.Default())
+
+	report := Run(nil, c, c, 0)
+	if report.Receipts != 0 || len(report.TopChanges) != 0 {
+		t.Errorf("got %+v, want an empty report", report)
+	}
+}
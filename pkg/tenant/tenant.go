@@ -0,0 +1,192 @@
+// Package tenant describes the loyalty programs served by one deployment.
+// Each tenant has its own receipts, rule set and limits; the tenant of a
+// request travels in its context.
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrIdEmpty       = errors.New("tenant id cannot be empty")
+	ErrIdInvalid     = errors.New("tenant id must be lowercase letters, digits and dashes")
+	ErrIdDuplicate   = errors.New("tenant id is used twice")
+	ErrHostDuplicate = errors.New("tenant host is used by two tenants")
+	ErrLimitInvalid  = errors.New("tenant limits cannot be negative")
+	ErrTenantsEmpty  = errors.New("tenants file must list at least one tenant")
+)
+
+var reId = regexp.MustCompile(`^[a-z0-9][a-z0-9\-]*$`)
+
+// Limits bound what a tenant can store. Zero means unlimited.
+type Limits struct {
+	MaxReceipts int `json:"maxReceipts,omitempty"`
+	MaxItems    int `json:"maxItems,omitempty"` // Per receipt.
+	// DailyReceipts is how many receipts each client or user may submit per
+	// UTC day, replacing the server's daily quota.
+	DailyReceipts int `json:"dailyReceipts,omitempty"`
+}
+
+type Tenant struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// Hosts are the hostnames, without port, whose requests belong to the
+	// tenant, such as "rewards.acme.com".
+	Hosts []string `json:"hosts,omitempty"`
+	// RulesFile is the tenant's rule set, with its campaigns. The server's
+	// rule set is used when empty.
+	RulesFile string `json:"rulesFile,omitempty"`
+	Limits    Limits `json:"limits"`
+}
+
+// ValidateID checks that id can name a tenant.
+func ValidateID(id string) error {
+	if id == "" {
+		return ErrIdEmpty
+	}
+	if !reId.MatchString(id) {
+		return ErrIdInvalid
+	}
+	return nil
+}
+
+func (t Tenant) IsValid() error {
+	err := ValidateID(t.ID)
+
+	if t.Limits.MaxReceipts < 0 || t.Limits.MaxItems < 0 || t.Limits.DailyReceipts < 0 {
+		err = errors.Join(err, ErrLimitInvalid)
+	}
+
+	return err
+}
+
+// Registry is the tenants of a deployment. It is read-only once built, so it
+// is safe for concurrent use.
+type Registry struct {
+	tenants []Tenant
+	byId    map[string]Tenant
+	byHost  map[string]Tenant
+}
+
+func NewRegistry(tenants []Tenant) (*Registry, error) {
+	if len(tenants) == 0 {
+		return nil, ErrTenantsEmpty
+	}
+
+	g := &Registry{byId: map[string]Tenant{}, byHost: map[string]Tenant{}}
+
+	var err error
+	for _, t := range tenants {
+		if terr := t.IsValid(); terr != nil {
+			err = errors.Join(err, fmt.Errorf("tenant %q: %w", t.ID, terr))
+			continue
+		}
+		if _, ok := g.byId[t.ID]; ok {
+			err = errors.Join(err, fmt.Errorf("%w: %q", ErrIdDuplicate, t.ID))
+			continue
+		}
+		for _, host := range t.Hosts {
+			host = strings.ToLower(host)
+			if other, ok := g.byHost[host]; ok {
+				err = errors.Join(err, fmt.Errorf("%w: %q in %q and %q", ErrHostDuplicate, host, other.ID, t.ID))
+			}
+			g.byHost[host] = t
+		}
+		g.byId[t.ID] = t
+		g.tenants = append(g.tenants, t)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// Load reads tenants from JSON of the form {"tenants": [...]}.
+func Load(r io.Reader) (*Registry, error) {
+	tenants, err := decode(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewRegistry(tenants)
+}
+
+func decode(r io.Reader) ([]Tenant, error) {
+	var file struct {
+		Tenants []Tenant `json:"tenants"`
+	}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("error decoding tenants: %w", err)
+	}
+	return file.Tenants, nil
+}
+
+// LoadFile reads tenants from path. A relative rulesFile is resolved against
+// path's directory, so the tenants file and rule sets can move together.
+func LoadFile(path string) (*Registry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tenants, err := decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, t := range tenants {
+		if t.RulesFile != "" && !filepath.IsAbs(t.RulesFile) {
+			tenants[i].RulesFile = filepath.Join(filepath.Dir(path), t.RulesFile)
+		}
+	}
+
+	g, err := NewRegistry(tenants)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return g, nil
+}
+
+func (g *Registry) Get(id string) (Tenant, bool) {
+	t, ok := g.byId[id]
+	return t, ok
+}
+
+// ByHost returns the tenant serving a request's Host, which may carry a port.
+func (g *Registry) ByHost(host string) (Tenant, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	t, ok := g.byHost[strings.ToLower(host)]
+	return t, ok
+}
+
+// List returns the tenants in file order.
+func (g *Registry) List() []Tenant {
+	return slices.Clone(g.tenants)
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant of a request. It reports false when the
+// deployment has no tenants.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok
+}
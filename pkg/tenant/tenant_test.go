@@ -0,0 +1,137 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	g, err := Load(strings.NewReader(`{"tenants": [
+		{"id": "acme", "hosts": ["rewards.acme.com"], "limits": {"maxReceipts": 100}},
+		{"id": "globex", "hosts": ["points.globex.io"], "rulesFile": "globex.json"}
+	]}`))
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	t.Run("Get: should return the tenant", func(t *testing.T) {
+		got, ok := g.Get("acme")
+		if !ok || got.Limits.MaxReceipts != 100 {
+			t.Errorf("got %+v %v, want acme", got, ok)
+		}
+		if _, ok := g.Get("initech"); ok {
+			t.Error("got a tenant, want none")
+		}
+	})
+
+	t.Run("ByHost: should match the host without port or case", func(t *testing.T) {
+		for _, host := range []string{"points.globex.io", "Points.Globex.IO:8443"} {
+			if got, ok := g.ByHost(host); !ok || got.ID != "globex" {
+				t.Errorf("%s: got %+v %v, want globex", host, got, ok)
+			}
+		}
+		if _, ok := g.ByHost("localhost:8080"); ok {
+			t.Error("got a tenant, want none")
+		}
+	})
+
+	t.Run("List: should keep file order", func(t *testing.T) {
+		if got := g.List(); len(got) != 2 || got[0].ID != "acme" || got[1].ID != "globex" {
+			t.Errorf("got %+v, want acme and globex", got)
+		}
+	})
+
+	t.Run("FromContext: should return the tenant", func(t *testing.T) {
+		if _, ok := FromContext(context.Background()); ok {
+			t.Error("got a tenant, want none")
+		}
+		if id, ok := FromContext(NewContext(context.Background(), "acme")); !ok || id != "acme" {
+			t.Errorf("got %q %v, want acme", id, ok)
+		}
+	})
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tenants.json")
+	abs := filepath.Join(dir, "shared", "rules.json")
+	data := `{"tenants": [
+		{"id": "acme", "rulesFile": "rules/acme.json"},
+		{"id": "globex", "rulesFile": ` + strconv.Quote(abs) + `},
+		{"id": "initech"}
+	]}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+
+	tests := []struct {
+		name string
+		id   string
+		want string
+	}{
+		{name: "LoadFile: should resolve a relative rules file against the tenants file", id: "acme", want: filepath.Join(dir, "rules", "acme.json")},
+		{name: "LoadFile: should keep an absolute rules file", id: "globex", want: abs},
+		{name: "LoadFile: should keep an empty rules file", id: "initech", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := g.Get(tt.id); got.RulesFile != tt.want {
+				t.Errorf("got %q, want %q", got.RulesFile, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateID(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		wantErr error
+	}{
+		{name: "ValidateID: should accept lowercase letters, digits and dashes", id: "acme-2"},
+		{name: "ValidateID: should return error for an empty id", id: "", wantErr: ErrIdEmpty},
+		{name: "ValidateID: should return error for an invalid id", id: "Acme Corp", wantErr: ErrIdInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateID(tt.id); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewRegistry(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenants []Tenant
+		wantErr error
+	}{
+		{name: "NewRegistry: should return error without tenants", wantErr: ErrTenantsEmpty},
+		{name: "NewRegistry: should return error for an empty id", tenants: []Tenant{{}}, wantErr: ErrIdEmpty},
+		{name: "NewRegistry: should return error for an invalid id", tenants: []Tenant{{ID: "Acme Corp"}}, wantErr: ErrIdInvalid},
+		{name: "NewRegistry: should return error for a duplicate id", tenants: []Tenant{{ID: "acme"}, {ID: "acme"}}, wantErr: ErrIdDuplicate},
+		{name: "NewRegistry: should return error for a shared host", tenants: []Tenant{{ID: "a", Hosts: []string{"x.com"}}, {ID: "b", Hosts: []string{"X.com"}}}, wantErr: ErrHostDuplicate},
+		{name: "NewRegistry: should return error for a negative limit", tenants: []Tenant{{ID: "a", Limits: Limits{MaxItems: -1}}}, wantErr: ErrLimitInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRegistry(tt.tenants); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
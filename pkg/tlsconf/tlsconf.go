@@ -0,0 +1,183 @@
+// Package tlsconf builds the server's TLS configuration from certificate
+// files and reloads them in place, so certificates can be rotated without
+// restarting or dropping connections.
+package tlsconf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+var (
+	ErrCertKeyEmpty     = errors.New("tls cert and key files must both be set")
+	ErrMinVersion       = errors.New("tls min version must be 1.2 or 1.3")
+	ErrCipherPolicy     = errors.New("tls cipher policy must be default or strict")
+	ErrClientAuth       = errors.New("tls client auth must be none, request or require")
+	ErrClientAuthNoCert = errors.New("tls client auth requires a server certificate")
+	ErrClientCAEmpty    = errors.New("tls client auth requires a client CA file")
+	ErrClientCAUnused   = errors.New("tls client CA file is only used with client auth")
+	ErrClientCAInvalid  = errors.New("tls client CA file has no PEM certificates")
+)
+
+var minVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// strictCiphers are the TLS 1.2 suites with forward secrecy and AEAD. TLS 1.3
+// suites are not configurable and are all strict.
+var strictCiphers = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+var clientAuths = map[string]tls.ClientAuthType{
+	"none":    tls.NoClientCert,
+	"request": tls.VerifyClientCertIfGiven,
+	"require": tls.RequireAndVerifyClientCert,
+}
+
+// Options are the TLS settings as they appear in the configuration.
+type Options struct {
+	CertFile     string
+	KeyFile      string
+	MinVersion   string // "1.2" or "1.3"
+	CipherPolicy string // "default" or "strict"
+	ClientAuth   string // "none", "request" or "require"
+	ClientCAFile string
+}
+
+// Enabled reports whether a certificate is configured.
+func (o Options) Enabled() bool {
+	return o.CertFile != "" || o.KeyFile != ""
+}
+
+func (o Options) IsValid() error {
+	var err error
+
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		err = errors.Join(err, ErrCertKeyEmpty)
+	}
+
+	if _, ok := minVersions[o.MinVersion]; !ok {
+		err = errors.Join(err, ErrMinVersion)
+	}
+
+	if o.CipherPolicy != "default" && o.CipherPolicy != "strict" {
+		err = errors.Join(err, ErrCipherPolicy)
+	}
+
+	auth, ok := clientAuths[o.ClientAuth]
+	switch {
+	case !ok:
+		err = errors.Join(err, ErrClientAuth)
+	case auth != tls.NoClientCert && !o.Enabled():
+		err = errors.Join(err, ErrClientAuthNoCert)
+	case auth != tls.NoClientCert && o.ClientCAFile == "":
+		err = errors.Join(err, ErrClientCAEmpty)
+	case auth == tls.NoClientCert && o.ClientCAFile != "":
+		err = errors.Join(err, ErrClientCAUnused)
+	}
+
+	return err
+}
+
+// Reloader serves the most recently loaded certificate and client CAs to each
+// new handshake. It is safe for concurrent use.
+type Reloader struct {
+	opts Options
+
+	mu     sync.RWMutex
+	cert   *tls.Certificate
+	config *tls.Config // Built from cert on each reload and shared by handshakes.
+}
+
+// New validates the options and loads the certificate files.
+func New(opts Options) (*Reloader, error) {
+	if err := opts.IsValid(); err != nil {
+		return nil, err
+	}
+	if !opts.Enabled() {
+		return nil, ErrCertKeyEmpty
+	}
+
+	r := &Reloader{opts: opts}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the certificate, key and client CA files again. If any of them
+// fails to load the previous ones stay in use.
+func (r *Reloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("error loading tls certificate: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if r.opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.opts.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("error loading tls client CA: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%w: %s", ErrClientCAInvalid, r.opts.ClientCAFile)
+		}
+	}
+
+	c := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersions[r.opts.MinVersion],
+		ClientAuth:   clientAuths[r.opts.ClientAuth],
+		ClientCAs:    pool,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if r.opts.CipherPolicy == "strict" {
+		c.CipherSuites = strictCiphers
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert = &cert
+	r.config = c
+	return nil
+}
+
+// Certificate is the certificate currently served.
+func (r *Reloader) Certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert
+}
+
+// Config is the server TLS configuration. Each handshake gets the
+// configuration built by the latest reload, so a reload applies to new
+// connections while existing ones carry on. Session tickets are issued with
+// the keys of the returned base configuration, so they survive reloads.
+func (r *Reloader) Config() *tls.Config {
+	base := r.current().Clone()
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		return r.current(), nil
+	}
+	return base
+}
+
+func (r *Reloader) current() *tls.Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.config
+}
@@ -0,0 +1,357 @@
+package tlsconf
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type keyPair struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issue creates a certificate signed by parent, or self-signed when parent is
+// nil.
+func issue(t *testing.T, serial int64, parent *keyPair, ca bool) keyPair {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if ca {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	}
+
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyPair{cert: cert, key: key}
+}
+
+// write stores the pair as PEM files named name.crt and name.key in dir.
+func (p keyPair) write(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalECPrivateKey(p.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: p.cert.Raw}))
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certFile, keyFile
+}
+
+func (p keyPair) tls() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{p.cert.Raw}, PrivateKey: p.key}
+}
+
+func writeFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func serve(t *testing.T, r *Reloader) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = r.Config()
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// dial handshakes with srv and returns the serial of the server certificate.
+func dial(srv *httptest.Server, roots *x509.CertPool, client *tls.Certificate) (int64, error) {
+	cfg := &tls.Config{RootCAs: roots}
+	if client != nil {
+		cfg.Certificates = []tls.Certificate{*client}
+	}
+
+	conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	// TLS 1.3 servers report a rejected client certificate after the client
+	// finishes its handshake, so read to surface the alert.
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n")); err != nil {
+		return 0, err
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		return 0, err
+	}
+
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func TestOptions(t *testing.T) {
+	valid := Options{CertFile: "a.crt", KeyFile: "a.key", MinVersion: "1.2", CipherPolicy: "default", ClientAuth: "none"}
+
+	tests := []struct {
+		name    string
+		edit    func(o *Options)
+		wantErr error
+	}{
+		{name: "IsValid: should accept a certificate", edit: func(o *Options) {}},
+		{name: "IsValid: should accept no certificate", edit: func(o *Options) { o.CertFile, o.KeyFile = "", "" }},
+		{name: "IsValid: should accept mutual TLS", edit: func(o *Options) { o.ClientAuth, o.ClientCAFile = "require", "ca.crt" }},
+		{name: "IsValid: should return error for a cert without a key", edit: func(o *Options) { o.KeyFile = "" }, wantErr: ErrCertKeyEmpty},
+		{name: "IsValid: should return error for TLS 1.1", edit: func(o *Options) { o.MinVersion = "1.1" }, wantErr: ErrMinVersion},
+		{name: "IsValid: should return error for an unknown cipher policy", edit: func(o *Options) { o.CipherPolicy = "modern" }, wantErr: ErrCipherPolicy},
+		{name: "IsValid: should return error for an unknown client auth", edit: func(o *Options) { o.ClientAuth = "optional" }, wantErr: ErrClientAuth},
+		{name: "IsValid: should return error for client auth without a CA", edit: func(o *Options) { o.ClientAuth = "request" }, wantErr: ErrClientCAEmpty},
+		{name: "IsValid: should return error for a CA without client auth", edit: func(o *Options) { o.ClientCAFile = "ca.crt" }, wantErr: ErrClientCAUnused},
+		{
+			name:    "IsValid: should return error for client auth without a certificate",
+			edit:    func(o *Options) { o.CertFile, o.KeyFile, o.ClientAuth, o.ClientCAFile = "", "", "require", "ca.crt" },
+			wantErr: ErrClientAuthNoCert,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := valid
+			tt.edit(&o)
+			if err := o.IsValid(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, 1, nil, true)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := issue(t, 10, &ca, false).write(t, dir, "server")
+	client := issue(t, 20, &ca, false).tls()
+	stranger := issue(t, 30, nil, false).tls()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	opts := Options{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2", CipherPolicy: "strict", ClientAuth: "none"}
+
+	t.Run("New: should return error for missing files", func(t *testing.T) {
+		o := opts
+		o.KeyFile = filepath.Join(dir, "missing.key")
+		if _, err := New(o); err == nil {
+			t.Error("got nil, want error")
+		}
+	})
+
+	t.Run("New: should return error without a certificate", func(t *testing.T) {
+		o := opts
+		o.CertFile, o.KeyFile = "", ""
+		if _, err := New(o); !errors.Is(err, ErrCertKeyEmpty) {
+			t.Errorf("got %v, want %v", err, ErrCertKeyEmpty)
+		}
+	})
+
+	t.Run("Config: should serve the certificate", func(t *testing.T) {
+		r, err := New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serial, err := dial(serve(t, r), roots, nil)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if serial != 10 {
+			t.Errorf("got serial %d, want 10", serial)
+		}
+	})
+
+	t.Run("Config: should share a configuration between handshakes until a reload", func(t *testing.T) {
+		r, err := New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		get := r.Config().GetConfigForClient
+
+		first, _ := get(&tls.ClientHelloInfo{})
+		second, _ := get(&tls.ClientHelloInfo{})
+		if first != second {
+			t.Error("got a new configuration, want the same one")
+		}
+
+		if err := r.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		if reloaded, _ := get(&tls.ClientHelloInfo{}); reloaded == first {
+			t.Error("got the same configuration, want the reloaded one")
+		}
+	})
+
+	t.Run("Config: should enforce the minimum version", func(t *testing.T) {
+		o := opts
+		o.MinVersion = "1.3"
+		r, err := New(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := serve(t, r)
+
+		conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{RootCAs: roots, MaxVersion: tls.VersionTLS12})
+		if err == nil {
+			conn.Close()
+			t.Error("got nil, want error")
+		}
+	})
+
+	t.Run("Config: should require a client certificate from the client CA", func(t *testing.T) {
+		o := opts
+		o.ClientAuth, o.ClientCAFile = "require", caFile
+		r, err := New(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := serve(t, r)
+
+		if _, err := dial(srv, roots, &client); err != nil {
+			t.Errorf("with client certificate: got %v, want nil", err)
+		}
+		if _, err := dial(srv, roots, nil); err == nil {
+			t.Error("without client certificate: got nil, want error")
+		}
+		if _, err := dial(srv, roots, &stranger); err == nil {
+			t.Error("with untrusted client certificate: got nil, want error")
+		}
+	})
+
+	t.Run("Config: should accept missing client certificates on request", func(t *testing.T) {
+		o := opts
+		o.ClientAuth, o.ClientCAFile = "request", caFile
+		r, err := New(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := serve(t, r)
+
+		if _, err := dial(srv, roots, nil); err != nil {
+			t.Errorf("without client certificate: got %v, want nil", err)
+		}
+		if _, err := dial(srv, roots, &stranger); err == nil {
+			t.Error("with untrusted client certificate: got nil, want error")
+		}
+	})
+
+	t.Run("Reload: should serve a rotated certificate to new connections", func(t *testing.T) {
+		dir := t.TempDir()
+		certFile, keyFile := issue(t, 11, &ca, false).write(t, dir, "server")
+		o := opts
+		o.CertFile, o.KeyFile = certFile, keyFile
+		r, err := New(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := serve(t, r)
+
+		// A connection opened before the rotation stays usable after it.
+		before, err := tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{RootCAs: roots})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer before.Close()
+
+		issue(t, 12, &ca, false).write(t, dir, "server")
+		if err := r.Reload(); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+
+		serial, err := dial(srv, roots, nil)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if serial != 12 {
+			t.Errorf("got serial %d, want 12", serial)
+		}
+
+		if _, err := before.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n")); err != nil {
+			t.Errorf("existing connection: got %v, want nil", err)
+		}
+		before.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := before.Read(make([]byte, 1)); err != nil {
+			t.Errorf("existing connection: got %v, want nil", err)
+		}
+		if got := before.ConnectionState().PeerCertificates[0].SerialNumber.Int64(); got != 11 {
+			t.Errorf("existing connection: got serial %d, want 11", got)
+		}
+	})
+
+	t.Run("Reload: should keep the previous certificate on error", func(t *testing.T) {
+		dir := t.TempDir()
+		certFile, keyFile := issue(t, 13, &ca, false).write(t, dir, "server")
+		o := opts
+		o.CertFile, o.KeyFile = certFile, keyFile
+		r, err := New(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := serve(t, r)
+
+		writeFile(t, keyFile, []byte("not a key"))
+		if err := r.Reload(); err == nil {
+			t.Fatal("got nil, want error")
+		}
+
+		serial, err := dial(srv, roots, nil)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if serial != 13 {
+			t.Errorf("got serial %d, want 13", serial)
+		}
+	})
+
+	t.Run("Reload: should return error for a CA file without certificates", func(t *testing.T) {
+		bad := filepath.Join(dir, "bad-ca.crt")
+		writeFile(t, bad, []byte("not a certificate"))
+		o := opts
+		o.ClientAuth, o.ClientCAFile = "require", bad
+		if _, err := New(o); !errors.Is(err, ErrClientCAInvalid) {
+			t.Errorf("got %v, want %v", err, ErrClientCAInvalid)
+		}
+	})
+}
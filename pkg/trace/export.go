@@ -0,0 +1,171 @@
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// ServiceName is the service.name resource attribute of exported spans.
+const ServiceName = "receipt-processor"
+
+// JSONExporter writes each span as a line of OTLP JSON, the format of the
+// OpenTelemetry Collector's file exporter, so files can be replayed into a
+// collector with its otlpjsonfile receiver.
+type JSONExporter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{w: w, enc: json.NewEncoder(w)}
+}
+
+// NewFileExporter appends spans to the file at path, creating it if needed.
+func NewFileExporter(path string) (*JSONExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONExporter(f), nil
+}
+
+func (e *JSONExporter) Export(data SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(otlpRequest(data))
+}
+
+// Close closes the underlying writer when it is a Closer.
+func (e *JSONExporter) Close() error {
+	if c, ok := e.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Collector keeps spans in memory, for tests.
+type Collector struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func NewCollector() *Collector {
+	return &Collector{}
+}
+
+func (c *Collector) Export(data SpanData) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spans = append(c.spans, data)
+	return nil
+}
+
+// Spans returns the spans exported so far, in the order they ended.
+func (c *Collector) Spans() []SpanData {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.spans)
+}
+
+func (c *Collector) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spans = nil
+}
+
+// The OTLP JSON encoding: IDs are hex, 64-bit integers are strings and
+// enums are numbers.
+type (
+	otlpExport struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttr `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Flags             uint32     `json:"flags"`
+		Name              string     `json:"name"`
+		Kind              Kind       `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []otlpAttr `json:"attributes,omitempty"`
+		Status            otlpStatus `json:"status"`
+	}
+	otlpStatus struct {
+		Code    StatusCode `json:"code,omitempty"`
+		Message string     `json:"message,omitempty"`
+	}
+	otlpAttr struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+func otlpRequest(data SpanData) otlpExport {
+	span := otlpSpan{
+		TraceID:           data.Context.TraceID.String(),
+		SpanID:            data.Context.SpanID.String(),
+		Flags:             uint32(data.Context.Flags),
+		Name:              data.Name,
+		Kind:              data.Kind,
+		StartTimeUnixNano: strconv.FormatInt(data.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(data.End.UnixNano(), 10),
+		Attributes:        otlpAttrs(data.Attributes),
+		Status:            otlpStatus{Code: data.Status, Message: data.StatusMessage},
+	}
+	if data.Parent.IsValid() {
+		span.ParentSpanID = data.Parent.String()
+	}
+
+	return otlpExport{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttrs([]Attr{String("service.name", ServiceName)})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "github.com/FourSigma/receipt-processor-challenge"}, Spans: []otlpSpan{span}}},
+	}}}
+}
+
+func otlpAttrs(attrs []Attr) []otlpAttr {
+	out := make([]otlpAttr, 0, len(attrs))
+	for _, a := range attrs {
+		var v otlpValue
+		switch value := a.Value.(type) {
+		case string:
+			v.StringValue = &value
+		case bool:
+			v.BoolValue = &value
+		case int64:
+			s := strconv.FormatInt(value, 10)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &value
+		default:
+			continue
+		}
+		out = append(out, otlpAttr{Key: a.Key, Value: v})
+	}
+	return out
+}
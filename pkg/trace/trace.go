@@ -0,0 +1,295 @@
+// Package trace records spans compatible with OpenTelemetry: W3C trace
+// context IDs and propagation, and spans exported as OTLP JSON. Spans are
+// started from a parent in the context, so code below the API traces
+// requests without being configured.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header carries the W3C trace context of a request.
+const Header = "traceparent"
+
+var ErrTraceparentInvalid = errors.New("traceparent must be 00-<32 hex trace ID>-<16 hex span ID>-<2 hex flags>")
+
+type TraceID [16]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id TraceID) IsValid() bool  { return id != TraceID{} }
+
+type SpanID [8]byte
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) IsValid() bool  { return id != SpanID{} }
+
+// FlagSampled marks a trace whose spans are exported.
+const FlagSampled byte = 0x01
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   byte
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&FlagSampled != 0
+}
+
+// Traceparent formats sc as a traceparent header.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ParseTraceparent parses a version 00 traceparent header. Later versions
+// are read as 00, as the specification asks.
+func ParseTraceparent(s string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, fmt.Errorf("%w: %q", ErrTraceparentInvalid, s)
+	}
+
+	var sc SpanContext
+	var flags [1]byte
+	if !decodeHex(sc.TraceID[:], parts[1]) || !decodeHex(sc.SpanID[:], parts[2]) || !decodeHex(flags[:], parts[3]) || !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("%w: %q", ErrTraceparentInvalid, s)
+	}
+	sc.Flags = flags[0]
+
+	return sc, nil
+}
+
+// decodeHex decodes lowercase hex of exactly len(dst) bytes.
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// Kind is an OpenTelemetry span kind.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+)
+
+// StatusCode is an OpenTelemetry span status.
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Attr is a span attribute. Value is a string, bool, int64 or float64.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attr      { return Attr{Key: key, Value: value} }
+func Int(key string, value int) Attr     { return Attr{Key: key, Value: int64(value)} }
+func Int64(key string, value int64) Attr { return Attr{Key: key, Value: value} }
+func Bool(key string, value bool) Attr   { return Attr{Key: key, Value: value} }
+
+// SpanData is a finished span, as handed to an Exporter.
+type SpanData struct {
+	Name       string
+	Kind       Kind
+	Context    SpanContext
+	Parent     SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes []Attr
+	Status     StatusCode
+	// StatusMessage describes an error status.
+	StatusMessage string
+}
+
+// Exporter receives spans as they end. It must be safe for concurrent use.
+type Exporter interface {
+	Export(SpanData) error
+}
+
+// Span is an operation being timed. A nil Span is valid and records
+// nothing, so callers need not check whether tracing is enabled.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+// RecordError sets the span's status to an error when err is not nil.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Status = StatusError
+	s.data.StatusMessage = err.Error()
+}
+
+// End finishes the span and exports it when its trace is sampled. Only the
+// first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = s.tracer.now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.Context.Sampled() {
+		s.tracer.export(data)
+	}
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(contextKey{}).(*Span)
+	return s
+}
+
+// Start starts a span that is a child of the span in ctx. Without one it
+// returns a nil Span, so nothing is traced outside of a traced request.
+func Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.start(ctx, name, KindInternal, parent.data.Context, attrs)
+}
+
+type Option func(*Tracer)
+
+// WithClock replaces time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(t *Tracer) {
+		t.now = now
+	}
+}
+
+// WithErrorHandler is called when the exporter fails. Spans are dropped
+// silently without it.
+func WithErrorHandler(fn func(error)) Option {
+	return func(t *Tracer) {
+		t.onError = fn
+	}
+}
+
+// Tracer starts root and remote-parented spans and exports them.
+type Tracer struct {
+	exporter Exporter
+	now      func() time.Time
+	onError  func(error)
+}
+
+func New(exporter Exporter, opts ...Option) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		now:      time.Now,
+		onError:  func(error) {},
+	}
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
+}
+
+// StartServer starts a server span for an incoming request whose caller
+// sent remote, which continues the caller's trace when it is valid. New
+// traces are sampled.
+func (t *Tracer) StartServer(ctx context.Context, name string, remote SpanContext, attrs ...Attr) (context.Context, *Span) {
+	return t.start(ctx, name, KindServer, remote, attrs)
+}
+
+func (t *Tracer) start(ctx context.Context, name string, kind Kind, parent SpanContext, attrs []Attr) (context.Context, *Span) {
+	sc := SpanContext{TraceID: parent.TraceID, Flags: parent.Flags}
+	if !parent.IsValid() {
+		sc = SpanContext{TraceID: newTraceID(), Flags: FlagSampled}
+	}
+	sc.SpanID = newSpanID()
+
+	s := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:       name,
+			Kind:       kind,
+			Context:    sc,
+			Parent:     parent.SpanID,
+			Start:      t.now(),
+			Attributes: attrs,
+		},
+	}
+	return NewContext(ctx, s), s
+}
+
+func (t *Tracer) export(data SpanData) {
+	if err := t.exporter.Export(data); err != nil {
+		t.onError(err)
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
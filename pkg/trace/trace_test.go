@@ -0,0 +1,150 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	tests := []struct {
+		name    string
+		header  string
+		wantErr bool
+	}{
+		{name: "ParseTraceparent: should parse a version 00 header", header: valid},
+		{name: "ParseTraceparent: should read later versions as 00", header: "cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+		{name: "ParseTraceparent: should reject extra fields in version 00", header: valid + "-extra", wantErr: true},
+		{name: "ParseTraceparent: should reject version ff", header: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantErr: true},
+		{name: "ParseTraceparent: should reject a zero trace ID", header: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", wantErr: true},
+		{name: "ParseTraceparent: should reject a zero span ID", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", wantErr: true},
+		{name: "ParseTraceparent: should reject uppercase hex", header: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", wantErr: true},
+		{name: "ParseTraceparent: should reject short IDs", header: "00-4bf92f35-00f067aa0ba902b7-01", wantErr: true},
+		{name: "ParseTraceparent: should reject an empty header", header: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.header)
+			if tt.wantErr {
+				if !errors.Is(err, ErrTraceparentInvalid) {
+					t.Fatal("got", err, "want", ErrTraceparentInvalid)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sc.Traceparent(); got != valid {
+				t.Errorf("got %s, want %s", got, valid)
+			}
+		})
+	}
+}
+
+func TestTracer(t *testing.T) {
+	collector := NewCollector()
+	tracer := New(collector)
+
+	t.Run("Start: should not trace without a parent", func(t *testing.T) {
+		_, span := Start(context.Background(), "orphan")
+		span.SetAttributes(String("a", "b"))
+		span.RecordError(errors.New("ignored"))
+		span.End()
+		if spans := collector.Spans(); len(spans) != 0 {
+			t.Error("got", spans, "want none")
+		}
+	})
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, server := tracer.StartServer(context.Background(), "POST /receipts/process", remote)
+	_, child := Start(ctx, "decode")
+	child.RecordError(errors.New("bad body"))
+	child.End()
+	child.End()
+	server.End()
+
+	spans := collector.Spans()
+	if len(spans) != 2 {
+		t.Fatal("got", len(spans), "spans, want 2")
+	}
+	decode, root := spans[0], spans[1]
+
+	t.Run("StartServer: should continue the remote trace", func(t *testing.T) {
+		if root.Context.TraceID != remote.TraceID || root.Parent != remote.SpanID || root.Kind != KindServer {
+			t.Errorf("got %+v, want a server child of %+v", root, remote)
+		}
+	})
+
+	t.Run("Start: should start a child of the span in the context", func(t *testing.T) {
+		if decode.Context.TraceID != remote.TraceID || decode.Parent != root.Context.SpanID || decode.Kind != KindInternal {
+			t.Errorf("got %+v, want an internal child of %+v", decode, root.Context)
+		}
+	})
+
+	t.Run("RecordError: should set an error status", func(t *testing.T) {
+		if decode.Status != StatusError || decode.StatusMessage != "bad body" {
+			t.Errorf("got %d %q, want an error status", decode.Status, decode.StatusMessage)
+		}
+	})
+
+	t.Run("StartServer: should sample new traces", func(t *testing.T) {
+		_, span := tracer.StartServer(context.Background(), "GET /", SpanContext{})
+		if sc := span.Context(); !sc.IsValid() || !sc.Sampled() {
+			t.Errorf("got %+v, want a sampled trace", sc)
+		}
+	})
+
+	t.Run("End: should not export unsampled traces", func(t *testing.T) {
+		collector.Reset()
+		unsampled := remote
+		unsampled.Flags = 0
+		_, span := tracer.StartServer(context.Background(), "GET /", unsampled)
+		span.End()
+		if spans := collector.Spans(); len(spans) != 0 {
+			t.Error("got", spans, "want none")
+		}
+	})
+}
+
+func TestJSONExporter(t *testing.T) {
+	var buf bytes.Buffer
+	start := time.Unix(1700000000, 0)
+	tracer := New(NewJSONExporter(&buf), WithClock(func() time.Time { return start }))
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, span := tracer.StartServer(context.Background(), "GET /receipts/{id}/points", remote, String("http.route", "GET /receipts/{id}/points"), Int("http.response.status_code", 200))
+	span.End()
+
+	var got otlpExport
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	s := got.ResourceSpans[0].ScopeSpans[0].Spans[0]
+
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{name: "Export: should write the trace ID as hex", got: s.TraceID, want: "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{name: "Export: should write the parent span ID", got: s.ParentSpanID, want: "00f067aa0ba902b7"},
+		{name: "Export: should write times as nanosecond strings", got: s.StartTimeUnixNano, want: "1700000000000000000"},
+		{name: "Export: should write string attributes", got: *s.Attributes[0].Value.StringValue, want: "GET /receipts/{id}/points"},
+		{name: "Export: should write integers as strings", got: *s.Attributes[1].Value.IntValue, want: "200"},
+		{name: "Export: should name the service", got: *got.ResourceSpans[0].Resource.Attributes[0].Value.StringValue, want: ServiceName},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}